@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic writes data to a temporary file next to filename and
+// renames it into place, so readers of filename never see a partial write.
+// If ctx is cancelled before the rename the temporary file is removed and
+// filename is left untouched.
+func writeFileAtomic(ctx context.Context, filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := checkInterrupted(ctx); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// refuseEmptyOverwrite fails if writing no records to filename, or to its
+// first chunk with -split-size, would replace a non-empty file, as when a
+// filter leaves nothing of an in-place input.
+func refuseEmptyOverwrite(filename string, opts storeOptions) error {
+	target := filename
+	if opts.splitSize > 0 {
+		target = chunkFile(filename, 1)
+	}
+	if fi, err := os.Stat(target); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+		return fmt.Errorf("no records to write, refusing to overwrite non-empty %s; pass -allow-empty to write it anyway", target)
+	}
+	return nil
+}
+
+// resolveSymlinks returns p with its symlinks resolved, so that an in-place
+// save writes through a symlinked state file to its target instead of
+// renaming a regular file over the link. A p that does not resolve, such
+// as an -out yet to be created, is returned as is, or with its directory
+// resolved if that exists.
+func resolveSymlinks(p string) string {
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		return resolved
+	}
+	if target, err := os.Readlink(p); err == nil {
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		return target
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+		return filepath.Join(dir, filepath.Base(p))
+	}
+	return p
+}
+
+// sameFile reports whether a and b name the same file, either by path or,
+// when both exist, by identity.
+func sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(fa, fb)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertInPlace(t *testing.T) {
+	tests := [][]string{
+		nil,
+		// -canonical takes the general path instead of fastConvert.
+		{"-canonical"},
+		{"-format", "jsonl"},
+	}
+	for _, args := range tests {
+		in := writeGobState(t, tempDir(t), "state", recordMap(testRecords(500)))
+		// The first run converts the gob file in place, the second the
+		// JSON state it wrote.
+		for run := 1; run <= 2; run++ {
+			mustRun(t, append([]string{"-in", in}, args...)...)
+			if got := len(loadTestState(t, in)); got != 500 {
+				t.Errorf("%q, run %d: the converted file holds %d records, want 500", args, run, got)
+			}
+		}
+		entries, err := ioutil.ReadDir(filepath.Dir(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("%q: %d files left beside the state, want no temporary files", args, len(entries)-1)
+		}
+	}
+}
+
+func TestSameFile(t *testing.T) {
+	dir := tempDir(t)
+	a := writeTestFile(t, dir, "a", []byte("a"))
+	b := writeTestFile(t, dir, "b", []byte("a"))
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(a, link); err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		x, y string
+		want bool
+	}{
+		{a, a, true},
+		{a, link, true},
+		{a, b, false},
+		{a, filepath.Join(dir, "missing"), false},
+	}
+	for _, tt := range tests {
+		if got := sameFile(tt.x, tt.y); got != tt.want {
+			t.Errorf("sameFile(%s, %s) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFileAtomicCancelled(t *testing.T) {
+	dir := tempDir(t)
+	name := writeTestFile(t, dir, "state.json", []byte("old"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := writeFileAtomic(ctx, name, []byte("new"), 0600); err == nil {
+		t.Fatal("a cancelled write succeeded")
+	}
+	if got := readTestFile(t, name); got != "old" {
+		t.Errorf("the file holds %q, want it untouched", got)
+	}
+	if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the cancelled write left %d files", len(entries))
+	}
+}
+
+// symlink links name to target, skipping the test where the platform or
+// user may not create links.
+func symlink(t *testing.T, target, name string) {
+	t.Helper()
+	if err := os.Symlink(target, name); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+}
+
+func TestResolveSymlinks(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(tempDir(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	real := writeTestFile(t, dir, "real.json", []byte("[]"))
+	symlink(t, "real.json", filepath.Join(dir, "relative"))
+	symlink(t, real, filepath.Join(dir, "absolute"))
+	symlink(t, "missing.json", filepath.Join(dir, "dangling"))
+	symlink(t, dir, filepath.Join(dir, "linkdir"))
+	tests := []struct {
+		path, want string
+	}{
+		{real, real},
+		{filepath.Join(dir, "relative"), real},
+		{filepath.Join(dir, "absolute"), real},
+		{filepath.Join(dir, "dangling"), filepath.Join(dir, "missing.json")},
+		{filepath.Join(dir, "linkdir", "new.json"), filepath.Join(dir, "new.json")},
+		{filepath.Join(dir, "nodir", "new.json"), filepath.Join(dir, "nodir", "new.json")},
+	}
+	for _, tt := range tests {
+		if got := resolveSymlinks(tt.path); got != tt.want {
+			t.Errorf("resolveSymlinks(%s) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSymlinkedInputAndOutput(t *testing.T) {
+	dir := tempDir(t)
+	real := writeGobState(t, dir, "state.gob", recordMap(testRecords(2)))
+	link := filepath.Join(dir, "state_data")
+	symlink(t, "state.gob", link)
+
+	// Converting in place through the link writes the real file.
+	mustRun(t, "-in", link)
+	if target, err := os.Readlink(link); err != nil || target != "state.gob" {
+		t.Errorf("the link now points at %q, %v", target, err)
+	}
+	if got := readJsonState(t, real); len(got) != 2 {
+		t.Errorf("the real file holds %d records, want the converted 2", len(got))
+	}
+
+	out := filepath.Join(dir, "out.json")
+	outLink := filepath.Join(dir, "out-link.json")
+	symlink(t, "out.json", outLink)
+	mustRun(t, "-in", link, "-out", outLink)
+	if fi, err := os.Lstat(outLink); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("saving replaced the -out link: %v", err)
+	}
+	if got := readJsonState(t, out); len(got) != 2 {
+		t.Errorf("the link target holds %d records, want 2", len(got))
+	}
+
+	mustRun(t, "-in", link, "-no-follow-symlinks")
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("-no-follow-symlinks kept the link converted in place: %v", err)
+	}
+}
+
+func TestRefuseEmptyOverwrite(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		gob  interface{}
+	}{
+		{"filtered in place", []string{"-number-min", "10"}, recordMap(testRecords(3))},
+		// Only empty records take the fast path and still leave nothing.
+		{"fast path", nil, []SectorRecord{{}, {}}},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		in := writeGobState(t, dir, "state", tt.gob)
+		before := readTestFile(t, in)
+
+		res := runTool(t, append([]string{"-in", in}, tt.args...)...)
+		if res.err == nil || !strings.Contains(res.err.Error(), "refusing to overwrite non-empty "+in) {
+			t.Errorf("%s: got %v, want the empty save refused", tt.name, res.err)
+		}
+		if readTestFile(t, in) != before {
+			t.Errorf("%s: the refused save changed the input", tt.name)
+		}
+
+		fresh := filepath.Join(dir, "fresh.json")
+		mustRun(t, append([]string{"-in", in, "-out", fresh}, tt.args...)...)
+		if got := readTestFile(t, fresh); got != "[]" {
+			t.Errorf("%s: a new output holds %q, want []", tt.name, got)
+		}
+
+		mustRun(t, append([]string{"-in", in, "-allow-empty"}, tt.args...)...)
+		if got := readTestFile(t, in); got != "[]" {
+			t.Errorf("%s: -allow-empty wrote %q, want []", tt.name, got)
+		}
+	}
+}
+
+func TestRefuseEmptyOverwriteChecksTheFirstChunk(t *testing.T) {
+	dir := tempDir(t)
+	out := filepath.Join(dir, "out.jsonl")
+	writeTestFile(t, dir, "out.jsonl.001", []byte("{}\n"))
+	if err := refuseEmptyOverwrite(out, storeOptions{splitSize: 100}); err == nil {
+		t.Error("an empty write over a non-empty first chunk was allowed")
+	}
+	if err := refuseEmptyOverwrite(out, storeOptions{}); err != nil {
+		t.Errorf("without -split-size the missing %s was refused: %v", out, err)
+	}
+	writeTestFile(t, dir, "out.jsonl", nil)
+	if err := refuseEmptyOverwrite(out, storeOptions{}); err != nil {
+		t.Errorf("an empty file was refused: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// auditEntry records one change made to a record during the run.
+type auditEntry struct {
+	Sector string `json:"sector"`
+	Field  string `json:"field,omitempty"`
+	Action string `json:"action"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+
+	// id is the sector the entry is sorted by.
+	id SectorID
+}
+
+// auditLog collects the mutations applied to the state. A nil log records
+// nothing.
+type auditLog struct {
+	entries []auditEntry
+}
+
+func (a *auditLog) changed(id SectorID, field, old, new string) {
+	if a == nil {
+		return
+	}
+	a.entries = append(a.entries, auditEntry{id: id, Sector: sectorName(id), Field: field, Action: "changed", Old: old, New: new})
+}
+
+func (a *auditLog) cleared(id SectorID, field, old string) {
+	if a == nil {
+		return
+	}
+	a.entries = append(a.entries, auditEntry{id: id, Sector: sectorName(id), Field: field, Action: "cleared", Old: old})
+}
+
+func (a *auditLog) dropped(id SectorID) {
+	if a == nil {
+		return
+	}
+	a.entries = append(a.entries, auditEntry{id: id, Sector: sectorName(id), Action: "dropped"})
+}
+
+// write saves the entries to filename by sector, and for each sector in
+// the order the changes were made. The passes over the state visit the
+// sectors in no fixed order, so this keeps the log of the same run on the
+// same input identical.
+func (a *auditLog) write(ctx context.Context, filename string) error {
+	entries := make([]auditEntry, len(a.entries))
+	copy(entries, a.entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return sectorIDLess(entries[i].id, entries[j].id)
+	})
+	marshaled, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ctx, filename, marshaled, 0600)
+}
+
+func blobSummary(b []byte) string {
+	return fmt.Sprintf("%d bytes", len(b))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := tempDir(t)
+	paths := testRecord(1000, 1, 1)
+	paths.CurrentFileTask = FileTask{FileTaskType: "move", TargetSealedSectorPath: "/dst/sealed", Done: true}
+	commit2 := testRecord(1000, 2, 6)
+	commit2.CurrentSealTask.TaskType = TTCommit2
+	commit2.CurrentSealTask.Commit1Out = []byte("c1out")
+	in := writeGobState(t, dir, "in.gob", []SectorRecord{paths, commit2, {}})
+	out := filepath.Join(dir, "out.json")
+	audit := filepath.Join(dir, "audit.json")
+
+	mustRun(t, "-in", in, "-out", out, "-audit", audit, "-prune-done-filetasks")
+	var got []auditEntry
+	if err := json.Unmarshal([]byte(readTestFile(t, audit)), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []auditEntry{
+		{Sector: "s-t00-0", Action: "dropped"},
+		{Sector: "s-t01000-1", Field: "CurrentFileTask.TargetSealedSectorPath", Action: "cleared", Old: "/dst/sealed"},
+		{Sector: "s-t01000-2", Field: "CurrentSealTask.Commit1Out", Action: "cleared", Old: "5 bytes"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log = %+v, want %+v", got, want)
+	}
+
+	os.Remove(audit)
+	mustRun(t, "-in", in, "-out", out, "-prune-done-filetasks")
+	if _, err := os.Stat(audit); !os.IsNotExist(err) {
+		t.Errorf("a run without -audit wrote the log: %v", err)
+	}
+}
+
+func TestAuditLogWithoutChanges(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(2)))
+	audit := filepath.Join(dir, "audit.json")
+	mustRun(t, "-in", in, "-out", filepath.Join(dir, "out.json"), "-audit", audit)
+	if got := readTestFile(t, audit); got != "[]" {
+		t.Errorf("audit log = %s, want an empty array", got)
+	}
+}
+
+func TestAuditLogOrder(t *testing.T) {
+	dir := tempDir(t)
+	recordList := make([]SectorRecord, 0)
+	for _, id := range []SectorID{{2000, 1}, {1000, 10}, {1000, 9}, {1000, 2}} {
+		r := testRecord(id.Miner, id.Number, 6)
+		r.CurrentFileTask = FileTask{FileTaskType: "move", TargetSealedSectorPath: "/dst/sealed", Done: true}
+		r.CurrentSealTask.TaskType = TTCommit2
+		r.CurrentSealTask.Commit1Out = []byte("c1out")
+		recordList = append(recordList, r)
+	}
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+	audit := filepath.Join(dir, "audit.json")
+
+	mustRun(t, "-in", in, "-out", out, "-audit", audit, "-prune-done-filetasks")
+	first := readTestFile(t, audit)
+	var got []auditEntry
+	if err := json.Unmarshal([]byte(first), &got); err != nil {
+		t.Fatal(err)
+	}
+	logged := make([]string, 0, len(got))
+	for _, e := range got {
+		logged = append(logged, e.Sector+" "+e.Field)
+	}
+	want := make([]string, 0)
+	for _, sector := range []string{"s-t01000-2", "s-t01000-9", "s-t01000-10", "s-t02000-1"} {
+		want = append(want, sector+" CurrentFileTask.TargetSealedSectorPath", sector+" CurrentSealTask.Commit1Out")
+	}
+	if !reflect.DeepEqual(logged, want) {
+		t.Errorf("logged\n%q\nwant by sector ID, then in run order\n%q", logged, want)
+	}
+	for i := 0; i < 5; i++ {
+		mustRun(t, "-in", in, "-out", out, "-audit", audit, "-prune-done-filetasks")
+		if readTestFile(t, audit) != first {
+			t.Fatal("two runs on the same input logged in different orders")
+		}
+	}
+	if names := dirEntries(t, dir); len(names) != 3 {
+		t.Errorf("writing the log left %v", names)
+	}
+}
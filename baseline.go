@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// tombstone stands in the output for a sector the -baseline state has and
+// the converted state does not. Its @deleted member cannot be a record
+// field, like the @count of a countHeader.
+type tombstone struct {
+	SectorId SectorID
+	Deleted  bool `json:"@deleted"`
+}
+
+// keepChanged removes from s the records equal, field for field, to their
+// record in baseline, leaving the new and changed ones. It returns how many
+// it removed and, in order, the sectors of baseline that s does not hold.
+func (s *State) keepChanged(baseline map[SectorID]SectorRecord) (int, []SectorID, error) {
+	removed := make([]SectorID, 0)
+	for id := range baseline {
+		if _, ok := s.state[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return sectorIDLess(removed[i], removed[j]) })
+	unchanged := 0
+	for id, r := range s.state {
+		base, ok := baseline[id]
+		if !ok {
+			continue
+		}
+		fields, err := recordDiff(r, base)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(fields) == 0 {
+			delete(s.state, id)
+			unchanged++
+		}
+	}
+	return unchanged, removed, nil
+}
+
+func marshalTombstone(id SectorID) json.RawMessage {
+	marshaled, _ := json.Marshal(tombstone{SectorId: id, Deleted: true})
+	return marshaled
+}
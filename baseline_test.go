@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeepChanged(t *testing.T) {
+	changed := testRecord(1000, 2, 2)
+	changed.P1WorkerAddress = "elsewhere"
+	s := &State{state: recordMap([]SectorRecord{testRecord(1000, 1, 1), changed, testRecord(1000, 5, 5)})}
+	baseline := recordMap(testRecords(4))
+
+	unchanged, removed, err := s.keepChanged(baseline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sectorNumbers(sortedRecords(s.state)); got != "2 5" {
+		t.Errorf("kept the sectors %s, want the changed 2 and the new 5", got)
+	}
+	want := []SectorID{{Miner: 1000, Number: 3}, {Miner: 1000, Number: 4}}
+	if unchanged != 1 || !reflect.DeepEqual(removed, want) {
+		t.Errorf("got %d unchanged and removed %v, want 1 and %v", unchanged, removed, want)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	dir := tempDir(t)
+	baseRecords := testRecords(4)
+	baseRecords[3].CurrentSealTask.TaskType = TTCommit2
+	baseRecords[3].CurrentSealTask.Commit1Out = []byte("c1")
+	baseline := filepath.Join(dir, "baseline.json")
+	mustRun(t, "-in", writeGobState(t, dir, "old.gob", recordMap(baseRecords)), "-out", baseline)
+
+	current := testRecords(5)
+	current[1].P1WorkerAddress = "elsewhere"
+	// The baseline holds sector 4 with its Commit1Out already cleared.
+	current[3].CurrentSealTask.TaskType = TTCommit2
+	current[3].CurrentSealTask.Commit1Out = []byte("c1")
+	current = append(current[:2], current[3:]...)
+	in := writeGobState(t, dir, "new.gob", recordMap(current))
+
+	tests := []struct {
+		args       []string
+		sectors    string
+		tombstones string
+	}{
+		{nil, "2 5", ""},
+		{[]string{"-tombstones"}, "2 5", "3"},
+		{[]string{"-tombstones", "-format", "jsonl"}, "2 5", "3"},
+		// The baseline is filtered too, so sector 1 is not a removal.
+		{[]string{"-tombstones", "-number-min", "2"}, "2 5", "3"},
+		{[]string{"-tombstones", "-number-max", "2"}, "2", ""},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "delta")
+		res := mustRun(t, append([]string{"-in", in, "-out", out, "-baseline", baseline}, tt.args...)...)
+		var sectors, tombstones []string
+		for _, entry := range readEntries(t, out) {
+			var e struct {
+				SectorId SectorID
+				Deleted  bool `json:"@deleted"`
+			}
+			if err := json.Unmarshal(entry, &e); err != nil {
+				t.Fatal(err)
+			}
+			n := sectorNumbers([]SectorRecord{{SectorId: e.SectorId}})
+			if e.Deleted {
+				tombstones = append(tombstones, n)
+			} else {
+				sectors = append(sectors, n)
+			}
+		}
+		if got := strings.Join(sectors, " "); got != tt.sectors {
+			t.Errorf("%q: wrote the sectors %s, want %s", tt.args, got, tt.sectors)
+		}
+		if got := strings.Join(tombstones, " "); got != tt.tombstones {
+			t.Errorf("%q: wrote tombstones for %q, want %q", tt.args, got, tt.tombstones)
+		}
+		if !strings.Contains(res.log, "new or changed since "+baseline) {
+			t.Errorf("%q: the delta was not logged:\n%s", tt.args, res.log)
+		}
+	}
+
+	// Nothing changed is an empty delta, not an input to refuse to write.
+	unchanged := filepath.Join(dir, "unchanged.json")
+	mustRun(t, "-in", writeGobState(t, dir, "same.gob", recordMap(baseRecords)), "-out", unchanged, "-baseline", baseline)
+	if got := readTestFile(t, unchanged); got != "[]" {
+		t.Errorf("an input equal to the baseline wrote %s, want []", got)
+	}
+
+	for _, args := range [][]string{
+		{"-tombstones"},
+		{"-baseline", baseline, "-tombstones", "-flatten"},
+		{"-baseline", baseline, "-compare", baseline},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", filepath.Join(dir, "x")}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
+
+// readEntries returns the entries of the JSON array or JSON Lines file name.
+func readEntries(t *testing.T, name string) []json.RawMessage {
+	t.Helper()
+	raw := readTestFile(t, name)
+	var entries []json.RawMessage
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(raw, "\n"), "\n") {
+		entries = append(entries, json.RawMessage(line))
+	}
+	return entries
+}
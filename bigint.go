@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// bigintsAsStrings makes ActorID and SectorNumber values marshal as quoted
+// decimal strings, as asked with -bigints-as-strings, so JavaScript
+// consumers do not round those above 2^53. Loading accepts both forms
+// regardless of this setting.
+var bigintsAsStrings bool
+
+func marshalBigint(n uint64) []byte {
+	if bigintsAsStrings {
+		b := append(make([]byte, 0, 22), '"')
+		return append(strconv.AppendUint(b, n, 10), '"')
+	}
+	return strconv.AppendUint(make([]byte, 0, 20), n, 10)
+}
+
+func unmarshalBigint(data []byte) (uint64, error) {
+	if string(data) == "null" {
+		return 0, nil
+	}
+	digits := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &digits); err != nil {
+			return 0, err
+		}
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("json: cannot unmarshal %s into an unsigned 64-bit integer", data)
+	}
+	return n, nil
+}
+
+func (a ActorID) MarshalJSON() ([]byte, error) {
+	return marshalBigint(uint64(a)), nil
+}
+
+func (a *ActorID) UnmarshalJSON(data []byte) error {
+	n, err := unmarshalBigint(data)
+	*a = ActorID(n)
+	return err
+}
+
+func (n SectorNumber) MarshalJSON() ([]byte, error) {
+	return marshalBigint(uint64(n)), nil
+}
+
+func (n *SectorNumber) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalBigint(data)
+	*n = SectorNumber(v)
+	return err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalBigint(t *testing.T) {
+	tests := []struct {
+		data string
+		want uint64
+		ok   bool
+	}{
+		{`1000`, 1000, true},
+		{`"1000"`, 1000, true},
+		{`9007199254740993`, 1<<53 + 1, true},
+		{`"18446744073709551615"`, math.MaxUint64, true},
+		{`null`, 0, true},
+		{`"18446744073709551616"`, 0, false},
+		{`-1`, 0, false},
+		{`"-1"`, 0, false},
+		{`1.5`, 0, false},
+		{`""`, 0, false},
+		{`"1000`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := unmarshalBigint([]byte(tt.data))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("unmarshalBigint(%s) = %d, %v, want %d", tt.data, got, err, tt.want)
+		}
+	}
+}
+
+func TestMarshalBigint(t *testing.T) {
+	defer func() { bigintsAsStrings = false }()
+	id := SectorID{Miner: 1<<53 + 1, Number: math.MaxUint64}
+	for _, tt := range []struct {
+		asStrings bool
+		want      string
+	}{
+		{false, `{"Miner":9007199254740993,"Number":18446744073709551615}`},
+		{true, `{"Miner":"9007199254740993","Number":"18446744073709551615"}`},
+	} {
+		bigintsAsStrings = tt.asStrings
+		raw, err := json.Marshal(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(raw) != tt.want {
+			t.Errorf("-bigints-as-strings %v: got %s, want %s", tt.asStrings, raw, tt.want)
+		}
+		bigintsAsStrings = !tt.asStrings
+		var back SectorID
+		if err := json.Unmarshal(raw, &back); err != nil || back != id {
+			t.Errorf("%s loaded back as %+v, %v", raw, back, err)
+		}
+	}
+}
+
+func TestBigintsAsStrings(t *testing.T) {
+	dir := tempDir(t)
+	big := testRecord(1<<53+1, 1<<60+3, 2)
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{big, testRecord(1000, 1, 1)}))
+	quoted := fmt.Sprintf(`"SectorId":{"Miner":"%d","Number":"%d"}`, uint64(1<<53+1), uint64(1<<60+3))
+	for _, args := range [][]string{{}, {"-explain"}, {"-format", "jsonl"}, {"-format", "map-json"}} {
+		out := filepath.Join(dir, "out.json")
+		mustRun(t, append([]string{"-in", in, "-out", out, "-bigints-as-strings"}, args...)...)
+		got := readTestFile(t, out)
+		if !strings.Contains(got, quoted) || !strings.Contains(got, `"Miner":"1000","Number":"1"`) {
+			t.Errorf("%q: wrote\n%s\nwant the IDs quoted", args, got)
+		}
+		// Both forms load, and the value above 2^53 comes back exactly.
+		back := filepath.Join(dir, "back.json")
+		mustRun(t, "-in", out, "-out", back)
+		loaded := loadTestState(t, back)
+		if r, ok := loaded[big.SectorId]; !ok || r.SectorId != big.SectorId || len(loaded) != 2 {
+			t.Errorf("%q: loaded %v back, want %+v", args, loaded, big.SectorId)
+		}
+		if strings.Contains(readTestFile(t, back), `"Miner":"`) {
+			t.Errorf("%q: quoted the IDs without -bigints-as-strings", args)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// blobEncoding is the alphabet byte-backed fields are written with. Loading
+// accepts either alphabet regardless of this setting.
+var blobEncoding = base64.StdEncoding
+
+func setBlobEncoding(name string) error {
+	switch name {
+	case "base64":
+		blobEncoding = base64.StdEncoding
+	case "base64url":
+		blobEncoding = base64.URLEncoding
+	default:
+		return fmt.Errorf("unknown blob encoding %q", name)
+	}
+	return nil
+}
+
+func marshalBlob(b []byte) ([]byte, error) {
+	if b == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(blobEncoding.EncodeToString(b))
+}
+
+func unmarshalBlob(data []byte) ([]byte, error) {
+	if string(data) == "null" {
+		return nil, nil
+	}
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return nil, err
+	}
+	b, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		b, err = base64.URLEncoding.DecodeString(str)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (r SealRandomness) MarshalJSON() ([]byte, error) {
+	return marshalBlob(r)
+}
+
+func (r *SealRandomness) UnmarshalJSON(data []byte) error {
+	b, err := unmarshalBlob(data)
+	*r = b
+	return err
+}
+
+func (r InteractiveSealRandomness) MarshalJSON() ([]byte, error) {
+	return marshalBlob(r)
+}
+
+func (r *InteractiveSealRandomness) UnmarshalJSON(data []byte) error {
+	b, err := unmarshalBlob(data)
+	*r = b
+	return err
+}
+
+func (o PreCommit1Out) MarshalJSON() ([]byte, error) {
+	return marshalBlob(o)
+}
+
+func (o *PreCommit1Out) UnmarshalJSON(data []byte) error {
+	b, err := unmarshalBlob(data)
+	*o = b
+	return err
+}
+
+func (o Commit1Out) MarshalJSON() ([]byte, error) {
+	return marshalBlob(o)
+}
+
+func (o *Commit1Out) UnmarshalJSON(data []byte) error {
+	b, err := unmarshalBlob(data)
+	*o = b
+	return err
+}
+
+func (p Proof) MarshalJSON() ([]byte, error) {
+	return marshalBlob(p)
+}
+
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	b, err := unmarshalBlob(data)
+	*p = b
+	return err
+}
+
+// oversizedBlob is a byte field of a record longer than -max-blob-size.
+type oversizedBlob struct {
+	SectorID SectorID
+	Field    string
+	Size     int
+}
+
+func (b oversizedBlob) String() string {
+	return fmt.Sprintf("%s %s is %d bytes", sectorName(b.SectorID), b.Field, b.Size)
+}
+
+// oversizedBlobs returns, in sector order, the proof and randomness blobs
+// of data longer than max bytes. Such sizes point at a corrupt record.
+func oversizedBlobs(data map[SectorID]SectorRecord, max int) []oversizedBlob {
+	found := make([]oversizedBlob, 0)
+	for _, r := range sortedRecords(data) {
+		for _, f := range recordBlobs(r) {
+			if len(f.b) > max {
+				found = append(found, oversizedBlob{SectorID: r.SectorId, Field: f.name, Size: len(f.b)})
+			}
+		}
+	}
+	return found
+}
+
+// namedBlob is a byte field of a record and its path.
+type namedBlob struct {
+	name string
+	b    []byte
+}
+
+// recordBlobs returns the proof and randomness blobs of r.
+func recordBlobs(r SectorRecord) []namedBlob {
+	t := r.CurrentSealTask
+	return []namedBlob{
+		{"CurrentSealTask.Ticket", t.Ticket},
+		{"CurrentSealTask.Seed", t.Seed},
+		{"CurrentSealTask.PreCommit1Out", t.PreCommit1Out},
+		{"CurrentSealTask.Commit1Out", t.Commit1Out},
+		{"CurrentSealTask.Commit2Out", t.Commit2Out},
+	}
+}
+
+// humanSizes makes byteSize values marshal as strings such as "1.5 MiB"
+// instead of byte counts.
+var humanSizes bool
+
+// byteSize is a size in bytes in stats output.
+type byteSize int64
+
+func (n byteSize) MarshalJSON() ([]byte, error) {
+	if humanSizes {
+		return json.Marshal(n.String())
+	}
+	return json.Marshal(int64(n))
+}
+
+// String formats n in binary units with one decimal, e.g. 512 B, 1.5 KiB
+// or 3.2 GiB.
+func (n byteSize) String() string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", int64(n))
+	}
+	v := float64(n) / 1024
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// urlUnsafe encodes to "+/+/" in standard base64 and to "-_-_" in base64url.
+var urlUnsafe = []byte{0xfb, 0xff, 0xbf}
+
+func TestBlobEncodingRoundTrip(t *testing.T) {
+	t.Cleanup(func() { blobEncoding = base64.StdEncoding })
+	tests := []struct {
+		encoding string
+		text     string
+	}{
+		{"base64", `"Ticket":"+/+/"`},
+		{"base64url", `"Ticket":"-_-_"`},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		r := testRecord(1000, 1, 1)
+		r.CurrentSealTask.Ticket = urlUnsafe
+		in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{r}))
+		out := filepath.Join(dir, "out.json")
+
+		mustRun(t, "-in", in, "-out", out, "-blob-encoding", tt.encoding)
+		if text := readTestFile(t, out); !strings.Contains(text, tt.text) {
+			t.Errorf("-blob-encoding %s: the output lacks %s:\n%s", tt.encoding, tt.text, text)
+		}
+		if got := readJsonState(t, out)[0].CurrentSealTask.Ticket; !bytes.Equal(got, urlUnsafe) {
+			t.Errorf("-blob-encoding %s: loaded Ticket %x, want %x", tt.encoding, got, urlUnsafe)
+		}
+	}
+}
+
+func TestUnmarshalBlobAcceptsBothAlphabets(t *testing.T) {
+	for _, text := range []string{`"+/+/"`, `"-_-_"`} {
+		got, err := unmarshalBlob([]byte(text))
+		if err != nil || !bytes.Equal(got, urlUnsafe) {
+			t.Errorf("unmarshalBlob(%s) = %x, %v, want %x", text, got, err, urlUnsafe)
+		}
+	}
+	if got, err := unmarshalBlob([]byte("null")); got != nil || err != nil {
+		t.Errorf("unmarshalBlob(null) = %x, %v, want nil", got, err)
+	}
+	if _, err := unmarshalBlob([]byte(`"not base64!"`)); err == nil {
+		t.Error("unmarshalBlob accepted text in neither alphabet")
+	}
+}
+
+func TestSetBlobEncodingRejectsUnknown(t *testing.T) {
+	if err := setBlobEncoding("hex"); err == nil {
+		t.Error("setBlobEncoding accepted hex")
+	}
+}
+
+func TestOversizedBlobs(t *testing.T) {
+	big := testRecord(1000, 2, 1)
+	big.CurrentSealTask.Commit2Out = make([]byte, 200)
+	big.CurrentSealTask.Ticket = make([]byte, 100)
+	small := testRecord(1000, 1, 1)
+	small.CurrentSealTask.Commit2Out = make([]byte, 100)
+	data := recordMap([]SectorRecord{small, big})
+
+	got := oversizedBlobs(data, 100)
+	if len(got) != 1 || got[0].String() != "s-t01000-2 CurrentSealTask.Commit2Out is 200 bytes" {
+		t.Errorf("got %v, want Commit2Out of sector 2 only", got)
+	}
+	if got := oversizedBlobs(data, 99); len(got) != 3 {
+		t.Errorf("got %v, want the three blobs over 99 bytes", got)
+	}
+}
+
+func TestMaxBlobSize(t *testing.T) {
+	dir := tempDir(t)
+	big := testRecord(1000, 2, 1)
+	big.CurrentSealTask.Commit2Out = make([]byte, 2048)
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{testRecord(1000, 1, 1), big}))
+	out := filepath.Join(dir, "out.json")
+
+	for _, args := range [][]string{{}, {"-canonical"}} {
+		res := mustRun(t, append([]string{"-in", in, "-out", out, "-max-blob-size", "1024"}, args...)...)
+		if !strings.Contains(res.log, "oversized blob: s-t01000-2 CurrentSealTask.Commit2Out is 2048 bytes, over -max-blob-size 1024") {
+			t.Errorf("%q: the log lacks the warning:\n%s", args, res.log)
+		}
+		if got := readJsonState(t, out); len(got) != 2 {
+			t.Errorf("%q: a warning dropped records, got %d", args, len(got))
+		}
+	}
+
+	fatal := filepath.Join(dir, "fatal.json")
+	res := runTool(t, "-in", in, "-out", fatal, "-max-blob-size", "1024", "-max-blob-size-error")
+	if res.err == nil || res.err.Error() != "1 blob(s) exceed -max-blob-size 1024" {
+		t.Errorf("-max-blob-size-error: got %v", res.err)
+	}
+	if _, err := ioutil.ReadFile(fatal); err == nil {
+		t.Error("-max-blob-size-error still saved the output")
+	}
+
+	for _, args := range [][]string{{"-max-blob-size", "-1"}, {"-max-blob-size-error"}} {
+		if res := runTool(t, append([]string{"-in", in, "-out", out}, args...)...); errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	tests := []struct {
+		n    byteSize
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3<<30 + 200<<20, "3.2 GiB"},
+		{2048 << 40, "2048.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := tt.n.String(); got != tt.want {
+			t.Errorf("byteSize(%d) = %q, want %q", int64(tt.n), got, tt.want)
+		}
+	}
+}
+
+func TestBlobTotals(t *testing.T) {
+	a := testRecord(1000, 1, 3)
+	a.CurrentSealTask.Ticket = make([]byte, 32)
+	a.CurrentSealTask.PreCommit1Out = make([]byte, 1<<20)
+	b := testRecord(1000, 2, 5)
+	b.CurrentSealTask.Seed = make([]byte, 32)
+	b.CurrentSealTask.Commit2Out = make([]byte, 1<<20)
+	c := testRecord(1000, 3, 5)
+	c.CurrentSealTask.Commit1Out = make([]byte, 512)
+	st := computeStats(recordMap([]SectorRecord{c, b, a}))
+	if st.BlobBytes != 2<<20+64+512 {
+		t.Errorf("got %d blob bytes, want %d", st.BlobBytes, 2<<20+64+512)
+	}
+	// Sectors 1 and 2 tie, so the lower one is the largest.
+	if l := st.LargestRecord; l == nil || l.Sector != "s-t01000-1" || l.BlobBytes != 1<<20+32 {
+		t.Errorf("largest record %+v, want s-t01000-1 with %d bytes", l, 1<<20+32)
+	}
+	if st := computeStats(nil); st.BlobBytes != 0 || st.LargestRecord != nil {
+		t.Errorf("an empty state has %d blob bytes, largest %+v", st.BlobBytes, st.LargestRecord)
+	}
+
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap([]SectorRecord{c, b, a}))
+	res := mustRun(t, "-in", in, "-format", "stats-json", "-human-sizes")
+	for _, want := range []string{`"blobBytes":"2.0 MiB"`, `"largestRecord":{"sector":"s-t01000-1","blobBytes":"1.0 MiB"}`} {
+		if !strings.Contains(res.stdout, want) {
+			t.Errorf("-human-sizes output lacks %s:\n%s", want, res.stdout)
+		}
+	}
+	res = mustRun(t, "-in", in, "-format", "stats-json")
+	if !strings.Contains(res.stdout, `"blobBytes":2097728`) {
+		t.Errorf("without -human-sizes the sizes are not byte counts:\n%s", res.stdout)
+	}
+}
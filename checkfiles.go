@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// missingFile is a path field of a record naming nothing on disk, or a
+// path that could not be looked up for err.
+type missingFile struct {
+	field string
+	path  string
+	err   error
+}
+
+// missingFiles stats every path held by the records of data, using at most
+// concurrency goroutines, and returns the missing ones by sector. A path
+// shared by several records is looked up once.
+func missingFiles(ctx context.Context, data map[SectorID]SectorRecord, concurrency int) (map[SectorID][]missingFile, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	paths := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, r := range data {
+		for _, p := range recordPaths(r) {
+			if !seen[p.path] {
+				seen[p.path] = true
+				paths = append(paths, p.path)
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	statErrs := make(map[string]error, len(paths))
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				_, err := os.Stat(p)
+				if err != nil {
+					mu.Lock()
+					statErrs[p] = err
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+feed:
+	for _, p := range paths {
+		select {
+		case jobs <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	missing := make(map[SectorID][]missingFile)
+	for id, r := range data {
+		for _, p := range recordPaths(r) {
+			if err, ok := statErrs[p.path]; ok {
+				f := missingFile{field: p.field, path: p.path}
+				if !os.IsNotExist(err) {
+					f.err = err
+				}
+				missing[id] = append(missing[id], f)
+			}
+		}
+	}
+	return missing, nil
+}
+
+func printMissingFiles(w io.Writer, data map[SectorID]SectorRecord, missing map[SectorID][]missingFile) {
+	n := 0
+	for _, r := range sortedRecords(data) {
+		files := missing[r.SectorId]
+		if len(files) == 0 {
+			continue
+		}
+		parts := make([]string, 0, len(files))
+		for _, f := range files {
+			part := f.field + " " + f.path
+			if f.err != nil {
+				part += " (" + f.err.Error() + ")"
+			}
+			parts = append(parts, part)
+		}
+		fmt.Fprintf(w, "%s: missing %s\n", sectorName(r.SectorId), strings.Join(parts, ", "))
+		n += len(files)
+	}
+	fmt.Fprintf(w, "%d missing path(s) in %d sector(s)\n", n, len(missing))
+}
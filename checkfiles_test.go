@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// checkFilesRecords returns records of which sector 1 names only existing
+// paths, sectors 2 and 3 share a missing cache directory and sector 4 names
+// no paths, along with the missing cache directory.
+func checkFilesRecords(t *testing.T, dir string) (recordList []SectorRecord, missing string) {
+	t.Helper()
+	sealed := writeTestFile(t, dir, "s-t01000-1", []byte("sealed"))
+	cache := filepath.Join(dir, "cache")
+	if err := os.Mkdir(cache, 0700); err != nil {
+		t.Fatal(err)
+	}
+	missing = filepath.Join(dir, "gone")
+	recordList = testRecords(4)
+	recordList[0].P1SealedSectorPath = sealed
+	recordList[0].CurrentSealTask.CacheDirPath = cache
+	recordList[1].P1SealedSectorPath = filepath.Join(dir, "s-t01000-2")
+	recordList[1].C1CacheDirPath = missing
+	recordList[2].C1CacheDirPath = missing
+	recordList[2].CurrentFileTask.SourceCachePath = cache
+	return recordList, missing
+}
+
+func TestMissingFiles(t *testing.T) {
+	dir := tempDir(t)
+	recordList, missing := checkFilesRecords(t, dir)
+	data := recordMap(recordList)
+	want := map[SectorID][]missingFile{
+		{Miner: 1000, Number: 2}: {
+			{field: "P1SealedSectorPath", path: filepath.Join(dir, "s-t01000-2")},
+			{field: "C1CacheDirPath", path: missing},
+		},
+		{Miner: 1000, Number: 3}: {{field: "C1CacheDirPath", path: missing}},
+	}
+	for _, concurrency := range []int{0, 1, 8} {
+		got, err := missingFiles(context.Background(), data, concurrency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("concurrency %d: got %v, want %v", concurrency, got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := missingFiles(ctx, data, 2); err != context.Canceled {
+		t.Errorf("a cancelled check returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestCheckFiles(t *testing.T) {
+	dir := tempDir(t)
+	recordList, missing := checkFilesRecords(t, dir)
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+	res := runTool(t, "-in", in, "-out", out, "-check-files", "-concurrency", "3")
+	if res.code != 1 {
+		t.Errorf("missing files: exit status %d, want 1", res.code)
+	}
+	want := "s-t01000-2: missing P1SealedSectorPath " + filepath.Join(dir, "s-t01000-2") + ", C1CacheDirPath " + missing + "\n" +
+		"s-t01000-3: missing C1CacheDirPath " + missing + "\n" +
+		"3 missing path(s) in 2 sector(s)\n"
+	if res.stdout != want {
+		t.Errorf("got\n%swant\n%s", res.stdout, want)
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("-check-files saved the state")
+	}
+
+	in = writeGobState(t, dir, "present.gob", recordMap(recordList[:1]))
+	res = mustRun(t, "-in", in, "-check-files")
+	if !strings.Contains(res.stdout, "0 missing path(s) in 0 sector(s)") {
+		t.Errorf("with every path present got\n%s", res.stdout)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// dropBadCids returns raw with every CID of the record that does not decode
+// replaced by null, so it loads as cid.Undef, and the number replaced. Each
+// replacement is logged as a warning. -strict-json skips this and fails
+// the load instead.
+func dropBadCids(raw json.RawMessage) (json.RawMessage, int) {
+	var head struct{ SectorId SectorID }
+	json.Unmarshal(raw, &head)
+	n := 0
+	fixCid := func(field string) func(json.RawMessage) (json.RawMessage, error) {
+		return func(value json.RawMessage) (json.RawMessage, error) {
+			var c cid.Cid
+			if err := json.Unmarshal(value, &c); err != nil {
+				warnf("%s %s: dropping undecodable CID %s: %v", sectorName(head.SectorId), field, value, err)
+				n++
+				return json.RawMessage("null"), nil
+			}
+			return value, nil
+		}
+	}
+	fixed, err := replaceField(raw, []string{"CurrentSealTask", "PreCommit2Out", "Unsealed"}, fixCid("CurrentSealTask.PreCommit2Out.Unsealed"))
+	if err != nil {
+		return raw, 0
+	}
+	fixed, err = replaceField(fixed, []string{"CurrentSealTask", "PreCommit2Out", "Sealed"}, fixCid("CurrentSealTask.PreCommit2Out.Sealed"))
+	if err != nil {
+		return raw, 0
+	}
+	fixed, err = replaceField(fixed, []string{"CurrentSealTask", "Pieces"}, func(value json.RawMessage) (json.RawMessage, error) {
+		var pieces []json.RawMessage
+		if err := json.Unmarshal(value, &pieces); err != nil || pieces == nil {
+			return value, nil
+		}
+		for i := range pieces {
+			var err error
+			pieces[i], err = replaceField(pieces[i], []string{"PieceCID"}, fixCid(fmt.Sprintf("CurrentSealTask.Pieces.%d.PieceCID", i)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(pieces)
+	})
+	if err != nil {
+		return raw, 0
+	}
+	return fixed, n
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDropBadCids(t *testing.T) {
+	dir := tempDir(t)
+	piece, sealed := testCid(t, "piece"), testCid(t, "sealed")
+	recordList := testRecords(3)
+	recordList[1].CurrentSealTask.Pieces = []PieceInfo{{Size: 2048, PieceCID: piece}, {Size: 4096, PieceCID: piece}}
+	recordList[1].CurrentSealTask.PreCommit2Out.Sealed = sealed
+	recordList[2].CurrentSealTask.PreCommit2Out.Sealed = sealed
+	marshaled, err := json.Marshal(recordList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the second piece and the sealed CID of sector 2 only.
+	doc := string(marshaled)
+	second := strings.Index(doc, `"SectorId":{"Miner":1000,"Number":2}`)
+	third := strings.Index(doc, `"SectorId":{"Miner":1000,"Number":3}`)
+	record := doc[second:third]
+	at := strings.LastIndex(record, piece.String())
+	record = record[:at] + "notacid" + record[at+len(piece.String()):]
+	record = strings.Replace(record, sealed.String(), "bafynotacid", 1)
+	in := writeTestFile(t, dir, "in.json", []byte(doc[:second]+record+doc[third:]))
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", in, "-out", out)
+	for _, want := range []string{
+		"s-t01000-2 CurrentSealTask.PreCommit2Out.Sealed: dropping undecodable CID",
+		"s-t01000-2 CurrentSealTask.Pieces.1.PieceCID: dropping undecodable CID",
+	} {
+		if !strings.Contains(res.log, want) {
+			t.Errorf("the log lacks %q:\n%s", want, res.log)
+		}
+	}
+	if strings.Contains(res.log, "Pieces.0") {
+		t.Errorf("a valid CID was dropped:\n%s", res.log)
+	}
+	got := readJsonState(t, out)
+	if len(got) != 3 {
+		t.Fatalf("got %d records, want 3", len(got))
+	}
+	task := got[1].CurrentSealTask
+	if task.PreCommit2Out.Sealed.Defined() || task.Pieces[1].PieceCID.Defined() {
+		t.Errorf("the undecodable CIDs were kept: %+v", task)
+	}
+	if !task.Pieces[0].PieceCID.Equals(piece) || task.Pieces[1].Size != 4096 || got[1].P1WorkerAddress != "10.0.0.1:3456" {
+		t.Errorf("repairing sector 2 lost other fields: %+v", got[1])
+	}
+	if !got[2].CurrentSealTask.PreCommit2Out.Sealed.Equals(sealed) {
+		t.Error("the CID of an intact record was changed")
+	}
+
+	if res := runTool(t, "-in", in, "-out", out, "-strict-json"); res.err == nil || !strings.Contains(res.err.Error(), "s-t01000-2") {
+		t.Errorf("-strict-json: got %v, want the bad record rejected", res.err)
+	}
+}
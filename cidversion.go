@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// mhSha2_256 is the multihash code of sha2-256, the only hash CIDv0 allows.
+const mhSha2_256 = 0x12
+
+// convertCid returns c as a CID of version v. Only dag-pb CIDs hashed with
+// sha2-256 have a version 0 form; converting others to version 0 fails.
+func convertCid(c cid.Cid, v uint64) (cid.Cid, error) {
+	if !c.Defined() || c.Version() == v {
+		return c, nil
+	}
+	switch v {
+	case 0:
+		if c.Type() != cid.DagProtobuf {
+			return c, fmt.Errorf("%s: codec 0x%x has no CIDv0 form", c, c.Type())
+		}
+		if p := c.Prefix(); p.MhType != mhSha2_256 {
+			return c, fmt.Errorf("%s: hash 0x%x has no CIDv0 form", c, p.MhType)
+		}
+		return cid.NewCidV0(c.Hash()), nil
+	case 1:
+		return cid.NewCidV1(c.Type(), c.Hash()), nil
+	}
+	return c, fmt.Errorf("unknown CID version %d", v)
+}
+
+// setCidVersion converts the piece and sealing CIDs of every record to
+// version v, warning about the ones that cannot be converted. It returns
+// the number of CIDs changed.
+func (s *State) setCidVersion(v uint64) int {
+	n := 0
+	for id := range s.state {
+		r := s.state[id]
+		changed := false
+		update := func(field string, c *cid.Cid) {
+			conv, err := convertCid(*c, v)
+			if err != nil {
+				warnf("%s %s: %v", sectorName(id), field, err)
+				return
+			}
+			if conv.Equals(*c) {
+				return
+			}
+			s.audit.changed(id, field, c.String(), conv.String())
+			*c = conv
+			changed = true
+			n++
+		}
+		task := &r.CurrentSealTask
+		task.Pieces = append([]PieceInfo(nil), task.Pieces...)
+		for i := range task.Pieces {
+			update(fmt.Sprintf("CurrentSealTask.Pieces.%d.PieceCID", i), &task.Pieces[i].PieceCID)
+		}
+		update("CurrentSealTask.PreCommit2Out.Unsealed", &task.PreCommit2Out.Unsealed)
+		update("CurrentSealTask.PreCommit2Out.Sealed", &task.PreCommit2Out.Sealed)
+		if changed {
+			s.updateSectorRecord(r)
+		}
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+const testCidV0 = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+
+func TestConvertCid(t *testing.T) {
+	v0, err := cid.Decode(testCidV0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := convertCid(v0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.Version() != 1 || v1.Type() != cid.DagProtobuf || !strings.HasPrefix(v1.String(), "bafy") || v1.Hash().B58String() != v0.Hash().B58String() {
+		t.Errorf("converted %s to %s, want the CIDv1 of the same dag-pb hash", v0, v1)
+	}
+	back, err := convertCid(v1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.String() != testCidV0 {
+		t.Errorf("converted %s back to %s, want %s", v1, back, testCidV0)
+	}
+	if same, err := convertCid(v0, 0); err != nil || !same.Equals(v0) {
+		t.Errorf("converting a CIDv0 to version 0 gave %s, %v", same, err)
+	}
+	if undef, err := convertCid(cid.Undef, 0); err != nil || undef.Defined() {
+		t.Errorf("converting the undefined CID gave %s, %v", undef, err)
+	}
+	if _, err := convertCid(testCid(t, "raw"), 0); err == nil {
+		t.Error("a raw-codec CID was converted to version 0")
+	}
+	if _, err := convertCid(v0, 2); err == nil {
+		t.Error("CID version 2 was accepted")
+	}
+}
+
+func TestCidVersionFlag(t *testing.T) {
+	dir := tempDir(t)
+	v0, err := cid.Decode(testCidV0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRecord(1000, 1, 1)
+	r.CurrentSealTask.Pieces[0].PieceCID = v0
+	r.CurrentSealTask.PreCommit2Out = SectorCids{Unsealed: v0, Sealed: testCid(t, "sealed")}
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{r}))
+	v1 := filepath.Join(dir, "v1.json")
+	out := filepath.Join(dir, "v0.json")
+
+	mustRun(t, "-in", in, "-out", v1, "-cid-version", "1")
+	task := readJsonState(t, v1)[0].CurrentSealTask
+	if task.Pieces[0].PieceCID.Version() != 1 || task.PreCommit2Out.Unsealed.Version() != 1 {
+		t.Errorf("-cid-version 1 wrote %s and %s", task.Pieces[0].PieceCID, task.PreCommit2Out.Unsealed)
+	}
+
+	res := mustRun(t, "-in", v1, "-out", out, "-cid-version", "0")
+	task = readJsonState(t, out)[0].CurrentSealTask
+	if task.Pieces[0].PieceCID.String() != testCidV0 || task.PreCommit2Out.Unsealed.String() != testCidV0 {
+		t.Errorf("-cid-version 0 wrote %s and %s, want %s", task.Pieces[0].PieceCID, task.PreCommit2Out.Unsealed, testCidV0)
+	}
+	if !task.PreCommit2Out.Sealed.Equals(testCid(t, "sealed")) {
+		t.Errorf("the unconvertible Sealed CID became %s, want it kept", task.PreCommit2Out.Sealed)
+	}
+	if !strings.Contains(res.log, "s-t01000-1 CurrentSealTask.PreCommit2Out.Sealed: ") || warnings != 1 {
+		t.Errorf("got %d warning(s), want one for the Sealed CID:\n%s", warnings, res.log)
+	}
+}
+
+func TestCidsWrittenAsBase32(t *testing.T) {
+	dir := tempDir(t)
+	piece, sealed := testCid(t, "piece"), testCid(t, "sealed")
+	r := testRecord(1000, 1, 1)
+	r.CurrentSealTask.Pieces = []PieceInfo{{Size: 2048, PieceCID: piece}}
+	r.CurrentSealTask.PreCommit2Out.Sealed = sealed
+	doc := readTestFile(t, writeJsonState(t, dir, "in.json", []SectorRecord{r}))
+	// Store the piece CID in base58btc and the sealed CID in uppercase
+	// base32; the multibase prefixes are z and B.
+	base58, err := piece.StringOfBase('z')
+	if err != nil {
+		t.Fatal(err)
+	}
+	upper, err := sealed.StringOfBase('B')
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = strings.Replace(doc, piece.String(), base58, 1)
+	doc = strings.Replace(doc, sealed.String(), upper, 1)
+	if !strings.Contains(doc, `"`+base58+`"`) || !strings.Contains(doc, `"`+upper+`"`) {
+		t.Fatalf("the input lacks the re-encoded CIDs:\n%s", doc)
+	}
+	in := writeTestFile(t, dir, "in.json", []byte(doc))
+	out := filepath.Join(dir, "out.json")
+
+	for _, args := range [][]string{{}, {"-canonical"}} {
+		mustRun(t, append([]string{"-in", in, "-out", out}, args...)...)
+		got := readTestFile(t, out)
+		for _, want := range []string{`{"/":"` + piece.String() + `"}`, `{"/":"` + sealed.String() + `"}`} {
+			if !strings.Contains(got, want) {
+				t.Errorf("%q: the output lacks the lowercase base32 %s:\n%s", args, want, got)
+			}
+		}
+		if strings.Contains(got, base58) || strings.Contains(got, upper) {
+			t.Errorf("%q: the output keeps the stored encodings:\n%s", args, got)
+		}
+	}
+}
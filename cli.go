@@ -0,0 +1,947 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const defaultStatePath = "~/.lotus_scheduler/state_data"
+
+// options holds the parsed command line.
+type options struct {
+	inputs     []string
+	out        string
+	inPattern  string
+	inFormats  string
+	implodeDir string
+	// noFollowLinks keeps symlinked -in and -out paths as given.
+	noFollowLinks bool
+	ssh           sshOptions
+	dirMode       string
+	concurrency   int
+	mmap          bool
+	strictJson    bool
+	nonFinite     string
+	format        string
+	store         storeOptions
+	merge         mergeOptions
+
+	blobEncoding  string
+	keyFormat     string
+	padNumbers    int
+	extension     string
+	renameFields  string
+	strategy      string
+	onDuplicate   string
+	selectFields  string
+	excludeFields string
+	logLevel      string
+	errorFormat   string
+	auditPath     string
+	comparePath   string
+	pointer       string
+	pointerSet    bool
+	baselinePath  string
+	tombstones    bool
+	taskType      string
+	where         string
+	noErrors      bool
+	errorsOnly    bool
+	miner         optionalUint
+	numberMin     optionalUint
+	numberMax     optionalUint
+	sectorsFile   string
+	// sectorList holds the sectors read from sectorsFile.
+	sectorList       []SectorID
+	filters          []recordFilter
+	limit            int
+	maxBlobSize      int
+	blobSizeFatal    bool
+	groupBy          string
+	humanSizes       bool
+	bigintsAsStrings bool
+	forceTypeCompat  bool
+	groupKeys        []func(r SectorRecord) string
+
+	flatten       bool
+	preserveOrder bool
+	keepEmpty     bool
+	trimErrMsg    int
+	cidVersion    int
+	pruneDone     bool
+	touchUpdated  bool
+	stripUUIDs    bool
+	redactConfig  string
+	redaction     *redaction
+	applyPhases   string
+	phaseChanges  []phaseChange
+	emptyPieces   bool
+	checkCids     bool
+	fixCids       bool
+	allowEmpty    bool
+
+	serveAddr    string
+	dumpTypes    bool
+	check        bool
+	validateOnly bool
+	countOnly    bool
+	upgrade      bool
+	keepUnknown  bool
+	checkFiles   bool
+	dupPieces    bool
+	sharedPieces string
+	allowShared  map[string]bool
+	clusterTasks bool
+	workers      bool
+	workerCounts bool
+	snapshot     string
+	restore      string
+	listSnaps    bool
+	snapshotDir  string
+	explain      bool
+	summary      bool
+	failOnWarn   bool
+}
+
+// exitError ends the run with a specific exit code and no further message.
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// Environment variables supplying defaults for the flags of the same name.
+// A flag given on the command line wins over its variable, which wins over
+// the built-in default.
+const (
+	envIn       = "STATE_IN"
+	envOut      = "STATE_OUT"
+	envFormat   = "STATE_FORMAT"
+	envLogLevel = "STATE_LOG_LEVEL"
+)
+
+func envDefault(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func parseFlags(args []string) (options, error) {
+	var opts options
+	var inputs, tees stringsFlag
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
+		fmt.Fprintf(fs.Output(), "\n%s (comma separated), %s, %s and %s provide defaults for -in, -out, -format\nand -log-level; flags on the command line take precedence over them.\n",
+			envIn, envOut, envFormat, envLogLevel)
+	}
+	fs.Var(&inputs, "in", "state file or directory to load, or an sftp://user@host:/path URL to download; repeat to merge several, later files win on duplicate sectors (default "+defaultStatePath+")")
+	fs.StringVar(&opts.out, "out", envDefault(envOut, ""), "file to write the JSON state to, or the directory for -dir-mode each (default: the first -in)")
+	fs.Var(&tees, "tee", "also write the json, map-json, jsonl or ndjson output to this file, or to stdout for -; repeat for several. The file copies are written to temporary files first, so a copy that cannot be written stops the save before -out is touched, and are renamed into place once -out is written")
+	fs.StringVar(&opts.inPattern, "in-pattern", "*", "glob pattern selecting the files of an -in directory")
+	fs.StringVar(&opts.inFormats, "input-format", "auto", "comma-separated formats of the -in files in order, each auto, gob or json; the last repeats for the remaining -in, and gzip is always detected")
+	fs.StringVar(&opts.dirMode, "dir-mode", "merge", "how to handle -in directories: merge all files into one output, or each to convert every file separately")
+	fs.StringVar(&opts.ssh.keyFile, "ssh-key", "", "private key file for sftp:// inputs, tried after the keys of the ssh agent at SSH_AUTH_SOCK")
+	fs.StringVar(&opts.ssh.knownHosts, "ssh-known-hosts", "~/.ssh/known_hosts", "known_hosts file verifying the host keys of sftp:// inputs")
+	fs.BoolVar(&opts.noFollowLinks, "no-follow-symlinks", false, "use symlinked -in and -out paths as given instead of resolving them, so converting a symlinked state file in place replaces the link with a regular file")
+	fs.BoolVar(&opts.mmap, "mmap", false, "memory-map input files instead of reading them onto the heap (ignored where unsupported)")
+	fs.IntVar(&opts.concurrency, "concurrency", runtime.NumCPU(), "maximum number of input files decoded in parallel")
+	fs.StringVar(&opts.format, "format", envDefault(envFormat, "json"), "output format: json, jsonl (or ndjson) with one record per line, map-json for an object keyed by -key-format, properties for write-only sector.<key>.<field>=<value> lines without blobs, sqlite for a write-only SQLite database with sectors and pieces tables, table to print a summary to stdout without saving, dot to print a Graphviz graph of sectors and their workers by phase to stdout without saving, or stats-json to print record counts as one JSON object")
+	fs.StringVar(&opts.keyFormat, "key-format", defaultKeyFormat, "layout of sector keys in map-json and of -explode and blob sidecar file names, using the {miner} and {number} placeholders")
+	fs.IntVar(&opts.padNumbers, "pad-numbers", 0, "zero-pad sector numbers in -key-format keys and file names to this many digits so they sort lexically")
+	fs.BoolVar(&opts.store.appendLines, "append", false, "append to an existing jsonl/ndjson -out, skipping sectors it already holds")
+	fs.StringVar(&opts.store.blobDir, "externalize-blobs", "", "write non-empty PreCommit1Out and Commit2Out blobs to sidecar files in this directory, which must be inside that of -out, and reference them from the JSON")
+	fs.BoolVar(&opts.strictJson, "strict-json", false, "fail on JSON input records with fields SectorRecord does not have or undecodable CIDs, instead of ignoring the fields and clearing the CIDs")
+	fs.StringVar(&opts.nonFinite, "non-finite", "error", "what to do with NaN and infinite floats, which JSON cannot represent: error to fail naming the field, or null to write null with a warning")
+	fs.StringVar(&opts.renameFields, "rename-fields", "", "comma-separated old=new record field names, e.g. NVMESealedPath=P2SealedSectorPath, renamed in JSON inputs before decoding")
+	fs.StringVar(&opts.extension, "record-extension", "", "decode and keep the fork-specific record fields registered under this name")
+	fs.IntVar(&opts.store.bufferSize, "output-buffer-size", 64*1024, "write buffer in bytes for streamed output such as -flatten")
+	fs.IntVar(&opts.store.splitSize, "split-size", 0, "split jsonl/ndjson output into <out>.001, <out>.002, ... of at most this many bytes each, never splitting a record; 0 disables")
+	fs.BoolVar(&opts.store.withCount, "with-count", false, "start jsonl/ndjson and -flatten output with a {\"@count\": N} line giving the number of lines that follow")
+	fs.IntVar(&opts.maxBlobSize, "max-blob-size", 0, "warn about records with a proof or randomness blob larger than this many bytes; 0 disables")
+	fs.BoolVar(&opts.blobSizeFatal, "max-blob-size-error", false, "fail instead of warning when a blob exceeds -max-blob-size")
+	fs.BoolVar(&opts.store.canonical, "canonical", false, "write JSON with object keys sorted at every level")
+	fs.BoolVar(&opts.store.versioned, "versioned", false, "wrap the saved records in an object with version and generatedAt metadata")
+	fs.StringVar(&opts.logLevel, "log-level", envDefault(envLogLevel, "info"), "lowest level of messages logged to stderr: debug, info, warn or error")
+	fs.StringVar(&opts.errorFormat, "error-format", "text", "how a fatal error is reported: text, or json for an {\"error\", \"type\"} object on stderr")
+	fs.StringVar(&opts.blobEncoding, "blob-encoding", "base64", "encoding of byte fields in JSON output: base64 or base64url")
+	fs.StringVar(&opts.selectFields, "select", "", "comma-separated field paths (e.g. SectorId,CurrentSealTask.TaskType) to keep in the JSON output")
+	fs.StringVar(&opts.excludeFields, "exclude-fields", "", "comma-separated field paths (e.g. CurrentSealTask.Commit2Out) to leave out of the JSON output")
+	fs.BoolVar(&opts.store.skipBadRecords, "skip-bad-records", false, "leave out records that fail to encode instead of aborting the save")
+	fs.StringVar(&opts.onDuplicate, "on-duplicate", string(duplicateKeepLast), "which record wins when a slice-shaped gob input holds several for one sector: keep-first, keep-last, keep-highest-phase, or error to fail")
+	fs.StringVar(&opts.strategy, "merge-strategy", string(mergeLastWins), "which record wins when several -in hold the same sector: last, newest by UpdatedAt then phase, or error to fail on differing records")
+	fs.BoolVar(&opts.merge.reportDuplicates, "report-duplicates-across-files", false, "list the sectors present in more than one -in, with their files and whether the copies are identical, before merging")
+	fs.BoolVar(&opts.merge.rejectPhaseRegression, "require-phase-monotonic", false, "fail when a later -in moves a sector to an earlier phase instead of only reporting it")
+	fs.IntVar(&opts.limit, "limit", 0, "stop loading each input after this many records and write at most this many, taken before filtering in input order; map-shaped input has no order, so its lowest sector IDs are taken; 0 means no limit")
+	fs.Var(&opts.miner, "miner", "keep only the sectors of this miner actor ID")
+	fs.Var(&opts.numberMin, "number-min", "keep only sectors numbered at least this, inclusive")
+	fs.Var(&opts.numberMax, "number-max", "keep only sectors numbered at most this, inclusive")
+	fs.StringVar(&opts.sectorsFile, "sectors-from-file", "", "keep only the sectors listed in this file, one per line as miner/number or a name like s-t01000-3, warning about those not in the input")
+	fs.IntVar(&opts.cidVersion, "cid-version", -1, "convert piece and sealing CIDs to this version, 0 or 1, where possible")
+	fs.StringVar(&opts.where, "where", "", "keep only records matching this expression over phase, miner, number, finalized, tasktype and worker, e.g. 'phase>3 && finalized==false'; see where.go for the grammar")
+	fs.BoolVar(&opts.noErrors, "no-errors", false, "drop the records whose current seal or file task has a non-empty ErrMsg, for a working set without failed sectors; cannot be combined with -errors-only")
+	fs.BoolVar(&opts.errorsOnly, "errors-only", false, "keep only the records whose current seal or file task has a non-empty ErrMsg; cannot be combined with -no-errors")
+	fs.StringVar(&opts.taskType, "tasktype", "", "keep only sectors whose current seal task has this type, given as the raw string or a constant name like TTCommit2")
+	fs.StringVar(&opts.auditPath, "audit", "", "write a JSON log of every change made to the records to this file")
+	fs.StringVar(&opts.store.explodeDir, "explode", "", "write each record to <dir>/<sector>.json in this directory instead of one state file")
+	fs.StringVar(&opts.implodeDir, "implode", "", "load the per-sector *.json files of this directory, as written by -explode, instead of -in; requires -out")
+	fs.BoolVar(&opts.store.force, "force", false, "let -explode overwrite existing sector files")
+	fs.BoolVar(&opts.preserveOrder, "preserve-order", false, "write records in the order of array and JSON Lines inputs instead of sector order; records of gob inputs follow in sector order")
+	fs.BoolVar(&opts.flatten, "flatten", false, "write one JSON line per piece with the sector fields repeated, for analytics loads; requires -out")
+	fs.BoolVar(&opts.allowEmpty, "allow-empty", false, "write an output without records even over an existing non-empty file")
+	fs.BoolVar(&opts.keepEmpty, "keep-empty-records", false, "keep records whose SectorId is the zero value instead of dropping them")
+	fs.IntVar(&opts.trimErrMsg, "trim-errmsg", 0, "truncate task ErrMsg fields to N characters before saving (0 disables)")
+	fs.BoolVar(&opts.checkCids, "check-sector-cids", false, "warn about records whose PreCommit2Out CIDs are swapped or disagree with the CID of a piece filling the whole sector")
+	fs.BoolVar(&opts.fixCids, "fix", false, "with -check-sector-cids, repair those records instead of warning: swap the CIDs back, or copy the piece CID, which is authoritative, into PreCommit2Out.Unsealed")
+	fs.BoolVar(&opts.emptyPieces, "include-empty-pieces", false, "write CurrentSealTask.Pieces of records without pieces as null or [], instead of leaving the field out")
+	fs.StringVar(&opts.redactConfig, "redact-config", "", "JSON or YAML file of per-field redaction rules (hash, mask, drop or keep-prefix-N) for the string fields of the records")
+	fs.StringVar(&opts.applyPhases, "apply-phases", "", "set the SectorWorkingPhase of the sectors listed in this CSV file of sector,phase lines, the sector as miner/number or a name like s-t01000-3, before saving")
+	fs.BoolVar(&opts.stripUUIDs, "strip-uuids", false, "set the CurrentFileTask.ID of every record to the nil UUID, for reproducible exports")
+	fs.BoolVar(&opts.touchUpdated, "touch-updatedat", false, "set the UpdatedAt of every record to the time of the run, e.g. to mark a migration")
+	fs.BoolVar(&opts.pruneDone, "prune-done-filetasks", false, "blank the path fields of finished file tasks")
+	fs.BoolVar(&opts.store.progress, "progress", false, "log the progress of encoding the records every second, with the records per second and an estimate of the time remaining")
+	fs.IntVar(&opts.store.rate, "rate", 0, "write jsonl/ndjson or -flatten output, or stream /sectors in -serve mode, at most this many records per second; 0 is unlimited")
+	fs.StringVar(&opts.serveAddr, "serve", "", "serve the loaded state read-only over HTTP on this address instead of converting")
+	fs.BoolVar(&opts.dumpTypes, "dump-gob-types", false, "print the type structure encoded in each gob -in file and exit")
+	fs.BoolVar(&opts.check, "check", false, "only test that the inputs load, printing OK and the record count, and exit non-zero on failure")
+	fs.BoolVar(&opts.forceTypeCompat, "force-type-compat", false, "load gob inputs whose field types differ from this version's in known-safe ways: signed for unsigned integers and back, byte arrays for byte slices and back, strings for byte slices and back, and bytes or strings for UUIDs and CIDs; each substitution is a warning, and a field the producer meant differently loads wrong, see typecompat.go")
+	fs.BoolVar(&opts.bigintsAsStrings, "bigints-as-strings", false, "write the uint64 miner IDs and sector numbers as quoted strings, which JavaScript reads without losing precision above 2^53; both forms always load")
+	fs.BoolVar(&opts.humanSizes, "human-sizes", false, "write the byte sizes of -format stats-json in KiB, MiB and GiB instead of bytes")
+	fs.StringVar(&opts.groupBy, "group-by", "", "print record counts as JSON grouped by these comma-separated fields, nested in order: phase, tasktype, miner, worker or finalized; exits without saving")
+	fs.BoolVar(&opts.keepUnknown, "keep-unknown-fields", false, "carry top-level members of JSON records that this version does not know through to the output instead of dropping them; nested ones are still dropped")
+	fs.BoolVar(&opts.upgrade, "upgrade", false, "load the output of older versions of this tool, rewriting string and empty CIDs, byte-array blobs and removed fields and reporting each change, and warn about the records -validate would flag")
+	fs.BoolVar(&opts.countOnly, "count-only", false, "print the number of records stored in each -in without decoding them, and exit")
+	fs.BoolVar(&opts.validateOnly, "validate", false, "check the loaded state for inconsistent records and exit without saving")
+	fs.BoolVar(&opts.checkFiles, "check-files", false, "check that every non-empty path field names an existing file or directory, stating up to -concurrency paths at once, list the missing ones by sector and exit without saving")
+	fs.BoolVar(&opts.dupPieces, "duplicate-pieces", false, "list the piece CIDs held by more than one sector and exit without saving, non-zero if there are any")
+	fs.StringVar(&opts.sharedPieces, "allow-shared-pieces", "", "comma-separated piece CIDs, such as filler pieces, that -duplicate-pieces does not report")
+	fs.BoolVar(&opts.clusterTasks, "collapse-identical-tasks", false, "report groups of sectors whose current seal tasks are identical apart from sector ID and blobs, and exit without saving")
+	fs.BoolVar(&opts.workers, "workers", false, "list the distinct non-empty P1, P2, C1 and C2 worker addresses of the records and exit without saving")
+	fs.BoolVar(&opts.workerCounts, "worker-counts", false, "with -workers, follow each address with a tab and the number of sectors referencing it")
+	fs.StringVar(&opts.snapshot, "snapshot", "", "copy the first -in as it is into the snapshot directory under this label and a timestamp, and exit")
+	fs.StringVar(&opts.restore, "restore", "", "replace -out, or the first -in, with the newest snapshot of this label, or the snapshot of this exact label@time name, and exit")
+	fs.BoolVar(&opts.listSnaps, "list-snapshots", false, "list the snapshots in the snapshot directory, oldest first, and exit")
+	fs.StringVar(&opts.snapshotDir, "snapshot-dir", "", "directory of -snapshot, -restore and -list-snapshots (default: snapshots next to the first -in)")
+	fs.StringVar(&opts.baselinePath, "baseline", "", "write only the records that are new or changed compared with this state file")
+	fs.BoolVar(&opts.tombstones, "tombstones", false, "with -baseline, also write a {\"SectorId\": ..., \"@deleted\": true} entry for every baseline sector no longer present")
+	fs.StringVar(&opts.pointer, "pointer", "", "print the value this RFC 6901 JSON pointer, e.g. /0/CurrentSealTask/PreCommit2Out/Sealed, selects in the json or map-json output, instead of saving")
+	fs.StringVar(&opts.comparePath, "compare", "", "convert in memory and compare the records with this golden state file instead of saving; exits non-zero listing the differing sectors on mismatch")
+	fs.BoolVar(&opts.failOnWarn, "fail-on-warning", false, "exit 1 if the run logged any warning, such as a warning-level -validate issue, a phase regression or an oversized blob, even when it otherwise succeeded")
+	fs.BoolVar(&opts.summary, "summary", false, "end the run with one line on stderr: summary duration_ms= read= written= dropped= bytes= format= status=")
+	fs.BoolVar(&opts.explain, "explain", false, "print a plain description of the steps about to run to stderr, then run them")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "pointer" {
+			opts.pointerSet = true
+		}
+	})
+
+	if len(inputs) == 0 {
+		if v := envDefault(envIn, ""); v != "" {
+			inputs = strings.Split(v, ",")
+		} else {
+			inputs = stringsFlag{defaultStatePath}
+		}
+	}
+	for _, in := range inputs {
+		if isRemoteInput(in) {
+			opts.inputs = append(opts.inputs, in)
+			continue
+		}
+		p, err := getAbsPath(in)
+		if err != nil {
+			return opts, err
+		}
+		if !opts.noFollowLinks {
+			p = resolveSymlinks(p)
+		}
+		opts.inputs = append(opts.inputs, p)
+	}
+	for _, t := range tees {
+		if t != teeStdout {
+			p, err := getAbsPath(t)
+			if err != nil {
+				return opts, err
+			}
+			t = p
+		}
+		opts.store.tee = append(opts.store.tee, t)
+	}
+	if opts.out != "" {
+		p, err := getAbsPath(opts.out)
+		if err != nil {
+			return opts, err
+		}
+		if !opts.noFollowLinks {
+			p = resolveSymlinks(p)
+		}
+		opts.out = p
+	}
+	if opts.implodeDir != "" {
+		p, err := getAbsPath(opts.implodeDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.implodeDir = p
+	}
+	if opts.store.explodeDir != "" {
+		p, err := getAbsPath(opts.store.explodeDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.store.explodeDir = p
+	}
+	if opts.store.blobDir != "" {
+		p, err := getAbsPath(opts.store.blobDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.store.blobDir = p
+	}
+	if opts.auditPath != "" {
+		p, err := getAbsPath(opts.auditPath)
+		if err != nil {
+			return opts, err
+		}
+		opts.auditPath = p
+	}
+	if opts.comparePath != "" {
+		p, err := getAbsPath(opts.comparePath)
+		if err != nil {
+			return opts, err
+		}
+		opts.comparePath = p
+	}
+	if opts.baselinePath != "" {
+		p, err := getAbsPath(opts.baselinePath)
+		if err != nil {
+			return opts, err
+		}
+		opts.baselinePath = p
+	}
+	if opts.snapshotDir != "" {
+		p, err := getAbsPath(opts.snapshotDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.snapshotDir = p
+	}
+	return opts, nil
+}
+
+func run(opts options) (err error) {
+	defer recoverPanic(&err)
+	if err := setLogLevel(opts.logLevel); err != nil {
+		return err
+	}
+	if opts.errorFormat != "text" && opts.errorFormat != "json" {
+		return usageErrorf("unknown -error-format %q", opts.errorFormat)
+	}
+	switch opts.format {
+	case "json", "table", "stats-json", "dot":
+	case "properties", "sqlite":
+		if opts.store.versioned || opts.flatten || opts.tombstones || opts.pointerSet {
+			return usageErrorf("-format %s cannot be combined with -versioned, -flatten, -tombstones or -pointer", opts.format)
+		}
+	case "jsonl", "ndjson":
+		if opts.store.versioned {
+			return usageErrorf("-versioned cannot be combined with -format %s", opts.format)
+		}
+		opts.store.lines = true
+	case "map-json":
+		if opts.store.versioned {
+			return usageErrorf("-versioned cannot be combined with -format map-json")
+		}
+		opts.store.keyed = true
+	default:
+		return usageErrorf("unknown -format %q", opts.format)
+	}
+	if opts.store.appendLines && !opts.store.lines {
+		return usageErrorf("-append requires -format jsonl or ndjson")
+	}
+	if opts.store.withCount && !opts.store.lines && !opts.flatten {
+		return usageErrorf("-with-count requires -format jsonl or ndjson, or -flatten")
+	}
+	if opts.store.splitSize < 0 {
+		return usageErrorf("-split-size must not be negative")
+	}
+	if opts.store.splitSize > 0 && (!opts.store.lines || opts.store.appendLines || opts.store.withCount) {
+		return usageErrorf("-split-size requires -format jsonl or ndjson and cannot be combined with -append or -with-count")
+	}
+	if opts.store.withCount && opts.store.appendLines {
+		return usageErrorf("-with-count cannot be combined with -append, the count would go stale")
+	}
+	if opts.cidVersion != -1 && opts.cidVersion != 0 && opts.cidVersion != 1 {
+		return usageErrorf("-cid-version must be 0 or 1")
+	}
+	if opts.store.explodeDir != "" && (opts.format != "json" || opts.store.versioned || opts.flatten) {
+		return usageErrorf("-explode writes plain JSON records and cannot be combined with -format %s, -versioned or -flatten", opts.format)
+	}
+	if opts.store.rate < 0 {
+		return usageErrorf("-rate must not be negative")
+	}
+	if opts.store.rate > 0 && opts.serveAddr == "" && !opts.flatten && (!opts.store.lines || opts.store.splitSize > 0) {
+		return usageErrorf("-rate requires -format jsonl or ndjson without -split-size, -flatten, or -serve")
+	}
+	if opts.store.bufferSize < 1 {
+		return usageErrorf("-output-buffer-size must be positive")
+	}
+	if opts.maxBlobSize < 0 {
+		return usageErrorf("-max-blob-size must not be negative")
+	}
+	if opts.blobSizeFatal && opts.maxBlobSize == 0 {
+		return usageErrorf("-max-blob-size-error requires -max-blob-size")
+	}
+	if opts.workerCounts && !opts.workers {
+		return usageErrorf("-worker-counts requires -workers")
+	}
+	if opts.limit < 0 {
+		return usageErrorf("-limit must not be negative")
+	}
+	if opts.pointerSet && (opts.store.lines || opts.store.explodeDir != "" || opts.flatten || opts.store.blobDir != "" || opts.comparePath != "") {
+		return usageErrorf("-pointer looks into a json or map-json document and cannot be combined with -format %s, -explode, -flatten, -externalize-blobs or -compare", opts.format)
+	}
+	if opts.fixCids && !opts.checkCids {
+		return usageErrorf("-fix requires -check-sector-cids")
+	}
+	if opts.tombstones && opts.baselinePath == "" {
+		return usageErrorf("-tombstones requires -baseline")
+	}
+	if opts.tombstones && (opts.flatten || opts.store.explodeDir != "") {
+		return usageErrorf("-tombstones cannot be combined with -flatten or -explode")
+	}
+	if opts.baselinePath != "" && opts.comparePath != "" {
+		return usageErrorf("-baseline cannot be combined with -compare")
+	}
+	if opts.comparePath != "" && (opts.flatten || opts.store.explodeDir != "" || opts.selectFields != "" || opts.excludeFields != "") {
+		return usageErrorf("-compare checks whole records and cannot be combined with -flatten, -explode, -select or -exclude-fields")
+	}
+	if len(opts.store.tee) > 0 && (opts.store.explodeDir != "" || opts.flatten || opts.store.splitSize > 0 || opts.store.appendLines || opts.dirMode == "each" ||
+		(opts.format != "json" && opts.format != "map-json" && !opts.store.lines)) {
+		return usageErrorf("-tee copies one json, map-json, jsonl or ndjson output and cannot be combined with -format %s, -explode, -flatten, -split-size, -append or -dir-mode each", opts.format)
+	}
+	keys, err := newKeyFormat(opts.keyFormat)
+	if err != nil {
+		return err
+	}
+	if opts.padNumbers < 0 || opts.padNumbers > 20 {
+		return usageErrorf("-pad-numbers must be between 0 and 20")
+	}
+	keys.pad = opts.padNumbers
+	sectorKeyFormat = keys
+	opts.merge.strategy, err = parseMergeStrategy(opts.strategy)
+	if err != nil {
+		return err
+	}
+	opts.merge.onDuplicate, err = parseDuplicatePolicy(opts.onDuplicate)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	opts.store.fields, err = newFieldProjection(opts.selectFields, opts.excludeFields)
+	if err != nil {
+		return err
+	}
+	if opts.sectorsFile != "" {
+		if opts.sectorList, err = readSectorList(opts.sectorsFile); err != nil {
+			return err
+		}
+	}
+	if opts.redactConfig != "" {
+		if opts.redaction, err = readRedactConfig(opts.redactConfig); err != nil {
+			return err
+		}
+	}
+	if opts.applyPhases != "" {
+		if opts.phaseChanges, err = readPhaseChanges(opts.applyPhases); err != nil {
+			return err
+		}
+	}
+	opts.filters, err = buildFilters(opts)
+	if err != nil {
+		return err
+	}
+	opts.allowShared, err = parsePieceAllowlist(opts.sharedPieces)
+	if err != nil {
+		return err
+	}
+	if opts.groupBy != "" {
+		opts.groupKeys, err = parseGroupBy(opts.groupBy)
+		if err != nil {
+			return err
+		}
+	}
+	useMmap = opts.mmap
+	humanSizes = opts.humanSizes
+	forceTypeCompat = opts.forceTypeCompat
+	bigintsAsStrings = opts.bigintsAsStrings
+	strictJson = opts.strictJson
+	loadLimit = 0
+	if opts.nonFinite != "error" && opts.nonFinite != "null" {
+		return usageErrorf("-non-finite must be error or null")
+	}
+	nullNonFinite = opts.nonFinite == "null"
+	includeEmptyPieces = opts.emptyPieces
+	if err := setRecordExtension(opts.extension); err != nil {
+		return err
+	}
+	upgradeLegacy = opts.upgrade
+	if opts.keepUnknown && opts.strictJson {
+		return usageErrorf("-keep-unknown-fields cannot be combined with -strict-json, which rejects unknown fields")
+	}
+	keepUnknownFields = opts.keepUnknown
+	fieldRenames, err = parseFieldRenames(opts.renameFields)
+	if err != nil {
+		return err
+	}
+	if err := setBlobEncoding(opts.blobEncoding); err != nil {
+		return err
+	}
+	if opts.dirMode != "merge" && opts.dirMode != "each" {
+		return usageErrorf("unknown -dir-mode %q", opts.dirMode)
+	}
+	if err := setInputFormats(opts.inFormats, opts.inputs); err != nil {
+		return err
+	}
+	if opts.out == "" && isRemoteInput(opts.inputs[0]) {
+		return usageErrorf("-out is required when the first -in is an sftp:// URL")
+	}
+	if opts.snapshot != "" || opts.restore != "" || opts.listSnaps {
+		return runSnapshots(opts)
+	}
+	if err := fetchRemoteInputs(opts.inputs, opts.ssh); err != nil {
+		return err
+	}
+	paths, err := expandInputs(opts.inputs, opts.inPattern)
+	if err != nil {
+		return err
+	}
+
+	if opts.dumpTypes {
+		for _, p := range paths {
+			fmt.Println(p)
+			if err := dumpGobTypes(os.Stdout, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if opts.countOnly {
+		for _, p := range paths {
+			n, err := countRecords(p, opts.merge)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			if len(paths) == 1 {
+				fmt.Println(n)
+			} else {
+				fmt.Printf("%d %s\n", n, p)
+			}
+		}
+		return nil
+	}
+	if opts.check {
+		data, err := loadStates(context.Background(), paths, opts.concurrency, opts.merge)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("OK %d records\n", len(data))
+		return nil
+	}
+	if opts.serveAddr != "" {
+		return serve(opts.serveAddr, paths, opts.concurrency, opts.merge, opts.store.rate)
+	}
+	// -count-only, -check and -serve look at whole inputs; conversions
+	// stop loading at -limit.
+	loadLimit = opts.limit
+	if opts.flatten && (opts.out == "" || contains(paths, opts.out)) {
+		return usageErrorf("-flatten output cannot be loaded back; pass an -out other than the inputs")
+	}
+	if (opts.format == "properties" || opts.format == "sqlite") && (opts.out == "" || contains(paths, opts.out)) {
+		return usageErrorf("-format %s output cannot be loaded back; pass an -out other than the inputs", opts.format)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if opts.implodeDir != "" {
+		if opts.out == "" {
+			return usageErrorf("-out is required with -implode")
+		}
+		data, files, err := loadImploded(ctx, opts.implodeDir)
+		if err != nil {
+			return interruptedError(err)
+		}
+		s := &State{filePath: opts.out, sources: files, state: data}
+		return interruptedError(convert(ctx, s, opts))
+	}
+	if opts.dirMode == "each" && hasDir(opts.inputs) {
+		return interruptedError(convertEach(ctx, paths, opts))
+	}
+
+	outPath := opts.out
+	if outPath == "" {
+		if hasDir(opts.inputs[:1]) {
+			return usageErrorf("-out is required when the first -in is a directory")
+		}
+		outPath = opts.inputs[0]
+	}
+	if canFastConvert(paths, opts) {
+		handled, err := fastConvert(ctx, paths[0], outPath, opts)
+		if handled {
+			if err != nil {
+				return interruptedError(err)
+			}
+			fmt.Println("done ok")
+			return nil
+		}
+	}
+	s, err := loadStateFromFiles(ctx, paths, outPath, opts.concurrency, opts.merge)
+	if err != nil {
+		return interruptedError(err)
+	}
+	return interruptedError(convert(ctx, s, opts))
+}
+
+// interruptedError reports a load cut short by an interrupt as
+// errInterrupted, which main prints without the failing file.
+func interruptedError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return errInterrupted
+	}
+	return err
+}
+
+// convert applies the requested transforms to s and writes it out.
+func convert(ctx context.Context, s *State, opts options) error {
+	if opts.auditPath != "" {
+		s.audit = &auditLog{}
+	}
+	s.store = opts.store
+	summary.read += len(s.state)
+	if opts.preserveOrder {
+		s.store.order = s.order
+		if s.store.order == nil {
+			s.store.order = make([]SectorID, 0)
+		}
+	}
+	for _, in := range opts.inputs {
+		if sameFile(in, s.filePath) {
+			s.store.atomic = true
+		}
+	}
+	if s.store.atomic && s.store.rate > 0 {
+		warnf("-rate has no effect when -out is one of the inputs, which is replaced in one step")
+	}
+	if opts.explain {
+		for _, step := range explainPlan(s, opts) {
+			fmt.Fprintln(os.Stderr, "plan: "+step)
+		}
+	}
+	if opts.upgrade && !opts.validateOnly {
+		for _, issue := range validate(s.state) {
+			warnf("%s: [%s] %s", sectorName(issue.SectorID), issue.Rule, issue.Message)
+		}
+	}
+	if opts.limit > 0 {
+		if n := s.limit(opts.limit); n > 0 {
+			summary.dropped += n
+			infof("limited to %d record(s), left out %d", opts.limit, n)
+		}
+	}
+	if !opts.keepEmpty {
+		if n := s.dropEmptyRecords(); n > 0 {
+			summary.dropped += n
+			infof("dropped %d empty record(s)", n)
+		}
+	}
+	for _, id := range opts.sectorList {
+		if _, ok := s.state[id]; !ok {
+			warnf("%s is listed in %s but not in the input", sectorName(id), opts.sectorsFile)
+		}
+	}
+	if n := s.filter(opts.filters); n > 0 {
+		summary.dropped += n
+		infof("filtered out %d record(s)", n)
+	}
+	if opts.maxBlobSize > 0 {
+		oversized := oversizedBlobs(s.state, opts.maxBlobSize)
+		for _, b := range oversized {
+			warnf("oversized blob: %s, over -max-blob-size %d", b, opts.maxBlobSize)
+		}
+		if len(oversized) > 0 && opts.blobSizeFatal {
+			return fmt.Errorf("%d blob(s) exceed -max-blob-size %d", len(oversized), opts.maxBlobSize)
+		}
+	}
+	if opts.validateOnly {
+		if printValidationIssues(os.Stdout, validate(s.state)) > 0 {
+			return exitError{code: 1}
+		}
+		return nil
+	}
+	if opts.checkFiles {
+		missing, err := missingFiles(ctx, s.state, opts.concurrency)
+		if err != nil {
+			return err
+		}
+		printMissingFiles(os.Stdout, s.state, missing)
+		if len(missing) > 0 {
+			return exitError{code: 1}
+		}
+		return nil
+	}
+	if opts.dupPieces {
+		shared := duplicatePieces(s.state, opts.allowShared)
+		printDuplicatePieces(os.Stdout, shared)
+		if len(shared) > 0 {
+			return exitError{code: 1}
+		}
+		return nil
+	}
+	if opts.clusterTasks {
+		clusters, err := identicalTaskClusters(s.state)
+		if err != nil {
+			return err
+		}
+		printTaskClusters(os.Stdout, clusters)
+		return nil
+	}
+	if opts.workers {
+		printWorkers(os.Stdout, distinctWorkers(s.state), opts.workerCounts)
+		return nil
+	}
+	if opts.format == "table" {
+		return writeTable(os.Stdout, s.state)
+	}
+	if opts.format == "stats-json" {
+		return writeStatsJson(os.Stdout, s.state)
+	}
+	if opts.format == "dot" {
+		return writeDot(os.Stdout, s.state)
+	}
+	if opts.groupKeys != nil {
+		return writeGroupCounts(os.Stdout, s.state, opts.groupKeys)
+	}
+	if opts.phaseChanges != nil {
+		applied, skipped := s.applyPhases(opts.phaseChanges, opts.applyPhases)
+		infof("applied %d phase change(s) from %s, skipped %d", applied, opts.applyPhases, skipped)
+	}
+	if opts.pruneDone {
+		s.pruneDoneFileTasks()
+	}
+	if opts.trimErrMsg > 0 {
+		s.trimErrMsg(opts.trimErrMsg)
+	}
+	if opts.checkCids {
+		s.checkSectorCids(opts.fixCids)
+	}
+	if opts.stripUUIDs {
+		if n := s.stripUUIDs(); n > 0 {
+			infof("set %d file task ID(s) to the nil UUID", n)
+		}
+	}
+	if opts.redaction != nil {
+		if n := opts.redaction.apply(s); n > 0 {
+			infof("redacted %d value(s) following %s", n, opts.redactConfig)
+		}
+	}
+	if opts.touchUpdated {
+		s.touchUpdatedAt(time.Now().UTC())
+	}
+	if opts.cidVersion >= 0 {
+		if n := s.setCidVersion(uint64(opts.cidVersion)); n > 0 {
+			infof("converted %d CID(s) to version %d", n, opts.cidVersion)
+		}
+	}
+	if opts.comparePath != "" {
+		return compareToGolden(ctx, s, opts.comparePath)
+	}
+	if opts.baselinePath != "" {
+		if err := keepChangedSince(ctx, s, opts); err != nil {
+			return err
+		}
+	}
+	if opts.pointerSet {
+		return printPointer(s, opts.pointer)
+	}
+	if len(s.state) == 0 && !opts.allowEmpty && !s.store.appendLines && s.store.explodeDir == "" && opts.baselinePath == "" {
+		if err := refuseEmptyOverwrite(s.filePath, s.store); err != nil {
+			return err
+		}
+	}
+	var err error
+	if opts.flatten {
+		err = storeFlattened(ctx, s.state, s.filePath, s.store)
+	} else if opts.format == "properties" {
+		err = storeProperties(ctx, s.state, s.filePath, s.store)
+	} else if opts.format == "sqlite" {
+		err = storeSqlite(ctx, s.state, s.filePath)
+	} else {
+		err = s.save(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if s.audit != nil {
+		if err := s.audit.write(ctx, opts.auditPath); err != nil {
+			return err
+		}
+	}
+	if !teesToStdout(s.store.tee) {
+		fmt.Println("done ok")
+	}
+	return nil
+}
+
+// compareToGolden checks the records s would save against the state in
+// golden, reporting the differences to stdout.
+func compareToGolden(ctx context.Context, s *State, golden string) error {
+	s.cleanCommit1Out()
+	want, err := loadStates(ctx, []string{golden}, 1, mergeOptions{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", golden, err)
+	}
+	diffs, err := compareStates(s.state, want)
+	if err != nil {
+		return err
+	}
+	printComparison(os.Stdout, golden, diffs)
+	if len(diffs) > 0 {
+		return exitError{code: 1}
+	}
+	return nil
+}
+
+// printPointer prints the value ptr selects in the document s would save.
+func printPointer(s *State, ptr string) error {
+	s.cleanCommit1Out()
+	recordList, records, err := encodeRecords(s.state, s.filePath, s.store)
+	if err != nil {
+		return err
+	}
+	doc, err := marshalDocument(recordList, records, s.store)
+	if err != nil {
+		return err
+	}
+	value, err := evalPointer(doc, ptr)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(value))
+	return nil
+}
+
+// keepChangedSince reduces s to the records that differ from the state in
+// -baseline, filtered like s, and queues tombstones for the sectors gone
+// from it if asked.
+func keepChangedSince(ctx context.Context, s *State, opts options) error {
+	baseline, err := loadStates(ctx, []string{opts.baselinePath}, 1, mergeOptions{})
+	if err != nil {
+		return fmt.Errorf("%s: %w", opts.baselinePath, err)
+	}
+	base := &State{state: baseline}
+	if !opts.keepEmpty {
+		base.dropEmptyRecords()
+	}
+	base.filter(opts.filters)
+	s.cleanCommit1Out()
+	unchanged, removed, err := s.keepChanged(base.state)
+	if err != nil {
+		return err
+	}
+	summary.dropped += unchanged
+	infof("%d record(s) new or changed since %s, %d unchanged, %d removed", len(s.state), opts.baselinePath, unchanged, len(removed))
+	if opts.tombstones {
+		s.store.tombstones = removed
+	}
+	return nil
+}
+
+// convertEach converts every input file on its own, writing <name>.json
+// into the -out directory (default: next to the input).
+func convertEach(ctx context.Context, paths []string, opts options) error {
+	if opts.out != "" {
+		if err := os.MkdirAll(opts.out, 0700); err != nil {
+			return err
+		}
+	}
+	for _, p := range paths {
+		data, order, err := loadOrderedStates(ctx, []string{p}, 1, opts.merge)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		dir := filepath.Dir(p)
+		if opts.out != "" {
+			dir = opts.out
+		}
+		s := &State{filePath: filepath.Join(dir, filepath.Base(p)+".json"), sources: []string{p}, state: data, order: order}
+		if err := convert(ctx, s, opts); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// expandInputs replaces every directory in inputs by the regular files in
+// it matching pattern, in lexical order. Subdirectories are skipped.
+func expandInputs(inputs []string, pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid -in-pattern: %w", err)
+	}
+	paths := make([]string, 0, len(inputs))
+	for _, in := range inputs {
+		fi, err := os.Stat(in)
+		if err != nil || !fi.IsDir() {
+			paths = append(paths, in)
+			continue
+		}
+		entries, err := ioutil.ReadDir(in)
+		if err != nil {
+			return nil, err
+		}
+		n := 0
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern, e.Name()); ok {
+				paths = append(paths, filepath.Join(in, e.Name()))
+				n++
+			}
+		}
+		if n == 0 {
+			return nil, errors.New(in + ": no files match -in-pattern " + pattern)
+		}
+		infof("%s: %d file(s) to process", in, n)
+	}
+	return paths, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDir(inputs []string) bool {
+	for _, in := range inputs {
+		if fi, err := os.Stat(in); err == nil && fi.IsDir() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stateDir returns a directory holding three state files matching state_*,
+// a file that does not and a subdirectory that would.
+func stateDir(t *testing.T) string {
+	t.Helper()
+	dir := tempDir(t)
+	for i, name := range []string{"state_b", "state_a", "state_c"} {
+		writeGobState(t, dir, name, recordMap([]SectorRecord{testRecord(1000, SectorNumber(i+1), 1)}))
+	}
+	writeTestFile(t, dir, "notes.txt", []byte("not a state"))
+	if err := os.Mkdir(filepath.Join(dir, "state_old"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := stateDir(t)
+	got, err := expandInputs([]string{"single.gob", dir}, "state_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"single.gob", filepath.Join(dir, "state_a"), filepath.Join(dir, "state_b"), filepath.Join(dir, "state_c")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := expandInputs([]string{dir}, "*.gob"); err == nil {
+		t.Error("a directory without matching files was accepted")
+	}
+	if _, err := expandInputs([]string{dir}, "["); err == nil {
+		t.Error("a malformed -in-pattern was accepted")
+	}
+}
+
+func TestDirectoryInputMerged(t *testing.T) {
+	dir := stateDir(t)
+	out := filepath.Join(tempDir(t), "out.json")
+	res := mustRun(t, "-in", dir, "-in-pattern", "state_*", "-out", out)
+	if got := readJsonState(t, out); len(got) != 3 {
+		t.Errorf("merged %d records, want 3", len(got))
+	}
+	if !strings.Contains(res.log, dir+": 3 file(s) to process") {
+		t.Errorf("the log does not report the files processed:\n%s", res.log)
+	}
+	if res := runTool(t, "-in", dir, "-in-pattern", "state_*"); res.err == nil {
+		t.Error("a directory -in without -out was accepted")
+	}
+}
+
+func TestDirectoryInputEach(t *testing.T) {
+	dir := stateDir(t)
+	out := tempDir(t)
+	mustRun(t, "-in", dir, "-in-pattern", "state_*", "-dir-mode", "each", "-out", out)
+	for i, name := range []string{"state_b", "state_a", "state_c"} {
+		got := readJsonState(t, filepath.Join(out, name+".json"))
+		if len(got) != 1 || got[0].SectorId.Number != SectorNumber(i+1) {
+			t.Errorf("%s.json holds %+v, want sector %d alone", name, got, i+1)
+		}
+	}
+}
+
+// setenv sets the environment variable name to value for the rest of the
+// test.
+func setenv(t *testing.T, name, value string) {
+	t.Helper()
+	old, had := os.LookupEnv(name)
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestEnvironmentDefaults(t *testing.T) {
+	setenv(t, envIn, "/env/a.gob,/env/b.gob")
+	setenv(t, envOut, "/env/out.json")
+	setenv(t, envFormat, "jsonl")
+	setenv(t, envLogLevel, "warn")
+
+	opts, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/env/a.gob", "/env/b.gob"}; !reflect.DeepEqual(opts.inputs, want) {
+		t.Errorf("inputs = %q, want %q", opts.inputs, want)
+	}
+	if opts.out != "/env/out.json" || opts.format != "jsonl" || opts.logLevel != "warn" {
+		t.Errorf("got -out %q, -format %q, -log-level %q from the environment", opts.out, opts.format, opts.logLevel)
+	}
+
+	opts, err = parseFlags([]string{"-in", "/flag/in.gob", "-out", "/flag/out.json", "-format", "json", "-log-level", "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/flag/in.gob"}; !reflect.DeepEqual(opts.inputs, want) {
+		t.Errorf("inputs = %q, want the flag's %q", opts.inputs, want)
+	}
+	if opts.out != "/flag/out.json" || opts.format != "json" || opts.logLevel != "debug" {
+		t.Errorf("got -out %q, -format %q, -log-level %q, want the flags to win", opts.out, opts.format, opts.logLevel)
+	}
+}
+
+func TestEmptyEnvironmentVariablesAreIgnored(t *testing.T) {
+	setenv(t, envFormat, "")
+	opts, err := parseFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.format != "json" {
+		t.Errorf("format = %q, want the built-in json", opts.format)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		name   string
+		path   string
+		code   int
+		stdout string
+	}{
+		{"gob", writeGobState(t, dir, "state.gob", recordMap(testRecords(3))), 0, "OK 3 records\n"},
+		{"json", writeJsonState(t, dir, "state.json", testRecords(2)), 0, "OK 2 records\n"},
+		{"corrupt", writeTestFile(t, dir, "corrupt", []byte("\x00\x01 not a state")), 1, ""},
+		{"missing", filepath.Join(dir, "missing"), 1, ""},
+	}
+	for _, tt := range tests {
+		before, _ := os.Stat(tt.path)
+		res := runTool(t, "-in", tt.path, "-check")
+		if res.code != tt.code {
+			t.Errorf("%s: exit status %d, want %d (%v)", tt.name, res.code, tt.code, res.err)
+		}
+		if tt.code == 0 && res.stdout != tt.stdout {
+			t.Errorf("%s: printed %q, want %q", tt.name, res.stdout, tt.stdout)
+		}
+		if tt.code != 0 && !strings.Contains(res.stdout, tt.path) {
+			t.Errorf("%s: printed %q, want the error naming the file", tt.name, res.stdout)
+		}
+		if after, _ := os.Stat(tt.path); before != nil && !after.ModTime().Equal(before.ModTime()) {
+			t.Errorf("%s: -check rewrote the file", tt.name)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("-check left %d files in the directory, want the 3 inputs", len(entries))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxClusterSamples caps the sectors listed for each cluster.
+const maxClusterSamples = 5
+
+type taskCluster struct {
+	hash    string
+	sectors []SectorID
+}
+
+// taskConfigHash hashes the current seal task of r, leaving out the
+// sector ID and the randomness and proof blobs.
+func taskConfigHash(r SectorRecord) (string, error) {
+	t := r.CurrentSealTask
+	t.SectorID = SectorID{}
+	t.Ticket = nil
+	t.Seed = nil
+	t.PreCommit1Out = nil
+	t.Commit1Out = nil
+	t.Commit2Out = nil
+	marshaled, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(marshaled)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// identicalTaskClusters groups the sectors sharing a task config, largest
+// group first. Sectors with a unique config are left out.
+func identicalTaskClusters(data map[SectorID]SectorRecord) ([]taskCluster, error) {
+	byHash := make(map[string][]SectorID)
+	for _, r := range sortedRecords(data) {
+		h, err := taskConfigHash(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sectorName(r.SectorId), err)
+		}
+		byHash[h] = append(byHash[h], r.SectorId)
+	}
+	clusters := make([]taskCluster, 0)
+	for h, ids := range byHash {
+		if len(ids) > 1 {
+			clusters = append(clusters, taskCluster{hash: h, sectors: ids})
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].sectors) != len(clusters[j].sectors) {
+			return len(clusters[i].sectors) > len(clusters[j].sectors)
+		}
+		return clusters[i].hash < clusters[j].hash
+	})
+	return clusters, nil
+}
+
+func printTaskClusters(w io.Writer, clusters []taskCluster) {
+	for _, c := range clusters {
+		samples := c.sectors
+		if len(samples) > maxClusterSamples {
+			samples = samples[:maxClusterSamples]
+		}
+		names := make([]string, 0, len(samples))
+		for _, id := range samples {
+			names = append(names, sectorName(id))
+		}
+		more := ""
+		if len(c.sectors) > len(samples) {
+			more = ", ..."
+		}
+		fmt.Fprintf(w, "%s: %d sectors (%s%s)\n", c.hash[:12], len(c.sectors), strings.Join(names, ", "), more)
+	}
+	fmt.Fprintf(w, "%d cluster(s) of identical task configs\n", len(clusters))
+}
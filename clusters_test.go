@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestIdenticalTaskClusters(t *testing.T) {
+	recordList := make([]SectorRecord, 0, 10)
+	for n := 1; n <= 10; n++ {
+		r := testRecord(1000, SectorNumber(n), 1)
+		// The blobs and sector IDs differ, which the hash ignores.
+		r.CurrentSealTask.Ticket = []byte{byte(n)}
+		r.CurrentSealTask.Commit2Out = []byte{byte(n)}
+		switch {
+		case n <= 7:
+			r.CurrentSealTask.CacheDirPath = "/shared"
+		case n <= 9:
+			r.CurrentSealTask.CacheDirPath = "/pair"
+		default:
+			r.CurrentSealTask.CacheDirPath = "/unique"
+		}
+		recordList = append(recordList, r)
+	}
+	clusters, err := identicalTaskClusters(recordMap(recordList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clusters) != 2 || len(clusters[0].sectors) != 7 || len(clusters[1].sectors) != 2 {
+		t.Fatalf("got clusters %+v, want one of 7 sectors and one of 2", clusters)
+	}
+	if clusters[1].sectors[0].Number != 8 || clusters[1].sectors[1].Number != 9 {
+		t.Errorf("the pair holds %v, want sectors 8 and 9", clusters[1].sectors)
+	}
+}
+
+func TestCollapseIdenticalTasksFlag(t *testing.T) {
+	recordList := testRecords(7)
+	recordList[6].CurrentSealTask.CacheDirPath = "/other"
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap(recordList))
+	res := mustRun(t, "-in", in, "-collapse-identical-tasks")
+	want := regexp.MustCompile(`^[0-9a-f]{12}: 6 sectors \(s-t01000-1, s-t01000-2, s-t01000-3, s-t01000-4, s-t01000-5, \.\.\.\)
+1 cluster\(s\) of identical task configs
+$`)
+	if !want.MatchString(res.stdout) {
+		t.Errorf("printed:\n%s", res.stdout)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// comparison is how one sector of the converted state differs from the
+// golden state given to -compare.
+type comparison struct {
+	id SectorID
+	// missing is set when only the golden state holds the sector, extra
+	// when only the converted state does.
+	missing bool
+	extra   bool
+	fields  []string
+}
+
+// compareStates lists, in sector order, the sectors that differ between
+// got and want.
+func compareStates(got, want map[SectorID]SectorRecord) ([]comparison, error) {
+	diffs := make([]comparison, 0)
+	for _, r := range sortedRecords(got) {
+		w, ok := want[r.SectorId]
+		if !ok {
+			diffs = append(diffs, comparison{id: r.SectorId, extra: true})
+			continue
+		}
+		fields, err := recordDiff(r, w)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sectorName(r.SectorId), err)
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, comparison{id: r.SectorId, fields: fields})
+		}
+	}
+	for _, w := range sortedRecords(want) {
+		if _, ok := got[w.SectorId]; !ok {
+			diffs = append(diffs, comparison{id: w.SectorId, missing: true})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return sectorIDLess(diffs[i].id, diffs[j].id)
+	})
+	return diffs, nil
+}
+
+func printComparison(w io.Writer, golden string, diffs []comparison) {
+	for _, d := range diffs {
+		switch {
+		case d.missing:
+			fmt.Fprintf(w, "%s: only in %s\n", sectorName(d.id), golden)
+		case d.extra:
+			fmt.Fprintf(w, "%s: not in %s\n", sectorName(d.id), golden)
+		default:
+			fmt.Fprintf(w, "%s: differs in %s\n", sectorName(d.id), strings.Join(d.fields, ", "))
+		}
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(w, "matches %s\n", golden)
+		return
+	}
+	fmt.Fprintf(w, "%d sector(s) differ from %s\n", len(diffs), golden)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompareStates(t *testing.T) {
+	changed := testRecord(1000, 2, 2)
+	changed.P1WorkerAddress = "elsewhere"
+	got := recordMap([]SectorRecord{testRecord(1000, 1, 1), changed, testRecord(1000, 4, 4)})
+	want := recordMap(testRecords(3))
+
+	diffs, err := compareStates(got, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDiffs := []struct {
+		number         SectorNumber
+		missing, extra bool
+		fields         string
+	}{
+		{2, false, false, "P1WorkerAddress"},
+		{3, true, false, ""},
+		{4, false, true, ""},
+	}
+	if len(diffs) != len(wantDiffs) {
+		t.Fatalf("got %+v, want %d differences", diffs, len(wantDiffs))
+	}
+	for i, w := range wantDiffs {
+		d := diffs[i]
+		if d.id.Number != w.number || d.missing != w.missing || d.extra != w.extra || strings.Join(d.fields, ", ") != w.fields {
+			t.Errorf("difference %d = %+v, want %+v", i, d, w)
+		}
+	}
+	if diffs, err := compareStates(want, want); err != nil || len(diffs) != 0 {
+		t.Errorf("comparing a state with itself: %+v, %v", diffs, err)
+	}
+}
+
+func TestCompareToGolden(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	recordList[2].CurrentSealTask.TaskType = TTCommit2
+	recordList[2].CurrentSealTask.Commit1Out = []byte("c1")
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	golden := filepath.Join(dir, "golden.json")
+	mustRun(t, "-in", in, "-out", golden)
+
+	// The conversion clears the Commit1Out of the commit2 sector, as it is
+	// in the golden file.
+	res := mustRun(t, "-in", in, "-compare", golden)
+	if res.stdout != "matches "+golden+"\n" {
+		t.Errorf("match: printed %q", res.stdout)
+	}
+
+	recordList[0].P1WorkerAddress = "elsewhere"
+	changed := writeGobState(t, dir, "changed.gob", recordMap(recordList[:2]))
+	before := readTestFile(t, changed)
+	res = runTool(t, "-in", changed, "-compare", golden)
+	want := "s-t01000-1: differs in P1WorkerAddress\n" +
+		"s-t01000-3: only in " + golden + "\n" +
+		"2 sector(s) differ from " + golden + "\n"
+	if res.code != 1 || res.stdout != want {
+		t.Errorf("mismatch: exit status %d, printed\n%s\nwant\n%s", res.code, res.stdout, want)
+	}
+	if readTestFile(t, changed) != before {
+		t.Error("-compare converted the input in place")
+	}
+
+	if res := runTool(t, "-in", in, "-compare", golden, "-flatten"); errorType(res.err) != "usage" {
+		t.Errorf("-compare -flatten: got %v, want a usage error", res.err)
+	}
+}
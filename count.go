@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// countRecords returns the number of records stored in filename, as
+// -count-only prints it: every entry of the file, before empty records
+// are dropped or the sectors of several entries merged. JSON records are
+// only scanned, and the count of a gob map or slice is read from the
+// stream without decoding any record; a gob file of several values is
+// loaded in full instead.
+func countRecords(filename string, merge mergeOptions) (int, error) {
+	raw, release, err := readInput(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	trimmed := bytes.TrimSpace(raw)
+	format := inputFormatOf(filename)
+	if format == "auto" {
+		format = "gob"
+		if len(trimmed) > 0 && (trimmed[0] == '[' || trimmed[0] == '{') {
+			format = "json"
+		}
+	}
+	if format == "json" {
+		return countJson(trimmed)
+	}
+	n, ok, err := countGob(raw)
+	if err != nil || ok {
+		return n, err
+	}
+	s, err := newState(filename, merge)
+	if err != nil {
+		return 0, err
+	}
+	return len(s.state), nil
+}
+
+// countJson counts the records of a JSON array, JSON Lines, keyed or
+// versioned document, decoding the tokens around them but no record.
+func countJson(raw []byte) (int, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	var skip json.RawMessage
+	if len(raw) > 0 && raw[0] == '{' && (isJsonLines(raw) || isCountHeader(raw)) {
+		n := 0
+		for line := 1; dec.More(); line++ {
+			if err := dec.Decode(&skip); err != nil {
+				return 0, err
+			}
+			if line > 1 || !isCountHeader(skip) {
+				n++
+			}
+		}
+		return n, nil
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, err
+	}
+	switch tok {
+	case json.Delim('['):
+		return countJsonElements(dec)
+	case json.Delim('{'):
+	default:
+		return 0, fmt.Errorf("unexpected %v at the start of a JSON state", tok)
+	}
+	members, records := 0, -1
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, err
+		}
+		if key == "records" {
+			if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+				return 0, fmt.Errorf("records of a versioned state is not an array")
+			}
+			if records, err = countJsonElements(dec); err != nil {
+				return 0, err
+			}
+		} else if err := dec.Decode(&skip); err != nil {
+			return 0, err
+		}
+		members++
+	}
+	if records >= 0 {
+		return records, nil
+	}
+	return members, nil
+}
+
+// countJsonElements counts the elements of the array dec has just opened,
+// consuming its end.
+func countJsonElements(dec *json.Decoder) (int, error) {
+	var skip json.RawMessage
+	n := 0
+	for dec.More() {
+		if err := dec.Decode(&skip); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	_, err := dec.Token()
+	return n, err
+}
+
+// countGob reads the length of the map or slice a gob stream holds from
+// the head of its value message. It reports false when the stream holds
+// any other value, or more messages follow.
+func countGob(raw []byte) (int, bool, error) {
+	types, valueID, err := readGobTypes(bytes.NewReader(raw))
+	if err != nil {
+		return 0, false, err
+	}
+	kind := ""
+	for _, t := range types {
+		if t.ID == valueID {
+			kind = t.Kind
+		}
+	}
+	if kind != "map" && kind != "slice" {
+		return 0, false, nil
+	}
+	br := bufio.NewReader(bytes.NewReader(raw))
+	for {
+		size, err := readGobUint(br)
+		if err != nil {
+			return 0, false, err
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return 0, false, err
+		}
+		mr := bytes.NewReader(msg)
+		id, err := readGobInt(mr)
+		if err != nil {
+			return 0, false, err
+		}
+		if id < 0 {
+			continue
+		}
+		// A top-level map or slice is sent as the single field of a
+		// struct: a zero field delta, then its length.
+		if delta, err := readGobUint(mr); err != nil || delta != 0 {
+			return 0, false, nil
+		}
+		n, err := readGobUint(mr)
+		if err != nil {
+			return 0, false, err
+		}
+		if _, err := br.Peek(1); err != io.EOF {
+			return 0, false, nil
+		}
+		return int(n), true, nil
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountJson(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want int
+		ok   bool
+	}{
+		{`[]`, 0, true},
+		{`[{"SectorId":{"Miner":1000,"Number":1}},{"SectorId":{"Miner":1000,"Number":2}}]`, 2, true},
+		{"{\"SectorId\":{\"Miner\":1000,\"Number\":1}}\n{\"SectorId\":{\"Miner\":1000,\"Number\":2}}\n", 2, true},
+		{"{\"@count\":2}\n{\"SectorId\":{\"Miner\":1000,\"Number\":1}}\n{\"SectorId\":{\"Miner\":1000,\"Number\":2}}\n", 2, true},
+		{`{"s-t01000-1":{"SectorId":{"Miner":1000,"Number":1}},"s-t01000-2":{},"s-t01000-3":{}}`, 3, true},
+		{`{"version":1,"generatedAt":"2021-03-04T05:06:07Z","records":[{},{}]}`, 2, true},
+		{`{"version":1,"records":{}}`, 0, false},
+		{`"state"`, 0, false},
+		{`[{"SectorId":`, 0, false},
+	}
+	for _, tt := range tests {
+		got, err := countJson([]byte(tt.doc))
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("countJson(%s) = %d, %v, want %d", tt.doc, got, err, tt.want)
+		}
+	}
+}
+
+func TestCountGob(t *testing.T) {
+	encode := func(values ...interface{}) []byte {
+		var buf bytes.Buffer
+		enc := gob.NewEncoder(&buf)
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+	tests := []struct {
+		name string
+		raw  []byte
+		want int
+		ok   bool
+	}{
+		{"map", encode(recordMap(testRecords(3))), 3, true},
+		{"slice", encode(testRecords(5)), 5, true},
+		{"empty map", encode(map[SectorID]SectorRecord{}), 0, true},
+		{"struct", encode(testRecord(1000, 1, 1)), 0, false},
+		{"two values", encode(testRecords(2), testRecords(1)), 0, false},
+	}
+	for _, tt := range tests {
+		got, ok, err := countGob(tt.raw)
+		if err != nil || ok != tt.ok || got != tt.want {
+			t.Errorf("%s: got %d, %v, %v, want %d, %v", tt.name, got, ok, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	dir := tempDir(t)
+	// Empty records and sectors repeated across snapshots are counted
+	// as stored.
+	twice := append(encodeGob(t, recordMap(testRecords(2))), encodeGob(t, recordMap(testRecords(3)))...)
+	tests := []struct {
+		name string
+		want int
+	}{
+		{writeGobState(t, dir, "map.gob", recordMap(testRecords(4))), 4},
+		{writeGobState(t, dir, "slice.gob", append(testRecords(2), SectorRecord{})), 3},
+		{writeJsonState(t, dir, "state.json", testRecords(2)), 2},
+		{writeTestFile(t, dir, "snapshots.gob", twice), 3},
+	}
+	for _, tt := range tests {
+		got, err := countRecords(tt.name, mergeOptions{})
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := countRecords(writeTestFile(t, dir, "junk", []byte("junk")), mergeOptions{}); err == nil {
+		t.Error("counting a file that is no state succeeded")
+	}
+}
+
+// encodeGob returns v gob-encoded by an encoder of its own.
+func encodeGob(t testing.TB, v interface{}) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCountOnly(t *testing.T) {
+	dir := tempDir(t)
+	a := writeGobState(t, dir, "a.gob", recordMap(testRecords(3)))
+	b := writeJsonState(t, dir, "b.json", testRecords(2))
+	if res := mustRun(t, "-in", a, "-count-only"); res.stdout != "3\n" {
+		t.Errorf("one input: printed %q, want just the count", res.stdout)
+	}
+	res := mustRun(t, "-in", a, "-in", b, "-count-only")
+	if want := "3 " + a + "\n2 " + b + "\n"; res.stdout != want {
+		t.Errorf("two inputs: printed %q, want %q", res.stdout, want)
+	}
+	res = runTool(t, "-in", filepath.Join(dir, "missing"), "-count-only")
+	if res.err == nil || !strings.Contains(res.err.Error(), "missing") {
+		t.Errorf("a missing input: got %v, want an error naming it", res.err)
+	}
+}
+
+// BenchmarkCountOnly compares -count-only with loading the State to count
+// its sectors. Counting a gob slice takes about a tenth of the time of
+// loading it and a JSON array about a third, with a fraction of the
+// allocations.
+func BenchmarkCountOnly(b *testing.B) {
+	dir := tempDir(b)
+	recordList := make([]SectorRecord, 0, 20000)
+	for n := 1; n <= 20000; n++ {
+		r := testRecord(1000, SectorNumber(n), 5)
+		r.CurrentSealTask.Commit1Out = make([]byte, 256)
+		recordList = append(recordList, r)
+	}
+	inputs := map[string]string{
+		"gob":  writeGobState(b, dir, "in.gob", recordList),
+		"json": writeJsonState(b, dir, "in.json", recordList),
+	}
+	for _, format := range []string{"gob", "json"} {
+		in := inputs[format]
+		b.Run(format+"/count-only", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if n, err := countRecords(in, mergeOptions{}); err != nil || n != len(recordList) {
+					b.Fatal(n, err)
+				}
+			}
+		})
+		b.Run(format+"/load", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s, err := newState(in, mergeOptions{})
+				if err != nil || len(s.state) != len(recordList) {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// recordDiff returns the dotted paths of the fields whose JSON encoding
+// differs between a and b, descending into objects. Arrays are compared
+// as a whole.
+func recordDiff(a, b SectorRecord) ([]string, error) {
+	ra, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0)
+	err = diffJson(ra, rb, "", &paths)
+	return paths, err
+}
+
+func diffJson(a, b json.RawMessage, path string, paths *[]string) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	if !isJsonObject(a) || !isJsonObject(b) {
+		*paths = append(*paths, path)
+		return nil
+	}
+	child := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+	ma, err := decodeMembers(a)
+	if err != nil {
+		return err
+	}
+	mb, err := decodeMembers(b)
+	if err != nil {
+		return err
+	}
+	inB := make(map[string]json.RawMessage, len(mb))
+	for _, m := range mb {
+		inB[m.key] = m.value
+	}
+	inA := make(map[string]bool, len(ma))
+	for _, m := range ma {
+		inA[m.key] = true
+		other, ok := inB[m.key]
+		if !ok {
+			*paths = append(*paths, child(m.key))
+			continue
+		}
+		if err := diffJson(m.value, other, child(m.key), paths); err != nil {
+			return err
+		}
+	}
+	for _, m := range mb {
+		if !inA[m.key] {
+			*paths = append(*paths, child(m.key))
+		}
+	}
+	return nil
+}
+
+func isJsonObject(raw json.RawMessage) bool {
+	t := bytes.TrimSpace(raw)
+	return len(t) > 0 && t[0] == '{'
+}
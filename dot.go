@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotID quotes s as a DOT identifier.
+func dotID(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// writeDot prints data as a Graphviz digraph for dot: a box per sector,
+// an ellipse per worker address and an edge from a sector to the worker of
+// each phase it was assigned to, labelled P1, P2, C1 or C2. The output is
+// not meant to be loaded back.
+func writeDot(w io.Writer, data map[SectorID]SectorRecord) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph sectors {")
+	fmt.Fprintln(bw, "  rankdir=LR;")
+	fmt.Fprintln(bw, "  node [shape=box];")
+	records := sortedRecords(data)
+	for _, r := range records {
+		fmt.Fprintf(bw, "  %s;\n", dotID(sectorName(r.SectorId)))
+	}
+	fmt.Fprintln(bw, "  node [shape=ellipse];")
+	for _, u := range distinctWorkers(data) {
+		fmt.Fprintf(bw, "  %s;\n", dotID(u.address))
+	}
+	for _, r := range records {
+		for _, e := range []struct{ phase, addr string }{
+			{"P1", r.P1WorkerAddress},
+			{"P2", r.P2WorkerAddress},
+			{"C1", r.C1WorkerAddress},
+			{"C2", r.C2WorkerAddress},
+		} {
+			if e.addr != "" {
+				fmt.Fprintf(bw, "  %s -> %s [label=%s];\n", dotID(sectorName(r.SectorId)), dotID(e.addr), e.phase)
+			}
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDotID(t *testing.T) {
+	tests := []struct{ s, want string }{
+		{"s-t01000-1", `"s-t01000-1"`},
+		{"10.0.0.1:3456", `"10.0.0.1:3456"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`C:\workers`, `"C:\\workers"`},
+		{"two\nlines", `"two\nlines"`},
+	}
+	for _, tt := range tests {
+		if got := dotID(tt.s); got != tt.want {
+			t.Errorf("dotID(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	recordList := testRecords(2)
+	recordList[0].P2WorkerAddress = "10.0.0.2:3456"
+	recordList[0].C2WorkerAddress = "gpu-1:2345"
+	recordList[1].C1WorkerAddress = "10.0.0.1:3456"
+	var buf bytes.Buffer
+	if err := writeDot(&buf, recordMap(recordList)); err != nil {
+		t.Fatal(err)
+	}
+	want := `digraph sectors {
+  rankdir=LR;
+  node [shape=box];
+  "s-t01000-1";
+  "s-t01000-2";
+  node [shape=ellipse];
+  "10.0.0.1:3456";
+  "10.0.0.2:3456";
+  "gpu-1:2345";
+  "s-t01000-1" -> "10.0.0.1:3456" [label=P1];
+  "s-t01000-1" -> "10.0.0.2:3456" [label=P2];
+  "s-t01000-1" -> "gpu-1:2345" [label=C2];
+  "s-t01000-2" -> "10.0.0.1:3456" [label=P1];
+  "s-t01000-2" -> "10.0.0.1:3456" [label=C1];
+}
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := writeDot(&buf, recordMap(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if want := "digraph sectors {\n  rankdir=LR;\n  node [shape=box];\n  node [shape=ellipse];\n}\n"; buf.String() != want {
+		t.Errorf("an empty state printed\n%s", buf.String())
+	}
+}
+
+func TestDotFormat(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	out := filepath.Join(dir, "out.dot")
+	res := mustRun(t, "-in", in, "-out", out, "-format", "dot")
+	want := "digraph sectors {\n  rankdir=LR;\n  node [shape=box];\n  \"s-t01000-1\";\n  node [shape=ellipse];\n  \"10.0.0.1:3456\";\n  \"s-t01000-1\" -> \"10.0.0.1:3456\" [label=P1];\n}\n"
+	if res.stdout != want {
+		t.Errorf("-format dot printed\n%s\nwant\n%s", res.stdout, want)
+	}
+	if _, err := ioutil.ReadFile(out); err == nil {
+		t.Error("-format dot saved the state")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// duplicateSector is a sector held by more than one input.
+type duplicateSector struct {
+	id      SectorID
+	sources []string
+	// identical is set when every copy encodes to the same JSON.
+	identical bool
+}
+
+// duplicatesAcrossInputs lists, in sector order, the sectors present in
+// more than one of results; sources names the file of each result.
+func duplicatesAcrossInputs(results []map[SectorID]SectorRecord, sources []string) ([]duplicateSector, error) {
+	type seen struct {
+		sources   []string
+		first     []byte
+		identical bool
+	}
+	byID := make(map[SectorID]*seen)
+	for i, m := range results {
+		for id, r := range m {
+			marshaled, err := json.Marshal(r)
+			if err != nil {
+				return nil, fmt.Errorf("%s in %s: %w", sectorName(id), sources[i], err)
+			}
+			d, ok := byID[id]
+			if !ok {
+				byID[id] = &seen{sources: []string{sources[i]}, first: marshaled, identical: true}
+				continue
+			}
+			d.sources = append(d.sources, sources[i])
+			d.identical = d.identical && bytes.Equal(d.first, marshaled)
+		}
+	}
+	dups := make([]duplicateSector, 0)
+	for id, d := range byID {
+		if len(d.sources) > 1 {
+			dups = append(dups, duplicateSector{id: id, sources: d.sources, identical: d.identical})
+		}
+	}
+	sort.Slice(dups, func(i, j int) bool {
+		return sectorIDLess(dups[i].id, dups[j].id)
+	})
+	return dups, nil
+}
+
+func printDuplicates(w io.Writer, dups []duplicateSector) {
+	for _, d := range dups {
+		agree := "identical"
+		if !d.identical {
+			agree = "different"
+		}
+		fmt.Fprintf(w, "%s: %s in %s\n", sectorName(d.id), agree, strings.Join(d.sources, ", "))
+	}
+	fmt.Fprintf(w, "%d sector(s) in more than one input\n", len(dups))
+}
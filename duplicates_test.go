@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDuplicatesAcrossInputs(t *testing.T) {
+	changed := testRecord(1000, 2, 2)
+	changed.P1WorkerAddress = "elsewhere"
+	results := []map[SectorID]SectorRecord{
+		recordMap(testRecords(3)),
+		recordMap([]SectorRecord{testRecord(1000, 1, 1), changed}),
+		recordMap([]SectorRecord{testRecord(1000, 1, 1), testRecord(1000, 4, 4)}),
+	}
+	dups, err := duplicatesAcrossInputs(results, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []duplicateSector{
+		{id: SectorID{Miner: 1000, Number: 1}, sources: []string{"a", "b", "c"}, identical: true},
+		{id: SectorID{Miner: 1000, Number: 2}, sources: []string{"a", "b"}, identical: false},
+	}
+	if !reflect.DeepEqual(dups, want) {
+		t.Errorf("got %+v, want %+v", dups, want)
+	}
+}
+
+func TestReportDuplicatesFlag(t *testing.T) {
+	dir := tempDir(t)
+	changed := testRecord(1000, 2, 2)
+	changed.P1WorkerAddress = "elsewhere"
+	a := writeGobState(t, dir, "a.gob", recordMap(testRecords(2)))
+	b := writeGobState(t, dir, "b.gob", recordMap([]SectorRecord{testRecord(1000, 1, 1), changed, testRecord(1000, 3, 3)}))
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", a, "-in", b, "-out", out, "-report-duplicates-across-files")
+	want := strings.Join([]string{
+		"s-t01000-1: identical in " + a + ", " + b,
+		"s-t01000-2: different in " + a + ", " + b,
+		"2 sector(s) in more than one input",
+	}, "\n") + "\n"
+	if !strings.HasPrefix(res.stdout, want) {
+		t.Errorf("printed:\n%s\nwant:\n%s", res.stdout, want)
+	}
+	// The report is informational: the merge goes ahead.
+	if got := readJsonState(t, out); len(got) != 3 || got[1].P1WorkerAddress != "elsewhere" {
+		t.Errorf("merged %+v, want the 3 sectors with the last input winning", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// loadError is a failure to decode one of the input files.
+type loadError struct {
+	path string
+	err  error
+}
+
+func (e *loadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+func (e *loadError) Unwrap() error {
+	return e.err
+}
+
+// usageError is an invalid combination or value of command line flags.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, v ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, v...)}
+}
+
+// errorType names the kind of err for machine-readable reports.
+func errorType(err error) string {
+	var le *loadError
+	var ue *usageError
+	switch {
+	case errors.As(err, &le):
+		return "load"
+	case errors.As(err, &ue):
+		return "usage"
+	}
+	return "error"
+}
+
+// reportJsonError writes err to w as a single {"error", "type"} object.
+func reportJsonError(w io.Writer, err error) {
+	marshaled, _ := json.Marshal(struct {
+		Error string `json:"error"`
+		Type  string `json:"type"`
+	}{Error: err.Error(), Type: errorType(err)})
+	fmt.Fprintln(w, string(marshaled))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestErrorType(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&loadError{path: "state", err: errors.New("bad")}, "load"},
+		{fmt.Errorf("merging: %w", &loadError{path: "state", err: errors.New("bad")}), "load"},
+		{usageErrorf("-a and -b cannot be combined"), "usage"},
+		{errors.New("disk full"), "error"},
+	}
+	for _, tt := range tests {
+		if got := errorType(tt.err); got != tt.want {
+			t.Errorf("errorType(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// jsonErrorReport decodes the single JSON error object of stderr.
+func jsonErrorReport(t *testing.T, stderr string) map[string]string {
+	t.Helper()
+	if strings.Count(stderr, "\n") != 1 {
+		t.Fatalf("stderr holds %q, want one line", stderr)
+	}
+	var report map[string]string
+	if err := json.Unmarshal([]byte(stderr), &report); err != nil {
+		t.Fatalf("%q: %v", stderr, err)
+	}
+	return report
+}
+
+func TestJsonErrorFormat(t *testing.T) {
+	dir := tempDir(t)
+	corrupt := writeTestFile(t, dir, "corrupt", []byte("not a state"))
+	tests := []struct {
+		name string
+		args []string
+		typ  string
+	}{
+		{"load failure", []string{"-in", corrupt}, "load"},
+		{"-check load failure", []string{"-in", corrupt, "-check"}, "load"},
+		{"flag conflict", []string{"-in", corrupt, "-no-errors", "-errors-only"}, "usage"},
+	}
+	for _, tt := range tests {
+		res := runTool(t, append(tt.args, "-error-format", "json")...)
+		if res.code != 1 {
+			t.Errorf("%s: exit status %d, want 1", tt.name, res.code)
+		}
+		report := jsonErrorReport(t, res.stderr)
+		if report["type"] != tt.typ || report["error"] == "" || len(report) != 2 {
+			t.Errorf("%s: reported %v, want an error of type %s", tt.name, report, tt.typ)
+		}
+		if res.stdout != "" {
+			t.Errorf("%s: printed %q to stdout as well", tt.name, res.stdout)
+		}
+	}
+
+	res := runTool(t, "-in", corrupt)
+	if res.code != 1 || res.stderr != "" || !strings.HasPrefix(res.stdout, corrupt+": ") {
+		t.Errorf("the text format reported %q on stdout and %q on stderr, exit status %d", res.stdout, res.stderr, res.code)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sniffFormat guesses whether filename holds JSON or gob from its first
+// non-blank byte, looking through gzip compression.
+func sniffFormat(filename string) string {
+	f, err := openInput(filename)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+	r, compressed, err := gunzipReader(f)
+	if err != nil {
+		return "unknown"
+	}
+	format := "gob"
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(r, head)
+	head = bytes.TrimSpace(head[:n])
+	if len(head) > 0 && (head[0] == '[' || head[0] == '{') {
+		format = "json"
+	}
+	if compressed {
+		return "gzip-compressed " + format
+	}
+	return format
+}
+
+// explainPlan describes, in run order, what convert is about to do with s.
+func explainPlan(s *State, opts options) []string {
+	plan := make([]string, 0)
+	for _, src := range s.sources {
+		plan = append(plan, fmt.Sprintf("load %s from %s", describeInputFormat(src), src))
+	}
+	if len(s.sources) > 1 {
+		plan = append(plan, fmt.Sprintf("merge %d inputs keeping the %s record per sector", len(s.sources), opts.merge.strategy))
+	}
+
+	loaded := make([]SectorRecord, 0, len(s.state))
+	for _, r := range s.state {
+		loaded = append(loaded, r)
+	}
+	if opts.limit > 0 {
+		order := "in input order"
+		if s.order == nil {
+			order = "by lowest sector ID, as the input is map-shaped"
+		}
+		plan = append(plan, fmt.Sprintf("stop loading each input after %d record(s), taken %s", opts.limit, order))
+		if len(loaded) > opts.limit {
+			loaded = s.firstRecords()[:opts.limit]
+		}
+	}
+
+	kept := make([]SectorRecord, 0, len(loaded))
+	empty := 0
+	for _, r := range loaded {
+		if !opts.keepEmpty && r.SectorId == (SectorID{}) {
+			empty++
+			continue
+		}
+		match := true
+		for _, keep := range opts.filters {
+			if !keep(r) {
+				match = false
+				break
+			}
+		}
+		if match {
+			kept = append(kept, r)
+		}
+	}
+	if empty > 0 {
+		plan = append(plan, fmt.Sprintf("drop %d empty record(s)", empty))
+	}
+	if opts.taskType != "" {
+		plan = append(plan, fmt.Sprintf("filter to task type %s, keeping %d of %d record(s)", parseTaskType(opts.taskType), len(kept), len(loaded)-empty))
+	}
+	if opts.miner.set || opts.numberMin.set || opts.numberMax.set {
+		plan = append(plan, fmt.Sprintf("filter to %s, keeping %d of %d record(s)", describeRange(opts), len(kept), len(loaded)-empty))
+	}
+	if opts.sectorList != nil {
+		plan = append(plan, fmt.Sprintf("filter to the %d sector(s) listed in %s, keeping %d of %d record(s)", len(opts.sectorList), opts.sectorsFile, len(kept), len(loaded)-empty))
+	}
+	if opts.noErrors {
+		plan = append(plan, fmt.Sprintf("drop records with a task ErrMsg, keeping %d of %d record(s)", len(kept), len(loaded)-empty))
+	}
+	if opts.errorsOnly {
+		plan = append(plan, fmt.Sprintf("keep only records with a task ErrMsg, keeping %d of %d record(s)", len(kept), len(loaded)-empty))
+	}
+	if opts.where != "" {
+		plan = append(plan, fmt.Sprintf("filter to records where %s, keeping %d of %d record(s)", opts.where, len(kept), len(loaded)-empty))
+	}
+
+	switch {
+	case opts.validateOnly:
+		return append(plan, "validate the records and exit without writing")
+	case opts.checkFiles:
+		return append(plan, "check that the path fields name existing files and exit without writing")
+	case opts.dupPieces:
+		return append(plan, "report piece CIDs held by more than one sector and exit without writing")
+	case opts.clusterTasks:
+		return append(plan, "report identical task configs and exit without writing")
+	case opts.workers:
+		return append(plan, "list the distinct worker addresses and exit without writing")
+	case opts.format == "table":
+		return append(plan, "print a table to stdout without writing")
+	case opts.format == "dot":
+		return append(plan, "print a Graphviz graph of sectors and workers to stdout without writing")
+	case opts.format == "stats-json":
+		return append(plan, "print record counts as JSON to stdout without writing")
+	case opts.groupBy != "":
+		return append(plan, fmt.Sprintf("print record counts by %s as JSON to stdout without writing", opts.groupBy))
+	}
+
+	done, trimmed, commit2 := 0, 0, 0
+	for _, r := range kept {
+		if r.CurrentFileTask.Done {
+			done++
+		}
+		_, sealCut := truncate(r.CurrentSealTask.ErrMsg, opts.trimErrMsg)
+		_, fileCut := truncate(r.CurrentFileTask.ErrMsg, opts.trimErrMsg)
+		if sealCut || fileCut {
+			trimmed++
+		}
+		if r.CurrentSealTask.TaskType == TTCommit2 && len(r.CurrentSealTask.Commit1Out) > 0 {
+			commit2++
+		}
+	}
+	if opts.phaseChanges != nil {
+		plan = append(plan, fmt.Sprintf("set the SectorWorkingPhase of the sectors in the %d row(s) of %s", len(opts.phaseChanges), opts.applyPhases))
+	}
+	if opts.pruneDone {
+		plan = append(plan, fmt.Sprintf("blank file task paths of %d finished sector(s)", done))
+	}
+	if opts.trimErrMsg > 0 {
+		plan = append(plan, fmt.Sprintf("trim error messages of %d sector(s) to %d characters", trimmed, opts.trimErrMsg))
+	}
+	if opts.checkCids {
+		verb := "check"
+		if opts.fixCids {
+			verb = "check and fix"
+		}
+		plan = append(plan, fmt.Sprintf("%s the PreCommit2Out CIDs of %d record(s)", verb, len(kept)))
+	}
+	if opts.stripUUIDs {
+		plan = append(plan, fmt.Sprintf("set the file task IDs of %d record(s) to the nil UUID", len(kept)))
+	}
+	if opts.redaction != nil {
+		plan = append(plan, fmt.Sprintf("redact %d string field(s) of %d record(s) following %s", len(opts.redaction.fields), len(kept), opts.redactConfig))
+	}
+	if opts.touchUpdated {
+		plan = append(plan, fmt.Sprintf("set UpdatedAt of %d record(s) to now", len(kept)))
+	}
+	if opts.cidVersion >= 0 {
+		plan = append(plan, fmt.Sprintf("convert CIDs to version %d where possible", opts.cidVersion))
+	}
+	if opts.baselinePath != "" {
+		step := "keep the records new or changed since " + opts.baselinePath
+		if opts.tombstones {
+			step += ", with tombstones for the removed ones"
+		}
+		plan = append(plan, step)
+	}
+	if opts.pointerSet {
+		return append(plan, fmt.Sprintf("clear Commit1Out for %d commit2 sector(s)", commit2), fmt.Sprintf("print the value at JSON pointer %q and exit without writing", opts.pointer))
+	}
+	if opts.comparePath != "" {
+		return append(plan, fmt.Sprintf("clear Commit1Out for %d commit2 sector(s)", commit2), "compare the records with "+opts.comparePath+" and exit without writing")
+	}
+	if opts.flatten {
+		return append(plan, fmt.Sprintf("write flattened JSON lines to %s", s.filePath))
+	}
+	if opts.format == "properties" {
+		return append(plan, fmt.Sprintf("write the records without blobs as properties to %s", s.filePath))
+	}
+	if opts.format == "sqlite" {
+		return append(plan, fmt.Sprintf("write the records without blobs to the sectors and pieces tables of SQLite database %s", s.filePath))
+	}
+	plan = append(plan, fmt.Sprintf("clear Commit1Out for %d commit2 sector(s)", commit2))
+
+	var how []string
+	if s.store.canonical {
+		how = append(how, "canonical")
+	}
+	if s.store.versioned {
+		how = append(how, "versioned")
+	}
+	if s.store.fields != nil {
+		how = append(how, "projected")
+	}
+	if bigintsAsStrings {
+		how = append(how, "string-ID")
+	}
+	how = append(how, opts.format)
+	verb := "write"
+	if s.store.appendLines {
+		verb = "append"
+	}
+	if s.store.explodeDir != "" {
+		plan = append(plan, fmt.Sprintf("write %s records to one file per sector in %s", strings.Join(how, " "), s.store.explodeDir))
+		if opts.auditPath != "" {
+			plan = append(plan, "write the audit log to "+opts.auditPath)
+		}
+		return plan
+	}
+	target := s.filePath
+	if s.store.atomic {
+		target += " (atomically, it is also an input)"
+	}
+	plan = append(plan, fmt.Sprintf("%s %s to %s", verb, strings.Join(how, " "), target))
+	for _, t := range s.store.tee {
+		if t == teeStdout {
+			t = "stdout"
+		}
+		plan = append(plan, "copy the output to "+t)
+	}
+	if opts.auditPath != "" {
+		plan = append(plan, "write the audit log to "+opts.auditPath)
+	}
+	return plan
+}
+
+// describeRange phrases the -miner and -number-min/-max filters.
+func describeRange(opts options) string {
+	parts := make([]string, 0, 2)
+	if opts.miner.set {
+		parts = append(parts, fmt.Sprintf("miner t0%d", opts.miner.v))
+	}
+	switch {
+	case opts.numberMin.set && opts.numberMax.set:
+		parts = append(parts, fmt.Sprintf("numbers %d-%d", opts.numberMin.v, opts.numberMax.v))
+	case opts.numberMin.set:
+		parts = append(parts, fmt.Sprintf("numbers from %d", opts.numberMin.v))
+	case opts.numberMax.set:
+		parts = append(parts, fmt.Sprintf("numbers up to %d", opts.numberMax.v))
+	}
+	return strings.Join(parts, " ")
+}
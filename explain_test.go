@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// planSteps returns the plan: lines of stderr without the prefix.
+func planSteps(stderr string) []string {
+	var steps []string
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, "plan: ") {
+			steps = append(steps, strings.TrimPrefix(line, "plan: "))
+		}
+	}
+	return steps
+}
+
+func TestExplain(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(4)
+	recordList[1].CurrentSealTask.TaskType = TTCommit2
+	recordList[1].CurrentSealTask.Commit1Out = []byte("c1out")
+	recordList[2].CurrentSealTask.ErrMsg = strings.Repeat("x", 50)
+	in := writeGobState(t, dir, "in.gob", append(recordList, SectorRecord{}))
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", in, "-out", out, "-explain", "-miner", "1000", "-number-max", "3", "-trim-errmsg", "10")
+	want := []string{
+		"load gob from " + in,
+		"drop 1 empty record(s)",
+		"filter to miner t01000 numbers up to 3, keeping 3 of 4 record(s)",
+		"trim error messages of 1 sector(s) to 10 characters",
+		"clear Commit1Out for 1 commit2 sector(s)",
+		"write json to " + out,
+	}
+	if got := planSteps(res.stderr); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	// Unlike -dry-run, -explain still converts.
+	if got := readJsonState(t, out); len(got) != 3 {
+		t.Errorf("wrote %d records, want the 3 the plan keeps", len(got))
+	}
+}
+
+func TestExplainInPlace(t *testing.T) {
+	in := writeJsonState(t, tempDir(t), "state.json", testRecords(1))
+	res := mustRun(t, "-in", in, "-explain", "-canonical")
+	steps := planSteps(res.stderr)
+	if len(steps) == 0 || steps[len(steps)-1] != "write canonical json to "+in+" (atomically, it is also an input)" {
+		t.Errorf("plan:\n%s", strings.Join(steps, "\n"))
+	}
+}
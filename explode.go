@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// explodedFile is the file of record id in an -explode directory, named by
+// sectorKeyFormat.
+func explodedFile(dir string, id SectorID) string {
+	return filepath.Join(dir, sectorKeyFormat.format(id)+".json")
+}
+
+// loadImploded reads every *.json file of dir as one record, the inverse of
+// storeExploded. Two files holding the same sector are an error, as is a
+// file named by sectorKeyFormat after another sector.
+func loadImploded(ctx context.Context, dir string) (map[SectorID]SectorRecord, []string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make(map[SectorID]SectorRecord, len(files))
+	from := make(map[SectorID]string, len(files))
+	dec := recordDecoder{dir: dir}
+	for _, name := range files {
+		if err := checkInterrupted(ctx); err != nil {
+			return nil, nil, err
+		}
+		raw, release, err := readInput(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		r, err := dec.decode(raw)
+		release()
+		if err != nil {
+			return nil, nil, &loadError{path: name, err: err}
+		}
+		key := strings.TrimSuffix(filepath.Base(name), ".json")
+		if id, err := sectorKeyFormat.parse(key); err == nil && id != r.SectorId {
+			return nil, nil, fmt.Errorf("%s holds the record of %s", name, sectorName(r.SectorId))
+		}
+		if prev, ok := from[r.SectorId]; ok {
+			return nil, nil, fmt.Errorf("%s: %s is also in %s", name, sectorName(r.SectorId), prev)
+		}
+		data[r.SectorId] = r
+		from[r.SectorId] = name
+	}
+	return data, files, nil
+}
+
+// storeExploded writes each of records, encoded from recordList, to its
+// own file in opts.explodeDir. Existing files are an error unless
+// opts.force is set, checked before anything is written. An interrupted
+// run removes the files it wrote.
+func storeExploded(ctx context.Context, recordList []SectorRecord, records []json.RawMessage, opts storeOptions) error {
+	dir := opts.explodeDir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if !opts.force {
+		for _, r := range recordList {
+			name := explodedFile(dir, r.SectorId)
+			if _, err := os.Lstat(name); err == nil {
+				return fmt.Errorf("%s already exists; pass -force to overwrite", name)
+			}
+		}
+	}
+	written := make([]string, 0, len(records))
+	for i, r := range recordList {
+		if err := checkInterrupted(ctx); err != nil {
+			for _, name := range written {
+				os.Remove(name)
+			}
+			return err
+		}
+		raw := records[i]
+		if opts.canonical {
+			var err error
+			raw, err = canonicalJson(raw)
+			if err != nil {
+				return err
+			}
+		}
+		name := explodedFile(dir, r.SectorId)
+		if err := ioutil.WriteFile(name, raw, 0600); err != nil {
+			return err
+		}
+		written = append(written, name)
+		summary.wrote(1, int64(len(raw)))
+	}
+	infof("wrote %d sector file(s) to %s", len(written), dir)
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExplode(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+	explode := filepath.Join(dir, "sectors", "nested")
+
+	res := mustRun(t, "-in", in, "-explode", explode)
+	if !strings.Contains(res.log, "wrote 3 sector file(s) to "+explode) {
+		t.Errorf("the files written were not reported:\n%s", res.log)
+	}
+	files, err := ioutil.ReadDir(explode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want one per record", len(files))
+	}
+	for _, want := range testRecords(3) {
+		name := filepath.Join(explode, sectorName(want.SectorId)+".json")
+		var got SectorRecord
+		if err := json.Unmarshal([]byte(readTestFile(t, name)), &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.SectorId != want.SectorId || got.SectorWorkingPhase != want.SectorWorkingPhase ||
+			got.P1WorkerAddress != want.P1WorkerAddress {
+			t.Errorf("%s holds %+v, want the record of %s", name, got.SectorId, sectorName(want.SectorId))
+		}
+	}
+}
+
+func TestExplodeExistingFiles(t *testing.T) {
+	dir := tempDir(t)
+	explode := filepath.Join(dir, "sectors")
+	if err := os.Mkdir(explode, 0700); err != nil {
+		t.Fatal(err)
+	}
+	existing := writeTestFile(t, explode, "s-t01000-2.json", []byte("keep"))
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+
+	res := runTool(t, "-in", in, "-explode", explode)
+	if res.err == nil || !strings.Contains(res.err.Error(), existing+" already exists") {
+		t.Errorf("got %v, want the existing file named", res.err)
+	}
+	if files, _ := ioutil.ReadDir(explode); len(files) != 1 || readTestFile(t, existing) != "keep" {
+		t.Errorf("a refused run wrote %d file(s)", len(files)-1)
+	}
+
+	mustRun(t, "-in", in, "-explode", explode, "-force")
+	if files, _ := ioutil.ReadDir(explode); len(files) != 3 || readTestFile(t, existing) == "keep" {
+		t.Errorf("-force: got %d file(s), want 3 with %s overwritten", len(files), existing)
+	}
+}
+
+func TestExplodeFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	for _, args := range [][]string{{"-format", "jsonl"}, {"-versioned"}, {"-flatten"}} {
+		res := runTool(t, append([]string{"-in", in, "-explode", filepath.Join(dir, "x")}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("-explode %q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
+
+func TestImplodeRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	recordList[1].CurrentSealTask.PreCommit2Out.Sealed = testCid(t, "sealed")
+	recordList[1].CurrentSealTask.Commit2Out = []byte("proof")
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	direct := filepath.Join(dir, "direct.json")
+	imploded := filepath.Join(dir, "imploded.json")
+	explode := filepath.Join(dir, "sectors")
+
+	mustRun(t, "-in", in, "-out", direct)
+	mustRun(t, "-in", in, "-explode", explode)
+	mustRun(t, "-implode", explode, "-out", imploded)
+	if got, want := readTestFile(t, imploded), readTestFile(t, direct); got != want {
+		t.Errorf("explode then implode wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestImplodeRejectsDuplicates(t *testing.T) {
+	dir := tempDir(t)
+	explode := filepath.Join(dir, "sectors")
+	mustRun(t, "-in", writeGobState(t, dir, "in.gob", recordMap(testRecords(2))), "-explode", explode)
+	copied := writeTestFile(t, explode, "copy.json", []byte(readTestFile(t, filepath.Join(explode, "s-t01000-1.json"))))
+	renamed := filepath.Join(explode, "s-t01000-7.json")
+	if err := os.Rename(filepath.Join(explode, "s-t01000-2.json"), renamed); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.json")
+
+	res := runTool(t, "-implode", explode, "-out", out)
+	if res.err == nil || !strings.Contains(res.err.Error(), "s-t01000-1 is also in") || !strings.Contains(res.err.Error(), copied) {
+		t.Errorf("got %v, want the duplicate of s-t01000-1 reported", res.err)
+	}
+	os.Remove(copied)
+	res = runTool(t, "-implode", explode, "-out", out)
+	if res.err == nil || !strings.Contains(res.err.Error(), renamed+" holds the record of s-t01000-2") {
+		t.Errorf("got %v, want the misnamed file reported", res.err)
+	}
+	if res := runTool(t, "-implode", explode); errorType(res.err) != "usage" {
+		t.Errorf("-implode without -out: got %v, want a usage error", res.err)
+	}
+}
+
+func TestExplodePadNumbers(t *testing.T) {
+	dir := tempDir(t)
+	recordList := []SectorRecord{testRecord(1000, 5, 1), testRecord(1000, 50, 1), testRecord(1000, 500, 1)}
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	explode := filepath.Join(dir, "sectors")
+
+	mustRun(t, "-in", in, "-explode", explode, "-pad-numbers", "4")
+	files, err := ioutil.ReadDir(explode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	want := []string{"s-t01000-0005.json", "s-t01000-0050.json", "s-t01000-0500.json"}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Errorf("got the files %q, want %q in sector order", names, want)
+	}
+
+	out := filepath.Join(dir, "out.json")
+	mustRun(t, "-implode", explode, "-out", out, "-pad-numbers", "4")
+	if got := sectorNumbers(readJsonState(t, out)); got != "5 50 500" {
+		t.Errorf("imploded the sectors %s, want 5 50 500", got)
+	}
+	// Leading zeros parse as well without the flag.
+	mustRun(t, "-implode", explode, "-out", out)
+	if got := sectorNumbers(readJsonState(t, out)); got != "5 50 500" {
+		t.Errorf("without -pad-numbers, imploded the sectors %s", got)
+	}
+
+	for _, n := range []string{"-1", "21"} {
+		if res := runTool(t, "-in", in, "-explode", explode, "-pad-numbers", n); errorType(res.err) != "usage" {
+			t.Errorf("-pad-numbers %s: got %v, want a usage error", n, res.err)
+		}
+	}
+}
+
+// sectorNumbers lists the sector numbers of recordList separated by spaces.
+func sectorNumbers(recordList []SectorRecord) string {
+	numbers := make([]string, 0, len(recordList))
+	for _, r := range recordList {
+		numbers = append(numbers, fmt.Sprint(r.SectorId.Number))
+	}
+	return strings.Join(numbers, " ")
+}
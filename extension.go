@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// recordExtension decodes the fields a fork adds to SectorRecord without
+// patching the struct. fields is a struct type declaring only the added
+// fields: gob matches fields by name, so the input is decoded a second time
+// into a map of it, and each JSON member of a decoded value is carried in
+// SectorRecord.Extra. JSON inputs keep the members with those names.
+//
+// Extensions register themselves from an init function, typically in a
+// file behind a build tag naming the fork; see extension_example.go.
+type recordExtension struct {
+	name   string
+	fields reflect.Type
+	names  map[string]bool
+}
+
+var recordExtensions = make(map[string]*recordExtension)
+
+// currentExtension is the extension selected with -record-extension, if
+// any.
+var currentExtension *recordExtension
+
+// registerRecordExtension makes fields, a struct value, available as the
+// extension name.
+func registerRecordExtension(name string, fields interface{}) {
+	t := reflect.TypeOf(fields)
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("record extension %s: %s is not a struct", name, t))
+	}
+	known := recordFieldNames
+	names := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		n := jsonFieldName(f)
+		if known[n] {
+			panic(fmt.Sprintf("record extension %s: field %s is already part of SectorRecord", name, n))
+		}
+		names[n] = true
+	}
+	recordExtensions[name] = &recordExtension{name: name, fields: t, names: names}
+}
+
+func setRecordExtension(name string) error {
+	if name == "" {
+		currentExtension = nil
+		return nil
+	}
+	ext, ok := recordExtensions[name]
+	if !ok && len(recordExtensions) == 0 {
+		return fmt.Errorf("unknown record extension %q, none are built in", name)
+	}
+	if !ok {
+		return fmt.Errorf("unknown record extension %q, have %s", name, strings.Join(extensionNames(), ", "))
+	}
+	currentExtension = ext
+	return nil
+}
+
+func extensionNames() []string {
+	names := make([]string, 0, len(recordExtensions))
+	for n := range recordExtensions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadGob decodes the extension fields of the gob file filename into the
+// Extra of the matching records of data.
+func (e *recordExtension) loadGob(data map[SectorID]SectorRecord, filename string) error {
+	m := reflect.New(reflect.MapOf(reflect.TypeOf(SectorID{}), e.fields))
+	m.Elem().Set(reflect.MakeMap(m.Elem().Type()))
+	if err := loadByGob(m.Interface(), filename); err != nil {
+		return err
+	}
+	iter := m.Elem().MapRange()
+	for iter.Next() {
+		id := iter.Key().Interface().(SectorID)
+		r, ok := data[id]
+		if !ok {
+			continue
+		}
+		fields := reflect.New(e.fields)
+		fields.Elem().Set(iter.Value())
+		raw, err := marshalFinite(id, fields.Interface())
+		if err != nil {
+			return err
+		}
+		r.Extra, err = e.extract(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sectorName(id), err)
+		}
+		data[id] = r
+	}
+	return nil
+}
+
+// extract returns the members of the JSON object raw that are fields of
+// the extension.
+func (e *recordExtension) extract(raw json.RawMessage) (map[string]json.RawMessage, error) {
+	members, err := decodeMembers(raw)
+	if err != nil {
+		return nil, err
+	}
+	var extra map[string]json.RawMessage
+	for _, m := range members {
+		if !e.names[m.key] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[m.key] = m.value
+	}
+	return extra, nil
+}
+
+// recordFieldNames are the JSON names of the fields of SectorRecord.
+var recordFieldNames = func() map[string]bool {
+	known := make(map[string]bool)
+	rt := reflect.TypeOf(SectorRecord{})
+	for i := 0; i < rt.NumField(); i++ {
+		known[jsonFieldName(rt.Field(i))] = true
+	}
+	return known
+}()
+
+// keepUnknownFields carries the top-level members of JSON records that
+// SectorRecord does not have in Extra, so they are written back, as asked
+// with -keep-unknown-fields.
+var keepUnknownFields bool
+
+// unknownMembers adds the members of the JSON object raw that are not
+// fields of SectorRecord to extra, which may be nil, and returns it.
+func unknownMembers(raw json.RawMessage, extra map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	members, err := decodeMembers(raw)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		if recordFieldNames[m.key] {
+			continue
+		}
+		if extra == nil {
+			extra = make(map[string]json.RawMessage)
+		}
+		extra[m.key] = m.value
+	}
+	return extra, nil
+}
+
+// plainRecord marshals a SectorRecord without its Extra.
+type plainRecord SectorRecord
+
+func (r SectorRecord) MarshalJSON() ([]byte, error) {
+	marshaled, err := json.Marshal(plainRecord(r))
+	if err != nil || len(r.Extra) == 0 {
+		return marshaled, err
+	}
+	keys := make([]string, 0, len(r.Extra))
+	for k := range r.Extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	buf.Write(marshaled[:len(marshaled)-1])
+	for _, k := range keys {
+		key, _ := json.Marshal(k)
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(r.Extra[k])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
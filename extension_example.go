@@ -0,0 +1,16 @@
+//go:build extension_example
+// +build extension_example
+
+package main
+
+// exampleFields shows how a fork registers the fields it adds to
+// SectorRecord. Build with -tags extension_example and convert with
+// -record-extension example.
+type exampleFields struct {
+	Cluster  string
+	Priority int
+}
+
+func init() {
+	registerRecordExtension("example", exampleFields{})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// forkFields are the fields a fork adds to SectorRecord, and forkRecord
+// the record that fork writes.
+type forkFields struct {
+	Cluster  string
+	Priority int
+}
+
+type forkRecord struct {
+	SectorId           SectorID
+	SectorWorkingPhase SectorWorkingPhase
+	P1WorkerAddress    string
+	Cluster            string
+	Priority           int
+}
+
+func TestRecordExtension(t *testing.T) {
+	registerRecordExtension("test-fork", forkFields{})
+	defer delete(recordExtensions, "test-fork")
+	defer setRecordExtension("")
+
+	dir := tempDir(t)
+	id := SectorID{Miner: 1000, Number: 1}
+	in := writeGobState(t, dir, "fork.gob", map[SectorID]forkRecord{
+		id: {SectorId: id, SectorWorkingPhase: 3, P1WorkerAddress: "10.0.0.1:3456", Cluster: "east", Priority: 7},
+	})
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-record-extension", "test-fork")
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 1 || string(raw[0]["Cluster"]) != `"east"` || string(raw[0]["Priority"]) != "7" {
+		t.Fatalf("wrote %s, want the fork fields Cluster and Priority", readTestFile(t, out))
+	}
+	if string(raw[0]["SectorWorkingPhase"]) != "3" || string(raw[0]["P1WorkerAddress"]) != `"10.0.0.1:3456"` {
+		t.Errorf("wrote %s, want the SectorRecord fields as well", readTestFile(t, out))
+	}
+
+	// Converting the JSON output again keeps the fork fields.
+	back := filepath.Join(dir, "back.json")
+	mustRun(t, "-in", out, "-out", back, "-record-extension", "test-fork")
+	if got, want := readTestFile(t, back), readTestFile(t, out); got != want {
+		t.Errorf("the JSON round trip wrote\n%s\nwant\n%s", got, want)
+	}
+
+	// Without the extension the fork fields are dropped.
+	mustRun(t, "-in", in, "-out", back)
+	if strings.Contains(readTestFile(t, back), "Cluster") {
+		t.Errorf("wrote the fork fields without -record-extension:\n%s", readTestFile(t, back))
+	}
+}
+
+func TestSetRecordExtensionRejectsUnknown(t *testing.T) {
+	if err := setRecordExtension("no-such-fork"); err == nil {
+		t.Error("an unknown extension was accepted")
+	}
+}
+
+func TestRegisterRecordExtensionRejectsKnownFields(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a SectorRecord field as an extension did not panic")
+		}
+		delete(recordExtensions, "clash")
+	}()
+	registerRecordExtension("clash", struct{ P1WorkerAddress string }{})
+}
+
+func TestUnknownMembers(t *testing.T) {
+	tests := []struct {
+		raw   string
+		extra map[string]json.RawMessage
+		want  string
+	}{
+		{`{"SectorId":{"Miner":1000,"Number":1},"P1WorkerAddress":""}`, nil, `null`},
+		{`{"SectorId":{"Miner":1000,"Number":1},"Rack":"r1"}`, nil, `{"Rack":"r1"}`},
+		{`{"Rack":"r1","Labels":{"a":[1,2]}}`, map[string]json.RawMessage{"Cluster": json.RawMessage(`"east"`)}, `{"Cluster":"east","Labels":{"a":[1,2]},"Rack":"r1"}`},
+	}
+	for _, tt := range tests {
+		extra, err := unknownMembers(json.RawMessage(tt.raw), tt.extra)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.raw, err)
+		}
+		if got, _ := json.Marshal(extra); string(got) != tt.want {
+			t.Errorf("unknownMembers(%s) = %s, want %s", tt.raw, got, tt.want)
+		}
+	}
+	if _, err := unknownMembers(json.RawMessage(`[1]`), nil); err == nil {
+		t.Error("a record that is no object was accepted")
+	}
+}
+
+func TestKeepUnknownFields(t *testing.T) {
+	dir := tempDir(t)
+	// A record of a newer producer, with members this version does not
+	// know at the top level and inside CurrentSealTask.
+	var newer map[string]json.RawMessage
+	raw, err := json.Marshal(testRecord(1000, 1, 3))
+	if err == nil {
+		err = json.Unmarshal(raw, &newer)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := string(newer["CurrentSealTask"])
+	newer["CurrentSealTask"] = json.RawMessage(strings.TrimSuffix(task, "}") + `,"Deadline":9}`)
+	newer["Rack"] = json.RawMessage(`"r1"`)
+	newer["Labels"] = json.RawMessage(`{"a":[1,2]}`)
+	doc, err := json.Marshal([]interface{}{newer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := writeTestFile(t, dir, "newer.json", doc)
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-keep-unknown-fields")
+	got := readTestFile(t, out)
+	if !strings.Contains(got, `"Rack":"r1"`) || !strings.Contains(got, `"Labels":{"a":[1,2]}`) {
+		t.Errorf("-keep-unknown-fields wrote\n%s\nwant Rack and Labels kept", got)
+	}
+	if strings.Contains(got, "Deadline") {
+		t.Errorf("an unknown member nested in CurrentSealTask was kept:\n%s", got)
+	}
+	if records := readJsonState(t, out); len(records) != 1 || records[0].P1WorkerAddress != "10.0.0.1:3456" {
+		t.Errorf("the known fields did not survive: %+v", records)
+	}
+
+	// The kept members survive a second conversion, to JSON Lines too.
+	back := filepath.Join(dir, "back.json")
+	mustRun(t, "-in", out, "-out", back, "-keep-unknown-fields")
+	if readTestFile(t, back) != got {
+		t.Errorf("the JSON round trip wrote\n%s\nwant\n%s", readTestFile(t, back), got)
+	}
+	lines := filepath.Join(dir, "out.jsonl")
+	mustRun(t, "-in", out, "-out", lines, "-format", "jsonl", "-keep-unknown-fields")
+	if !strings.Contains(readTestFile(t, lines), `"Rack":"r1"`) {
+		t.Errorf("the JSON Lines output lost Rack:\n%s", readTestFile(t, lines))
+	}
+
+	mustRun(t, "-in", in, "-out", back)
+	if strings.Contains(readTestFile(t, back), "Rack") {
+		t.Errorf("kept Rack without -keep-unknown-fields:\n%s", readTestFile(t, back))
+	}
+	res := mustRun(t, "-in", in, "-out", back, "-upgrade", "-keep-unknown-fields")
+	if !strings.Contains(readTestFile(t, back), `"Rack":"r1"`) || !strings.Contains(res.log, "Deadline") {
+		t.Errorf("-upgrade wrote\n%s\nlogging\n%s\nwant Rack kept and the nested Deadline reported dropped", readTestFile(t, back), res.log)
+	}
+	if res := runTool(t, "-in", in, "-out", back, "-keep-unknown-fields", "-strict-json"); errorType(res.err) != "usage" {
+		t.Errorf("-keep-unknown-fields with -strict-json: got %v, want a usage error", res.err)
+	}
+}
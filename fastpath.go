@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// canFastConvert reports whether opts asks only for the default conversion
+// of one gob file: drop empty records, clear Commit1Out and save plain
+// JSON. fastConvert does that in a single pass.
+func canFastConvert(paths []string, opts options) bool {
+	st := opts.store
+	return len(paths) == 1 && inputFormatOf(paths[0]) != "json" &&
+		opts.format == "json" && !st.canonical && !st.versioned && st.fields == nil &&
+		st.blobDir == "" && st.explodeDir == "" && len(st.tee) == 0 && !st.skipBadRecords &&
+		len(opts.filters) == 0 && opts.limit == 0 && opts.maxBlobSize == 0 &&
+		!opts.validateOnly && !opts.checkFiles && !opts.dupPieces && !opts.clusterTasks && !opts.workers && !opts.forceTypeCompat && opts.groupKeys == nil &&
+		opts.phaseChanges == nil && !opts.pruneDone && !opts.touchUpdated && !opts.stripUUIDs && opts.redaction == nil && !opts.checkCids && opts.trimErrMsg == 0 && opts.cidVersion < 0 &&
+		!opts.flatten && !opts.preserveOrder && opts.auditPath == "" &&
+		opts.comparePath == "" && opts.baselinePath == "" && !opts.tombstones && !opts.pointerSet && !opts.explain && currentExtension == nil
+}
+
+// fastConvert writes the map- or slice-shaped gob state in as a JSON array
+// to out, applying the cleanCommit1Out rule and dropping empty records as
+// it encodes each one. It skips the JSON attempt, the State map of a slice
+// input and the separate cleaning pass of the general path. It returns
+// false, leaving out untouched, when in is not such a gob file, so the
+// general path can load it and report its errors.
+func fastConvert(ctx context.Context, in, out string, opts options) (bool, error) {
+	raw, release, err := readInput(in)
+	if err != nil {
+		return false, nil
+	}
+	defer release()
+	types, valueID, err := readGobTypes(bytes.NewReader(raw))
+	if err != nil {
+		return false, nil
+	}
+	kind := ""
+	for _, t := range types {
+		if t.ID == valueID {
+			kind = t.Kind
+		}
+	}
+	var (
+		each  func(func(SectorRecord) error) error
+		total int
+	)
+	r := bytes.NewReader(raw)
+	dec := gob.NewDecoder(r)
+	switch kind {
+	case "map":
+		data := make(map[SectorID]SectorRecord)
+		if err := dec.Decode(&data); err != nil {
+			return false, nil
+		}
+		total = len(data)
+		each = func(write func(SectorRecord) error) error {
+			for _, r := range sortedRecords(data) {
+				if err := write(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case "slice":
+		recordList := make([]SectorRecord, 0)
+		if err := dec.Decode(&recordList); err != nil {
+			return false, nil
+		}
+		recordList, err := opts.merge.onDuplicate.dedupe(recordList)
+		if err != nil {
+			return true, fmt.Errorf("%s: %w", in, err)
+		}
+		total = len(recordList)
+		each = func(write func(SectorRecord) error) error {
+			for _, r := range recordList {
+				if err := write(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return false, nil
+	}
+	if r.Len() > 0 {
+		// More gob values follow, which the general path merges.
+		return false, nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(out), "."+filepath.Base(out)+".tmp*")
+	if err != nil {
+		return true, err
+	}
+	defer os.Remove(tmp.Name())
+	written, empty := 0, 0
+	p := newProgress(opts.store.progress, "encoding", total)
+	err = writeCleanJson(ctx, tmp, opts.store.bufferSize, func(write func(SectorRecord) error) error {
+		return each(func(r SectorRecord) error {
+			summary.read++
+			p.add(1)
+			if !opts.keepEmpty && r.SectorId == (SectorID{}) {
+				empty++
+				return nil
+			}
+			written++
+			clearCommit1Out(&r)
+			return write(r)
+		})
+	})
+	p.finish()
+	if empty > 0 {
+		summary.dropped += empty
+		infof("dropped %d empty record(s)", empty)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+	if err == nil && written == 0 && !opts.allowEmpty {
+		err = refuseEmptyOverwrite(out, opts.store)
+	}
+	if err == nil {
+		err = checkInterrupted(ctx)
+	}
+	if err != nil {
+		return true, err
+	}
+	if err := os.Rename(tmp.Name(), out); err != nil {
+		return true, err
+	}
+	summary.wroteFile(written, out)
+	return true, nil
+}
+
+// writeCleanJson writes the records each passes to its callback as a JSON
+// array to w, stopping once ctx is cancelled.
+func writeCleanJson(ctx context.Context, w io.Writer, bufferSize int, each func(func(SectorRecord) error) error) error {
+	a := &jsonArrayWriter{w: bufio.NewWriterSize(w, bufferSize)}
+	err := each(func(r SectorRecord) error {
+		if a.count%1024 == 0 {
+			if err := checkInterrupted(ctx); err != nil {
+				return err
+			}
+		}
+		return a.write(r)
+	})
+	if err != nil {
+		return err
+	}
+	return a.close()
+}
+
+// jsonArrayWriter writes records as the elements of one JSON array, in the
+// same compact layout storeByJson produces.
+type jsonArrayWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (a *jsonArrayWriter) write(r SectorRecord) error {
+	sep := byte(',')
+	if a.count == 0 {
+		sep = '['
+	}
+	if err := a.w.WriteByte(sep); err != nil {
+		return err
+	}
+	processingSector(r.SectorId)
+	marshaled, err := marshalFinite(r.SectorId, &r)
+	if err != nil {
+		return err
+	}
+	if marshaled, err = omitEmptyPieces(r, marshaled); err != nil {
+		return err
+	}
+	a.count++
+	_, err = a.w.Write(marshaled)
+	return err
+}
+
+func (a *jsonArrayWriter) close() error {
+	end := "]"
+	if a.count == 0 {
+		end = "[]"
+	}
+	if _, err := a.w.WriteString(end); err != nil {
+		return err
+	}
+	return a.w.Flush()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// eachRecord passes recordList to writeCleanJson.
+func eachRecord(recordList []SectorRecord) func(func(SectorRecord) error) error {
+	return func(write func(SectorRecord) error) error {
+		for _, r := range recordList {
+			if err := write(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestWriteCleanJson(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		recordList := testRecords(n)
+		var buf bytes.Buffer
+		if err := writeCleanJson(context.Background(), &buf, 16, eachRecord(recordList)); err != nil {
+			t.Fatal(err)
+		}
+		want, err := json.Marshal(recordList)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			want = []byte("[]")
+		}
+		if buf.String() != string(want) {
+			t.Errorf("%d records: got %s, want %s", n, buf.Bytes(), want)
+		}
+	}
+}
+
+func TestWriteCleanJsonStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := writeCleanJson(ctx, ioutil.Discard, 4096, eachRecord(testRecords(1))); err == nil {
+		t.Error("a cancelled write succeeded")
+	}
+}
+
+// BenchmarkWriteJsonArray compares streaming the records of a slice one by
+// one with marshaling the whole slice. Streaming holds the encoding of one
+// record at a time rather than of the whole array, so it allocates about
+// half the bytes.
+func BenchmarkWriteJsonArray(b *testing.B) {
+	recordList := testRecords(10000)
+	b.Run("streamed", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := writeCleanJson(context.Background(), ioutil.Discard, 64<<10, eachRecord(recordList)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("marshaled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			marshaled, err := json.Marshal(recordList)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(marshaled)
+		}
+	})
+}
+
+// fastPathRecords are records exercising every rule fastConvert applies:
+// an empty record to drop and commit2 sectors whose Commit1Out is cleared.
+func fastPathRecords() []SectorRecord {
+	recordList := testRecords(4)
+	recordList[1].CurrentSealTask.TaskType = TTCommit2
+	recordList[1].CurrentSealTask.Commit1Out = []byte("commit1")
+	recordList[2].CurrentSealTask.Commit1Out = []byte("kept before commit2")
+	recordList[3].CurrentSealTask.TaskType = TTCommit2
+	return append(recordList, SectorRecord{})
+}
+
+func TestFastConvertMatchesGeneralPath(t *testing.T) {
+	dir := tempDir(t)
+	inputs := map[string]interface{}{
+		"map":   recordMap(fastPathRecords()),
+		"slice": fastPathRecords(),
+	}
+	for shape, v := range inputs {
+		in := writeGobState(t, dir, shape+".gob", v)
+		opts, err := parseFlags([]string{"-in", in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fast := filepath.Join(dir, shape+"-fast.json")
+		handled, err := fastConvert(context.Background(), in, fast, opts)
+		if !handled || err != nil {
+			t.Fatalf("%s: fastConvert handled %v, %v", shape, handled, err)
+		}
+		// -explain only writes to stderr, but takes the general path.
+		general := filepath.Join(dir, shape+"-general.json")
+		mustRun(t, "-in", in, "-out", general, "-explain")
+		if got, want := readTestFile(t, fast), readTestFile(t, general); got != want {
+			t.Errorf("%s: fastConvert wrote\n%s\nthe general path\n%s", shape, got, want)
+		}
+		got := readJsonState(t, fast)
+		if len(got) != 4 || len(got[1].CurrentSealTask.Commit1Out) != 0 || string(got[2].CurrentSealTask.Commit1Out) != "kept before commit2" {
+			t.Errorf("%s: fastConvert wrote %d records without applying the cleaning rules", shape, len(got))
+		}
+	}
+}
+
+func TestFastConvertDeclinesOtherInputs(t *testing.T) {
+	dir := tempDir(t)
+	out := filepath.Join(dir, "out.json")
+	for _, in := range []string{
+		writeJsonState(t, dir, "state.json", testRecords(1)),
+		writeGobState(t, dir, "other.gob", map[string]int{"a": 1}),
+		writeTestFile(t, dir, "junk", []byte("junk")),
+	} {
+		opts, err := parseFlags([]string{"-in", in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if handled, err := fastConvert(context.Background(), in, out, opts); handled || err != nil {
+			t.Errorf("%s: handled %v, %v, want it left to the general path", in, handled, err)
+		}
+		if _, err := ioutil.ReadFile(out); err == nil {
+			t.Fatalf("%s: declining wrote %s", in, out)
+		}
+	}
+}
+
+// BenchmarkConvert compares fastConvert with the general path of loading
+// the State, cleaning it and saving it, for both gob input shapes. The
+// fast path allocates about half the bytes, and for slice inputs, which
+// skip the State map, it also takes about half the time.
+func BenchmarkConvert(b *testing.B) {
+	dir := tempDir(b)
+	recordList := make([]SectorRecord, 0, 20000)
+	for n := 1; n <= 20000; n++ {
+		r := testRecord(1000, SectorNumber(n), 5)
+		r.CurrentSealTask.TaskType = TTCommit2
+		r.CurrentSealTask.Commit1Out = make([]byte, 256)
+		recordList = append(recordList, r)
+	}
+	out := filepath.Join(dir, "out.json")
+	for _, shape := range []string{"map", "slice"} {
+		var v interface{} = recordList
+		if shape == "map" {
+			v = recordMap(recordList)
+		}
+		in := writeGobState(b, dir, shape+".gob", v)
+		opts, err := parseFlags([]string{"-in", in})
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(shape+"/fast", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if handled, err := fastConvert(context.Background(), in, out, opts); !handled || err != nil {
+					b.Fatal(handled, err)
+				}
+			}
+		})
+		b.Run(shape+"/two-pass", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s, err := newState(in, mergeOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				s.filePath = out
+				s.store = opts.store
+				if err := s.save(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
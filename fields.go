@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldTree is a set of dotted JSON field paths such as
+// CurrentSealTask.Commit2Out, stored as nested maps. An empty subtree
+// stands for the whole field.
+type fieldTree map[string]fieldTree
+
+// fieldProjection removes fields from each record's JSON, either keeping
+// only the selected paths or dropping the excluded ones.
+type fieldProjection struct {
+	tree    fieldTree
+	exclude bool
+}
+
+// newFieldProjection builds a projection from comma-separated paths given
+// to -select or -exclude-fields; at most one of them may be set.
+func newFieldProjection(selectPaths, excludePaths string) (*fieldProjection, error) {
+	if selectPaths != "" && excludePaths != "" {
+		return nil, errors.New("-select and -exclude-fields cannot be combined")
+	}
+	paths, exclude := selectPaths, false
+	if excludePaths != "" {
+		paths, exclude = excludePaths, true
+	}
+	if paths == "" {
+		return nil, nil
+	}
+	tree := make(fieldTree)
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimSpace(p)
+		if err := checkFieldPath(reflect.TypeOf(SectorRecord{}), p); err != nil {
+			return nil, err
+		}
+		node := tree
+		for _, name := range strings.Split(p, ".") {
+			sub, ok := node[name]
+			if !ok {
+				sub = make(fieldTree)
+				node[name] = sub
+			}
+			node = sub
+		}
+	}
+	return &fieldProjection{tree: tree, exclude: exclude}, nil
+}
+
+// checkFieldPath reports an error unless path names a JSON field of t.
+func checkFieldPath(t reflect.Type, path string) error {
+	for _, name := range strings.Split(path, ".") {
+		for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return fmt.Errorf("unknown field %q", path)
+		}
+		found := false
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && jsonFieldName(f) == name {
+				t = f.Type
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown field %q", path)
+		}
+	}
+	return nil
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" {
+		return tag
+	}
+	return f.Name
+}
+
+func (p *fieldProjection) apply(raw json.RawMessage) (json.RawMessage, error) {
+	return projectFields(raw, p.tree, p.exclude)
+}
+
+func projectFields(raw json.RawMessage, tree fieldTree, exclude bool) (json.RawMessage, error) {
+	if t := bytes.TrimSpace(raw); len(t) == 0 || t[0] != '{' {
+		return raw, nil
+	}
+	members, err := decodeMembers(raw)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	n := 0
+	for _, m := range members {
+		sub, listed := tree[m.key]
+		value := m.value
+		switch {
+		case listed && len(sub) == 0:
+			if exclude {
+				continue
+			}
+		case listed:
+			if value, err = projectFields(value, sub, exclude); err != nil {
+				return nil, err
+			}
+		case !exclude:
+			continue
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+		n++
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+type jsonMember struct {
+	key   string
+	value json.RawMessage
+}
+
+// decodeMembers splits a JSON object into its members, keeping their order.
+func decodeMembers(raw json.RawMessage) ([]jsonMember, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	members := make([]jsonMember, 0)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		members = append(members, jsonMember{key: tok.(string), value: value})
+	}
+	return members, nil
+}
+
+// replaceField returns raw with the value at path set by update, which
+// is given the current value. Array elements are named by their index.
+// Members missing along the path leave raw unchanged.
+func replaceField(raw json.RawMessage, path []string, update func(json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	t := bytes.TrimSpace(raw)
+	if len(t) > 0 && t[0] == '[' {
+		return replaceElement(raw, path, update)
+	}
+	if len(t) == 0 || t[0] != '{' {
+		return raw, nil
+	}
+	members, err := decodeMembers(raw)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range members {
+		value := m.value
+		if m.key == path[0] {
+			if len(path) == 1 {
+				value, err = update(value)
+			} else {
+				value, err = replaceField(value, path[1:], update)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func replaceElement(raw json.RawMessage, path []string, update func(json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		return nil, err
+	}
+	i, err := strconv.Atoi(path[0])
+	if err != nil || i < 0 || i >= len(elems) {
+		return raw, nil
+	}
+	if len(path) == 1 {
+		elems[i], err = update(elems[i])
+	} else {
+		elems[i], err = replaceField(elems[i], path[1:], update)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(elems)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFieldProjection(t *testing.T) {
+	raw := json.RawMessage(`{"a":1,"b":{"c":2,"d":[3]},"e":null}`)
+	tests := []struct {
+		tree    fieldTree
+		exclude bool
+		want    string
+	}{
+		{fieldTree{"a": {}}, false, `{"a":1}`},
+		{fieldTree{"b": {"c": {}}}, false, `{"b":{"c":2}}`},
+		{fieldTree{"a": {}, "b": {}}, false, `{"a":1,"b":{"c":2,"d":[3]}}`},
+		{fieldTree{"a": {}}, true, `{"b":{"c":2,"d":[3]},"e":null}`},
+		{fieldTree{"b": {"d": {}}}, true, `{"a":1,"b":{"c":2},"e":null}`},
+		{fieldTree{"missing": {}}, true, `{"a":1,"b":{"c":2,"d":[3]},"e":null}`},
+	}
+	for _, tt := range tests {
+		got, err := projectFields(raw, tt.tree, tt.exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("projectFields(%v, exclude %v) = %s, want %s", tt.tree, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestNewFieldProjection(t *testing.T) {
+	tests := []struct {
+		selectPaths, excludePaths string
+		ok                        bool
+	}{
+		{"", "", true},
+		{"SectorId,CurrentSealTask.TaskType", "", true},
+		{"", "CurrentSealTask.Pieces.Size", true},
+		{"", "UpdatedAt", true},
+		{"SectorId", "CurrentSealTask", false},
+		{"NoSuchField", "", false},
+		{"", "CurrentSealTask.NoSuchField", false},
+		{"", "SectorId.Miner.Deeper", false},
+		{"", "Extra", false},
+	}
+	for _, tt := range tests {
+		_, err := newFieldProjection(tt.selectPaths, tt.excludePaths)
+		if (err == nil) != tt.ok {
+			t.Errorf("newFieldProjection(%q, %q): %v, want ok %v", tt.selectPaths, tt.excludePaths, err, tt.ok)
+		}
+	}
+}
+
+func TestExcludeProofBlobs(t *testing.T) {
+	dir := tempDir(t)
+	r := testRecord(1000, 1, 1)
+	r.CurrentSealTask.Ticket = []byte("ticket")
+	r.CurrentSealTask.PreCommit1Out = []byte("pc1")
+	r.CurrentSealTask.Commit2Out = []byte("proof")
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{r}))
+	full := filepath.Join(dir, "full.json")
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", full)
+	mustRun(t, "-in", in, "-out", out, "-exclude-fields", "CurrentSealTask.PreCommit1Out, CurrentSealTask.Commit2Out")
+	var got, want []map[string]interface{}
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(readTestFile(t, full)), &want); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"PreCommit1Out", "Commit2Out"} {
+		if _, ok := got[0]["CurrentSealTask"].(map[string]interface{})[f]; ok {
+			t.Errorf("CurrentSealTask.%s is still in the output", f)
+		}
+		delete(want[0]["CurrentSealTask"].(map[string]interface{}), f)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("excluding the proof blobs changed other fields:\n%s", readTestFile(t, out))
+	}
+
+	res := runTool(t, "-in", in, "-out", out, "-select", "SectorId", "-exclude-fields", "Extra")
+	if res.err == nil || !strings.Contains(res.err.Error(), "cannot be combined") {
+		t.Errorf("-select with -exclude-fields: got %v, want an error", res.err)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-exclude-fields", "CurrentSealTask.Proof"); res.err == nil {
+		t.Error("an unknown field was accepted")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// recordFilter reports whether a record should be kept in the output.
+type recordFilter func(r SectorRecord) bool
+
+// taskTypeNames maps the symbolic names of the TaskType constants to
+// their values, so filters accept either form.
+var taskTypeNames = map[string]TaskType{
+	"TTCommit2": TTCommit2,
+}
+
+func parseTaskType(v string) TaskType {
+	if t, ok := taskTypeNames[v]; ok {
+		return t
+	}
+	return TaskType(v)
+}
+
+func taskTypeFilter(v string) recordFilter {
+	t := parseTaskType(v)
+	return func(r SectorRecord) bool {
+		return r.CurrentSealTask.TaskType == t
+	}
+}
+
+// hasTaskError reports whether the current seal or file task of r has an
+// ErrMsg. -no-errors keeps the records without, -errors-only those with.
+func hasTaskError(r SectorRecord) bool {
+	return r.CurrentSealTask.ErrMsg != "" || r.CurrentFileTask.ErrMsg != ""
+}
+
+func noErrorsFilter(r SectorRecord) bool { return !hasTaskError(r) }
+
+// filter removes the records not matched by every one of filters and
+// returns how many were removed.
+func (s *State) filter(filters []recordFilter) int {
+	if len(filters) == 0 {
+		return 0
+	}
+	n := 0
+	for id, r := range s.state {
+		for _, keep := range filters {
+			if !keep(r) {
+				delete(s.state, id)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// optionalUint is an unsigned flag that records whether it was given, for
+// filters where zero is a valid bound.
+type optionalUint struct {
+	v   uint64
+	set bool
+}
+
+func (o *optionalUint) String() string {
+	if !o.set {
+		return ""
+	}
+	return strconv.FormatUint(o.v, 10)
+}
+
+func (o *optionalUint) Set(s string) error {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	o.v, o.set = v, true
+	return nil
+}
+
+// sectorRangeFilter keeps the sectors of miner, when set, whose number
+// lies within [lo, hi]; an unset bound is open.
+func sectorRangeFilter(miner, lo, hi optionalUint) recordFilter {
+	return func(r SectorRecord) bool {
+		id := r.SectorId
+		if miner.set && uint64(id.Miner) != miner.v {
+			return false
+		}
+		if lo.set && uint64(id.Number) < lo.v {
+			return false
+		}
+		return !hi.set || uint64(id.Number) <= hi.v
+	}
+}
+
+// readSectorList reads the newline-separated sectors of filename, each
+// given as miner/number or named like s-t01000-3. Blank lines and lines
+// starting with # are skipped.
+func readSectorList(filename string) ([]SectorID, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	named := mustKeyFormat(defaultKeyFormat)
+	ids := make([]SectorID, 0)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, err := parseListedSector(named, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, sc.Err()
+}
+
+// parseListedSector parses a sector given as miner/number or named in
+// the named format.
+func parseListedSector(named *keyFormat, s string) (SectorID, error) {
+	id, err := named.parse(s)
+	if parts := strings.Split(s, "/"); err != nil && len(parts) == 2 {
+		miner, merr := strconv.ParseUint(parts[0], 10, 64)
+		number, nerr := strconv.ParseUint(parts[1], 10, 64)
+		if merr == nil && nerr == nil {
+			id, err = SectorID{Miner: ActorID(miner), Number: SectorNumber(number)}, nil
+		}
+	}
+	if err != nil {
+		return SectorID{}, fmt.Errorf("%q is neither miner/number nor a sector name like s-t01000-3", s)
+	}
+	return id, nil
+}
+
+func sectorListFilter(ids []SectorID) recordFilter {
+	listed := make(map[SectorID]bool, len(ids))
+	for _, id := range ids {
+		listed[id] = true
+	}
+	return func(r SectorRecord) bool {
+		return listed[r.SectorId]
+	}
+}
+
+// limit keeps the first n records and returns how many were removed.
+// Loading already stops after n records of each input, so this only
+// trims merged and map-shaped inputs.
+func (s *State) limit(n int) int {
+	records := s.firstRecords()
+	if len(records) <= n {
+		return 0
+	}
+	for _, r := range records[n:] {
+		delete(s.state, r.SectorId)
+	}
+	return len(records) - n
+}
+
+// firstRecords returns the records of s in the order -limit takes them:
+// the input order of slice gobs, arrays and JSON Lines, and sector order
+// for map-shaped input, whose order is lost once decoded.
+func (s *State) firstRecords() []SectorRecord {
+	if s.order != nil {
+		return orderedRecords(s.state, s.order)
+	}
+	return sortedRecords(s.state)
+}
+
+// buildFilters turns the filter flags into record filters, all of which
+// must match for a record to be kept.
+func buildFilters(opts options) ([]recordFilter, error) {
+	filters := make([]recordFilter, 0)
+	if opts.taskType != "" {
+		filters = append(filters, taskTypeFilter(opts.taskType))
+	}
+	if opts.numberMin.set && opts.numberMax.set && opts.numberMin.v > opts.numberMax.v {
+		return nil, usageErrorf("-number-min %d is greater than -number-max %d", opts.numberMin.v, opts.numberMax.v)
+	}
+	if opts.miner.set || opts.numberMin.set || opts.numberMax.set {
+		filters = append(filters, sectorRangeFilter(opts.miner, opts.numberMin, opts.numberMax))
+	}
+	if opts.sectorList != nil {
+		filters = append(filters, sectorListFilter(opts.sectorList))
+	}
+	if opts.noErrors && opts.errorsOnly {
+		return nil, usageErrorf("-no-errors and -errors-only cannot be combined")
+	}
+	if opts.noErrors {
+		filters = append(filters, noErrorsFilter)
+	}
+	if opts.errorsOnly {
+		filters = append(filters, hasTaskError)
+	}
+	if opts.where != "" {
+		keep, err := parseWhere(opts.where)
+		if err != nil {
+			return nil, usageErrorf("%v", err)
+		}
+		filters = append(filters, keep)
+	}
+	return filters, nil
+}
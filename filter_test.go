@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// taskRecords returns sectors 1 to 3 of miner 1000 at precommit1, commit2
+// and commit2 with an ErrMsg.
+func taskRecords() []SectorRecord {
+	recordList := testRecords(3)
+	recordList[1].CurrentSealTask.TaskType = TTCommit2
+	recordList[2].CurrentSealTask.TaskType = TTCommit2
+	recordList[2].CurrentSealTask.ErrMsg = "boom"
+	return recordList
+}
+
+// keptNumbers returns the numbers of the sectors of recordList every one
+// of filters keeps, in order.
+func keptNumbers(recordList []SectorRecord, filters ...recordFilter) []SectorNumber {
+	s := &State{state: recordMap(recordList)}
+	s.filter(filters)
+	kept := make([]SectorNumber, 0)
+	for _, r := range sortedRecords(s.state) {
+		kept = append(kept, r.SectorId.Number)
+	}
+	return kept
+}
+
+func TestTaskTypeFilter(t *testing.T) {
+	tests := []struct {
+		taskType string
+		want     []SectorNumber
+	}{
+		{"TTCommit2", []SectorNumber{2, 3}},
+		{"seal/v0/commit/2", []SectorNumber{2, 3}},
+		{"seal/v0/precommit/1", []SectorNumber{1}},
+		{"commit2", []SectorNumber{}},
+	}
+	for _, tt := range tests {
+		if got := keptNumbers(taskRecords(), taskTypeFilter(tt.taskType)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-tasktype %s kept %v, want %v", tt.taskType, got, tt.want)
+		}
+	}
+	if got := keptNumbers(taskRecords(), taskTypeFilter("TTCommit2"), noErrorsFilter); !reflect.DeepEqual(got, []SectorNumber{2}) {
+		t.Errorf("-tasktype TTCommit2 -no-errors kept %v, want both filters to apply", got)
+	}
+}
+
+func TestTaskTypeFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(taskRecords()))
+	out := filepath.Join(dir, "out.json")
+	mustRun(t, "-in", in, "-out", out, "-tasktype", "TTCommit2")
+	got := readJsonState(t, out)
+	if len(got) != 2 {
+		t.Fatalf("kept %d records, want the 2 at commit2", len(got))
+	}
+	for _, r := range got {
+		if r.CurrentSealTask.TaskType != TTCommit2 {
+			t.Errorf("kept sector %d at %s", r.SectorId.Number, r.CurrentSealTask.TaskType)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	reversed := []SectorID{{1000, 5}, {1000, 4}, {1000, 3}, {1000, 2}, {1000, 1}}
+	tests := []struct {
+		n       int
+		order   []SectorID
+		want    []SectorNumber
+		removed int
+	}{
+		{2, nil, []SectorNumber{1, 2}, 3},
+		{2, reversed, []SectorNumber{4, 5}, 3},
+		{2, reversed[3:], []SectorNumber{1, 2}, 3},
+		{5, reversed, []SectorNumber{1, 2, 3, 4, 5}, 0},
+		{9, nil, []SectorNumber{1, 2, 3, 4, 5}, 0},
+	}
+	for _, tt := range tests {
+		s := &State{state: recordMap(testRecords(5)), order: tt.order}
+		if removed := s.limit(tt.n); removed != tt.removed {
+			t.Errorf("limit(%d) of %v removed %d, want %d", tt.n, tt.order, removed, tt.removed)
+		}
+		if got := keptNumbers(sortedRecords(s.state)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("limit(%d) of %v kept %v, want %v", tt.n, tt.order, got, tt.want)
+		}
+	}
+}
+
+func TestLimitFlag(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(10)
+	reversed := make([]SectorRecord, 0, len(recordList))
+	for i := len(recordList) - 1; i >= 0; i-- {
+		reversed = append(reversed, recordList[i])
+	}
+	out := filepath.Join(dir, "out.json")
+
+	// Input with an order gives its first records, a map the lowest IDs.
+	var jsonl []byte
+	for _, r := range reversed {
+		line, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jsonl = append(append(jsonl, line...), '\n')
+	}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"slice", writeGobState(t, dir, "slice.gob", reversed), "10 9 8"},
+		{"array", writeJsonState(t, dir, "array.json", reversed), "10 9 8"},
+		{"jsonl", writeTestFile(t, dir, "lines.jsonl", jsonl), "10 9 8"},
+		{"map", writeGobState(t, dir, "map.gob", recordMap(reversed)), "1 2 3"},
+	}
+	for _, tt := range tests {
+		mustRun(t, "-in", tt.in, "-out", out, "-limit", "3", "-preserve-order")
+		if got := sectorNumbers(readJsonState(t, out)); got != tt.want {
+			t.Errorf("%s: wrote sectors %s, want %s", tt.name, got, tt.want)
+		}
+		// Without -preserve-order the same records are written in sector
+		// order.
+		mustRun(t, "-in", tt.in, "-out", out, "-limit", "3")
+		if got := readJsonState(t, out); len(got) != 3 || got[0].SectorId.Number > got[2].SectorId.Number {
+			t.Errorf("%s: wrote sectors %s, want 3 in sector order", tt.name, sectorNumbers(got))
+		}
+	}
+
+	// Loading stops before the records past the limit, so damage there
+	// goes unnoticed.
+	array, err := json.Marshal(recordList[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var slice bytes.Buffer
+	if err := gob.NewEncoder(&slice).Encode(recordList[:3]); err != nil {
+		t.Fatal(err)
+	}
+	damaged := []struct {
+		name string
+		data []byte
+	}{
+		{"array.json", append(array[:len(array)-1], []byte(`,{"SectorId":"bad"}]`)...)},
+		{"lines.jsonl", append(jsonl[:len(jsonl):len(jsonl)], []byte(`{"SectorId":"bad"}`+"\n")...)},
+		{"appended.gob", append(slice.Bytes(), "not a gob value"...)},
+	}
+	for _, d := range damaged {
+		in := writeTestFile(t, dir, d.name, d.data)
+		if res := runTool(t, "-in", in, "-out", out); res.err == nil {
+			t.Errorf("%s loaded in full", d.name)
+		}
+		mustRun(t, "-in", in, "-out", out, "-limit", "3")
+		if got := readJsonState(t, out); len(got) != 3 {
+			t.Errorf("%s: -limit 3 wrote %d records", d.name, len(got))
+		}
+	}
+
+	// The limit is taken before filtering.
+	in := tests[0].in
+	mustRun(t, "-in", in, "-out", out, "-limit", "3", "-number-max", "8")
+	if got := sectorNumbers(readJsonState(t, out)); got != "8" {
+		t.Errorf("-limit with a filter wrote sectors %s, want those of the first 3 kept", got)
+	}
+	res := mustRun(t, "-in", in, "-out", out, "-limit", "3", "-explain")
+	if !strings.Contains(res.stderr, "plan: stop loading each input after 3 record(s), taken in input order") {
+		t.Errorf("-explain printed\n%s", res.stderr)
+	}
+	res = mustRun(t, "-in", tests[3].in, "-out", out, "-limit", "3", "-explain")
+	if !strings.Contains(res.stderr, "taken by lowest sector ID, as the input is map-shaped") {
+		t.Errorf("-explain of a map printed\n%s", res.stderr)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-limit", "-1"); errorType(res.err) != "usage" {
+		t.Errorf("-limit -1: got %v, want a usage error", res.err)
+	}
+}
+
+func TestSectorRangeFilter(t *testing.T) {
+	recordList := append(testRecords(5), testRecord(2000, 3, 1))
+	set := func(v uint64) optionalUint { return optionalUint{v: v, set: true} }
+	var unset optionalUint
+	tests := []struct {
+		miner, lo, hi optionalUint
+		want          []SectorID
+	}{
+		{unset, set(2), set(3), []SectorID{{1000, 2}, {1000, 3}, {2000, 3}}},
+		{set(1000), set(2), set(3), []SectorID{{1000, 2}, {1000, 3}}},
+		{set(1000), set(4), unset, []SectorID{{1000, 4}, {1000, 5}}},
+		{set(1000), unset, set(1), []SectorID{{1000, 1}}},
+		{set(2000), unset, unset, []SectorID{{2000, 3}}},
+		{set(1000), set(3), set(3), []SectorID{{1000, 3}}},
+		{set(1000), set(0), set(0), []SectorID{}},
+	}
+	for _, tt := range tests {
+		s := &State{state: recordMap(recordList)}
+		s.filter([]recordFilter{sectorRangeFilter(tt.miner, tt.lo, tt.hi)})
+		got := make([]SectorID, 0)
+		for _, r := range sortedRecords(s.state) {
+			got = append(got, r.SectorId)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-miner %s -number-min %s -number-max %s kept %v, want %v", &tt.miner, &tt.lo, &tt.hi, got, tt.want)
+		}
+	}
+}
+
+func TestSectorRangeFlags(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(300)
+	in := writeGobState(t, dir, "in.gob", recordMap(append(recordList, testRecord(2000, 150, 1))))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-miner", "1000", "-number-min", "100", "-number-max", "200")
+	got := readJsonState(t, out)
+	if len(got) != 101 || got[0].SectorId.Number != 100 || got[100].SectorId.Number != 200 {
+		t.Errorf("kept %d records, want sectors 100 to 200 of miner 1000", len(got))
+	}
+	for _, r := range got {
+		if r.SectorId.Miner != 1000 {
+			t.Errorf("kept a sector of miner %d", r.SectorId.Miner)
+		}
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-number-min", "5", "-number-max", "4"); errorType(res.err) != "usage" {
+		t.Errorf("-number-min above -number-max: got %v, want a usage error", res.err)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-number-min", "-1"); res.code != 2 {
+		t.Errorf("-number-min -1: exit status %d, want the flag rejected", res.code)
+	}
+}
+
+func TestReadSectorList(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		list string
+		want []SectorID
+		ok   bool
+	}{
+		{"1000/1\ns-t01000-3\n", []SectorID{{1000, 1}, {1000, 3}}, true},
+		{"# incident 42\n\n  2000/7  \n", []SectorID{{2000, 7}}, true},
+		{"# nothing listed\n", []SectorID{}, true},
+		{"1000/1\n1000-2\n", nil, false},
+		{"1000/x\n", nil, false},
+		{"t01000/1\n", nil, false},
+	}
+	for _, tt := range tests {
+		got, err := readSectorList(writeTestFile(t, dir, "sectors", []byte(tt.list)))
+		if (err == nil) != tt.ok || tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+	_, err := readSectorList(writeTestFile(t, dir, "sectors", []byte("1000/1\nbogus\n")))
+	if err == nil || !strings.Contains(err.Error(), "sectors:2:") {
+		t.Errorf("got %v, want the bad line numbered", err)
+	}
+}
+
+func TestSectorListFilter(t *testing.T) {
+	recordList := append(testRecords(5), testRecord(2000, 3, 1))
+	tests := []struct {
+		ids  []SectorID
+		want []SectorNumber
+	}{
+		{[]SectorID{{1000, 2}, {1000, 4}}, []SectorNumber{2, 4}},
+		{[]SectorID{{2000, 3}, {1000, 9}}, []SectorNumber{3}},
+		{[]SectorID{}, []SectorNumber{}},
+	}
+	for _, tt := range tests {
+		if got := keptNumbers(recordList, sectorListFilter(tt.ids)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("listing %v kept %v, want %v", tt.ids, got, tt.want)
+		}
+	}
+}
+
+func TestSectorsFromFile(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(10)))
+	list := writeTestFile(t, dir, "incident.txt", []byte("1000/3\ns-t01000-7\n1000/42\n"))
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", in, "-out", out, "-sectors-from-file", list)
+	got := readJsonState(t, out)
+	if len(got) != 2 || got[0].SectorId.Number != 3 || got[1].SectorId.Number != 7 {
+		t.Errorf("kept %d records, want exactly sectors 3 and 7", len(got))
+	}
+	if !strings.Contains(res.log, "s-t01000-42 is listed in "+list+" but not in the input") {
+		t.Errorf("the missing sector 42 was not warned about:\n%s", res.log)
+	}
+	if strings.Contains(res.log, "s-t01000-3 is listed") {
+		t.Errorf("a sector that was found was warned about:\n%s", res.log)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-sectors-from-file", list, "-fail-on-warning"); res.code != 1 {
+		t.Errorf("-fail-on-warning with a sector not found: exit status %d, want 1", res.code)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-sectors-from-file", filepath.Join(dir, "missing")); res.err == nil {
+		t.Error("a missing -sectors-from-file was accepted")
+	}
+}
+
+// errorRecords are sectors 1 to 4 of miner 1000: 2 with a seal task
+// ErrMsg, 3 with a file task ErrMsg and 4 with both.
+func errorRecords() []SectorRecord {
+	recordList := testRecords(4)
+	recordList[1].CurrentSealTask.ErrMsg = "seal failed"
+	recordList[2].CurrentFileTask.ErrMsg = "move failed"
+	recordList[3].CurrentSealTask.ErrMsg = "seal failed"
+	recordList[3].CurrentFileTask.ErrMsg = "move failed"
+	return recordList
+}
+
+func TestErrorFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter recordFilter
+		want   []SectorNumber
+	}{
+		{"-no-errors", noErrorsFilter, []SectorNumber{1}},
+		{"-errors-only", hasTaskError, []SectorNumber{2, 3, 4}},
+	}
+	for _, tt := range tests {
+		if got := keptNumbers(errorRecords(), tt.filter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestErrorFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(errorRecords()))
+	out := filepath.Join(dir, "out.json")
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{"-no-errors", "1"},
+		{"-errors-only", "2 3 4"},
+	}
+	for _, tt := range tests {
+		mustRun(t, "-in", in, "-out", out, tt.flag)
+		if got := sectorNumbers(readJsonState(t, out)); got != tt.want {
+			t.Errorf("%s kept the sectors %s, want %s", tt.flag, got, tt.want)
+		}
+	}
+	mustRun(t, "-in", in, "-out", out)
+	if got := sectorNumbers(readJsonState(t, out)); got != "1 2 3 4" {
+		t.Errorf("without either flag kept the sectors %s, want all", got)
+	}
+	res := runTool(t, "-in", in, "-out", out, "-no-errors", "-errors-only")
+	if errorType(res.err) != "usage" || !strings.Contains(res.err.Error(), "-no-errors and -errors-only cannot be combined") {
+		t.Errorf("-no-errors with -errors-only: got %v, want a usage error", res.err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// lotusFixture is a state_data file captured from a lotus scheduler run,
+// and lotusFixtureSectors the sector,phase records it is known to hold;
+// see testdata/README.md.
+var (
+	lotusFixture        = filepath.Join("testdata", "lotus", "state_data")
+	lotusFixtureSectors = filepath.Join("testdata", "lotus", "sectors.csv")
+)
+
+func TestLotusFixture(t *testing.T) {
+	if _, err := os.Stat(lotusFixture); os.IsNotExist(err) {
+		t.Skip("no lotus scheduler capture in " + lotusFixture + " yet, see testdata/README.md")
+	}
+	want, err := readPhaseChanges(lotusFixtureSectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) == 0 {
+		t.Fatalf("%s lists no sectors to check the capture against", lotusFixtureSectors)
+	}
+	if res := mustRun(t, "-in", lotusFixture, "-dump-gob-types"); res.stdout == "" {
+		t.Error("-dump-gob-types printed nothing")
+	}
+	out := filepath.Join(tempDir(t), "out.json")
+	for _, args := range [][]string{{}, {"-canonical"}} {
+		mustRun(t, append([]string{"-in", lotusFixture, "-out", out}, args...)...)
+		got := loadTestState(t, out)
+		for _, w := range want {
+			r, ok := got[w.sector]
+			switch {
+			case !ok:
+				t.Errorf("%q: %s:%d: %s is not in the converted capture", args, lotusFixtureSectors, w.line, sectorName(w.sector))
+			case r.SectorWorkingPhase != w.phase:
+				t.Errorf("%q: %s:%d: %s is at phase %d, want %d", args, lotusFixtureSectors, w.line, sectorName(w.sector), r.SectorWorkingPhase, w.phase)
+			}
+		}
+	}
+}
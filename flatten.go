@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// flatRow is one denormalized output row: the scalar fields of a sector
+// and of its current seal task, plus one of the task's pieces. The piece
+// fields are null for sectors without pieces.
+type flatRow struct {
+	Miner              ActorID             `json:"miner"`
+	Number             SectorNumber        `json:"number"`
+	SectorWorkingPhase SectorWorkingPhase  `json:"sectorWorkingPhase"`
+	TaskType           TaskType            `json:"taskType"`
+	SealProofType      RegisteredSealProof `json:"sealProofType"`
+	Finalized          bool                `json:"finalized"`
+	ErrMsg             string              `json:"errMsg"`
+	FileTaskType       FileTaskType        `json:"fileTaskType"`
+	FileTaskDone       bool                `json:"fileTaskDone"`
+	P1WorkerAddress    string              `json:"p1WorkerAddress"`
+	P2WorkerAddress    string              `json:"p2WorkerAddress"`
+	C1WorkerAddress    string              `json:"c1WorkerAddress"`
+	C2WorkerAddress    string              `json:"c2WorkerAddress"`
+
+	PieceIndex *int             `json:"pieceIndex"`
+	PieceSize  *PaddedPieceSize `json:"pieceSize"`
+	PieceCID   *cid.Cid         `json:"pieceCid"`
+}
+
+// flattenRecord returns one row per piece of r, or a single row with null
+// piece fields when r has none.
+func flattenRecord(r SectorRecord) []flatRow {
+	base := flatRow{
+		Miner:              r.SectorId.Miner,
+		Number:             r.SectorId.Number,
+		SectorWorkingPhase: r.SectorWorkingPhase,
+		TaskType:           r.CurrentSealTask.TaskType,
+		SealProofType:      r.CurrentSealTask.SealProofType,
+		Finalized:          r.CurrentSealTask.Finalized,
+		ErrMsg:             r.CurrentSealTask.ErrMsg,
+		FileTaskType:       r.CurrentFileTask.FileTaskType,
+		FileTaskDone:       r.CurrentFileTask.Done,
+		P1WorkerAddress:    r.P1WorkerAddress,
+		P2WorkerAddress:    r.P2WorkerAddress,
+		C1WorkerAddress:    r.C1WorkerAddress,
+		C2WorkerAddress:    r.C2WorkerAddress,
+	}
+	pieces := r.CurrentSealTask.Pieces
+	if len(pieces) == 0 {
+		return []flatRow{base}
+	}
+	rows := make([]flatRow, 0, len(pieces))
+	for i := range pieces {
+		row := base
+		idx := i
+		row.PieceIndex = &idx
+		row.PieceSize = &pieces[i].Size
+		row.PieceCID = &pieces[i].PieceCID
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// storeFlattened writes the flattened rows of data as JSON Lines, after a
+// countHeader of the rows if opts.withCount is set, at most opts.rate
+// records a second. The rows are streamed through a buffer of
+// opts.bufferSize bytes into a temporary file next to filename, which
+// replaces filename once complete, so a failed or interrupted write leaves
+// filename as it was.
+func storeFlattened(ctx context.Context, data map[SectorID]SectorRecord, filename string, opts storeOptions) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	records := sortedRecords(data)
+	if err := writeFlattened(ctx, tmp, records, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := checkInterrupted(ctx); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	summary.wroteFile(len(records), filename)
+	return nil
+}
+
+func writeFlattened(ctx context.Context, f io.Writer, records []SectorRecord, opts storeOptions) error {
+	w := bufio.NewWriterSize(f, opts.bufferSize)
+	enc := json.NewEncoder(w)
+	if opts.withCount {
+		rows := 0
+		for _, r := range records {
+			rows += len(flattenRecord(r))
+		}
+		if err := enc.Encode(countHeader{Count: rows}); err != nil {
+			return err
+		}
+	}
+	p := newPacer(opts.rate)
+	for _, r := range records {
+		if err := checkInterrupted(ctx); err != nil {
+			return err
+		}
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+		for _, row := range flattenRecord(r) {
+			if err := enc.Encode(row); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
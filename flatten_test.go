@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readLines decodes every JSON line of the file name into a generic object.
+func readLines(t *testing.T, name string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var rows []map[string]interface{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var row map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &row); err != nil {
+			t.Fatalf("%s: %v", sc.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+func TestFlatten(t *testing.T) {
+	dir := tempDir(t)
+	three := testRecord(1000, 1, 1)
+	three.CurrentSealTask.Pieces = []PieceInfo{{Size: 2048}, {Size: 4096, PieceCID: testCid(t, "piece")}, {Size: 8192}}
+	none := testRecord(1000, 2, 2)
+	none.CurrentSealTask.Pieces = nil
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{three, none, testRecord(1000, 3, 3)}))
+	out := filepath.Join(dir, "out.jsonl")
+
+	mustRun(t, "-in", in, "-out", out, "-flatten")
+	rows := readLines(t, out)
+	if len(rows) != 5 {
+		t.Fatalf("got %d rows, want 3 + 1 + 1", len(rows))
+	}
+	if rows[1]["number"] != 1.0 || rows[1]["pieceIndex"] != 1.0 || rows[1]["pieceSize"] != 4096.0 {
+		t.Errorf("the second row is %v, want the second piece of sector 1", rows[1])
+	}
+	if got := rows[1]["pieceCid"].(map[string]interface{})["/"]; got != testCid(t, "piece").String() {
+		t.Errorf("pieceCid = %v, want %s", got, testCid(t, "piece"))
+	}
+	if rows[3]["number"] != 2.0 {
+		t.Errorf("the fourth row is %v, want the one of sector 2", rows[3])
+	}
+	for _, f := range []string{"pieceIndex", "pieceSize", "pieceCid"} {
+		if v, ok := rows[3][f]; !ok || v != nil {
+			t.Errorf("the row of sector 2 has %s %v, want null", f, v)
+		}
+	}
+	for _, row := range rows {
+		if row["miner"] != 1000.0 || row["p1WorkerAddress"] != "10.0.0.1:3456" {
+			t.Errorf("row %v lacks the sector fields", row)
+		}
+	}
+}
+
+func TestFlattenWithCount(t *testing.T) {
+	dir := tempDir(t)
+	r := testRecord(1000, 1, 1)
+	r.CurrentSealTask.Pieces = append(r.CurrentSealTask.Pieces, PieceInfo{Size: 2048})
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{r, testRecord(1000, 2, 2)}))
+	out := filepath.Join(dir, "out.jsonl")
+
+	mustRun(t, "-in", in, "-out", out, "-flatten", "-with-count")
+	rows := readLines(t, out)
+	if len(rows) != 4 || rows[0]["@count"] != 3.0 {
+		t.Errorf("got %v, want a count of 3 rows before them", rows)
+	}
+}
+
+func TestOutputBufferSize(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(50)))
+	want := filepath.Join(dir, "want.jsonl")
+	mustRun(t, "-in", in, "-out", want, "-flatten")
+	for _, size := range []string{"1", "100", "1048576"} {
+		out := filepath.Join(dir, "out-"+size+".jsonl")
+		mustRun(t, "-in", in, "-out", out, "-flatten", "-output-buffer-size", size)
+		if readTestFile(t, out) != readTestFile(t, want) {
+			t.Errorf("-output-buffer-size %s changed the output", size)
+		}
+	}
+	for _, size := range []string{"0", "-1"} {
+		if res := runTool(t, "-in", in, "-out", want, "-flatten", "-output-buffer-size", size); errorType(res.err) != "usage" {
+			t.Errorf("-output-buffer-size %s: got %v, want a usage error", size, res.err)
+		}
+	}
+}
+
+// BenchmarkStoreFlattened writes the rows of a large state to a file
+// through buffers of different sizes. bufio passes writes longer than its
+// buffer straight to the file, so with 16 bytes every row is its own
+// write system call, as without a buffer.
+func BenchmarkStoreFlattened(b *testing.B) {
+	data := make(map[SectorID]SectorRecord, 20000)
+	for n := 0; n < 20000; n++ {
+		r := testRecord(1000, SectorNumber(n), 1)
+		data[r.SectorId] = r
+	}
+	out := filepath.Join(tempDir(b), "out.jsonl")
+	for _, size := range []int{16, 4 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := storeFlattened(context.Background(), data, out, storeOptions{bufferSize: size}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
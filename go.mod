@@ -6,4 +6,10 @@ require (
 	github.com/google/uuid v1.1.2
 	github.com/ipfs/go-cid v0.0.7
 	github.com/mitchellh/go-homedir v1.1.0
+	github.com/multiformats/go-multihash v0.0.13
+	github.com/pkg/sftp v1.13.0
+	github.com/prometheus/client_golang v1.11.1
+	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.11.2
 )
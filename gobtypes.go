@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// gobType is a type descriptor as sent on the gob wire, read without
+// decoding the value that follows it.
+type gobType struct {
+	ID     int64
+	Name   string
+	Kind   string
+	Fields []gobField
+	Key    int64
+	Elem   int64
+	Len    int64
+}
+
+type gobField struct {
+	Name string
+	ID   int64
+}
+
+// Ids of the types predefined by encoding/gob.
+var gobBuiltinTypes = map[int64]string{
+	1: "bool",
+	2: "int",
+	3: "uint",
+	4: "float",
+	5: "[]byte",
+	6: "string",
+	7: "complex",
+	8: "interface",
+}
+
+// Field numbers of encoding/gob's wireType.
+var gobWireKinds = []string{"array", "slice", "struct", "map", "GobEncoder", "BinaryMarshaler", "TextMarshaler"}
+
+// readGobTypes reads the type definitions at the head of a gob stream and
+// stops at the first value message, returning the id of the value's type.
+func readGobTypes(r io.Reader) ([]gobType, int64, error) {
+	br := bufio.NewReader(r)
+	types := make([]gobType, 0)
+	for {
+		n, err := readGobUint(br)
+		if err != nil {
+			if err == io.EOF {
+				return types, 0, errors.New("gob stream holds no value")
+			}
+			return nil, 0, err
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return nil, 0, err
+		}
+		mr := bytes.NewReader(msg)
+		id, err := readGobInt(mr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if id >= 0 {
+			return types, id, nil
+		}
+		t, err := readGobWireType(mr)
+		if err != nil {
+			return nil, 0, fmt.Errorf("type %d: %w", -id, err)
+		}
+		t.ID = -id
+		types = append(types, t)
+	}
+}
+
+func readGobWireType(r *bytes.Reader) (gobType, error) {
+	var t gobType
+	err := readGobStruct(r, func(field int) error {
+		if field >= len(gobWireKinds) {
+			return fmt.Errorf("unknown wireType field %d", field)
+		}
+		t.Kind = gobWireKinds[field]
+		return readGobStruct(r, func(f int) error {
+			var err error
+			switch {
+			case f == 0:
+				t.Name, err = readGobCommonType(r)
+			case t.Kind == "struct" && f == 1:
+				t.Fields, err = readGobFields(r)
+			case t.Kind == "map" && f == 1:
+				t.Key, err = readGobInt(r)
+			case (t.Kind == "array" || t.Kind == "slice") && f == 1, t.Kind == "map" && f == 2:
+				t.Elem, err = readGobInt(r)
+			case t.Kind == "array" && f == 2:
+				t.Len, err = readGobInt(r)
+			default:
+				err = fmt.Errorf("unknown %s field %d", t.Kind, f)
+			}
+			return err
+		})
+	})
+	return t, err
+}
+
+func readGobCommonType(r *bytes.Reader) (string, error) {
+	var name string
+	err := readGobStruct(r, func(f int) error {
+		var err error
+		switch f {
+		case 0:
+			name, err = readGobString(r)
+		case 1:
+			_, err = readGobInt(r)
+		default:
+			err = fmt.Errorf("unknown CommonType field %d", f)
+		}
+		return err
+	})
+	return name, err
+}
+
+func readGobFields(r *bytes.Reader) ([]gobField, error) {
+	n, err := readGobUint(r)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]gobField, 0, n)
+	for i := uint64(0); i < n; i++ {
+		var fd gobField
+		err := readGobStruct(r, func(f int) error {
+			var err error
+			switch f {
+			case 0:
+				fd.Name, err = readGobString(r)
+			case 1:
+				fd.ID, err = readGobInt(r)
+			default:
+				err = fmt.Errorf("unknown fieldType field %d", f)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fd)
+	}
+	return fields, nil
+}
+
+// readGobStruct walks the delta-encoded fields of a gob struct value,
+// calling field with the index of each one present.
+func readGobStruct(r *bytes.Reader, field func(int) error) error {
+	i := -1
+	for {
+		delta, err := readGobUint(r)
+		if err != nil {
+			return err
+		}
+		if delta == 0 {
+			return nil
+		}
+		i += int(delta)
+		if err := field(i); err != nil {
+			return err
+		}
+	}
+}
+
+func readGobUint(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return uint64(b), nil
+	}
+	n := -int(int8(b))
+	if n > 8 {
+		return 0, errors.New("gob: uint too large")
+	}
+	var x uint64
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		x = x<<8 | uint64(b)
+	}
+	return x, nil
+}
+
+func readGobInt(r io.ByteReader) (int64, error) {
+	u, err := readGobUint(r)
+	if err != nil {
+		return 0, err
+	}
+	if u&1 != 0 {
+		return ^int64(u >> 1), nil
+	}
+	return int64(u >> 1), nil
+}
+
+func readGobString(r *bytes.Reader) (string, error) {
+	n, err := readGobUint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+// dumpGobTypes prints the encoded type structure of the gob file at filePath.
+func dumpGobTypes(w io.Writer, filePath string) error {
+	f, err := openInput(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, _, err := gunzipReader(f)
+	if err != nil {
+		return err
+	}
+	types, valueID, err := readGobTypes(r)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int64]gobType, len(types))
+	for _, t := range types {
+		byID[t.ID] = t
+	}
+	typeName := func(id int64) string {
+		if name, ok := gobBuiltinTypes[id]; ok {
+			return name
+		}
+		if t, ok := byID[id]; ok {
+			if t.Name == "" {
+				return t.Kind
+			}
+			return fmt.Sprintf("%s (%s)", t.Name, t.Kind)
+		}
+		return fmt.Sprintf("type %d", id)
+	}
+
+	fmt.Fprintf(w, "value: %s\n", typeName(valueID))
+	for _, t := range types {
+		fmt.Fprintf(w, "type %d %s", t.ID, strings.TrimSpace(t.Name+" "+t.Kind))
+		switch t.Kind {
+		case "struct":
+			fields := make([]string, 0, len(t.Fields))
+			for _, fd := range t.Fields {
+				fields = append(fields, fmt.Sprintf("\t%s %s\n", fd.Name, typeName(fd.ID)))
+			}
+			fmt.Fprintf(w, " {\n%s}", strings.Join(fields, ""))
+		case "map":
+			fmt.Fprintf(w, " [%s]%s", typeName(t.Key), typeName(t.Elem))
+		case "slice":
+			fmt.Fprintf(w, " []%s", typeName(t.Elem))
+		case "array":
+			fmt.Fprintf(w, " [%d]%s", t.Len, typeName(t.Elem))
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func dumpTestGob(t *testing.T, v interface{}) string {
+	t.Helper()
+	in := writeGobState(t, tempDir(t), "state.gob", v)
+	var buf bytes.Buffer
+	if err := dumpGobTypes(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// encoding/gob names a type after the first value that registered it in
+// the process, and a type first reached through a map goes unnamed, so
+// only the layout is checked.
+func TestDumpGobTypes(t *testing.T) {
+	tests := []struct {
+		v     interface{}
+		value string
+	}{
+		{testRecords(2), "value: slice\n"},
+		{recordMap(testRecords(2)), "value: map\n"},
+	}
+	for _, tt := range tests {
+		dump := dumpTestGob(t, tt.v)
+		for _, want := range []string{
+			tt.value,
+			"struct {\n\tMiner uint\n\tNumber uint\n}",
+			"\tSectorWorkingPhase int\n",
+			"\tP1WorkerAddress string\n",
+			"\tErrMsg string\n",
+		} {
+			if !strings.Contains(dump, want) {
+				t.Errorf("dump lacks %q:\n%s", want, dump)
+			}
+		}
+	}
+}
+
+func TestDumpGobTypesFlag(t *testing.T) {
+	in := writeGobState(t, tempDir(t), "state.gob", testRecords(1))
+	res := mustRun(t, "-in", in, "-dump-gob-types")
+	if !strings.HasPrefix(res.stdout, in+"\nvalue: slice\n") {
+		t.Errorf("-dump-gob-types printed:\n%s", res.stdout)
+	}
+}
+
+func TestDumpGobTypesRejectsJson(t *testing.T) {
+	in := writeJsonState(t, tempDir(t), "state.json", testRecords(1))
+	if err := dumpGobTypes(&bytes.Buffer{}, in); err == nil {
+		t.Error("dumping the types of a JSON file succeeded")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// groupKeys are the record properties -group-by can count by.
+var groupKeys = map[string]func(r SectorRecord) string{
+	"phase":    func(r SectorRecord) string { return strconv.Itoa(int(r.SectorWorkingPhase)) },
+	"tasktype": func(r SectorRecord) string { return string(r.CurrentSealTask.TaskType) },
+	"miner":    func(r SectorRecord) string { return fmt.Sprintf("t0%d", r.SectorId.Miner) },
+	"finalized": func(r SectorRecord) string {
+		return strconv.FormatBool(r.CurrentSealTask.Finalized)
+	},
+	"worker": func(r SectorRecord) string {
+		if w := currentWorker(r); w != "" {
+			return w
+		}
+		return unassignedWorker
+	},
+}
+
+func parseGroupBy(v string) ([]func(r SectorRecord) string, error) {
+	keys := make([]func(r SectorRecord) string, 0)
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		key, ok := groupKeys[name]
+		if !ok {
+			names := make([]string, 0, len(groupKeys))
+			for n := range groupKeys {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, usageErrorf("unknown -group-by field %q, have %s", name, strings.Join(names, ", "))
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// groupCounts counts records by the first of keys, nesting an object of
+// counts by the remaining keys under every value.
+func groupCounts(records []SectorRecord, keys []func(r SectorRecord) string) map[string]interface{} {
+	groups := make(map[string][]SectorRecord)
+	for _, r := range records {
+		k := keys[0](r)
+		groups[k] = append(groups[k], r)
+	}
+	counts := make(map[string]interface{}, len(groups))
+	for k, group := range groups {
+		if len(keys) == 1 {
+			counts[k] = len(group)
+		} else {
+			counts[k] = groupCounts(group, keys[1:])
+		}
+	}
+	return counts
+}
+
+// writeGroupCounts writes the counts of data grouped by keys to w as JSON.
+func writeGroupCounts(w io.Writer, data map[SectorID]SectorRecord, keys []func(r SectorRecord) string) error {
+	records := make([]SectorRecord, 0, len(data))
+	for _, r := range data {
+		records = append(records, r)
+	}
+	return json.NewEncoder(w).Encode(groupCounts(records, keys))
+}
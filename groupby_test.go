@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGroupCounts(t *testing.T) {
+	done := testRecord(1001, 2, 3)
+	done.CurrentSealTask.Finalized = true
+	done.C1WorkerAddress = "10.0.0.9:3456"
+	idle := testRecord(1000, 3, 0)
+	idle.P1WorkerAddress = ""
+	records := []SectorRecord{testRecord(1000, 1, 3), done, idle}
+
+	tests := []struct {
+		groupBy string
+		want    string
+	}{
+		{"phase", `{"0":1,"3":2}`},
+		{"tasktype", `{"seal/v0/precommit/1":3}`},
+		{"miner", `{"t01000":2,"t01001":1}`},
+		{"worker", `{"10.0.0.1:3456":1,"10.0.0.9:3456":1,"unassigned":1}`},
+		{"finalized", `{"false":2,"true":1}`},
+		{"miner,phase", `{"t01000":{"0":1,"3":1},"t01001":{"3":1}}`},
+		{"phase, finalized ,miner", `{"0":{"false":{"t01000":1}},"3":{"false":{"t01000":1},"true":{"t01001":1}}}`},
+	}
+	for _, tt := range tests {
+		keys, err := parseGroupBy(tt.groupBy)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.groupBy, err)
+		}
+		got, err := json.Marshal(groupCounts(records, keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("-group-by %s = %s, want %s", tt.groupBy, got, tt.want)
+		}
+	}
+}
+
+func TestParseGroupByRejectsUnknownFields(t *testing.T) {
+	for _, v := range []string{"sector", "phase,", "phase,Phase"} {
+		_, err := parseGroupBy(v)
+		if errorType(err) != "usage" || !strings.Contains(err.Error(), "have finalized, miner, phase, tasktype, worker") {
+			t.Errorf("parseGroupBy(%q): got %v, want a usage error listing the fields", v, err)
+		}
+	}
+}
+
+func TestGroupByFlag(t *testing.T) {
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap(testRecords(3)))
+	res := mustRun(t, "-in", in, "-group-by", "phase,tasktype", "-number-min", "2")
+	var got map[string]map[string]int
+	if err := json.NewDecoder(strings.NewReader(res.stdout)).Decode(&got); err != nil {
+		t.Fatalf("%v:\n%s", err, res.stdout)
+	}
+	want := map[string]map[string]int{
+		"2": {"seal/v0/precommit/1": 1},
+		"3": {"seal/v0/precommit/1": 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want the filtered counts %v", got, want)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+// gzipMagic starts every gzip stream. Inputs are sniffed for it rather
+// than trusting a .gz suffix, since backups are often compressed under
+// their plain name.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzipBytes returns raw decompressed if it is gzip, or raw otherwise.
+func gunzipBytes(raw []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(raw, gzipMagic) {
+		return raw, false, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, true, err
+	}
+	defer zr.Close()
+	data, err := ioutil.ReadAll(zr)
+	return data, true, err
+}
+
+// gunzipReader returns r decompressed if it starts with the gzip magic,
+// and whether it did.
+func gunzipReader(r io.Reader) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(gzipMagic))
+	if !bytes.Equal(head, gzipMagic) {
+		return br, false, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, true, err
+	}
+	return zr, true, nil
+}
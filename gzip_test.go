@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gzipTestFile compresses the file name in place, keeping its name.
+func gzipTestFile(t *testing.T, name string) {
+	t.Helper()
+	raw, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(raw)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGzipInputWithoutSuffix(t *testing.T) {
+	dir := tempDir(t)
+	inputs := []string{
+		writeGobState(t, dir, "state.gob", recordMap(testRecords(3))),
+		writeJsonState(t, dir, "state.json", testRecords(3)),
+	}
+	for _, in := range inputs {
+		gzipTestFile(t, in)
+		out := filepath.Join(dir, "out.json")
+		for _, args := range [][]string{{}, {"-mmap"}, {"-canonical"}} {
+			mustRun(t, append([]string{"-in", in, "-out", out}, args...)...)
+			if got := readJsonState(t, out); len(got) != 3 {
+				t.Errorf("%s %q: got %d records, want 3", filepath.Base(in), args, len(got))
+			}
+		}
+	}
+
+	res := mustRun(t, "-in", inputs[0], "-dump-gob-types")
+	if !strings.HasPrefix(res.stdout, inputs[0]+"\nvalue: map\n") {
+		t.Errorf("-dump-gob-types did not look through the compression:\n%s", res.stdout)
+	}
+}
+
+func TestGzipInputCorrupt(t *testing.T) {
+	dir := tempDir(t)
+	in := writeTestFile(t, dir, "state.gob", append(append([]byte{}, gzipMagic...), "not gzip"...))
+	if res := runTool(t, "-in", in, "-out", filepath.Join(dir, "out.json")); res.err == nil {
+		t.Error("converting a corrupt gzip stream succeeded")
+	}
+}
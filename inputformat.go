@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// inputFormats maps -in paths to the format -input-format gives them.
+// Files found in an -in directory take the directory's format.
+var inputFormats = make(map[string]string)
+
+// setInputFormats pairs the comma-separated formats with inputs in order,
+// repeating the last format for the remaining inputs.
+func setInputFormats(list string, inputs []string) error {
+	inputFormats = make(map[string]string)
+	formats := strings.Split(list, ",")
+	if len(formats) > len(inputs) {
+		return usageErrorf("-input-format lists %d formats for %d -in", len(formats), len(inputs))
+	}
+	for i, in := range inputs {
+		f := strings.TrimSpace(formats[len(formats)-1])
+		if i < len(formats) {
+			f = strings.TrimSpace(formats[i])
+		}
+		switch f {
+		case "auto", "gob", "json":
+		default:
+			return usageErrorf("unknown -input-format %q, want auto, gob or json", f)
+		}
+		if _, ok := inputFormats[in]; !ok {
+			inputFormats[in] = f
+		}
+	}
+	return nil
+}
+
+// inputFormatOf returns the format to load path as: gob, json, or auto to
+// try JSON and fall back to gob.
+func inputFormatOf(path string) string {
+	if f, ok := inputFormats[path]; ok {
+		return f
+	}
+	if f, ok := inputFormats[filepath.Dir(path)]; ok {
+		return f
+	}
+	return "auto"
+}
+
+// describeInputFormat phrases for -explain how path is going to be loaded.
+func describeInputFormat(path string) string {
+	if f := inputFormatOf(path); f != "auto" {
+		return fmt.Sprintf("%s (from -input-format)", f)
+	}
+	return sniffFormat(path)
+}
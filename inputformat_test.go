@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetInputFormats(t *testing.T) {
+	defer func() { inputFormats = make(map[string]string) }()
+	inputs := []string{"/a", "/b", "/c"}
+	tests := []struct {
+		list string
+		want []string
+	}{
+		{"auto", []string{"auto", "auto", "auto"}},
+		{"gob,json,auto", []string{"gob", "json", "auto"}},
+		{" gob , json", []string{"gob", "json", "json"}},
+		{"json", []string{"json", "json", "json"}},
+	}
+	for _, tt := range tests {
+		if err := setInputFormats(tt.list, inputs); err != nil {
+			t.Fatalf("%q: %v", tt.list, err)
+		}
+		for i, in := range inputs {
+			if got := inputFormatOf(in); got != tt.want[i] {
+				t.Errorf("%q: %s has the format %s, want %s", tt.list, in, got, tt.want[i])
+			}
+		}
+	}
+	if got := inputFormatOf("/b/file.gob"); got != "json" {
+		t.Errorf("a file in the -in directory /b has the format %s, want json", got)
+	}
+	if got := inputFormatOf("/elsewhere"); got != "auto" {
+		t.Errorf("a path not given to -in has the format %s, want auto", got)
+	}
+	for _, list := range []string{"gob,gob,gob,gob", "yaml", "gob,,json"} {
+		if err := setInputFormats(list, inputs); errorType(err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", list, err)
+		}
+	}
+}
+
+func TestInputFormatMerge(t *testing.T) {
+	dir := tempDir(t)
+	gobIn := writeGobState(t, dir, "a", recordMap(testRecords(2)))
+	jsonIn := writeJsonState(t, dir, "b", []SectorRecord{testRecord(1000, 2, 7), testRecord(1000, 3, 3)})
+	gzipTestFile(t, jsonIn)
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", gobIn, "-in", jsonIn, "-out", out, "-input-format", "gob,json")
+	got := readJsonState(t, out)
+	if len(got) != 3 || got[1].SectorWorkingPhase != 7 {
+		t.Errorf("merged %d records with sector 2 at phase %d, want 3 with the JSON copy of sector 2", len(got), got[1].SectorWorkingPhase)
+	}
+
+	res := runTool(t, "-in", gobIn, "-out", out, "-input-format", "json")
+	if res.err == nil || !strings.Contains(res.err.Error(), gobIn) {
+		t.Errorf("a gob file forced to json: got %v, want its JSON error", res.err)
+	}
+}
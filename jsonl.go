@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// storeLines writes records as JSON Lines, one record per line. With
+// opts.appendLines the lines are appended to filename, skipping sectors
+// that already appear in it.
+func storeLines(ctx context.Context, recordList []SectorRecord, records []json.RawMessage, filename string, opts storeOptions) error {
+	skip := make(map[SectorID]bool)
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.appendLines {
+		existing, err := existingLineSectors(filename)
+		if err != nil {
+			return err
+		}
+		skip = existing
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	var buf bytes.Buffer
+	if opts.withCount {
+		writeCountHeader(&buf, len(recordList))
+	}
+	skipped := 0
+	for i, r := range recordList {
+		if skip[r.SectorId] {
+			skipped++
+			continue
+		}
+		line := records[i]
+		if opts.canonical {
+			var err error
+			line, err = canonicalJson(line)
+			if err != nil {
+				return err
+			}
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if skipped > 0 {
+		infof("%d sector(s) already in %s, not appended", skipped, filename)
+	}
+	tee, err := stageTee(buf.Bytes(), opts.tee)
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.rate > 0 && !opts.atomic:
+		err = writePaced(ctx, filename, flag, buf.Bytes(), opts.withCount, newPacer(opts.rate))
+	case opts.splitSize > 0:
+		err = storeChunks(ctx, buf.Bytes(), filename, opts)
+	case opts.atomic && !opts.appendLines:
+		err = writeFileAtomic(ctx, filename, buf.Bytes(), 0600)
+	default:
+		err = appendFile(ctx, filename, flag, buf.Bytes())
+	}
+	if err != nil {
+		tee.discard()
+		return err
+	}
+	if err := tee.commit(); err != nil {
+		return err
+	}
+	summary.wrote(len(recordList)-skipped, int64(buf.Len()))
+	return nil
+}
+
+// appendFile writes data to filename opened with flag.
+func appendFile(ctx context.Context, filename string, flag int, data []byte) error {
+	if err := checkInterrupted(ctx); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, flag, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePaced writes the JSON Lines in lines to filename opened with flag,
+// one line at a time as p allows. A leading count header is not held back.
+// Nothing partial is left behind when ctx is cancelled or a write fails: a
+// replaced file is streamed into a temporary file renamed into place at
+// the end, and an appended one is cut back to its old length.
+func writePaced(ctx context.Context, filename string, flag int, lines []byte, withCount bool, p *pacer) error {
+	var f *os.File
+	var err error
+	commit := func() error { return f.Close() }
+	if flag&os.O_APPEND == 0 {
+		f, err = ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+		if err != nil {
+			return err
+		}
+		name := f.Name()
+		defer func() {
+			if f != nil {
+				f.Close()
+			}
+			os.Remove(name)
+		}()
+		commit = func() error {
+			if err := f.Chmod(0600); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			return os.Rename(f.Name(), filename)
+		}
+	} else {
+		f, err = os.OpenFile(filename, flag, 0600)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		size := fi.Size()
+		defer func() {
+			if f != nil {
+				f.Truncate(size)
+				f.Close()
+			}
+		}()
+	}
+	for n := 0; len(lines) > 0; n++ {
+		end := bytes.IndexByte(lines, '\n') + 1
+		if n > 0 || !withCount {
+			if err := p.wait(ctx); err != nil {
+				return err
+			}
+		}
+		if _, err := f.Write(lines[:end]); err != nil {
+			return err
+		}
+		lines = lines[end:]
+	}
+	err = commit()
+	f = nil
+	return err
+}
+
+// existingLineSectors returns the sectors of the JSON Lines file filename,
+// which may not exist yet.
+func existingLineSectors(filename string) (map[SectorID]bool, error) {
+	ids := make(map[SectorID]bool)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<30)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if n == 1 && isCountHeader(line) {
+			continue
+		}
+		var r struct{ SectorId SectorID }
+		if err := json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", filename, n, err)
+		}
+		ids[r.SectorId] = true
+	}
+	return ids, sc.Err()
+}
+
+// loadLines decodes a JSON Lines document.
+func loadLines(raw []byte, rd recordDecoder) ([]SectorRecord, error) {
+	recordList := make([]SectorRecord, 0)
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for n := 1; dec.More() && (loadLimit <= 0 || len(recordList) < loadLimit); n++ {
+		var line json.RawMessage
+		if err := dec.Decode(&line); err != nil {
+			return nil, err
+		}
+		if n == 1 && isCountHeader(line) {
+			continue
+		}
+		r, err := rd.decode(line)
+		if err != nil {
+			return nil, err
+		}
+		recordList = append(recordList, r)
+	}
+	return recordList, nil
+}
+
+// isJsonLines reports whether raw holds more than one top-level JSON value.
+func isJsonLines(raw []byte) bool {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	var first json.RawMessage
+	if err := dec.Decode(&first); err != nil {
+		return false
+	}
+	return dec.More()
+}
+
+// countHeader is the first line -with-count writes before the records.
+// Its only member starts with @, which no record field does, so readers
+// can drop it with e.g. jq 'select(has("@count") | not)' or tail -n +2.
+type countHeader struct {
+	Count int `json:"@count"`
+}
+
+func writeCountHeader(buf *bytes.Buffer, n int) {
+	marshaled, _ := json.Marshal(countHeader{Count: n})
+	buf.Write(marshaled)
+	buf.WriteByte('\n')
+}
+
+// isCountHeader reports whether line is a countHeader.
+func isCountHeader(line []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(line), []byte(`{"@count"`)) {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return false
+	}
+	_, ok := fields["@count"]
+	return ok && len(fields) == 1
+}
+
+// chunkFile names the n-th chunk of a -split-size output, counting from 1.
+func chunkFile(filename string, n int) string {
+	return fmt.Sprintf("%s.%03d", filename, n)
+}
+
+// storeChunks writes the JSON Lines in lines to filename.001, filename.002
+// and so on, starting a new file before a line would take the current one
+// past opts.splitSize bytes. A single longer line gets a file of its own.
+// Higher-numbered chunks left by an earlier, longer run are removed, so
+// the chunks always concatenate to the current output.
+func storeChunks(ctx context.Context, lines []byte, filename string, opts storeOptions) error {
+	chunks := make([][]byte, 0)
+	start, end := 0, 0
+	for end < len(lines) {
+		next := end + bytes.IndexByte(lines[end:], '\n') + 1
+		if next-start > opts.splitSize && end > start {
+			chunks = append(chunks, lines[start:end])
+			start = end
+		}
+		if next-start > opts.splitSize {
+			warnf("%s: one record of %d bytes exceeds -split-size %d", chunkFile(filename, len(chunks)+1), next-start, opts.splitSize)
+		}
+		end = next
+	}
+	if end > start {
+		chunks = append(chunks, lines[start:end])
+	}
+	for i, chunk := range chunks {
+		name := chunkFile(filename, i+1)
+		var err error
+		if opts.atomic {
+			err = writeFileAtomic(ctx, name, chunk, 0600)
+		} else if err = checkInterrupted(ctx); err == nil {
+			err = ioutil.WriteFile(name, chunk, 0600)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for n := len(chunks) + 1; ; n++ {
+		if err := os.Remove(chunkFile(filename, n)); err != nil {
+			break
+		}
+	}
+	infof("wrote %d chunk(s) of at most %d bytes to %s.*", len(chunks), opts.splitSize, filename)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// lineNumbers returns the sector numbers of the JSON Lines file name, in
+// file order.
+func lineNumbers(t *testing.T, name string) []SectorNumber {
+	t.Helper()
+	var numbers []SectorNumber
+	for _, line := range strings.Split(strings.TrimSuffix(readTestFile(t, name), "\n"), "\n") {
+		var r SectorRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		numbers = append(numbers, r.SectorId.Number)
+	}
+	return numbers
+}
+
+func TestJsonLinesOutput(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+	for _, format := range []string{"jsonl", "ndjson"} {
+		out := filepath.Join(dir, "out."+format)
+		mustRun(t, "-in", in, "-out", out, "-format", format)
+		if got := lineNumbers(t, out); len(got) != 3 || got[0] != 1 || got[2] != 3 {
+			t.Errorf("-format %s wrote the sectors %v, want 1, 2 and 3 one per line", format, got)
+		}
+		if got := loadTestState(t, out); len(got) != 3 {
+			t.Errorf("-format %s: loaded %d records back, want 3", format, len(got))
+		}
+	}
+}
+
+func TestAppendSkipsExistingSectors(t *testing.T) {
+	dir := tempDir(t)
+	out := filepath.Join(dir, "out.jsonl")
+	first := writeGobState(t, dir, "first.gob", recordMap(testRecords(2)))
+	mustRun(t, "-in", first, "-out", out, "-format", "jsonl")
+	before := readTestFile(t, out)
+
+	changed := testRecords(4)
+	changed[1].P1WorkerAddress = "changed"
+	second := writeGobState(t, dir, "second.gob", recordMap(changed))
+	res := mustRun(t, "-in", second, "-out", out, "-format", "jsonl", "-append")
+	got := readTestFile(t, out)
+	if !strings.HasPrefix(got, before) {
+		t.Errorf("-append rewrote the existing lines:\n%s", got)
+	}
+	if numbers := lineNumbers(t, out); len(numbers) != 4 || numbers[2] != 3 || numbers[3] != 4 {
+		t.Errorf("the file holds the sectors %v, want 1 to 4 each once", numbers)
+	}
+	if strings.Contains(got, "changed") {
+		t.Error("-append replaced a sector already in the file")
+	}
+	if !strings.Contains(res.log, "2 sector(s) already in "+out+", not appended") {
+		t.Errorf("the log does not report the skipped sectors:\n%s", res.log)
+	}
+}
+
+func TestAppendCreatesTheFile(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(2)))
+	out := filepath.Join(dir, "new.jsonl")
+	mustRun(t, "-in", in, "-out", out, "-format", "jsonl", "-append")
+	if got := lineNumbers(t, out); len(got) != 2 {
+		t.Errorf("wrote the sectors %v, want 1 and 2", got)
+	}
+}
+
+func TestAppendNeedsJsonLines(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	res := runTool(t, "-in", in, "-out", filepath.Join(dir, "out.json"), "-append")
+	if res.code != 1 || errorType(res.err) != "usage" {
+		t.Errorf("-append with json output: exit status %d, %v, want a usage error", res.code, res.err)
+	}
+}
+
+func TestJsonLinesWithCount(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+	out := filepath.Join(dir, "out.jsonl")
+	for _, args := range [][]string{{}, {"-canonical"}} {
+		mustRun(t, append([]string{"-in", in, "-out", out, "-format", "jsonl", "-with-count"}, args...)...)
+		lines := strings.Split(strings.TrimSuffix(readTestFile(t, out), "\n"), "\n")
+		if len(lines) != 4 || lines[0] != `{"@count":3}` {
+			t.Errorf("%q: got %d lines starting with %q, want the count header and 3 records", args, len(lines), lines[0])
+		}
+		if got := loadTestState(t, out); len(got) != 3 {
+			t.Errorf("%q: loaded %d records back, want the header skipped", args, len(got))
+		}
+	}
+}
+
+func TestIsCountHeader(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`{"@count":3}`, true},
+		{` {"@count": 0} `, true},
+		{`{"@count":3,"SectorId":{"Miner":1000,"Number":1}}`, false},
+		{`{"SectorId":{"Miner":1000,"Number":1}}`, false},
+		{`{"@count":`, false},
+	}
+	for _, tt := range tests {
+		if got := isCountHeader([]byte(tt.line)); got != tt.want {
+			t.Errorf("isCountHeader(%s) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestWithCountFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	for _, args := range [][]string{
+		{"-with-count"},
+		{"-with-count", "-format", "jsonl", "-append"},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", filepath.Join(dir, "out")}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
+
+func TestSplitSize(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(10)))
+	full := filepath.Join(dir, "full.jsonl")
+	mustRun(t, "-in", in, "-out", full, "-format", "jsonl")
+	stream := readTestFile(t, full)
+	lineLen := strings.Index(stream, "\n") + 1
+
+	out := filepath.Join(dir, "out.jsonl")
+	// A stale fifth chunk of an earlier run must go.
+	writeTestFile(t, dir, "out.jsonl.005", []byte("stale\n"))
+	size := 3*lineLen + lineLen/2
+	res := mustRun(t, "-in", in, "-out", out, "-format", "jsonl", "-split-size", fmt.Sprint(size))
+	var joined strings.Builder
+	for n := 1; n <= 4; n++ {
+		chunk := readTestFile(t, chunkFile(out, n))
+		if len(chunk) > size || !strings.HasSuffix(chunk, "\n") {
+			t.Errorf("chunk %d has %d bytes, want at most %d ending on a line", n, len(chunk), size)
+		}
+		joined.WriteString(chunk)
+	}
+	if joined.String() != stream {
+		t.Errorf("the chunks concatenate to %d bytes that differ from the %d of the full stream", joined.Len(), len(stream))
+	}
+	if got := lineNumbers(t, chunkFile(out, 2)); len(got) != 3 || got[0] != 4 {
+		t.Errorf("chunk 2 holds the sectors %v, want 4 to 6", got)
+	}
+	if _, err := ioutil.ReadFile(chunkFile(out, 5)); err == nil {
+		t.Error("the stale chunk 5 was left behind")
+	}
+	if !strings.Contains(res.log, fmt.Sprintf("wrote 4 chunk(s) of at most %d bytes", size)) {
+		t.Errorf("the chunks were not reported:\n%s", res.log)
+	}
+
+	res = mustRun(t, "-in", in, "-out", out, "-format", "jsonl", "-split-size", fmt.Sprint(lineLen/2))
+	if got := lineNumbers(t, chunkFile(out, 10)); len(got) != 1 || got[0] != 10 {
+		t.Errorf("with lines longer than -split-size, the last chunk holds %v, want one line each", got)
+	}
+	if !strings.Contains(res.log, "exceeds -split-size") {
+		t.Errorf("a record longer than -split-size was not warned about:\n%s", res.log)
+	}
+}
+
+func TestSplitSizeFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	for _, args := range [][]string{
+		{"-split-size", "-1"},
+		{"-split-size", "100"},
+		{"-split-size", "100", "-format", "jsonl", "-append"},
+		{"-split-size", "100", "-format", "jsonl", "-with-count"},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", filepath.Join(dir, "out")}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
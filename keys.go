@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const defaultKeyFormat = "s-t0{miner}-{number}"
+
+// keyFormat lays out a SectorID as a string key from a template holding
+// the {miner} and {number} placeholders.
+type keyFormat struct {
+	template string
+	minerIdx int
+	re       *regexp.Regexp
+	// pad zero-pads {number} to this many digits so keys sort lexically.
+	// parse accepts padded and unpadded numbers alike.
+	pad int
+}
+
+// sectorKeyFormat is the layout used for keyed map output and loading.
+var sectorKeyFormat = mustKeyFormat(defaultKeyFormat)
+
+func mustKeyFormat(template string) *keyFormat {
+	k, err := newKeyFormat(template)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+func newKeyFormat(template string) (*keyFormat, error) {
+	if strings.Count(template, "{miner}") != 1 || strings.Count(template, "{number}") != 1 {
+		return nil, fmt.Errorf("key format %q must contain {miner} and {number} once each", template)
+	}
+	mi := strings.Index(template, "{miner}")
+	ni := strings.Index(template, "{number}")
+	var between string
+	if mi < ni {
+		between = template[mi+len("{miner}") : ni]
+	} else {
+		between = template[ni+len("{number}") : mi]
+	}
+	if strings.IndexFunc(between, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+		return nil, errors.New("key format must separate {miner} and {number} by a non-digit")
+	}
+	pattern := regexp.QuoteMeta(template)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{miner}"), `(\d+)`, 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{number}"), `(\d+)`, 1)
+	minerIdx := 1
+	if ni < mi {
+		minerIdx = 2
+	}
+	return &keyFormat{
+		template: template,
+		minerIdx: minerIdx,
+		re:       regexp.MustCompile("^" + pattern + "$"),
+	}, nil
+}
+
+func (k *keyFormat) format(id SectorID) string {
+	key := strings.Replace(k.template, "{miner}", strconv.FormatUint(uint64(id.Miner), 10), 1)
+	return strings.Replace(key, "{number}", fmt.Sprintf("%0*d", k.pad, uint64(id.Number)), 1)
+}
+
+func (k *keyFormat) parse(key string) (SectorID, error) {
+	m := k.re.FindStringSubmatch(key)
+	if m == nil {
+		return SectorID{}, fmt.Errorf("key %q does not match format %q", key, k.template)
+	}
+	miner, err := strconv.ParseUint(m[k.minerIdx], 10, 64)
+	if err != nil {
+		return SectorID{}, fmt.Errorf("key %q: %w", key, err)
+	}
+	number, err := strconv.ParseUint(m[3-k.minerIdx], 10, 64)
+	if err != nil {
+		return SectorID{}, fmt.Errorf("key %q: %w", key, err)
+	}
+	return SectorID{Miner: ActorID(miner), Number: SectorNumber(number)}, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewKeyFormatValidatesTheTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{defaultKeyFormat, true},
+		{"{miner}_{number}", true},
+		{"{number}/{miner}", true},
+		{"{miner}", false},
+		{"{number}", false},
+		{"{miner}-{number}-{number}", false},
+		{"{miner}{number}", false},
+		{"{miner}7{number}", false},
+	}
+	for _, tt := range tests {
+		if _, err := newKeyFormat(tt.template); (err == nil) != tt.ok {
+			t.Errorf("newKeyFormat(%q): %v, want ok %v", tt.template, err, tt.ok)
+		}
+	}
+}
+
+func TestKeyFormatRoundTrip(t *testing.T) {
+	id := SectorID{Miner: 1000, Number: 42}
+	tests := []struct {
+		template string
+		key      string
+	}{
+		{defaultKeyFormat, "s-t01000-42"},
+		{"{miner}_{number}", "1000_42"},
+		{"{miner}/{number}", "1000/42"},
+		{"n{number}.m{miner}", "n42.m1000"},
+		{"a.b*{miner}+{number}", "a.b*1000+42"},
+	}
+	for _, tt := range tests {
+		k := mustKeyFormat(tt.template)
+		key := k.format(id)
+		if key != tt.key {
+			t.Errorf("%q formats %v as %q, want %q", tt.template, id, key, tt.key)
+		}
+		got, err := k.parse(key)
+		if err != nil || got != id {
+			t.Errorf("%q parses %q as %v, %v, want %v", tt.template, key, got, err, id)
+		}
+	}
+}
+
+func TestKeyFormatParseRejectsOtherLayouts(t *testing.T) {
+	k := mustKeyFormat("{miner}_{number}")
+	for _, key := range []string{"s-t01000-42", "1000-42", "1000_", "x1000_42", "1000_42x", "99999999999999999999_1"} {
+		if id, err := k.parse(key); err == nil {
+			t.Errorf("parse(%q) = %v, want an error", key, id)
+		}
+	}
+}
+
+func TestMapJsonCustomKeyFormat(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(2)))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-format", "map-json", "-key-format", "{miner}/{number}")
+	var keyed map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &keyed); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"1000/1", "1000/2"} {
+		if _, ok := keyed[key]; !ok {
+			t.Errorf("the output lacks the key %s: %v", key, keyed)
+		}
+	}
+
+	back := filepath.Join(dir, "back.json")
+	mustRun(t, "-in", out, "-out", back, "-key-format", "{miner}/{number}")
+	if got := readJsonState(t, back); len(got) != 2 || got[1].SectorId != (SectorID{Miner: 1000, Number: 2}) {
+		t.Errorf("loaded %+v back, want sectors 1000/1 and 1000/2", got)
+	}
+
+	res := runTool(t, "-in", out, "-out", back)
+	// Either key may be reported, as the object's members are unordered.
+	if res.err == nil || !strings.Contains(res.err.Error(), `key "1000/`) || !strings.Contains(res.err.Error(), "does not match format") {
+		t.Errorf("loading with the default key format: got %v, want the key reported", res.err)
+	}
+	var jsonErr *jsonRecordError
+	if !errors.As(res.err, &jsonErr) {
+		t.Errorf("got %T, want the key error reported as a JSON error", res.err)
+	}
+}
+
+func TestKeyFormatPadNumbers(t *testing.T) {
+	tests := []struct {
+		pad    int
+		number SectorNumber
+		key    string
+	}{
+		{0, 5, "s-t01000-5"},
+		{4, 5, "s-t01000-0005"},
+		{4, 50, "s-t01000-0050"},
+		{4, 12345, "s-t01000-12345"},
+	}
+	for _, tt := range tests {
+		k := mustKeyFormat(defaultKeyFormat)
+		k.pad = tt.pad
+		id := SectorID{Miner: 1000, Number: tt.number}
+		if key := k.format(id); key != tt.key {
+			t.Errorf("pad %d formats %d as %q, want %q", tt.pad, tt.number, key, tt.key)
+		}
+		if got, err := k.parse(tt.key); err != nil || got != id {
+			t.Errorf("pad %d parses %q as %v, %v, want %v", tt.pad, tt.key, got, err, id)
+		}
+	}
+}
+
+func TestMapJsonRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(5)
+	for i := range recordList {
+		recordList[i].CurrentSealTask.Pieces[0].PieceCID = testCid(t, fmt.Sprint("piece", i))
+		recordList[i].CurrentSealTask.Ticket = []byte{byte(i), 0xff}
+	}
+	updated := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	recordList[3].UpdatedAt = &updated
+	recordList[3].P2SealedSectorPath = "/nvme/s-t01000-4"
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	array := filepath.Join(dir, "array.json")
+	mustRun(t, "-in", in, "-out", array)
+
+	keyed := filepath.Join(dir, "keyed.json")
+	mustRun(t, "-in", in, "-out", keyed, "-format", "map-json")
+	if got, want := loadTestState(t, keyed), loadTestState(t, array); !reflect.DeepEqual(got, want) {
+		t.Errorf("map-json loaded back as\n%+v\nwant\n%+v", got, want)
+	}
+	again := filepath.Join(dir, "again.json")
+	mustRun(t, "-in", keyed, "-out", again, "-format", "map-json")
+	if readTestFile(t, again) != readTestFile(t, keyed) {
+		t.Error("converting map-json to map-json changed the bytes")
+	}
+	mustRun(t, "-in", keyed, "-out", again)
+	if readTestFile(t, again) != readTestFile(t, array) {
+		t.Error("converting map-json back to an array differs from converting the gob input")
+	}
+
+	// A key must name the sector of its record.
+	misfiled := writeTestFile(t, dir, "misfiled.json", []byte(`{"s-t01000-1":{"SectorId":{"Miner":1000,"Number":2}}}`))
+	res := runTool(t, "-in", misfiled, "-out", again)
+	if res.err == nil || !strings.Contains(res.err.Error(), "key s-t01000-1 holds the record of s-t01000-2") {
+		t.Errorf("a misfiled record: got %v", res.err)
+	}
+}
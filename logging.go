@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// currentLogLevel is the lowest level written to the log.
+var currentLogLevel = levelInfo
+
+func setLogLevel(name string) error {
+	l, ok := logLevelNames[name]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", name)
+	}
+	currentLogLevel = l
+	return nil
+}
+
+func logf(l logLevel, format string, v ...interface{}) {
+	if l >= currentLogLevel {
+		log.Printf(format, v...)
+	}
+}
+
+func debugf(format string, v ...interface{}) { logf(levelDebug, format, v...) }
+
+func infof(format string, v ...interface{}) { logf(levelInfo, format, v...) }
+
+// warnings counts the warnings of the run, logged or not, for
+// -fail-on-warning.
+var warnings int
+
+func warnf(format string, v ...interface{}) {
+	warnings++
+	logf(levelWarn, format, v...)
+}
+
+func errorf(format string, v ...interface{}) { logf(levelError, format, v...) }
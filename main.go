@@ -2,17 +2,24 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	cid "github.com/ipfs/go-cid"
 	"github.com/mitchellh/go-homedir"
+	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
-	cid "github.com/ipfs/go-cid"
+	"time"
 )
 
 type ActorID uint64
@@ -39,6 +46,12 @@ type SectorID struct {
 	Miner  ActorID
 	Number SectorNumber
 }
+
+// sectorName formats id the way lotus names sector files.
+func sectorName(id SectorID) string {
+	return fmt.Sprintf("s-t0%d-%d", id.Miner, id.Number)
+}
+
 type PieceInfo struct {
 	Size     PaddedPieceSize // Size in nodes. For BLS12-381 (capacity 254 bits), must be >= 16. (16 * 8 = 128)
 	PieceCID cid.Cid
@@ -109,81 +122,745 @@ type SectorRecord struct {
 	C1CacheDirPath     string
 
 	C2WorkerAddress string
+
+	// UpdatedAt is when the record last changed, if the producer records it.
+	UpdatedAt *time.Time `json:",omitempty"`
+
+	// Extra holds the fields a fork adds to the record, see
+	// recordExtension. They are written after the fields above.
+	Extra map[string]json.RawMessage `json:"-"`
 }
 
-func loadByGob(data interface{}, filename string) error {
-	raw, err := ioutil.ReadFile(filename)
+// useMmap makes the loaders map input files into memory instead of
+// copying them onto the heap, where the platform allows it.
+var useMmap bool
+
+// readInput returns the contents of filename, decompressed if it is gzip,
+// and a function releasing them once decoded.
+func readInput(filename string) ([]byte, func(), error) {
+	var (
+		raw     []byte
+		release = func() {}
+		err     error
+	)
+	if remote, ok := remoteInputs[filename]; ok {
+		raw = remote
+	} else if useMmap && mmapSupported {
+		raw, release, err = mmapFile(filename)
+	} else {
+		raw, err = ioutil.ReadFile(filename)
+	}
 	if err != nil {
-		return err
+		return nil, release, err
 	}
-	buffer := bytes.NewBuffer(raw)
-	dec := gob.NewDecoder(buffer)
-	err = dec.Decode(data)
+	data, compressed, err := gunzipBytes(raw)
+	if compressed {
+		release()
+		release = func() {}
+	}
+	return data, release, err
+}
+
+// openInput opens filename for reading, which may be a downloaded
+// remote input.
+func openInput(filename string) (io.ReadCloser, error) {
+	if remote, ok := remoteInputs[filename]; ok {
+		return ioutil.NopCloser(bytes.NewReader(remote)), nil
+	}
+	return os.Open(filename)
+}
+
+// loadByGob decodes every gob value in filename into data, so the entries
+// of later values win when data is a map.
+func loadByGob(data interface{}, filename string) error {
+	return decodeGobValues(filename, func() interface{} { return data })
+}
+
+// decodeGobValues decodes the gob values of filename one after another,
+// each into the value next returns, until it returns nil. Producers appending snapshots to a
+// file write several values with one encoder or one encoder each; a new
+// encoder repeats its type definitions, so decoding restarts there with a
+// fresh decoder.
+func decodeGobValues(filename string, next func() interface{}) error {
+	raw, release, err := readInput(filename)
 	if err != nil {
 		return err
 	}
+	defer release()
+	var compat *gobCompat
+	if forceTypeCompat {
+		compat = newGobCompat(filename, raw)
+	}
+	r := bytes.NewReader(raw)
+	dec := gob.NewDecoder(r)
+	decoded := 0
+	for first := true; first || r.Len() > 0; first = false {
+		pos := r.Size() - int64(r.Len())
+		v := next()
+		if v == nil {
+			break
+		}
+		target := v
+		if compat != nil {
+			target = compat.target(v)
+		}
+		err := dec.Decode(target)
+		if err != nil && decoded > 0 {
+			r.Seek(pos, io.SeekStart)
+			dec = gob.NewDecoder(r)
+			err = dec.Decode(target)
+		}
+		if err == nil && compat != nil {
+			err = compat.convert(v, target)
+		}
+		if err != nil {
+			if decoded > 0 {
+				return fmt.Errorf("gob value %d at offset %d: %w", decoded+1, pos, err)
+			}
+			return err
+		}
+		decoded++
+	}
 	return nil
 }
 
 func loadByJson(filename string) ([]SectorRecord, error) {
-	raw, err := ioutil.ReadFile(filename)
+	raw, release, err := readInput(filename)
 	if err != nil {
 		return nil, err
 	}
-	recordList := make([]SectorRecord, 0)
-	err = json.Unmarshal(raw, &recordList)
+	defer release()
+	dec := recordDecoder{dir: filepath.Dir(filename)}
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		if isJsonLines(trimmed) || isCountHeader(trimmed) {
+			return loadLines(trimmed, dec)
+		}
+		var fields map[string]json.RawMessage
+		err = json.Unmarshal(raw, &fields)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fields["records"]; !ok {
+			return loadKeyed(fields, dec)
+		}
+		var doc versionedState
+		err = json.Unmarshal(raw, &doc)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Version > stateVersion {
+			return nil, fmt.Errorf("unsupported state version %d", doc.Version)
+		}
+		raw = doc.Records
+	}
+	raws := make([]json.RawMessage, 0)
+	if loadLimit > 0 {
+		raws, err = firstElements(raw, loadLimit)
+	} else {
+		err = json.Unmarshal(raw, &raws)
+	}
 	if err != nil {
 		return nil, err
 	}
+	recordList := make([]SectorRecord, 0, len(raws))
+	for _, r := range raws {
+		record, err := dec.decode(r)
+		if err != nil {
+			return nil, err
+		}
+		recordList = append(recordList, record)
+	}
 	return recordList, nil
 }
 
+// loadLimit, when positive, stops loading each input once this many of its
+// records are read, see -limit.
+var loadLimit int
+
+// firstElements returns the first n elements of the JSON array raw without
+// reading the rest of it.
+func firstElements(raw []byte, n int) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return nil, nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+	raws := make([]json.RawMessage, 0, n)
+	for len(raws) < n && dec.More() {
+		var r json.RawMessage
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		raws = append(raws, r)
+	}
+	return raws, nil
+}
+
+// strictJson rejects JSON records with fields SectorRecord does not have
+// or CIDs that do not decode.
+var strictJson bool
+
+// jsonRecordError is a record rejected by strictJson or fieldRenames, a
+// blob reference out of the state's directory, or a bad entry of a keyed
+// map-json object. The file is JSON, so newState reports it instead of
+// falling back to gob.
+type jsonRecordError struct {
+	err error
+}
+
+func (e *jsonRecordError) Error() string {
+	return e.err.Error()
+}
+
+// recordDecoder unmarshals single JSON records read from a file in dir.
+type recordDecoder struct {
+	dir string
+}
+
+func (d recordDecoder) decode(raw json.RawMessage) (SectorRecord, error) {
+	var r SectorRecord
+	if fieldRenames != nil {
+		renamed, err := renameFields(raw, fieldRenames)
+		if err != nil {
+			var id struct{ SectorId SectorID }
+			json.Unmarshal(raw, &id)
+			return r, &jsonRecordError{err: fmt.Errorf("%s: %w", sectorName(id.SectorId), err)}
+		}
+		raw = renamed
+	}
+	if upgradeLegacy {
+		upgraded, changes, err := upgradeRecord(raw)
+		if err != nil {
+			return r, err
+		}
+		var id struct{ SectorId SectorID }
+		json.Unmarshal(upgraded, &id)
+		for _, change := range changes {
+			infof("%s: upgrade: %s", sectorName(id.SectorId), change)
+		}
+		raw = upgraded
+	}
+	raw, err := rehydrateBlobs(raw, d.dir)
+	if err != nil {
+		return r, err
+	}
+	if strictJson {
+		err = decodeStrict(raw, &r)
+	} else {
+		err = json.Unmarshal(raw, &r)
+		if err != nil {
+			if fixed, n := dropBadCids(raw); n > 0 {
+				r = SectorRecord{}
+				err = json.Unmarshal(fixed, &r)
+			}
+		}
+	}
+	if err != nil {
+		return r, err
+	}
+	processingSector(r.SectorId)
+	if currentExtension != nil {
+		if r.Extra, err = currentExtension.extract(raw); err != nil {
+			return r, err
+		}
+	}
+	if keepUnknownFields {
+		r.Extra, err = unknownMembers(raw, r.Extra)
+	}
+	return r, err
+}
+
+// loadKeyed decodes a keyed map document, whose keys must follow
+// sectorKeyFormat and name the sector of their record. Its members are
+// unordered once decoded, so the records are returned in sector order.
+func loadKeyed(fields map[string]json.RawMessage, dec recordDecoder) ([]SectorRecord, error) {
+	recordList := make([]SectorRecord, 0, len(fields))
+	for key, raw := range fields {
+		// The file is a JSON object, so its failures are reported as they
+		// are rather than as those of a gob retry.
+		id, err := sectorKeyFormat.parse(key)
+		if err != nil {
+			return nil, &jsonRecordError{err: err}
+		}
+		r, err := dec.decode(raw)
+		if err != nil {
+			return nil, &jsonRecordError{err: fmt.Errorf("%s: %w", key, err)}
+		}
+		if r.SectorId != id {
+			return nil, &jsonRecordError{err: fmt.Errorf("key %s holds the record of %s", key, sectorName(r.SectorId))}
+		}
+		recordList = append(recordList, r)
+	}
+	sort.Slice(recordList, func(i, j int) bool {
+		return sectorIDLess(recordList[i].SectorId, recordList[j].SectorId)
+	})
+	return recordList, nil
+}
+
+const stateVersion = 1
+
+// versionedState is the object written in versioned output mode, wrapping
+// the record list with provenance metadata.
+type versionedState struct {
+	Version     int             `json:"version"`
+	GeneratedAt string          `json:"generatedAt,omitempty"`
+	Records     json.RawMessage `json:"records"`
+}
+
 type State struct {
 	filePath string
+	store    storeOptions
+	audit    *auditLog
+	sources  []string
 	state    map[SectorID]SectorRecord
+	// order is the input order of the sectors, nil when the input was
+	// map-shaped.
+	order []SectorID
 }
 
 var onceState sync.Once
 var stateSingleton *State
 
-func loadStateFromFile(filePath string) *State {
+var errStateLoad error
+
+// loadStateFromFiles merges filePaths into the state singleton, which is
+// saved to outPath.
+func loadStateFromFiles(ctx context.Context, filePaths []string, outPath string, concurrency int, merge mergeOptions) (*State, error) {
 	onceState.Do(func() {
+		data, order, err := loadOrderedStates(ctx, filePaths, concurrency, merge)
+		if err != nil {
+			errStateLoad = err
+			return
+		}
 		stateSingleton = &State{
-			filePath: filePath,
-			state:    make(map[SectorID]SectorRecord),
+			filePath: outPath,
+			sources:  filePaths,
+			state:    data,
+			order:    order,
+		}
+	})
+	return stateSingleton, errStateLoad
+}
+
+// newState loads filePath into a fresh State, trying JSON first and falling
+// back to gob unless -input-format names the format of the file. Several
+// gob values in the file are combined as merge says.
+func newState(filePath string, merge mergeOptions) (*State, error) {
+	processingFile(filePath)
+	s := &State{
+		filePath: filePath,
+		state:    make(map[SectorID]SectorRecord),
+	}
+	format := inputFormatOf(filePath)
+	var (
+		recordList []SectorRecord
+		err        error
+	)
+	if format == "auto" && strings.HasSuffix(sniffFormat(filePath), "gob") {
+		// It does not start like JSON, so is not worth decoding as such.
+		format = "gob"
+	}
+	if format == "gob" {
+		err = errors.New("loading as gob")
+	} else {
+		recordList, err = loadByJson(filePath)
+	}
+	var recordErr *jsonRecordError
+	if errors.As(err, &recordErr) || (err != nil && format == "json") {
+		return nil, err
+	}
+	if err != nil {
+		if format == "auto" {
+			infof("%s: not valid JSON, trying gob: %v", filePath, err)
 		}
-		recordList, err := loadByJson(filePath)
+		s.state, s.order, err = loadGobState(filePath, merge)
 		if err != nil {
-			fmt.Println(err.Error())
-			err = loadByGob(&stateSingleton.state, filePath)
+			return nil, err
+		}
+		if currentExtension != nil {
+			err = currentExtension.loadGob(s.state, filePath)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-		} else {
-			for _, v := range recordList {
-				stateSingleton.state[v.SectorId] = v
+		}
+	} else {
+		s.order = make([]SectorID, 0, len(recordList))
+		for _, v := range recordList {
+			if _, ok := s.state[v.SectorId]; !ok {
+				s.order = append(s.order, v.SectorId)
 			}
+			s.state[v.SectorId] = v
 		}
-	})
-	return stateSingleton
+	}
+	return s, nil
+}
+
+// loadGobState decodes the gob state maps in filename and merges them in
+// file order as if each were an input of its own. A state written as a
+// slice of records is turned into a map following merge.onDuplicate, and
+// the order of the records kept is returned for -preserve-order; a map
+// has no order, so it is nil then. With loadLimit only the first records
+// of a slice are kept, and no further gob values are decoded once there
+// are enough; a map value is decoded whole.
+func loadGobState(filename string, merge mergeOptions) (map[SectorID]SectorRecord, []SectorID, error) {
+	values := make([]map[SectorID]SectorRecord, 0, 1)
+	var order []SectorID
+	var err error
+	if gobValueKind(filename) == "slice" {
+		lists := make([][]SectorRecord, 0, 1)
+		read := 0
+		err = decodeGobValues(filename, func() interface{} {
+			if len(lists) > 0 {
+				read += len(lists[len(lists)-1])
+			}
+			if loadLimit > 0 && read >= loadLimit {
+				return nil
+			}
+			lists = append(lists, nil)
+			return &lists[len(lists)-1]
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		seen := make(map[SectorID]bool)
+		taken := 0
+		for _, l := range lists {
+			if loadLimit > 0 && len(l) > loadLimit-taken {
+				l = l[:loadLimit-taken]
+			}
+			taken += len(l)
+			l, err := merge.onDuplicate.dedupe(l)
+			if err != nil {
+				return nil, nil, err
+			}
+			m := make(map[SectorID]SectorRecord, len(l))
+			for _, r := range l {
+				m[r.SectorId] = r
+				if !seen[r.SectorId] {
+					seen[r.SectorId] = true
+					order = append(order, r.SectorId)
+				}
+			}
+			values = append(values, m)
+		}
+	} else {
+		err = decodeGobValues(filename, func() interface{} {
+			values = append(values, make(map[SectorID]SectorRecord))
+			return &values[len(values)-1]
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(values) == 1 {
+		return values[0], order, nil
+	}
+	sources := make([]string, len(values))
+	for i := range values {
+		sources[i] = fmt.Sprintf("%s (gob value %d)", filename, i+1)
+	}
+	infof("%s: merging %d appended gob values", filename, len(values))
+	merge.reportDuplicates = false
+	data, err := mergeStates(values, sources, merge)
+	return data, order, err
 }
 
-func storeByJson(data map[SectorID]SectorRecord, filename string) error {
-	var recordList = make([]SectorRecord, 0)
+// gobValueKind returns the wire kind, such as map or slice, of the first
+// value of the gob stream in filename, or "" when its head cannot be read,
+// leaving the error to the decoder.
+func gobValueKind(filename string) string {
+	raw, release, err := readInput(filename)
+	if err != nil {
+		return ""
+	}
+	defer release()
+	types, valueID, err := readGobTypes(bytes.NewReader(raw))
+	if err != nil {
+		return ""
+	}
+	for _, t := range types {
+		if t.ID == valueID {
+			return t.Kind
+		}
+	}
+	return ""
+}
+
+// sortedRecords returns the records of data ordered by sector ID.
+func sortedRecords(data map[SectorID]SectorRecord) []SectorRecord {
+	recordList := make([]SectorRecord, 0, len(data))
 	for _, v := range data {
 		recordList = append(recordList, v)
 	}
-	marshaled, err := json.Marshal(recordList)
+	sort.Slice(recordList, func(i, j int) bool {
+		return sectorIDLess(recordList[i].SectorId, recordList[j].SectorId)
+	})
+	return recordList
+}
+
+// orderedRecords returns the records of data in the order of ids, followed
+// by any records ids does not list in sector order.
+func orderedRecords(data map[SectorID]SectorRecord, ids []SectorID) []SectorRecord {
+	recordList := make([]SectorRecord, 0, len(data))
+	listed := make(map[SectorID]bool, len(ids))
+	for _, id := range ids {
+		if r, ok := data[id]; ok && !listed[id] {
+			recordList = append(recordList, r)
+			listed[id] = true
+		}
+	}
+	for _, r := range sortedRecords(data) {
+		if !listed[r.SectorId] {
+			recordList = append(recordList, r)
+		}
+	}
+	return recordList
+}
+
+// storeOptions controls how the state is encoded on save.
+type storeOptions struct {
+	// canonical sorts object keys lexicographically at every level.
+	canonical bool
+	// versioned wraps the records in a versionedState object.
+	versioned bool
+	// lines writes JSON Lines, one record per line, in sector order.
+	lines bool
+	// appendLines appends to an existing JSON Lines file instead of
+	// replacing it, skipping sectors already present.
+	appendLines bool
+	// keyed writes an object keyed by sectorKeyFormat instead of a list.
+	keyed bool
+	// atomic writes through a temporary file renamed over the target,
+	// needed when the target is also one of the inputs.
+	atomic bool
+	// explodeDir, when set, writes each record to its own file there
+	// instead of one file for the state.
+	explodeDir string
+	// bufferSize is the write buffer of streamed output.
+	bufferSize int
+	// splitSize, when set, splits JSON Lines output into numbered files of
+	// at most this many bytes.
+	splitSize int
+	// order, when set, is the order to write records in, see
+	// orderedRecords. It has no effect on map-json.
+	order []SectorID
+	// withCount starts JSON Lines output with a countHeader.
+	withCount bool
+	// force lets explodeDir overwrite existing sector files.
+	force bool
+	// blobDir, when set, moves the proof blobs into sidecar files there.
+	blobDir string
+	// fields, when set, drops fields from every record.
+	fields *fieldProjection
+	// skipBadRecords leaves out records that fail to marshal instead of
+	// failing the whole save.
+	skipBadRecords bool
+	// tombstones are sectors written as a tombstone after the records.
+	tombstones []SectorID
+	// rate, when set, writes JSON Lines at most this many records a
+	// second, each line as soon as it is due.
+	rate int
+	// progress logs the progress of encoding the records, with an ETA.
+	progress bool
+	// tee lists further files, or - for stdout, that receive the same
+	// bytes as the output.
+	tee []string
+}
+
+func storeByJson(ctx context.Context, data map[SectorID]SectorRecord, filename string, opts storeOptions) error {
+	recordList, records, err := encodeRecords(data, filename, opts)
+	if err != nil {
+		return err
+	}
+	if opts.explodeDir != "" {
+		return storeExploded(ctx, recordList, records, opts)
+	}
+	if opts.lines {
+		return storeLines(ctx, recordList, records, filename, opts)
+	}
+	marshaled, err := marshalDocument(recordList, records, opts)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(filename, marshaled, 0600)
+	tee, err := stageTee(marshaled, opts.tee)
+	if err != nil {
+		return err
+	}
+	if opts.atomic {
+		err = writeFileAtomic(ctx, filename, marshaled, 0600)
+	} else if err = checkInterrupted(ctx); err == nil {
+		err = ioutil.WriteFile(filename, marshaled, 0600)
+	}
 	if err != nil {
+		tee.discard()
 		return err
 	}
+	if err := tee.commit(); err != nil {
+		return err
+	}
+	summary.wrote(len(records), int64(len(marshaled)))
 	return nil
 }
 
+// encodeRecords returns the records of data in the order they are written
+// to filename, with their encodings, followed by the tombstones of opts.
+func encodeRecords(data map[SectorID]SectorRecord, filename string, opts storeOptions) ([]SectorRecord, []json.RawMessage, error) {
+	// Records are written in sector order so that converting the same
+	// state twice gives the same bytes.
+	recordList := sortedRecords(data)
+	if opts.order != nil {
+		recordList = orderedRecords(data, opts.order)
+	}
+	if opts.skipBadRecords {
+		n := len(recordList)
+		recordList = marshalableRecords(recordList)
+		summary.dropped += n - len(recordList)
+	}
+	outDir := filepath.Dir(filename)
+	if opts.explodeDir != "" {
+		outDir = opts.explodeDir
+	}
+	records := make([]json.RawMessage, 0, len(recordList))
+	p := newProgress(opts.progress, "encoding", len(recordList))
+	for _, r := range recordList {
+		raw, err := encodeRecord(r, outDir, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, raw)
+		p.add(1)
+	}
+	p.finish()
+	for _, id := range opts.tombstones {
+		recordList = append(recordList, SectorRecord{SectorId: id})
+		records = append(records, marshalTombstone(id))
+	}
+	return recordList, records, nil
+}
+
+// marshalDocument assembles the encoded records of recordList into the
+// json, map-json or versioned document opts asks for.
+func marshalDocument(recordList []SectorRecord, records []json.RawMessage, opts storeOptions) ([]byte, error) {
+	var doc interface{} = records
+	if opts.keyed {
+		keyed := make(map[string]json.RawMessage, len(records))
+		for i, r := range recordList {
+			keyed[sectorKeyFormat.format(r.SectorId)] = records[i]
+		}
+		doc = keyed
+	}
+	if opts.versioned {
+		list, err := json.Marshal(records)
+		if err != nil {
+			return nil, err
+		}
+		doc = versionedState{
+			Version:     stateVersion,
+			GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+			Records:     list,
+		}
+	}
+	marshaled, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if opts.canonical {
+		return canonicalJson(marshaled)
+	}
+	return marshaled, nil
+}
+
+// encodeRecord marshals r for a file in outDir, moving out its blobs and
+// projecting its fields as opts asks.
+func encodeRecord(r SectorRecord, outDir string, opts storeOptions) (json.RawMessage, error) {
+	processingSector(r.SectorId)
+	raw, err := marshalFinite(r.SectorId, &r)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err = omitEmptyPieces(r, raw); err != nil {
+		return nil, err
+	}
+	if opts.blobDir != "" {
+		raw, err = externalizeBlobs(raw, r.SectorId, opts.blobDir, outDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.fields != nil {
+		raw, err = opts.fields.apply(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// includeEmptyPieces keeps the Pieces of records without pieces in the
+// output, as asked with -include-empty-pieces.
+var includeEmptyPieces bool
+
+var piecesField = fieldTree{"CurrentSealTask": fieldTree{"Pieces": fieldTree{}}}
+
+// omitEmptyPieces drops the null or empty CurrentSealTask.Pieces from raw,
+// the encoded r, unless includeEmptyPieces is set. Loading a record
+// without the field gives nil Pieces, as null does.
+func omitEmptyPieces(r SectorRecord, raw json.RawMessage) (json.RawMessage, error) {
+	if includeEmptyPieces || len(r.CurrentSealTask.Pieces) > 0 {
+		return raw, nil
+	}
+	return projectFields(raw, piecesField, true)
+}
+
+// marshalableRecords returns the records of recordList that encode to JSON
+// without error or panic, logging the others.
+func marshalableRecords(recordList []SectorRecord) []SectorRecord {
+	good := make([]SectorRecord, 0, len(recordList))
+	for _, r := range recordList {
+		if err := tryMarshal(r); err != nil {
+			errorf("skipping %s: %v", sectorName(r.SectorId), err)
+			continue
+		}
+		good = append(good, r)
+	}
+	if skipped := len(recordList) - len(good); skipped > 0 {
+		warnf("skipped %d bad record(s)", skipped)
+	}
+	return good
+}
+
+func tryMarshal(r SectorRecord) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	if nullNonFinite && len(nonFiniteFloats(reflect.ValueOf(r), nil)) > 0 {
+		// encodeRecord writes these floats as null.
+		return nil
+	}
+	_, err = json.Marshal(r)
+	return err
+}
+
+// canonicalJson re-encodes raw through generic maps, which encoding/json
+// writes with sorted keys. Numbers are kept verbatim.
+func canonicalJson(raw []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
 func getAbsPath(p string) (string, error) {
 	newPath, err := homedir.Expand(p)
 	if err != nil {
@@ -196,16 +873,9 @@ func getAbsPath(p string) (string, error) {
 	return newPath, nil
 }
 
-func getState() *State {
-	if stateSingleton == nil {
-		panic("get stateSingleton before initialize.")
-	}
-	return stateSingleton
-}
-
-func (s *State) save() error {
+func (s *State) save(ctx context.Context) error {
 	s.cleanCommit1Out()
-	err := storeByJson(s.state, s.filePath)
+	err := storeByJson(ctx, s.state, s.filePath, s.store)
 	if err != nil {
 		return err
 	}
@@ -216,17 +886,133 @@ const (
 	TTCommit2 TaskType = "seal/v0/commit/2"
 )
 
+// cleanCommit1Out empties the Commit1Out of sectors already at commit 2.
+// Records cleared by an earlier run are left alone.
 func (s *State) cleanCommit1Out() {
 	for id := range s.state {
 		r := s.state[id]
-		if r.CurrentSealTask.TaskType == TTCommit2 {
+		if r.CurrentSealTask.TaskType == TTCommit2 && len(r.CurrentSealTask.Commit1Out) > 0 {
+			s.audit.cleared(id, "CurrentSealTask.Commit1Out", blobSummary(r.CurrentSealTask.Commit1Out))
 			r.CurrentSealTask.Commit1Out = make([]byte, 0)
 			s.updateSectorRecord(r)
 		}
 	}
 }
 
+// clearCommit1Out applies the cleanCommit1Out rule to the single record r.
+func clearCommit1Out(r *SectorRecord) {
+	if r.CurrentSealTask.TaskType == TTCommit2 && len(r.CurrentSealTask.Commit1Out) > 0 {
+		r.CurrentSealTask.Commit1Out = make([]byte, 0)
+	}
+}
+
+// trimErrMsg truncates the ErrMsg of the current seal and file tasks to at
+// most n characters, marking the cut with an ellipsis.
+func (s *State) trimErrMsg(n int) {
+	for id := range s.state {
+		r := s.state[id]
+		sealMsg, sealCut := truncate(r.CurrentSealTask.ErrMsg, n)
+		fileMsg, fileCut := truncate(r.CurrentFileTask.ErrMsg, n)
+		if sealCut {
+			s.audit.changed(id, "CurrentSealTask.ErrMsg", r.CurrentSealTask.ErrMsg, sealMsg)
+		}
+		if fileCut {
+			s.audit.changed(id, "CurrentFileTask.ErrMsg", r.CurrentFileTask.ErrMsg, fileMsg)
+		}
+		if sealCut || fileCut {
+			r.CurrentSealTask.ErrMsg = sealMsg
+			r.CurrentFileTask.ErrMsg = fileMsg
+			s.updateSectorRecord(r)
+		}
+	}
+}
+
+// touchUpdatedAt sets the UpdatedAt of every record to now.
+func (s *State) touchUpdatedAt(now time.Time) {
+	for id := range s.state {
+		r := s.state[id]
+		old := ""
+		if r.UpdatedAt != nil {
+			old = r.UpdatedAt.Format(time.RFC3339Nano)
+		}
+		s.audit.changed(id, "UpdatedAt", old, now.Format(time.RFC3339Nano))
+		t := now
+		r.UpdatedAt = &t
+		s.updateSectorRecord(r)
+	}
+}
+
+// stripUUIDs sets the CurrentFileTask.ID of every record to the nil UUID,
+// which loads back like any other, and returns how many were changed.
+func (s *State) stripUUIDs() int {
+	n := 0
+	for id := range s.state {
+		r := s.state[id]
+		if r.CurrentFileTask.ID == uuid.Nil {
+			continue
+		}
+		s.audit.changed(id, "CurrentFileTask.ID", r.CurrentFileTask.ID.String(), uuid.Nil.String())
+		r.CurrentFileTask.ID = uuid.Nil
+		s.updateSectorRecord(r)
+		n++
+	}
+	return n
+}
+
+// dropEmptyRecords removes records whose SectorId is the zero value and
+// returns how many were dropped.
+func (s *State) dropEmptyRecords() int {
+	n := 0
+	for id, r := range s.state {
+		if r.SectorId == (SectorID{}) {
+			s.audit.dropped(id)
+			delete(s.state, id)
+			n++
+		}
+	}
+	return n
+}
+
+// pruneDoneFileTasks blanks the source and target paths of finished file
+// tasks. The task ID and type are kept for audit.
+func (s *State) pruneDoneFileTasks() {
+	for id := range s.state {
+		r := s.state[id]
+		if !r.CurrentFileTask.Done {
+			continue
+		}
+		t := &r.CurrentFileTask
+		paths := []struct {
+			name string
+			p    *string
+		}{
+			{"SourceUnsealedSectorPath", &t.SourceUnsealedSectorPath},
+			{"SourceSealedSectorPath", &t.SourceSealedSectorPath},
+			{"SourceCachePath", &t.SourceCachePath},
+			{"TargetUnsealedSectorPath", &t.TargetUnsealedSectorPath},
+			{"TargetSealedSectorPath", &t.TargetSealedSectorPath},
+			{"TargetCachePath", &t.TargetCachePath},
+		}
+		for _, f := range paths {
+			if *f.p != "" {
+				s.audit.cleared(id, "CurrentFileTask."+f.name, *f.p)
+				*f.p = ""
+			}
+		}
+		s.updateSectorRecord(r)
+	}
+}
+
+func truncate(msg string, n int) (string, bool) {
+	runes := []rune(msg)
+	if len(runes) <= n {
+		return msg, false
+	}
+	return string(runes[:n]) + "...", true
+}
+
 func (s *State) updateSectorRecord(r SectorRecord) error {
+	processingSector(r.SectorId)
 	sr, ok := s.state[r.SectorId]
 	if !ok {
 		return errors.New("sector Id not found")
@@ -239,15 +1025,63 @@ func (s *State) updateSectorRecord(r SectorRecord) error {
 }
 
 func main() {
-	path, err := getAbsPath("~/.lotus_scheduler/state_data")
+	opts, err := parseFlags(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(2)
 	}
-	loadStateFromFile(path)
-	err = getState().save()
-	if err != nil {
-		fmt.Println(err)
-	} else {
-		fmt.Println("done ok")
+	os.Exit(exitStatus(os.Stdout, os.Stderr, opts, run(opts)))
+}
+
+// exitStatus reports err, the outcome of run, and the -summary line and
+// returns the exit status of the run.
+func exitStatus(stdout, stderr io.Writer, opts options, err error) int {
+	code := 0
+	if e, ok := err.(exitError); ok {
+		code = e.code
+	} else if errors.Is(err, errInterrupted) {
+		fmt.Fprintln(stderr, "interrupted, no partial output written.")
+		code = 130
+	} else if err != nil {
+		if opts.errorFormat == "json" {
+			reportJsonError(stderr, err)
+		} else {
+			fmt.Fprintln(stdout, err)
+		}
+		code = 1
+	}
+	if code == 0 && opts.failOnWarn && warnings > 0 {
+		err = fmt.Errorf("%d warning(s), failing as -fail-on-warning asks", warnings)
+		fmt.Fprintln(stderr, err)
+		code = 1
+	}
+	if opts.summary {
+		format := opts.format
+		if opts.flatten {
+			format = "flatten"
+		}
+		fmt.Fprintln(stderr, summary.line(format, err))
 	}
+	return code
+}
+
+// decodeStrict unmarshals raw into r, failing on fields r does not have.
+// The fields of the current record extension are allowed.
+func decodeStrict(raw json.RawMessage, r *SectorRecord) error {
+	if currentExtension != nil {
+		tree := make(fieldTree)
+		for name := range currentExtension.names {
+			tree[name] = make(fieldTree)
+		}
+		var err error
+		raw, err = projectFields(raw, tree, true)
+		if err != nil {
+			return err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(r); err != nil {
+		return &jsonRecordError{err: fmt.Errorf("%s: %w", sectorName(r.SectorId), err)}
+	}
+	return nil
 }
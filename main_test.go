@@ -0,0 +1,732 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// testRecord returns a small record of sector miner/number at phase.
+func testRecord(miner ActorID, number SectorNumber, phase SectorWorkingPhase) SectorRecord {
+	id := SectorID{Miner: miner, Number: number}
+	return SectorRecord{
+		SectorId:           id,
+		SectorWorkingPhase: phase,
+		CurrentSealTask: TaskInfo{
+			SectorID: id,
+			TaskType: "seal/v0/precommit/1",
+			Pieces:   []PieceInfo{{Size: 2048}},
+		},
+		P1WorkerAddress: "10.0.0.1:3456",
+	}
+}
+
+func testRecords(n int) []SectorRecord {
+	recordList := make([]SectorRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		recordList = append(recordList, testRecord(1000, SectorNumber(i), SectorWorkingPhase(i)))
+	}
+	return recordList
+}
+
+// testCid returns the CIDv1 of the raw block data.
+func testCid(t testing.TB, data string) cid.Cid {
+	t.Helper()
+	h, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.Raw, h)
+}
+
+func recordMap(recordList []SectorRecord) map[SectorID]SectorRecord {
+	data := make(map[SectorID]SectorRecord, len(recordList))
+	for _, r := range recordList {
+		data[r.SectorId] = r
+	}
+	return data
+}
+
+// writeGobState writes v, a state map or record slice, to a gob file in
+// dir and returns its path.
+func writeGobState(t testing.TB, dir, name string, v interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, dir, name, buf.Bytes())
+}
+
+// writeJsonState writes recordList as a JSON array in dir and returns its
+// path.
+func writeJsonState(t testing.TB, dir, name string, recordList []SectorRecord) string {
+	t.Helper()
+	marshaled, err := json.Marshal(recordList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, dir, name, marshaled)
+}
+
+func writeTestFile(t testing.TB, dir, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func readTestFile(t testing.TB, name string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// readJsonState loads the records of the JSON state file name.
+func readJsonState(t testing.TB, name string) []SectorRecord {
+	t.Helper()
+	recordList, err := loadByJson(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recordList
+}
+
+// loadTestState loads the state file name in any format newState reads.
+func loadTestState(t testing.TB, name string) map[SectorID]SectorRecord {
+	t.Helper()
+	s, err := newState(name, mergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.state
+}
+
+func tempDir(t testing.TB) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "transfer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// toolRun is the outcome of runTool.
+type toolRun struct {
+	err    error
+	code   int
+	stdout string
+	stderr string
+	log    string
+}
+
+// runTool runs the command line args as main would, after resetting the
+// package state an earlier run left behind, and captures the exit status,
+// stdout, stderr and the log.
+func runTool(t testing.TB, args ...string) toolRun {
+	t.Helper()
+	onceState = sync.Once{}
+	stateSingleton = nil
+	errStateLoad = nil
+	warnings = 0
+	summary = runSummary{start: time.Now()}
+	remoteInputs = make(map[string][]byte)
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	out := redirect(t, &os.Stdout)
+	errOut := redirect(t, &os.Stderr)
+
+	var res toolRun
+	opts, err := parseFlags(args)
+	if err != nil {
+		res.err, res.code = err, 2
+	} else {
+		res.err = run(opts)
+		res.code = exitStatus(os.Stdout, os.Stderr, opts, res.err)
+	}
+	res.stdout = out()
+	res.stderr = errOut()
+	res.log = logged.String()
+	return res
+}
+
+// redirect points *f, os.Stdout or os.Stderr, at a temporary file until
+// the returned function restores it and returns what was written.
+func redirect(t testing.TB, f **os.File) func() string {
+	t.Helper()
+	tmp, err := ioutil.TempFile("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(tmp.Name()) })
+	orig := *f
+	*f = tmp
+	return func() string {
+		*f = orig
+		tmp.Close()
+		return readTestFile(t, tmp.Name())
+	}
+}
+
+// mustRun is runTool for a run expected to succeed.
+func mustRun(t testing.TB, args ...string) toolRun {
+	t.Helper()
+	res := runTool(t, args...)
+	if res.err != nil {
+		t.Fatalf("%s: %v\n%s", strings.Join(args, " "), res.err, res.log)
+	}
+	return res
+}
+
+func TestTrimErrMsg(t *testing.T) {
+	dir := tempDir(t)
+	long := testRecord(1000, 1, 1)
+	long.CurrentSealTask.ErrMsg = strings.Repeat("boom ", 100)
+	long.CurrentFileTask.ErrMsg = "short"
+	in := writeJsonState(t, dir, "in.json", []SectorRecord{long})
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-trim-errmsg", "20")
+	got := readJsonState(t, out)[0]
+	if want := strings.Repeat("boom ", 4) + "..."; got.CurrentSealTask.ErrMsg != want {
+		t.Errorf("seal task ErrMsg = %q, want %q", got.CurrentSealTask.ErrMsg, want)
+	}
+	if got.CurrentFileTask.ErrMsg != "short" {
+		t.Errorf("file task ErrMsg = %q, want it untouched", got.CurrentFileTask.ErrMsg)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		msg  string
+		n    int
+		want string
+		cut  bool
+	}{
+		{"", 3, "", false},
+		{"abc", 3, "abc", false},
+		{"abcd", 3, "abc...", true},
+		{"héllo", 2, "hé...", true},
+	}
+	for _, tt := range tests {
+		got, cut := truncate(tt.msg, tt.n)
+		if got != tt.want || cut != tt.cut {
+			t.Errorf("truncate(%q, %d) = %q, %v, want %q, %v", tt.msg, tt.n, got, cut, tt.want, tt.cut)
+		}
+	}
+}
+
+// checkKeysSorted fails t unless every object in the JSON document raw has
+// its keys in lexicographic order.
+func checkKeysSorted(t *testing.T, raw []byte) {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	// last holds the previous key of each open object, and nil for an
+	// open array; inKey tells whether the next token of an object is a key.
+	var last []*string
+	var inKey []bool
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		top := len(last) - 1
+		if top >= 0 && last[top] != nil && inKey[top] {
+			if d, ok := tok.(json.Delim); ok && d == '}' {
+				last, inKey = last[:top], inKey[:top]
+				continue
+			}
+			key := tok.(string)
+			if key < *last[top] {
+				t.Errorf("key %q follows %q", key, *last[top])
+			}
+			*last[top] = key
+			inKey[top] = false
+			continue
+		}
+		if top >= 0 && last[top] != nil {
+			inKey[top] = true
+		}
+		switch tok {
+		case json.Delim('{'):
+			last, inKey = append(last, new(string)), append(inKey, true)
+		case json.Delim('['):
+			last, inKey = append(last, nil), append(inKey, false)
+		case json.Delim(']'):
+			last, inKey = last[:top], inKey[:top]
+		}
+	}
+}
+
+func TestCanonicalJson(t *testing.T) {
+	got, err := canonicalJson([]byte(`{"b":{"z":1,"a":[{"y":2,"x":3}]},"a":1.50}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a":1.50,"b":{"a":[{"x":3,"y":2}],"z":1}}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCanonicalOutput(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(2)
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-canonical")
+	checkKeysSorted(t, []byte(readTestFile(t, out)))
+	got := readJsonState(t, out)
+	if len(got) != 2 || got[1].SectorId != recordList[1].SectorId || got[1].CurrentSealTask.Pieces[0].Size != 2048 {
+		t.Fatalf("the canonical output does not round-trip: %+v", got)
+	}
+}
+
+func TestPruneDoneFileTasks(t *testing.T) {
+	dir := tempDir(t)
+	fileTask := func(done bool) FileTask {
+		return FileTask{
+			ID:                     uuid.New(),
+			FileTaskType:           "move",
+			SourceSealedSectorPath: "/src/sealed",
+			SourceCachePath:        "/src/cache",
+			TargetSealedSectorPath: "/dst/sealed",
+			TargetCachePath:        "/dst/cache",
+			Done:                   done,
+		}
+	}
+	done, busy := testRecord(1000, 1, 1), testRecord(1000, 2, 1)
+	done.CurrentFileTask, busy.CurrentFileTask = fileTask(true), fileTask(false)
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{done, busy}))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-prune-done-filetasks")
+	got := readJsonState(t, out)
+	want := done.CurrentFileTask
+	want.SourceSealedSectorPath, want.SourceCachePath = "", ""
+	want.TargetSealedSectorPath, want.TargetCachePath = "", ""
+	if got[0].CurrentFileTask != want {
+		t.Errorf("done task = %+v, want %+v", got[0].CurrentFileTask, want)
+	}
+	if got[1].CurrentFileTask != busy.CurrentFileTask {
+		t.Errorf("in-progress task = %+v, want it untouched", got[1].CurrentFileTask)
+	}
+}
+
+func TestVersionedOutput(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(2)))
+	out := filepath.Join(dir, "out.json")
+
+	before := time.Now().Add(-time.Second)
+	mustRun(t, "-in", in, "-out", out, "-versioned")
+	var doc versionedState
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Version != stateVersion {
+		t.Errorf("version = %d, want %d", doc.Version, stateVersion)
+	}
+	generatedAt, err := time.Parse(time.RFC3339, doc.GeneratedAt)
+	if err != nil {
+		t.Fatalf("generatedAt %q: %v", doc.GeneratedAt, err)
+	}
+	if generatedAt.Before(before) || generatedAt.After(time.Now()) {
+		t.Errorf("generatedAt %s is not the time of the run", generatedAt)
+	}
+	if got := readJsonState(t, out); len(got) != 2 {
+		t.Errorf("loaded %d records from the versioned output, want 2", len(got))
+	}
+}
+
+func TestLoadVersionedState(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		doc     string
+		records int
+		ok      bool
+	}{
+		{`{"version":1,"generatedAt":"2021-03-04T05:06:07Z","records":[]}`, 0, true},
+		{`{"version":1,"records":[{"SectorId":{"Miner":1000,"Number":1}}]}`, 1, true},
+		{`{"version":2,"records":[]}`, 0, false},
+	}
+	for _, tt := range tests {
+		recordList, err := loadByJson(writeTestFile(t, dir, "state.json", []byte(tt.doc)))
+		if (err == nil) != tt.ok || len(recordList) != tt.records {
+			t.Errorf("loading %s: got %d records, %v", tt.doc, len(recordList), err)
+		}
+	}
+}
+
+func TestEmptyRecords(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", append(testRecords(2), SectorRecord{}))
+	out := filepath.Join(dir, "out.json")
+	tests := []struct {
+		args    []string
+		records int
+		logged  bool
+	}{
+		{nil, 2, true},
+		{[]string{"-keep-empty-records"}, 3, false},
+		// -canonical takes the general path instead of fastConvert.
+		{[]string{"-canonical"}, 2, true},
+		{[]string{"-canonical", "-keep-empty-records"}, 3, false},
+	}
+	for _, tt := range tests {
+		res := mustRun(t, append([]string{"-in", in, "-out", out}, tt.args...)...)
+		if got := readJsonState(t, out); len(got) != tt.records {
+			t.Errorf("%q: wrote %d records, want %d", tt.args, len(got), tt.records)
+		}
+		if logged := strings.Contains(res.log, "dropped 1 empty record(s)"); logged != tt.logged {
+			t.Errorf("%q: the log reports the dropped records: %v, want %v\n%s", tt.args, logged, tt.logged, res.log)
+		}
+		if summary.dropped != 3-tt.records {
+			t.Errorf("%q: the summary counts %d dropped, want %d", tt.args, summary.dropped, 3-tt.records)
+		}
+	}
+}
+
+func TestSkipBadRecords(t *testing.T) {
+	dir := tempDir(t)
+	// encoding/json refuses years past 9999, which gob encodes fine.
+	broken := testRecord(1000, 2, 2)
+	future := time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+	broken.UpdatedAt = &future
+	recordList := testRecords(3)
+	recordList[1] = broken
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	if res := runTool(t, "-in", in, "-out", out); res.err == nil {
+		t.Error("a record that cannot be encoded did not fail the conversion")
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("the failed conversion left an output: %v", err)
+	}
+
+	res := mustRun(t, "-in", in, "-out", out, "-skip-bad-records")
+	got := readJsonState(t, out)
+	if len(got) != 2 || got[0].SectorId.Number != 1 || got[1].SectorId.Number != 3 {
+		t.Errorf("wrote %+v, want sectors 1 and 3", got)
+	}
+	if !strings.Contains(res.log, "skipping s-t01000-2:") || !strings.Contains(res.log, "skipped 1 bad record(s)") {
+		t.Errorf("the log does not report the skipped record:\n%s", res.log)
+	}
+	if summary.dropped != 1 {
+		t.Errorf("the summary counts %d dropped, want 1", summary.dropped)
+	}
+}
+
+func TestCleanCommit1OutSkipsCleanRecords(t *testing.T) {
+	commit2 := func(number SectorNumber, c1out []byte) SectorRecord {
+		r := testRecord(1000, number, 6)
+		r.CurrentSealTask.TaskType = TTCommit2
+		r.CurrentSealTask.Commit1Out = c1out
+		return r
+	}
+	otherTask := testRecord(1000, 4, 1)
+	otherTask.CurrentSealTask.Commit1Out = []byte("kept")
+	s := &State{
+		audit: &auditLog{},
+		state: recordMap([]SectorRecord{commit2(1, []byte("c1out")), commit2(2, []byte{}), commit2(3, nil), otherTask}),
+	}
+	s.cleanCommit1Out()
+	want := []auditEntry{{Sector: "s-t01000-1", Field: "CurrentSealTask.Commit1Out", Action: "cleared", Old: "5 bytes", id: SectorID{Miner: 1000, Number: 1}}}
+	if !reflect.DeepEqual(s.audit.entries, want) {
+		t.Errorf("audit entries = %+v, want only the one of sector 1", s.audit.entries)
+	}
+	if c1out := s.state[SectorID{Miner: 1000, Number: 1}].CurrentSealTask.Commit1Out; c1out == nil || len(c1out) != 0 {
+		t.Errorf("sector 1 Commit1Out = %v, want it cleared to empty", c1out)
+	}
+	if c1out := s.state[SectorID{Miner: 1000, Number: 3}].CurrentSealTask.Commit1Out; c1out != nil {
+		t.Errorf("sector 3 Commit1Out = %v, want it left nil", c1out)
+	}
+	if c1out := string(s.state[otherTask.SectorId].CurrentSealTask.Commit1Out); c1out != "kept" {
+		t.Errorf("the Commit1Out of a precommit1 record is %q, want it kept", c1out)
+	}
+
+	s.audit = &auditLog{}
+	s.cleanCommit1Out()
+	if len(s.audit.entries) != 0 {
+		t.Errorf("cleaning a clean state logged %+v", s.audit.entries)
+	}
+}
+
+func TestStrictJson(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		misspelt string
+		doc      string
+	}{
+		{"P1WorkerAdress", `[{"SectorId":{"Miner":1000,"Number":1},"P1WorkerAdress":"10.0.0.1:3456"}]`},
+		{"TaskTyp", `[{"SectorId":{"Miner":1000,"Number":1},"CurrentSealTask":{"TaskTyp":"seal/v0/commit/2"}}]`},
+	}
+	for _, tt := range tests {
+		in := writeTestFile(t, dir, "in.json", []byte(tt.doc))
+		out := filepath.Join(dir, "out.json")
+
+		res := runTool(t, "-in", in, "-out", out, "-strict-json")
+		if res.err == nil || !strings.Contains(res.err.Error(), `unknown field "`+tt.misspelt+`"`) {
+			t.Errorf("-strict-json with %s: got %v, want the field named", tt.misspelt, res.err)
+		}
+		var jsonErr *jsonRecordError
+		if !errors.As(res.err, &jsonErr) {
+			t.Errorf("-strict-json with %s: got %T, want a JSON error rather than a gob retry", tt.misspelt, res.err)
+		}
+
+		mustRun(t, "-in", in, "-out", out)
+		if got := readJsonState(t, out); len(got) != 1 || strings.Contains(readTestFile(t, out), `"`+tt.misspelt+`"`) {
+			t.Errorf("without -strict-json, %s was not silently dropped:\n%s", tt.misspelt, readTestFile(t, out))
+		}
+	}
+
+	in := writeJsonState(t, dir, "ok.json", testRecords(1))
+	if res := runTool(t, "-in", in, "-out", filepath.Join(dir, "out.json"), "-strict-json", "-keep-unknown-fields"); errorType(res.err) != "usage" {
+		t.Errorf("-strict-json -keep-unknown-fields: got %v, want a usage error", res.err)
+	}
+}
+
+func TestOrderedRecords(t *testing.T) {
+	data := recordMap(testRecords(5))
+	id := func(n SectorNumber) SectorID { return SectorID{Miner: 1000, Number: n} }
+	tests := []struct {
+		name string
+		ids  []SectorID
+		want string
+	}{
+		{"no order", []SectorID{}, "1 2 3 4 5"},
+		{"full order", []SectorID{id(5), id(2), id(4), id(1), id(3)}, "5 2 4 1 3"},
+		{"partial order", []SectorID{id(4), id(2)}, "4 2 1 3 5"},
+		{"repeated and unknown sectors", []SectorID{id(3), id(9), id(3), id(1)}, "3 1 2 4 5"},
+	}
+	for _, tt := range tests {
+		if got := sectorNumbers(orderedRecords(data, tt.ids)); got != tt.want {
+			t.Errorf("%s: got the sectors %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	dir := tempDir(t)
+	scrambled := []SectorRecord{testRecord(1000, 5, 1), testRecord(1000, 2, 1), testRecord(1000, 9, 1), testRecord(1000, 1, 1)}
+	array := writeJsonState(t, dir, "array.json", scrambled)
+	mustRun(t, "-in", array, "-out", filepath.Join(dir, "lines.jsonl"), "-format", "jsonl", "-preserve-order")
+	lines := filepath.Join(dir, "lines.jsonl")
+	gobMap := writeGobState(t, dir, "map.gob", recordMap([]SectorRecord{testRecord(1000, 7, 1), testRecord(1000, 3, 1), testRecord(1000, 5, 1)}))
+	out := filepath.Join(dir, "out.json")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-in", array}, "1 2 5 9"},
+		{[]string{"-in", array, "-preserve-order"}, "5 2 9 1"},
+		{[]string{"-in", lines, "-preserve-order"}, "5 2 9 1"},
+		// Sectors keep their first position, and the sectors of a map input
+		// are in sector order at the position of the input.
+		{[]string{"-in", array, "-in", gobMap, "-preserve-order"}, "5 2 9 1 3 7"},
+		{[]string{"-in", gobMap, "-in", array, "-preserve-order"}, "3 5 7 2 9 1"},
+	}
+	for _, tt := range tests {
+		mustRun(t, append(tt.args, "-out", out)...)
+		if got := sectorNumbers(readJsonState(t, out)); got != tt.want {
+			t.Errorf("%q: wrote the sectors %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRepeatedConversionIsIdempotent(t *testing.T) {
+	dir := tempDir(t)
+	// Enough sectors that map order would show, with CIDs and blobs.
+	recordList := testRecords(50)
+	for i := range recordList {
+		task := &recordList[i].CurrentSealTask
+		task.Pieces[0].PieceCID = testCid(t, fmt.Sprint("piece", i))
+		task.PreCommit2Out.Sealed = testCid(t, fmt.Sprint("sealed", i))
+		task.Ticket = urlUnsafe
+	}
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	for _, format := range []string{"json", "jsonl"} {
+		first := filepath.Join(dir, "first."+format)
+		mustRun(t, "-in", in, "-out", first, "-format", format)
+		again := filepath.Join(dir, "again."+format)
+		mustRun(t, "-in", in, "-out", again, "-format", format)
+		if readTestFile(t, again) != readTestFile(t, first) {
+			t.Errorf("-format %s: converting the gob input twice gave different bytes", format)
+		}
+		// Converting the output itself, twice over, changes nothing.
+		for n := 0; n < 2; n++ {
+			next := filepath.Join(dir, fmt.Sprint("next", n, ".", format))
+			mustRun(t, "-in", first, "-out", next, "-format", format)
+			if readTestFile(t, next) != readTestFile(t, first) {
+				t.Errorf("-format %s: converting the output again gave different bytes", format)
+			}
+			first = next
+		}
+	}
+}
+
+func TestTouchUpdatedAt(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	recordList[0].UpdatedAt = &old
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out)
+	for _, r := range readJsonState(t, out) {
+		if r.SectorId.Number == 1 && !r.UpdatedAt.Equal(old) || r.SectorId.Number != 1 && r.UpdatedAt != nil {
+			t.Errorf("without -touch-updatedat sector %d has UpdatedAt %v", r.SectorId.Number, r.UpdatedAt)
+		}
+	}
+
+	before := time.Now()
+	mustRun(t, "-in", in, "-out", out, "-touch-updatedat")
+	after := time.Now()
+	got := readJsonState(t, out)
+	if len(got) != 3 {
+		t.Fatalf("wrote %d records, want 3", len(got))
+	}
+	for _, r := range got {
+		if r.UpdatedAt == nil || r.UpdatedAt.Before(before) || r.UpdatedAt.After(after) {
+			t.Errorf("sector %d has UpdatedAt %v, want the time of the run", r.SectorId.Number, r.UpdatedAt)
+		} else if !r.UpdatedAt.Equal(*got[0].UpdatedAt) {
+			t.Errorf("sector %d has UpdatedAt %v, want every record stamped with %v", r.SectorId.Number, r.UpdatedAt, got[0].UpdatedAt)
+		}
+	}
+}
+
+func TestStripUUIDs(t *testing.T) {
+	dir := tempDir(t)
+	id := uuid.MustParse("6f1c3a9e-0d2b-4c1e-9a57-3b8d2e4f6a10")
+	recordList := testRecords(3)
+	recordList[0].CurrentFileTask.ID = id
+	recordList[2].CurrentFileTask.ID = uuid.MustParse("11111111-2222-4333-8444-555555555555")
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out)
+	if got := readJsonState(t, out); got[0].CurrentFileTask.ID != id {
+		t.Errorf("without -strip-uuids sector 1 has the file task ID %s, want %s", got[0].CurrentFileTask.ID, id)
+	}
+
+	res := mustRun(t, "-in", in, "-out", out, "-strip-uuids")
+	if !strings.Contains(res.log, "set 2 file task ID(s) to the nil UUID") {
+		t.Errorf("the stripped IDs were not counted:\n%s", res.log)
+	}
+	if raw := readTestFile(t, out); strings.Count(raw, `"ID":"00000000-0000-0000-0000-000000000000"`) != 3 {
+		t.Errorf("the output does not hold three nil file task IDs:\n%s", raw)
+	}
+	// The nil UUIDs load back, and converting again keeps them.
+	again := filepath.Join(dir, "again.json")
+	mustRun(t, "-in", out, "-out", again)
+	for _, r := range readJsonState(t, again) {
+		if r.CurrentFileTask.ID != uuid.Nil {
+			t.Errorf("sector %d reloaded with the file task ID %s", r.SectorId.Number, r.CurrentFileTask.ID)
+		}
+	}
+	if readTestFile(t, again) != readTestFile(t, out) {
+		t.Error("reconverting the stripped output changed it")
+	}
+}
+
+func TestOmitEmptyPieces(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	recordList[0].CurrentSealTask.Pieces = nil
+	recordList[1].CurrentSealTask.Pieces = []PieceInfo{}
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	// No flags takes the fast path, -explain the general one.
+	for _, args := range [][]string{{}, {"-explain"}} {
+		mustRun(t, append([]string{"-in", in, "-out", out}, args...)...)
+		raw := readTestFile(t, out)
+		if n := strings.Count(raw, `"Pieces":`); n != 1 {
+			t.Errorf("%q: %d record(s) with a Pieces member, want only sector 3", args, n)
+		}
+		for _, strict := range []string{"-strict-json=false", "-strict-json"} {
+			again := filepath.Join(dir, "again.json")
+			mustRun(t, "-in", out, "-out", again, strict)
+			got := readJsonState(t, again)
+			if len(got) != 3 || got[0].CurrentSealTask.Pieces != nil || len(got[2].CurrentSealTask.Pieces) != 1 {
+				t.Errorf("%q, %s: loaded %+v back", args, strict, got)
+			}
+		}
+	}
+
+	mustRun(t, "-in", in, "-out", out, "-include-empty-pieces")
+	if raw := readTestFile(t, out); strings.Count(raw, `"Pieces":null`) != 2 {
+		t.Errorf("-include-empty-pieces did not write the empty Pieces:\n%s", raw)
+	}
+}
+
+func TestGobFallbackKeepsStdoutClean(t *testing.T) {
+	dir := tempDir(t)
+	compressed := writeGobState(t, dir, "compressed.gob", recordMap(testRecords(2)))
+	gzipTestFile(t, compressed)
+	for _, in := range []string{
+		writeGobState(t, dir, "map.gob", recordMap(testRecords(2))),
+		writeGobState(t, dir, "slice.gob", testRecords(2)),
+		compressed,
+	} {
+		out := filepath.Join(dir, "out.json")
+		res := mustRun(t, "-in", in, "-out", out, "-log-level", "debug")
+		if res.stdout != "done ok\n" {
+			t.Errorf("%s: loading a gob printed %q to stdout, want only done ok", in, res.stdout)
+		}
+		if strings.Contains(res.log, "not valid JSON") {
+			t.Errorf("%s: a gob was decoded as JSON first:\n%s", in, res.log)
+		}
+		if got := readJsonState(t, out); len(got) != 2 {
+			t.Errorf("%s: wrote %d records, want 2", in, len(got))
+		}
+	}
+
+	// Input starting like JSON that fails to parse is retried as gob,
+	// which is logged to stderr at info level.
+	broken := writeTestFile(t, dir, "broken.json", []byte(`[{"SectorId":`))
+	res := runTool(t, "-in", broken, "-out", filepath.Join(dir, "out.json"))
+	if res.err == nil {
+		t.Fatal("broken JSON loaded")
+	}
+	if !strings.Contains(res.log, broken+": not valid JSON, trying gob: ") {
+		t.Errorf("the fallback was not logged:\n%s", res.log)
+	}
+	if strings.Contains(res.stdout, "trying gob") || strings.Contains(res.stdout, "unexpected end of JSON input") {
+		t.Errorf("the JSON error reached stdout:\n%s", res.stdout)
+	}
+	res = runTool(t, "-in", broken, "-out", filepath.Join(dir, "out.json"), "-log-level", "warn")
+	if strings.Contains(res.log, "trying gob") {
+		t.Errorf("-log-level warn logged the fallback:\n%s", res.log)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// stringsFlag collects the values of a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// loadStates decodes every file in filePaths using at most concurrency
+// workers and merges the results in input order, so a sector present in
+// several files takes its record from the last one regardless of which
+// decode finished first. The first decode error cancels the remaining work.
+func loadStates(ctx context.Context, filePaths []string, concurrency int, opts mergeOptions) (map[SectorID]SectorRecord, error) {
+	data, _, err := loadOrderedStates(ctx, filePaths, concurrency, opts)
+	return data, err
+}
+
+// loadOrderedStates is loadStates also returning the order the sectors
+// first appear in across the inputs. Array and JSON Lines inputs keep their
+// record order; the sectors of map-shaped inputs follow in sector order.
+func loadOrderedStates(ctx context.Context, filePaths []string, concurrency int, opts mergeOptions) (map[SectorID]SectorRecord, []SectorID, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]map[SectorID]SectorRecord, len(filePaths))
+	orders := make([][]SectorID, len(filePaths))
+	jobs := make(chan int)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				s, err := func() (s *State, err error) {
+					defer recoverPanic(&err)
+					return newState(filePaths[i], opts)
+				}()
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = &loadError{path: filePaths[i], err: err}
+						cancel()
+					})
+					continue
+				}
+				results[i] = s.state
+				orders[i] = s.order
+			}
+		}()
+	}
+feed:
+	for i := range filePaths {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	merged, err := mergeStates(results, filePaths, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, mergeOrders(results, orders), nil
+}
+
+// mergeOrders lists every sector of results once, in the order of the
+// input it first appears in; orders[i] is the record order of results[i],
+// or nil if the input had none. It is nil when no input had an order.
+func mergeOrders(results []map[SectorID]SectorRecord, orders [][]SectorID) []SectorID {
+	ordered := false
+	for _, ids := range orders {
+		ordered = ordered || ids != nil
+	}
+	if !ordered {
+		return nil
+	}
+	seen := make(map[SectorID]bool)
+	order := make([]SectorID, 0)
+	for i, m := range results {
+		ids := orders[i]
+		if ids == nil {
+			for _, r := range sortedRecords(m) {
+				ids = append(ids, r.SectorId)
+			}
+		}
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				order = append(order, id)
+			}
+		}
+	}
+	return order
+}
+
+// mergeOptions controls how decoded inputs are combined.
+type mergeOptions struct {
+	strategy mergeStrategy
+	// rejectPhaseRegression fails the merge when a later input moves a
+	// sector's SectorWorkingPhase backwards.
+	rejectPhaseRegression bool
+	// reportDuplicates prints the sectors held by several inputs before
+	// merging them.
+	reportDuplicates bool
+	// onDuplicate picks the record kept when a slice-shaped gob input
+	// holds several for one sector.
+	onDuplicate duplicatePolicy
+}
+
+// mergeStrategy decides which record is kept when several inputs hold the
+// same sector.
+type mergeStrategy string
+
+const (
+	// mergeLastWins keeps the record from the last input.
+	mergeLastWins mergeStrategy = "last"
+	// mergePreferNewest keeps the record with the newest UpdatedAt,
+	// falling back to the higher SectorWorkingPhase when the timestamps
+	// are equal or absent, and to the last input on a tie.
+	mergePreferNewest mergeStrategy = "newest"
+	// mergeError fails the merge when inputs hold different records for
+	// the same sector, naming the fields that differ.
+	mergeError mergeStrategy = "error"
+)
+
+func parseMergeStrategy(name string) (mergeStrategy, error) {
+	switch s := mergeStrategy(name); s {
+	case mergeLastWins, mergePreferNewest, mergeError:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown merge strategy %q", name)
+}
+
+// duplicatePolicy decides which record is kept when a slice-shaped gob
+// value holds several for the same sector.
+type duplicatePolicy string
+
+const (
+	duplicateKeepFirst duplicatePolicy = "keep-first"
+	// duplicateKeepLast is what building the map from the slice always
+	// did.
+	duplicateKeepLast duplicatePolicy = "keep-last"
+	// duplicateKeepHighestPhase keeps the record with the highest
+	// SectorWorkingPhase, and the later one on a tie.
+	duplicateKeepHighestPhase duplicatePolicy = "keep-highest-phase"
+	duplicateError            duplicatePolicy = "error"
+)
+
+func parseDuplicatePolicy(name string) (duplicatePolicy, error) {
+	switch p := duplicatePolicy(name); p {
+	case duplicateKeepFirst, duplicateKeepLast, duplicateKeepHighestPhase, duplicateError:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown -on-duplicate policy %q, want keep-first, keep-last, keep-highest-phase or error", name)
+}
+
+// dedupe returns recordList with one record per sector, chosen by p, each
+// at the position of the record kept.
+func (p duplicatePolicy) dedupe(recordList []SectorRecord) ([]SectorRecord, error) {
+	kept := make(map[SectorID]int, len(recordList))
+	for i, r := range recordList {
+		prev, ok := kept[r.SectorId]
+		switch {
+		case !ok:
+		case p == duplicateError:
+			return nil, fmt.Errorf("%s is held by records %d and %d of the slice", sectorName(r.SectorId), prev, i)
+		case p == duplicateKeepFirst:
+			continue
+		case p == duplicateKeepHighestPhase && r.SectorWorkingPhase < recordList[prev].SectorWorkingPhase:
+			continue
+		}
+		kept[r.SectorId] = i
+	}
+	if len(kept) == len(recordList) {
+		return recordList, nil
+	}
+	out := make([]SectorRecord, 0, len(kept))
+	for i, r := range recordList {
+		if kept[r.SectorId] == i {
+			out = append(out, r)
+		}
+	}
+	return out, nil
+}
+
+// replaces reports whether incoming should take the place of current.
+func (s mergeStrategy) replaces(current, incoming SectorRecord) bool {
+	if s != mergePreferNewest {
+		return true
+	}
+	if current.UpdatedAt != nil && incoming.UpdatedAt != nil && !current.UpdatedAt.Equal(*incoming.UpdatedAt) {
+		return incoming.UpdatedAt.After(*current.UpdatedAt)
+	}
+	return incoming.SectorWorkingPhase >= current.SectorWorkingPhase
+}
+
+// phaseRegression is a sector whose phase in a later input is lower than
+// in an earlier one, a sign of a stale or out-of-order snapshot.
+type phaseRegression struct {
+	SectorID SectorID
+	From     SectorWorkingPhase
+	To       SectorWorkingPhase
+	File     string
+}
+
+func (p phaseRegression) String() string {
+	return fmt.Sprintf("%s: phase %d -> %d in %s", sectorName(p.SectorID), p.From, p.To, p.File)
+}
+
+// mergeConflict is a sector an input holds a different record for than an
+// earlier input, under mergeError.
+type mergeConflict struct {
+	SectorID SectorID
+	Fields   []string
+	File     string
+}
+
+func (c mergeConflict) String() string {
+	return fmt.Sprintf("%s in %s differs in %s", sectorName(c.SectorID), c.File, strings.Join(c.Fields, ", "))
+}
+
+// mergeStates folds results into one state in order; sources names the
+// file each result was decoded from.
+func mergeStates(results []map[SectorID]SectorRecord, sources []string, opts mergeOptions) (map[SectorID]SectorRecord, error) {
+	if opts.reportDuplicates {
+		dups, err := duplicatesAcrossInputs(results, sources)
+		if err != nil {
+			return nil, err
+		}
+		printDuplicates(os.Stdout, dups)
+	}
+	merged := make(map[SectorID]SectorRecord)
+	regressions := make([]phaseRegression, 0)
+	conflicts := make([]mergeConflict, 0)
+	for i, m := range results {
+		for id, r := range m {
+			prev, ok := merged[id]
+			if !ok {
+				merged[id] = r
+				continue
+			}
+			if opts.strategy == mergeError {
+				fields, err := recordDiff(prev, r)
+				if err != nil {
+					return nil, fmt.Errorf("%s in %s: %w", sectorName(id), sources[i], err)
+				}
+				if len(fields) > 0 {
+					conflicts = append(conflicts, mergeConflict{SectorID: id, Fields: fields, File: sources[i]})
+				}
+				continue
+			}
+			if r.SectorWorkingPhase < prev.SectorWorkingPhase {
+				regressions = append(regressions, phaseRegression{
+					SectorID: id,
+					From:     prev.SectorWorkingPhase,
+					To:       r.SectorWorkingPhase,
+					File:     sources[i],
+				})
+			}
+			if opts.strategy.replaces(prev, r) {
+				merged[id] = r
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool {
+			return sectorIDLess(conflicts[i].SectorID, conflicts[j].SectorID)
+		})
+		lines := make([]string, 0, len(conflicts))
+		for _, c := range conflicts {
+			lines = append(lines, c.String())
+		}
+		return nil, fmt.Errorf("%d conflicting sector record(s):\n%s", len(conflicts), strings.Join(lines, "\n"))
+	}
+	if len(regressions) == 0 {
+		return merged, nil
+	}
+	sort.Slice(regressions, func(i, j int) bool {
+		return sectorIDLess(regressions[i].SectorID, regressions[j].SectorID)
+	})
+	for _, p := range regressions {
+		warnf("phase regression: %s", p)
+	}
+	if opts.rejectPhaseRegression {
+		return nil, fmt.Errorf("%d sector(s) moved to an earlier phase while merging", len(regressions))
+	}
+	return merged, nil
+}
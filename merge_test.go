@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadStatesMergesInInputOrder(t *testing.T) {
+	dir := tempDir(t)
+	paths := make([]string, 0, 4)
+	for i := 0; i < 4; i++ {
+		// Every file holds sector 1000/0, and file i also its own sector
+		// 1000/i+1; the copy of the last file must win.
+		shared := testRecord(1000, 0, 1)
+		shared.P1WorkerAddress = fmt.Sprintf("file%d", i)
+		own := testRecord(1000, SectorNumber(i+1), 1)
+		paths = append(paths, writeGobState(t, dir, fmt.Sprintf("%d.gob", i), recordMap([]SectorRecord{shared, own})))
+	}
+	for _, concurrency := range []int{1, 4} {
+		data, err := loadStates(context.Background(), paths, concurrency, mergeOptions{strategy: mergeLastWins})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 5 {
+			t.Errorf("concurrency %d: got %d records, want 5", concurrency, len(data))
+		}
+		if w := data[SectorID{Miner: 1000}].P1WorkerAddress; w != "file3" {
+			t.Errorf("concurrency %d: the shared sector comes from %s, want file3", concurrency, w)
+		}
+	}
+}
+
+func TestLoadStatesReportsTheFailingFile(t *testing.T) {
+	dir := tempDir(t)
+	good := writeGobState(t, dir, "good.gob", recordMap(testRecords(1)))
+	bad := writeTestFile(t, dir, "bad.gob", []byte("not a state"))
+	_, err := loadStates(context.Background(), []string{good, bad}, 2, mergeOptions{})
+	if err == nil {
+		t.Fatal("loading a corrupt file succeeded")
+	}
+	if _, ok := err.(*loadError); !ok {
+		t.Errorf("got %T, want a *loadError naming the file", err)
+	}
+}
+
+// BenchmarkLoadStates decodes eight gob files one at a time and in
+// parallel; the speedup needs as many CPUs.
+func BenchmarkLoadStates(b *testing.B) {
+	dir := tempDir(b)
+	paths := make([]string, 0, 8)
+	for i := 0; i < 8; i++ {
+		recordList := make([]SectorRecord, 0, 2000)
+		for n := 0; n < 2000; n++ {
+			recordList = append(recordList, testRecord(ActorID(1000+i), SectorNumber(n), 1))
+		}
+		paths = append(paths, writeGobState(b, dir, fmt.Sprintf("%d.gob", i), recordMap(recordList)))
+	}
+	for _, concurrency := range []int{1, 4} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := loadStates(context.Background(), paths, concurrency, mergeOptions{strategy: mergeLastWins}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeReportsPhaseRegressions(t *testing.T) {
+	newer := recordMap([]SectorRecord{testRecord(1000, 1, 5), testRecord(1000, 2, 3)})
+	older := recordMap([]SectorRecord{testRecord(1000, 1, 2), testRecord(1000, 2, 4)})
+	results := []map[SectorID]SectorRecord{newer, older}
+	sources := []string{"new.gob", "old.gob"}
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	warnings = 0
+	merged, err := mergeStates(results, sources, mergeOptions{strategy: mergeLastWins})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings != 1 || !strings.Contains(logged.String(), "phase regression: s-t01000-1: phase 5 -> 2 in old.gob") {
+		t.Errorf("got %d warning(s), want the regression of sector 1:\n%s", warnings, logged.String())
+	}
+	if merged[SectorID{Miner: 1000, Number: 1}].SectorWorkingPhase != 2 {
+		t.Error("reporting a regression changed the record kept")
+	}
+
+	_, err = mergeStates(results, sources, mergeOptions{strategy: mergeLastWins, rejectPhaseRegression: true})
+	if err == nil || !strings.Contains(err.Error(), "1 sector(s) moved to an earlier phase") {
+		t.Errorf("-require-phase-monotonic: got %v, want the regression rejected", err)
+	}
+}
+
+func TestMergeMonotonicSnapshots(t *testing.T) {
+	results := []map[SectorID]SectorRecord{
+		recordMap([]SectorRecord{testRecord(1000, 1, 2)}),
+		recordMap([]SectorRecord{testRecord(1000, 1, 2), testRecord(1000, 2, 1)}),
+		recordMap([]SectorRecord{testRecord(1000, 1, 5)}),
+	}
+	warnings = 0
+	if _, err := mergeStates(results, []string{"a", "b", "c"}, mergeOptions{rejectPhaseRegression: true}); err != nil {
+		t.Fatal(err)
+	}
+	if warnings != 0 {
+		t.Errorf("got %d warning(s) for snapshots that only move forward", warnings)
+	}
+}
+
+func TestMergeStrategyReplaces(t *testing.T) {
+	at := func(phase SectorWorkingPhase, ts string) SectorRecord {
+		r := testRecord(1000, 1, phase)
+		if ts != "" {
+			u, err := time.Parse(time.RFC3339, ts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.UpdatedAt = &u
+		}
+		return r
+	}
+	tests := []struct {
+		name              string
+		strategy          mergeStrategy
+		current, incoming SectorRecord
+		want              bool
+	}{
+		{"last wins over a newer record", mergeLastWins, at(5, "2021-02-01T00:00:00Z"), at(1, "2021-01-01T00:00:00Z"), true},
+		{"newer timestamp", mergePreferNewest, at(5, "2021-01-01T00:00:00Z"), at(1, "2021-02-01T00:00:00Z"), true},
+		{"older timestamp", mergePreferNewest, at(1, "2021-02-01T00:00:00Z"), at(5, "2021-01-01T00:00:00Z"), false},
+		{"equal timestamps, higher phase", mergePreferNewest, at(1, "2021-01-01T00:00:00Z"), at(5, "2021-01-01T00:00:00Z"), true},
+		{"equal timestamps, lower phase", mergePreferNewest, at(5, "2021-01-01T00:00:00Z"), at(1, "2021-01-01T00:00:00Z"), false},
+		{"no timestamps, higher phase", mergePreferNewest, at(1, ""), at(5, ""), true},
+		{"no timestamps, lower phase", mergePreferNewest, at(5, ""), at(1, ""), false},
+		{"one timestamp, tie", mergePreferNewest, at(3, "2021-01-01T00:00:00Z"), at(3, ""), true},
+	}
+	for _, tt := range tests {
+		if got := tt.strategy.replaces(tt.current, tt.incoming); got != tt.want {
+			t.Errorf("%s: replaces = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergePreferNewest(t *testing.T) {
+	dir := tempDir(t)
+	older, newer := testRecord(1000, 1, 4), testRecord(1000, 1, 4)
+	older.P1WorkerAddress, newer.P1WorkerAddress = "old", "new"
+	t1 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+	older.UpdatedAt, newer.UpdatedAt = &t1, &t2
+	// The newer snapshot comes first, so last-wins would keep the older.
+	a := writeGobState(t, dir, "a.gob", recordMap([]SectorRecord{newer}))
+	b := writeGobState(t, dir, "b.gob", recordMap([]SectorRecord{older}))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", a, "-in", b, "-out", out, "-merge-strategy", "newest")
+	if got := readJsonState(t, out); len(got) != 1 || got[0].P1WorkerAddress != "new" {
+		t.Errorf("merged %+v, want the record updated last", got)
+	}
+	mustRun(t, "-in", a, "-in", b, "-out", out)
+	if got := readJsonState(t, out); got[0].P1WorkerAddress != "old" {
+		t.Errorf("the default strategy kept %q, want the last input's", got[0].P1WorkerAddress)
+	}
+}
+
+func TestParseMergeStrategy(t *testing.T) {
+	for _, name := range []string{"last", "newest", "error"} {
+		if s, err := parseMergeStrategy(name); err != nil || string(s) != name {
+			t.Errorf("parseMergeStrategy(%q) = %q, %v", name, s, err)
+		}
+	}
+	if _, err := parseMergeStrategy("first"); err == nil {
+		t.Error("parseMergeStrategy accepted first")
+	}
+}
+
+func TestRecordDiff(t *testing.T) {
+	a := testRecord(1000, 1, 1)
+	b := a
+	b.P1WorkerAddress = "elsewhere"
+	b.CurrentSealTask.TaskType = TTCommit2
+	b.CurrentSealTask.Pieces = []PieceInfo{{Size: 2048}, {Size: 4096}}
+	fields, err := recordDiff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CurrentSealTask.TaskType", "CurrentSealTask.Pieces", "P1WorkerAddress"}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("recordDiff = %q, want %q", fields, want)
+	}
+	if fields, err := recordDiff(a, a); err != nil || len(fields) != 0 {
+		t.Errorf("recordDiff of equal records = %q, %v", fields, err)
+	}
+}
+
+func TestMergeErrorNamesConflictingFields(t *testing.T) {
+	changed := testRecord(1000, 2, 2)
+	changed.P1WorkerAddress = "elsewhere"
+	changed.CurrentSealTask.ErrMsg = "boom"
+	results := []map[SectorID]SectorRecord{
+		recordMap(testRecords(3)),
+		recordMap([]SectorRecord{testRecord(1000, 1, 1), changed}),
+	}
+	_, err := mergeStates(results, []string{"a.gob", "b.gob"}, mergeOptions{strategy: mergeError})
+	want := "1 conflicting sector record(s):\ns-t01000-2 in b.gob differs in CurrentSealTask.ErrMsg, P1WorkerAddress"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %s", err, want)
+	}
+
+	results[1] = recordMap([]SectorRecord{testRecord(1000, 1, 1)})
+	merged, err := mergeStates(results, []string{"a.gob", "b.gob"}, mergeOptions{strategy: mergeError})
+	if err != nil || len(merged) != 3 {
+		t.Errorf("merging identical copies: got %d records, %v", len(merged), err)
+	}
+}
+
+// writeGobValues writes values to dir/name as consecutive gob values, with
+// one encoder for all of them or a new encoder for each.
+func writeGobValues(t *testing.T, dir, name string, oneEncoder bool, values ...interface{}) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for _, v := range values {
+		if !oneEncoder {
+			enc = gob.NewEncoder(&buf)
+		}
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return writeTestFile(t, dir, name, buf.Bytes())
+}
+
+func TestLoadConcatenatedGobValues(t *testing.T) {
+	dir := tempDir(t)
+	first := recordMap(testRecords(2))
+	updated := testRecord(1000, 2, 5)
+	second := recordMap([]SectorRecord{updated, testRecord(1000, 3, 3)})
+	out := filepath.Join(dir, "out.json")
+	for _, oneEncoder := range []bool{true, false} {
+		in := writeGobValues(t, dir, fmt.Sprintf("state-%v", oneEncoder), oneEncoder, first, second)
+		res := mustRun(t, "-in", in, "-out", out)
+		got := readJsonState(t, out)
+		if len(got) != 3 || got[1].SectorWorkingPhase != 5 {
+			t.Errorf("one encoder %v: got %d records with sector 2 at phase %d, want 3 with the appended copy", oneEncoder, len(got), got[1].SectorWorkingPhase)
+		}
+		if !strings.Contains(res.log, "merging 2 appended gob values") {
+			t.Errorf("one encoder %v: the merge was not logged:\n%s", oneEncoder, res.log)
+		}
+
+		res = runTool(t, "-in", in, "-out", out, "-merge-strategy", "error")
+		if res.err == nil || !strings.Contains(res.err.Error(), "s-t01000-2 in "+in+" (gob value 2) differs in") {
+			t.Errorf("one encoder %v, -merge-strategy error: got %v", oneEncoder, res.err)
+		}
+		res = runTool(t, "-in", in, "-out", out, "-require-phase-monotonic")
+		if res.err != nil {
+			t.Errorf("one encoder %v: a forward move was rejected: %v", oneEncoder, res.err)
+		}
+	}
+}
+
+func TestLoadConcatenatedGobTrailingBytes(t *testing.T) {
+	dir := tempDir(t)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(recordMap(testRecords(2))); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("torn write")
+	in := writeTestFile(t, dir, "state", buf.Bytes())
+	res := runTool(t, "-in", in, "-out", filepath.Join(dir, "out.json"))
+	if res.err == nil || !strings.Contains(res.err.Error(), "gob value 2 at offset") {
+		t.Errorf("got %v, want the undecodable trailing bytes reported", res.err)
+	}
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+	for _, name := range []string{"keep-first", "keep-last", "keep-highest-phase", "error"} {
+		if p, err := parseDuplicatePolicy(name); err != nil || string(p) != name {
+			t.Errorf("parseDuplicatePolicy(%q) = %q, %v", name, p, err)
+		}
+	}
+	if _, err := parseDuplicatePolicy("last"); err == nil {
+		t.Error("parseDuplicatePolicy accepted last")
+	}
+}
+
+// collidingRecords are records of a slice gob holding sector 1 three
+// times and sector 2 twice, told apart by P1WorkerAddress.
+func collidingRecords() []SectorRecord {
+	record := func(number SectorNumber, phase SectorWorkingPhase, worker string) SectorRecord {
+		r := testRecord(1000, number, phase)
+		r.P1WorkerAddress = worker
+		return r
+	}
+	return []SectorRecord{
+		record(1, 3, "1a"),
+		record(2, 4, "2a"),
+		record(1, 5, "1b"),
+		record(3, 1, "3a"),
+		record(1, 5, "1c"),
+		record(2, 2, "2b"),
+	}
+}
+
+func TestDuplicatePolicy(t *testing.T) {
+	tests := []struct {
+		policy duplicatePolicy
+		want   string
+	}{
+		{duplicateKeepFirst, "1a 2a 3a"},
+		{duplicateKeepLast, "3a 1c 2b"},
+		{"", "3a 1c 2b"},
+		{duplicateKeepHighestPhase, "2a 3a 1c"},
+	}
+	for _, tt := range tests {
+		kept, err := tt.policy.dedupe(collidingRecords())
+		if err != nil {
+			t.Fatalf("%q: %v", tt.policy, err)
+		}
+		workers := make([]string, 0, len(kept))
+		for _, r := range kept {
+			workers = append(workers, r.P1WorkerAddress)
+		}
+		if got := strings.Join(workers, " "); got != tt.want {
+			t.Errorf("%q kept %s, want %s in record order", tt.policy, got, tt.want)
+		}
+	}
+	_, err := duplicateError.dedupe(collidingRecords())
+	if want := "s-t01000-1 is held by records 0 and 2 of the slice"; err == nil || err.Error() != want {
+		t.Errorf("error: got %v, want %q", err, want)
+	}
+	unique := testRecords(3)
+	if kept, err := duplicateError.dedupe(unique); err != nil || len(kept) != 3 {
+		t.Errorf("error without duplicates: got %d records, %v", len(kept), err)
+	}
+}
+
+func TestOnDuplicateFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", collidingRecords())
+	out := filepath.Join(dir, "out.json")
+	tests := []struct {
+		policy string
+		want   map[SectorNumber]string
+	}{
+		{"", map[SectorNumber]string{1: "1c", 2: "2b", 3: "3a"}},
+		{"keep-first", map[SectorNumber]string{1: "1a", 2: "2a", 3: "3a"}},
+		{"keep-highest-phase", map[SectorNumber]string{1: "1c", 2: "2a", 3: "3a"}},
+	}
+	for _, tt := range tests {
+		// -explain takes the general path rather than fastConvert.
+		for _, extra := range [][]string{{}, {"-explain"}} {
+			args := append([]string{"-in", in, "-out", out}, extra...)
+			if tt.policy != "" {
+				args = append(args, "-on-duplicate", tt.policy)
+			}
+			mustRun(t, args...)
+			got := make(map[SectorNumber]string)
+			for _, r := range readJsonState(t, out) {
+				got[r.SectorId.Number] = r.P1WorkerAddress
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%q: kept %v, want %v", args[4:], got, tt.want)
+			}
+		}
+	}
+
+	for _, extra := range [][]string{{}, {"-explain"}} {
+		res := runTool(t, append([]string{"-in", in, "-out", out, "-on-duplicate", "error"}, extra...)...)
+		if res.code != 1 || res.err == nil || !strings.Contains(res.err.Error(), "s-t01000-1 is held by records 0 and 2") {
+			t.Errorf("%q: exit status %d, %v, want the duplicate reported", extra, res.code, res.err)
+		}
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-on-duplicate", "newest"); errorType(res.err) != "usage" {
+		t.Errorf("-on-duplicate newest: got %v, want a usage error", res.err)
+	}
+}
+
+func TestOnDuplicatePreservesOrder(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", collidingRecords())
+	out := filepath.Join(dir, "out.json")
+	mustRun(t, "-in", in, "-out", out, "-preserve-order", "-on-duplicate", "keep-highest-phase")
+	if got := sectorNumbers(readJsonState(t, out)); got != "2 3 1" {
+		t.Errorf("-preserve-order wrote the sectors %s, want 2 3 1, where the records kept stood", got)
+	}
+}
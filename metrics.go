@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serverMetrics are the Prometheus metrics exposed by serve mode on
+// /metrics.
+type serverMetrics struct {
+	registry       *prometheus.Registry
+	requests       *prometheus.CounterVec
+	sectorsLoaded  prometheus.Counter
+	reloads        prometheus.Counter
+	sectorsByPhase *prometheus.GaugeVec
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "state_http_requests_total",
+			Help: "HTTP requests served, by handler.",
+		}, []string{"handler"}),
+		sectorsLoaded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "state_sectors_loaded_total",
+			Help: "Sector records loaded, summed over the initial load and every reload.",
+		}),
+		reloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "state_reloads_total",
+			Help: "Successful reloads requested through /reload.",
+		}),
+		sectorsByPhase: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "state_sectors",
+			Help: "Sectors in the currently loaded state, by SectorWorkingPhase.",
+		}, []string{"phase"}),
+	}
+	m.registry.MustRegister(m.requests, m.sectorsLoaded, m.reloads, m.sectorsByPhase)
+	return m
+}
+
+// loaded updates the state metrics after data has been (re)loaded.
+func (m *serverMetrics) loaded(data map[SectorID]SectorRecord) {
+	m.sectorsLoaded.Add(float64(len(data)))
+	byPhase := make(map[SectorWorkingPhase]int)
+	for _, r := range data {
+		byPhase[r.SectorWorkingPhase]++
+	}
+	m.sectorsByPhase.Reset()
+	for phase, n := range byPhase {
+		m.sectorsByPhase.WithLabelValues(strconv.Itoa(int(phase))).Set(float64(n))
+	}
+}
+
+// instrument counts the requests served by h under the given handler name.
+func (m *serverMetrics) instrument(name string, h http.HandlerFunc) http.HandlerFunc {
+	counter := m.requests.WithLabelValues(name)
+	return func(w http.ResponseWriter, r *http.Request) {
+		counter.Inc()
+		h(w, r)
+	}
+}
+
+func (m *serverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+import "errors"
+
+const mmapSupported = false
+
+func mmapFile(filename string) ([]byte, func(), error) {
+	return nil, nil, errors.New("mmap is not supported on this platform")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMmapLoadMatchesRead(t *testing.T) {
+	if !mmapSupported {
+		t.Skip("mmap is not supported on this platform")
+	}
+	defer func() { useMmap = false }()
+	dir := tempDir(t)
+	recordList := testRecords(50)
+	recordList[3].CurrentSealTask.Ticket = []byte("ticket")
+	for _, in := range []string{
+		writeGobState(t, dir, "map.gob", recordMap(recordList)),
+		writeGobState(t, dir, "slice.gob", recordList),
+		writeJsonState(t, dir, "state.json", recordList),
+	} {
+		useMmap = false
+		read := loadTestState(t, in)
+		useMmap = true
+		mapped := loadTestState(t, in)
+		if !reflect.DeepEqual(mapped, read) {
+			t.Errorf("%s: the mmap load differs from the regular one", in)
+		}
+	}
+}
+
+func TestMmapFile(t *testing.T) {
+	if !mmapSupported {
+		t.Skip("mmap is not supported on this platform")
+	}
+	dir := tempDir(t)
+	for _, content := range []string{"", "state bytes"} {
+		data, release, err := mmapFile(writeTestFile(t, dir, "f", []byte(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("mapped %q, want %q", data, content)
+		}
+		release()
+	}
+	if _, _, err := mmapFile(dir + "/missing"); err == nil {
+		t.Error("mapping a missing file succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// nullNonFinite makes marshalFinite write NaN and infinite floats as null,
+// as asked with -non-finite null, instead of failing.
+var nullNonFinite bool
+
+// nonFiniteFloat is a NaN or infinite float inside a value being marshaled.
+// JSON cannot represent either.
+type nonFiniteFloat struct {
+	path  []string
+	value float64
+	// zero sets the float to 0 where it is stored.
+	zero func()
+}
+
+func (f nonFiniteFloat) String() string {
+	return fmt.Sprintf("%s is %v, which JSON cannot represent", strings.Join(f.path, "."), f.value)
+}
+
+// marshalFinite marshals the value v points to, the record of sector id. A
+// NaN or infinite float in it fails naming the field, or with nullNonFinite
+// is written as null and set to 0 in *v, which may share it with the
+// caller's copy.
+func marshalFinite(id SectorID, v interface{}) (json.RawMessage, error) {
+	found := nonFiniteFloats(reflect.ValueOf(v).Elem(), nil)
+	if len(found) == 0 {
+		return json.Marshal(v)
+	}
+	if !nullNonFinite {
+		return nil, fmt.Errorf("%s: %s; pass -non-finite null to write null instead", sectorName(id), found[0])
+	}
+	for _, f := range found {
+		f.zero()
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range found {
+		warnf("%s: writing null for %s, which was %v", sectorName(id), strings.Join(f.path, "."), f.value)
+		raw, err = replaceField(raw, f.path, func(json.RawMessage) (json.RawMessage, error) {
+			return json.RawMessage("null"), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// nonFiniteFloats returns the NaN and infinite floats reachable from v,
+// which is at path, using JSON field names for struct fields and indexes
+// or keys for the elements of slices, arrays and maps.
+func nonFiniteFloats(v reflect.Value, path []string) []nonFiniteFloat {
+	if !mayHoldFloat(v.Type()) {
+		return nil
+	}
+	child := func(name string) []string {
+		p := make([]string, len(path), len(path)+1)
+		copy(p, path)
+		return append(p, name)
+	}
+	var found []nonFiniteFloat
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			found = append(found, nonFiniteFloat{path: path, value: f, zero: func() { v.SetFloat(0) }})
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			found = nonFiniteFloats(v.Elem(), path)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+				continue
+			}
+			found = append(found, nonFiniteFloats(v.Field(i), child(jsonFieldName(f)))...)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			found = append(found, nonFiniteFloats(v.Index(i), child(strconv.Itoa(i)))...)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// Map elements cannot be set in place, so a copy is checked
+			// and stored back once zeroed.
+			key := iter.Key()
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			for _, f := range nonFiniteFloats(elem, child(fmt.Sprint(key.Interface()))) {
+				zero := f.zero
+				f.zero = func() {
+					zero()
+					v.SetMapIndex(key, elem)
+				}
+				found = append(found, f)
+			}
+		}
+	}
+	return found
+}
+
+// mayHoldFloat reports whether a value of type t can contain a float,
+// sparing the walk over byte slices and other float-free fields.
+func mayHoldFloat(t reflect.Type) bool {
+	if known, ok := floatTypes.Load(t); ok {
+		return known.(bool)
+	}
+	holds := holdsFloat(t, make(map[reflect.Type]bool))
+	floatTypes.Store(t, holds)
+	return holds
+}
+
+var floatTypes sync.Map
+
+func holdsFloat(t reflect.Type, seen map[reflect.Type]bool) bool {
+	if seen[t] {
+		return false
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64, reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return holdsFloat(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath == "" && holdsFloat(f.Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type floatTask struct {
+	Weights []float64
+	Limits  map[string]float32
+}
+
+type floatRecord struct {
+	Score  float64
+	Task   *floatTask `json:"task"`
+	Hidden float64    `json:"-"`
+	Plain  string
+}
+
+func TestMarshalFinite(t *testing.T) {
+	defer func() { nullNonFinite = false }()
+	id := SectorID{Miner: 1000, Number: 1}
+	record := func() floatRecord {
+		return floatRecord{
+			Score:  math.NaN(),
+			Task:   &floatTask{Weights: []float64{1, math.Inf(1)}, Limits: map[string]float32{"cpu": float32(math.Inf(-1))}},
+			Hidden: math.NaN(),
+			Plain:  "kept",
+		}
+	}
+
+	nullNonFinite = false
+	r := record()
+	_, err := marshalFinite(id, &r)
+	if err == nil || !strings.HasPrefix(err.Error(), "s-t01000-1: Score is NaN, which JSON cannot represent") {
+		t.Errorf("got %v, want the first non-finite field named", err)
+	}
+	if raw, err := marshalFinite(id, &floatRecord{Score: 1.5, Hidden: math.NaN()}); err != nil || string(raw) != `{"Score":1.5,"task":null,"Plain":""}` {
+		t.Errorf("a finite record marshaled as %s, %v", raw, err)
+	}
+
+	nullNonFinite = true
+	r = record()
+	raw, err := marshalFinite(id, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"Score":null,"task":{"Weights":[1,null],"Limits":{"cpu":null}},"Plain":"kept"}`
+	if string(raw) != want {
+		t.Errorf("marshaled %s, want %s", raw, want)
+	}
+}
+
+type scoredFields struct {
+	Score float64
+}
+
+type scoredRecord struct {
+	SectorId           SectorID
+	SectorWorkingPhase SectorWorkingPhase
+	Score              float64
+}
+
+func TestNonFiniteExtensionField(t *testing.T) {
+	registerRecordExtension("test-scored", scoredFields{})
+	defer delete(recordExtensions, "test-scored")
+	defer setRecordExtension("")
+
+	dir := tempDir(t)
+	a, b := SectorID{Miner: 1000, Number: 1}, SectorID{Miner: 1000, Number: 2}
+	in := writeGobState(t, dir, "scored.gob", map[SectorID]scoredRecord{
+		a: {SectorId: a, SectorWorkingPhase: 1, Score: 0.5},
+		b: {SectorId: b, SectorWorkingPhase: 1, Score: math.Inf(1)},
+	})
+	out := filepath.Join(dir, "out.json")
+
+	res := runTool(t, "-in", in, "-out", out, "-record-extension", "test-scored")
+	if res.err == nil || !strings.Contains(res.err.Error(), "s-t01000-2: Score is +Inf, which JSON cannot represent") {
+		t.Errorf("got %v, want the sector and field named", res.err)
+	}
+
+	res = mustRun(t, "-in", in, "-out", out, "-record-extension", "test-scored", "-non-finite", "null")
+	if !strings.Contains(res.log, "s-t01000-2: writing null for Score, which was +Inf") {
+		t.Errorf("the log lacks the warning:\n%s", res.log)
+	}
+	var raw []map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 || string(raw[0]["Score"]) != "0.5" || string(raw[1]["Score"]) != "null" {
+		t.Errorf("wrote %s, want the infinite score as null", readTestFile(t, out))
+	}
+
+	if res := runTool(t, "-in", in, "-out", out, "-non-finite", "zero"); errorType(res.err) != "usage" {
+		t.Errorf("-non-finite zero: got %v, want a usage error", res.err)
+	}
+}
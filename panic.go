@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// lastFile and lastSector are the input file and the sector most recently
+// taken up, so a panic can say where it happened. With -concurrency above
+// 1 another worker may have moved them on in the meantime.
+var lastFile, lastSector atomic.Value
+
+func processingFile(name string)   { lastFile.Store(name) }
+func processingSector(id SectorID) { lastSector.Store(id) }
+
+// panicError is a panic turned into an error by recoverPanic.
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	msg := fmt.Sprintf("internal error: panic: %v", e.value)
+	if id, ok := lastSector.Load().(SectorID); ok {
+		msg += " (last sector " + sectorName(id)
+		if name, ok := lastFile.Load().(string); ok {
+			msg += ", last input " + name
+		}
+		return msg + ")"
+	}
+	if name, ok := lastFile.Load().(string); ok {
+		msg += " (last input " + name + ")"
+	}
+	return msg
+}
+
+// recoverPanic, deferred, turns a panic of the calling goroutine into an
+// error stored in *err, logging the stack at debug level.
+func recoverPanic(err *error) {
+	if p := recover(); p != nil {
+		debugf("panic: %v\n%s", p, debug.Stack())
+		*err = &panicError{value: p}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// trap is a field that panics when it decodes the value "boom".
+type trap string
+
+func (t trap) MarshalBinary() ([]byte, error) { return []byte(t), nil }
+
+func (t *trap) UnmarshalBinary(b []byte) error {
+	if string(b) == "boom" {
+		panic("trap sprung")
+	}
+	*t = trap(b)
+	return nil
+}
+
+// trapRecord is a record with the trap field of the test-trap extension.
+type trapRecord struct {
+	SectorId SectorID
+	Trap     trap
+}
+
+func TestRecoverPanicDuringValidation(t *testing.T) {
+	orig := validationRules
+	defer func() { validationRules = orig }()
+	validationRules = append(validationRules, validationRule{name: "panics", check: func(r SectorRecord) []string {
+		if r.SectorId.Number == 2 {
+			var m map[string]int
+			m["boom"]++
+		}
+		return nil
+	}})
+
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap(testRecords(3)))
+	res := runTool(t, "-in", in, "-validate")
+	var p *panicError
+	if !errors.As(res.err, &p) || res.code != 1 {
+		t.Fatalf("got exit status %d, %v, want the panic returned as an error", res.code, res.err)
+	}
+	want := "internal error: panic: assignment to entry in nil map (last sector s-t01000-2, last input " + in + ")"
+	if res.err.Error() != want || !strings.Contains(res.stdout, want) {
+		t.Errorf("got %q printed as\n%s\nwant %q", res.err, res.stdout, want)
+	}
+	if strings.Contains(res.log, "goroutine") {
+		t.Errorf("the stack was logged above debug level:\n%s", res.log)
+	}
+	res = runTool(t, "-in", in, "-validate", "-log-level", "debug")
+	if !strings.Contains(res.log, "panic: assignment to entry in nil map") || !strings.Contains(res.log, "goroutine") {
+		t.Errorf("-log-level debug does not log the stack:\n%s", res.log)
+	}
+}
+
+func TestRecoverPanicInLoadWorker(t *testing.T) {
+	registerRecordExtension("test-trap", struct{ Trap trap }{})
+	defer delete(recordExtensions, "test-trap")
+	defer setRecordExtension("")
+
+	dir := tempDir(t)
+	a, b := SectorID{Miner: 1000, Number: 1}, SectorID{Miner: 1000, Number: 2}
+	good := writeGobState(t, dir, "good.gob", map[SectorID]trapRecord{a: {SectorId: a, Trap: "ok"}})
+	bad := writeGobState(t, dir, "bad.gob", map[SectorID]trapRecord{b: {SectorId: b, Trap: "boom"}})
+	out := filepath.Join(dir, "out.json")
+	for _, concurrency := range []string{"1", "4"} {
+		res := runTool(t, "-in", good, "-in", bad, "-out", out, "-record-extension", "test-trap", "-concurrency", concurrency)
+		var p *panicError
+		if !errors.As(res.err, &p) || res.code != 1 {
+			t.Errorf("-concurrency %s: got exit status %d, %v, want the panic returned as an error\n%s", concurrency, res.code, res.err, res.log)
+			continue
+		}
+		if concurrency == "1" && !strings.Contains(res.err.Error(), "panic: trap sprung (last sector s-t01000-2, last input "+bad+")") {
+			t.Errorf("got %q, want it to name sector 2 of %s", res.err, bad)
+		}
+	}
+}
+
+func TestPanicErrorWithoutContext(t *testing.T) {
+	lastFile, lastSector = atomic.Value{}, atomic.Value{}
+	if got := (&panicError{value: "x"}).Error(); got != "internal error: panic: x" {
+		t.Errorf("got %q", got)
+	}
+	processingFile("state_data")
+	if got := (&panicError{value: "x"}).Error(); got != "internal error: panic: x (last input state_data)" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// phaseChange is a row of an -apply-phases file: set the
+// SectorWorkingPhase of sector to phase.
+type phaseChange struct {
+	line   int
+	sector SectorID
+	phase  SectorWorkingPhase
+}
+
+// readPhaseChanges reads the sector,phase records of the CSV file filename.
+// The sector is given as miner/number or named like s-t01000-3. A header
+// record sector,phase, blank lines and lines starting with # are skipped.
+// Each line is one record, so errors name the line they are on.
+func readPhaseChanges(filename string) ([]phaseChange, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	named := mustKeyFormat(defaultKeyFormat)
+	changes := make([]phaseChange, 0)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		cr := csv.NewReader(strings.NewReader(line))
+		cr.FieldsPerRecord = 2
+		cr.TrimLeadingSpace = true
+		fields, err := cr.Read()
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				err = pe.Err
+			}
+			return nil, fmt.Errorf("%s:%d: want sector,phase: %v", filename, n, err)
+		}
+		sector, phase := strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1])
+		if len(changes) == 0 && strings.EqualFold(sector, "sector") && strings.EqualFold(phase, "phase") {
+			continue
+		}
+		id, err := parseListedSector(named, sector)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, n, err)
+		}
+		p, err := strconv.Atoi(phase)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: phase %q is not a number", filename, n, phase)
+		}
+		changes = append(changes, phaseChange{line: n, sector: id, phase: SectorWorkingPhase(p)})
+	}
+	return changes, sc.Err()
+}
+
+// applyPhases sets the SectorWorkingPhase of the records the changes name
+// and returns how many rows were applied and how many skipped, because
+// the sector is not in the state or already at that phase.
+func (s *State) applyPhases(changes []phaseChange, filename string) (applied, skipped int) {
+	for _, c := range changes {
+		r, ok := s.state[c.sector]
+		if !ok {
+			warnf("%s:%d: %s is not in the input, skipped", filename, c.line, sectorName(c.sector))
+			skipped++
+			continue
+		}
+		if r.SectorWorkingPhase == c.phase {
+			infof("%s:%d: %s is already at phase %d, skipped", filename, c.line, sectorName(c.sector), c.phase)
+			skipped++
+			continue
+		}
+		processingSector(c.sector)
+		s.audit.changed(c.sector, "SectorWorkingPhase", strconv.Itoa(int(r.SectorWorkingPhase)), strconv.Itoa(int(c.phase)))
+		r.SectorWorkingPhase = c.phase
+		// Not through updateSectorRecord, which refuses phase changes:
+		// here they are the point.
+		s.state[c.sector] = r
+		applied++
+	}
+	return applied, skipped
+}
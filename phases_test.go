@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPhaseChanges(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		csv  string
+		want []phaseChange
+		err  string
+	}{
+		{"sector,phase\n1000/1,4\ns-t01000-2, 5\n", []phaseChange{{2, SectorID{1000, 1}, 4}, {3, SectorID{1000, 2}, 5}}, ""},
+		{"# migration\n\n\"1000/3\",\"2\"\n", []phaseChange{{3, SectorID{1000, 3}, 2}}, ""},
+		{"SECTOR , PHASE\n1000/1,0\n", []phaseChange{{2, SectorID{1000, 1}, 0}}, ""},
+		{"# nothing to do\n", []phaseChange{}, ""},
+		{"1000/1,4\nsector,phase\n", nil, ":2: "},
+		{"1000/1,4\n1000/2\n", nil, ":2: want sector,phase: wrong number of fields"},
+		{"1000/1,4,5\n", nil, ":1: want sector,phase: wrong number of fields"},
+		{"1000/1,four\n", nil, `:1: phase "four" is not a number`},
+		{"1000-1,4\n", nil, ":1: "},
+		{"\"1000/1,4\n", nil, ":1: want sector,phase: "},
+	}
+	for _, tt := range tests {
+		name := writeTestFile(t, dir, "phases.csv", []byte(tt.csv))
+		got, err := readPhaseChanges(name)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), name+tt.err) {
+				t.Errorf("%q: got %v, want an error at %s", tt.csv, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, %v, want %v", tt.csv, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyPhases(t *testing.T) {
+	s := &State{state: recordMap(testRecords(3))}
+	applied, skipped := s.applyPhases([]phaseChange{
+		{1, SectorID{1000, 1}, 9},
+		{2, SectorID{1000, 2}, 2},
+		{3, SectorID{1000, 7}, 1},
+		{4, SectorID{1000, 3}, 1},
+	}, "phases.csv")
+	if applied != 2 || skipped != 2 {
+		t.Errorf("applied %d and skipped %d, want 2 of each", applied, skipped)
+	}
+	want := map[SectorNumber]SectorWorkingPhase{1: 9, 2: 2, 3: 1}
+	for id, r := range s.state {
+		if r.SectorWorkingPhase != want[id.Number] {
+			t.Errorf("%s is at phase %d, want %d", sectorName(id), r.SectorWorkingPhase, want[id.Number])
+		}
+	}
+	if _, ok := s.state[SectorID{1000, 7}]; ok || len(s.state) != 3 {
+		t.Error("a change to a sector not in the state added it")
+	}
+}
+
+func TestApplyPhasesFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(4)))
+	phases := writeTestFile(t, dir, "phases.csv", []byte("sector,phase\n1000/1,5\ns-t01000-3,1\n1000/4,4\n1000/9,2\n"))
+	out := filepath.Join(dir, "out.json")
+	audit := filepath.Join(dir, "audit.json")
+
+	res := mustRun(t, "-in", in, "-out", out, "-apply-phases", phases, "-audit", audit)
+	got := loadTestState(t, out)
+	for number, phase := range map[SectorNumber]SectorWorkingPhase{1: 5, 2: 2, 3: 1, 4: 4} {
+		if p := got[SectorID{1000, number}].SectorWorkingPhase; p != phase {
+			t.Errorf("sector %d is at phase %d, want %d", number, p, phase)
+		}
+	}
+	for _, want := range []string{
+		"applied 2 phase change(s) from " + phases + ", skipped 2",
+		phases + ":4: s-t01000-4 is already at phase 4, skipped",
+		phases + ":5: s-t01000-9 is not in the input, skipped",
+	} {
+		if !strings.Contains(res.log, want) {
+			t.Errorf("the log does not report %q:\n%s", want, res.log)
+		}
+	}
+	logged := readTestFile(t, audit)
+	if strings.Count(logged, `"field": "SectorWorkingPhase"`) != 2 || !strings.Contains(logged, `"old": "1"`) || !strings.Contains(logged, `"new": "5"`) {
+		t.Errorf("the audit log does not hold the 2 changes:\n%s", logged)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-apply-phases", phases, "-fail-on-warning"); res.code != 1 {
+		t.Errorf("-fail-on-warning with a sector not found: exit status %d, want 1", res.code)
+	}
+
+	bad := writeTestFile(t, dir, "bad.csv", []byte("1000/1,x\n"))
+	if res := runTool(t, "-in", in, "-out", out, "-apply-phases", bad); res.err == nil || !strings.Contains(res.err.Error(), bad+":1:") {
+		t.Errorf("a bad row: got %v, want an error naming its line", res.err)
+	}
+}
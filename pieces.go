@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// sharedPiece is a piece CID held by more than one sector.
+type sharedPiece struct {
+	cid     cid.Cid
+	sectors []SectorID
+}
+
+// pieceKey identifies c regardless of its CID version, so a piece written
+// as CIDv0 in one record and CIDv1 in another counts as the same.
+func pieceKey(c cid.Cid) string {
+	return cid.NewCidV1(c.Type(), c.Hash()).KeyString()
+}
+
+// parsePieceAllowlist parses the comma-separated CIDs of pieces that may
+// be shared between sectors, such as filler pieces.
+func parsePieceAllowlist(list string) (map[string]bool, error) {
+	allow := make(map[string]bool)
+	if list == "" {
+		return allow, nil
+	}
+	for _, s := range strings.Split(list, ",") {
+		c, err := cid.Decode(strings.TrimSpace(s))
+		if err != nil {
+			return nil, usageErrorf("-allow-shared-pieces %q: %v", s, err)
+		}
+		allow[pieceKey(c)] = true
+	}
+	return allow, nil
+}
+
+// duplicatePieces returns the piece CIDs of data found in more than one
+// sector, other than those in allow, ordered by their first sector.
+func duplicatePieces(data map[SectorID]SectorRecord, allow map[string]bool) []sharedPiece {
+	byKey := make(map[string]*sharedPiece)
+	keys := make([]string, 0)
+	for _, r := range sortedRecords(data) {
+		for _, p := range r.CurrentSealTask.Pieces {
+			if !p.PieceCID.Defined() {
+				continue
+			}
+			key := pieceKey(p.PieceCID)
+			if allow[key] {
+				continue
+			}
+			sp, ok := byKey[key]
+			if !ok {
+				sp = &sharedPiece{cid: p.PieceCID}
+				byKey[key] = sp
+				keys = append(keys, key)
+			}
+			if n := len(sp.sectors); n == 0 || sp.sectors[n-1] != r.SectorId {
+				sp.sectors = append(sp.sectors, r.SectorId)
+			}
+		}
+	}
+	shared := make([]sharedPiece, 0)
+	for _, key := range keys {
+		if sp := byKey[key]; len(sp.sectors) > 1 {
+			shared = append(shared, *sp)
+		}
+	}
+	return shared
+}
+
+func printDuplicatePieces(w io.Writer, shared []sharedPiece) {
+	for _, sp := range shared {
+		names := make([]string, 0, len(sp.sectors))
+		for _, id := range sp.sectors {
+			names = append(names, sectorName(id))
+		}
+		fmt.Fprintf(w, "%s: in %d sectors: %s\n", sp.cid, len(sp.sectors), strings.Join(names, ", "))
+	}
+	fmt.Fprintf(w, "%d piece CID(s) in more than one sector\n", len(shared))
+}
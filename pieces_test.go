@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// withPieces returns the test record of sector number holding pieces.
+func withPieces(number SectorNumber, pieces ...cid.Cid) SectorRecord {
+	r := testRecord(1000, number, 1)
+	r.CurrentSealTask.Pieces = nil
+	for _, c := range pieces {
+		r.CurrentSealTask.Pieces = append(r.CurrentSealTask.Pieces, PieceInfo{Size: 2048, PieceCID: c})
+	}
+	return r
+}
+
+func TestDuplicatePieces(t *testing.T) {
+	a, b, filler := testCid(t, "a"), testCid(t, "b"), testCid(t, "filler")
+	v0 := cid.NewCidV0(testCid(t, "v0").Hash())
+	v1 := cid.NewCidV1(cid.DagProtobuf, v0.Hash())
+	tests := []struct {
+		name    string
+		records []SectorRecord
+		allow   map[string]bool
+		want    string
+	}{
+		{"none shared", []SectorRecord{withPieces(1, a), withPieces(2, b), withPieces(3)}, nil, ""},
+		{"shared", []SectorRecord{withPieces(3, a), withPieces(1, a, b), withPieces(2, b)}, nil, a.String() + ": 1 3; " + b.String() + ": 1 2"},
+		{"twice in one sector", []SectorRecord{withPieces(1, a, a)}, nil, ""},
+		{"cid versions", []SectorRecord{withPieces(1, v0), withPieces(2, v1)}, nil, v0.String() + ": 1 2"},
+		{"undefined", []SectorRecord{withPieces(1, cid.Undef), withPieces(2, cid.Undef)}, nil, ""},
+		{"allowed", []SectorRecord{withPieces(1, filler, a), withPieces(2, filler, a)}, map[string]bool{pieceKey(filler): true}, a.String() + ": 1 2"},
+	}
+	for _, tt := range tests {
+		shared := duplicatePieces(recordMap(tt.records), tt.allow)
+		parts := make([]string, 0, len(shared))
+		for _, sp := range shared {
+			recordList := make([]SectorRecord, 0, len(sp.sectors))
+			for _, id := range sp.sectors {
+				recordList = append(recordList, SectorRecord{SectorId: id})
+			}
+			parts = append(parts, sp.cid.String()+": "+sectorNumbers(recordList))
+		}
+		if got := strings.Join(parts, "; "); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicatePiecesFlag(t *testing.T) {
+	dir := tempDir(t)
+	shared, filler := testCid(t, "shared"), testCid(t, "filler")
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{
+		withPieces(1, shared, filler), withPieces(2, filler), withPieces(3, shared),
+	}))
+
+	res := runTool(t, "-in", in, "-duplicate-pieces")
+	want := shared.String() + ": in 2 sectors: s-t01000-1, s-t01000-3\n" +
+		filler.String() + ": in 2 sectors: s-t01000-1, s-t01000-2\n" +
+		"2 piece CID(s) in more than one sector\n"
+	if res.code != 1 || res.stdout != want {
+		t.Errorf("exit status %d with\n%swant 1 with\n%s", res.code, res.stdout, want)
+	}
+
+	// The allowlist matches whatever base the CID is written in.
+	upper, err := filler.StringOfBase('B')
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = runTool(t, "-in", in, "-duplicate-pieces", "-allow-shared-pieces", shared.String()+", "+upper)
+	if res.code != 0 || res.stdout != "0 piece CID(s) in more than one sector\n" {
+		t.Errorf("with both CIDs allowed: exit status %d with\n%s", res.code, res.stdout)
+	}
+
+	res = runTool(t, "-in", in, "-duplicate-pieces", "-allow-shared-pieces", "not-a-cid")
+	if errorType(res.err) != "usage" {
+		t.Errorf("an invalid allowlist CID: got %v, want a usage error", res.err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// evalPointer returns the value the RFC 6901 JSON Pointer ptr selects in
+// doc. The empty pointer selects doc itself.
+func evalPointer(doc json.RawMessage, ptr string) (json.RawMessage, error) {
+	if ptr == "" {
+		return doc, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("JSON pointer %q must be empty or start with /", ptr)
+	}
+	value := doc
+	at := ""
+	for _, token := range strings.Split(ptr[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		next, err := pointerStep(value, token)
+		if err != nil {
+			return nil, fmt.Errorf("JSON pointer %q at %q: %w", ptr, at, err)
+		}
+		value = next
+		at += "/" + strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+	}
+	return value, nil
+}
+
+// pointerStep selects the member token of the object value, or the element
+// it indexes in the array value.
+func pointerStep(value json.RawMessage, token string) (json.RawMessage, error) {
+	t := bytes.TrimSpace(value)
+	switch {
+	case len(t) > 0 && t[0] == '{':
+		members, err := decodeMembers(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			if m.key == token {
+				return m.value, nil
+			}
+		}
+		return nil, fmt.Errorf("no member %q", token)
+	case len(t) > 0 && t[0] == '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(t, &elems); err != nil {
+			return nil, err
+		}
+		// RFC 6901 array indexes have no sign and no leading zeros.
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') {
+			return nil, fmt.Errorf("%q is not an array index", token)
+		}
+		if i >= len(elems) {
+			return nil, fmt.Errorf("index %d out of range, the array has %d element(s)", i, len(elems))
+		}
+		return elems[i], nil
+	}
+	return nil, fmt.Errorf("cannot look up %q in a scalar", token)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvalPointer(t *testing.T) {
+	doc := json.RawMessage(`{"a":[10,{"b":"c"}],"x/y":1,"m~n":2,"":3}`)
+	tests := []struct {
+		ptr  string
+		want string
+		err  string
+	}{
+		{"", string(doc), ""},
+		{"/a/0", "10", ""},
+		{"/a/1/b", `"c"`, ""},
+		{"/x~1y", "1", ""},
+		{"/m~0n", "2", ""},
+		{"/", "3", ""},
+		{"a", "", "must be empty or start with /"},
+		{"/missing", "", `at "": no member "missing"`},
+		{"/a/2", "", `at "/a": index 2 out of range, the array has 2 element(s)`},
+		{"/a/01", "", `"01" is not an array index`},
+		{"/a/-1", "", `"-1" is not an array index`},
+		{"/a/0/b", "", `at "/a/0": cannot look up "b" in a scalar`},
+		{"/x~1y/z", "", `at "/x~1y": cannot look up`},
+	}
+	for _, tt := range tests {
+		got, err := evalPointer(doc, tt.ptr)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("evalPointer(%q) = %s, %v, want an error containing %q", tt.ptr, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("evalPointer(%q) = %s, %v, want %s", tt.ptr, got, err, tt.want)
+		}
+	}
+}
+
+func TestPointer(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	sealed := testCid(t, "sealed")
+	recordList[1].CurrentSealTask.PreCommit2Out.Sealed = sealed
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+
+	want, err := json.Marshal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-format", "map-json", "-pointer", "/s-t01000-2/CurrentSealTask/PreCommit2Out/Sealed"},
+		{"-pointer", "/1/CurrentSealTask/PreCommit2Out/Sealed"},
+	} {
+		res := mustRun(t, append([]string{"-in", in, "-out", out}, args...)...)
+		if res.stdout != string(want)+"\n" {
+			t.Errorf("%q printed %q, want %s", args, res.stdout, want)
+		}
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("-pointer saved the state")
+	}
+
+	res := runTool(t, "-in", in, "-out", out, "-pointer", "/9")
+	if res.code == 0 || !strings.Contains(res.err.Error(), "index 9 out of range") {
+		t.Errorf("an unresolved pointer: exit status %d, %v", res.code, res.err)
+	}
+	for _, args := range [][]string{
+		{"-pointer", "", "-format", "jsonl"},
+		{"-pointer", "/0", "-flatten"},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", out}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// progressInterval is how often a progress line is logged.
+const progressInterval = time.Second
+
+// progress logs how far a pass over a known number of records has got,
+// with its throughput and an estimate of the time remaining, as asked with
+// -progress. A nil progress logs nothing.
+type progress struct {
+	label string
+	total int
+	done  int
+	start time.Time
+	last  time.Time
+}
+
+// newProgress returns a progress for a pass named label over total
+// records, or nil when enabled is false.
+func newProgress(enabled bool, label string, total int) *progress {
+	if !enabled {
+		return nil
+	}
+	return &progress{label: label, total: total}
+}
+
+// add counts n more records done, logging a line once progressInterval has
+// passed since the last. The clock starts with the first call.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start, p.last = now, now
+	}
+	p.done += n
+	if now.Sub(p.last) >= progressInterval {
+		p.last = now
+		log.Print(p.line(now))
+	}
+}
+
+// finish logs the final line of a pass that has logged any before.
+func (p *progress) finish() {
+	if p == nil || p.last.Equal(p.start) {
+		return
+	}
+	log.Printf("%s: %d records in %s", p.label, p.done, time.Since(p.start).Round(time.Millisecond))
+}
+
+// line formats the progress at now, such as
+// "encoding: 45% (135000/300000 records, 52000 records/s, ETA ~3s)".
+func (p *progress) line(now time.Time) string {
+	rate := float64(p.done) / now.Sub(p.start).Seconds()
+	if p.total <= 0 || p.done > p.total {
+		return fmt.Sprintf("%s: %d records, %.0f records/s", p.label, p.done, rate)
+	}
+	eta := "unknown"
+	if rate > 0 {
+		left := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		eta = "~" + left.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s: %d%% (%d/%d records, %.0f records/s, ETA %s)",
+		p.label, p.done*100/p.total, p.done, p.total, rate, eta)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	start := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		total, done int
+		elapsed     time.Duration
+		want        string
+	}{
+		{300000, 135000, 2 * time.Second, "encoding: 45% (135000/300000 records, 67500 records/s, ETA ~2s)"},
+		{300000, 1000, time.Second, "encoding: 0% (1000/300000 records, 1000 records/s, ETA ~4m59s)"},
+		{100, 100, time.Second, "encoding: 100% (100/100 records, 100 records/s, ETA ~0s)"},
+		{100, 0, time.Second, "encoding: 0% (0/100 records, 0 records/s, ETA unknown)"},
+		{0, 500, time.Second, "encoding: 500 records, 500 records/s"},
+		{100, 150, time.Second, "encoding: 150 records, 150 records/s"},
+	}
+	for _, tt := range tests {
+		p := &progress{label: "encoding", total: tt.total, done: tt.done, start: start}
+		if got := p.line(start.Add(tt.elapsed)); got != tt.want {
+			t.Errorf("%d of %d in %s: got %q, want %q", tt.done, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestProgressAdd(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	var off *progress
+	off.add(1)
+	off.finish()
+	if p := newProgress(false, "encoding", 10); p != nil {
+		t.Errorf("newProgress without -progress returned %+v", p)
+	}
+
+	// A large state: the first second has passed with a third of it
+	// encoded.
+	p := newProgress(true, "encoding", 300000)
+	p.add(1)
+	p.start = p.start.Add(-progressInterval)
+	p.last = p.start
+	p.add(99999)
+	p.done = p.total
+	p.finish()
+	lines := strings.Split(strings.TrimSuffix(logged.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %q, want a progress line and the final line", lines)
+	}
+	if !strings.Contains(lines[0], "encoding: 33% (100000/300000 records, ") || !strings.Contains(lines[0], "records/s, ETA ~2s)") {
+		t.Errorf("the progress line is %q, want a third done with an ETA of ~2s", lines[0])
+	}
+	if !strings.Contains(lines[1], "encoding: 300000 records in ") {
+		t.Errorf("the final line is %q", lines[1])
+	}
+
+	// A pass done within the first interval logs nothing.
+	logged.Reset()
+	p = newProgress(true, "encoding", 10)
+	p.add(10)
+	p.finish()
+	if got := logged.String(); got != "" {
+		t.Errorf("a short pass logged %q", got)
+	}
+}
+
+func TestProgressFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+	for _, args := range [][]string{{}, {"-explain"}} {
+		out := filepath.Join(dir, "out.json")
+		res := mustRun(t, append([]string{"-in", in, "-out", out, "-progress"}, args...)...)
+		if strings.Contains(res.log, "encoding:") {
+			t.Errorf("%q: a conversion within a second logged progress:\n%s", args, res.log)
+		}
+		if got := readJsonState(t, out); len(got) != 3 {
+			t.Errorf("%q: wrote %d records, want 3", args, len(got))
+		}
+	}
+}
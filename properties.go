@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// storeProperties writes the records of data to filename as a Java
+// properties file with one sector.<key>.<field>=<value> line per scalar
+// field, the key following -key-format. Nested fields are joined with dots
+// and list elements named by index; CIDs are written as strings, null as
+// an empty value, and the proof and randomness blobs are left out. There
+// is no way to load the file back.
+func storeProperties(ctx context.Context, data map[SectorID]SectorRecord, filename string, opts storeOptions) error {
+	recordList, records, err := encodeRecords(data, filename, opts)
+	if err != nil {
+		return err
+	}
+	skip := make(map[string]bool)
+	for _, b := range recordBlobs(SectorRecord{}) {
+		skip[b.name] = true
+	}
+	var buf bytes.Buffer
+	for i, r := range recordList {
+		prefix := "sector." + sectorKeyFormat.format(r.SectorId)
+		err := propertyLines(records[i], "", skip, func(path, value string) {
+			buf.WriteString(escapeProperty(prefix+"."+path, true))
+			buf.WriteByte('=')
+			buf.WriteString(escapeProperty(value, false))
+			buf.WriteByte('\n')
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", sectorName(r.SectorId), err)
+		}
+	}
+	if opts.atomic {
+		err = writeFileAtomic(ctx, filename, buf.Bytes(), 0600)
+	} else if err = checkInterrupted(ctx); err == nil {
+		err = ioutil.WriteFile(filename, buf.Bytes(), 0600)
+	}
+	if err != nil {
+		return err
+	}
+	summary.wrote(len(recordList), int64(buf.Len()))
+	return nil
+}
+
+// propertyLines calls emit with the path and value of every scalar in the
+// JSON value raw, which is at path, in document order. Paths in skip are
+// left out with everything below them.
+func propertyLines(raw json.RawMessage, path string, skip map[string]bool, emit func(path, value string)) error {
+	if skip[path] {
+		return nil
+	}
+	child := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case len(raw) == 0:
+		return nil
+	case raw[0] == '{':
+		// A CID is written as {"/": "..."}.
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err == nil && len(fields) == 1 {
+			var c string
+			if json.Unmarshal(fields["/"], &c) == nil {
+				emit(path, c)
+				return nil
+			}
+		}
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			if err := propertyLines(v, child(name.(string)), skip, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	case raw[0] == '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return err
+		}
+		for i, v := range elems {
+			if err := propertyLines(v, child(strconv.Itoa(i)), skip, emit); err != nil {
+				return err
+			}
+		}
+		return nil
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		emit(path, s)
+		return nil
+	case string(raw) == "null":
+		emit(path, "")
+		return nil
+	default:
+		emit(path, string(raw))
+		return nil
+	}
+}
+
+// escapeProperty escapes s as a properties key or value: backslash, the
+// separators = and :, the comment markers # and ! and the whitespace
+// escapes are backslash-escaped, spaces only where they would otherwise be
+// dropped, and everything outside printable ASCII becomes \uXXXX, so the
+// file is valid ISO 8859-1.
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\' || r == '=' || r == ':' || r == '#' || r == '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == ' ':
+			if key || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(' ')
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04X`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapeProperty(t *testing.T) {
+	tests := []struct {
+		s          string
+		key, value string
+	}{
+		{"plain", "plain", "plain"},
+		{`a=b:c\d`, `a\=b\:c\\d`, `a\=b\:c\\d`},
+		{"#x !y", `\#x\ \!y`, `\#x \!y`},
+		{" lead", `\ lead`, `\ lead`},
+		{"tab\tnl\ncr\rff\f", `tab\tnl\ncr\rff\f`, `tab\tnl\ncr\rff\f`},
+		{"\x01é€", `\u0001\u00E9\u20AC`, `\u0001\u00E9\u20AC`},
+		{"😀", `\uD83D\uDE00`, `\uD83D\uDE00`},
+	}
+	for _, tt := range tests {
+		if got := escapeProperty(tt.s, true); got != tt.key {
+			t.Errorf("escapeProperty(%q) as a key = %s, want %s", tt.s, got, tt.key)
+		}
+		if got := escapeProperty(tt.s, false); got != tt.value {
+			t.Errorf("escapeProperty(%q) as a value = %s, want %s", tt.s, got, tt.value)
+		}
+	}
+}
+
+func TestPropertiesOutput(t *testing.T) {
+	dir := tempDir(t)
+	r := testRecord(1000, 7, 2)
+	piece := testCid(t, "piece")
+	r.CurrentSealTask.Pieces[0].PieceCID = piece
+	r.CurrentSealTask.ErrMsg = "worker 10.0.0.1: out of space"
+	r.CurrentSealTask.Ticket = []byte("ticket")
+	r.CurrentSealTask.PreCommit1Out = []byte("p1")
+	r.C2WorkerAddress = "héte"
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{r, testRecord(1000, 8, 1)}))
+	out := filepath.Join(dir, "out.properties")
+
+	mustRun(t, "-in", in, "-out", out, "-format", "properties")
+	got := readTestFile(t, out)
+	for _, want := range []string{
+		"sector.s-t01000-7.SectorId.Miner=1000\n",
+		"sector.s-t01000-7.SectorWorkingPhase=2\n",
+		"sector.s-t01000-7.CurrentSealTask.Pieces.0.Size=2048\n",
+		"sector.s-t01000-7.CurrentSealTask.Pieces.0.PieceCID=" + piece.String() + "\n",
+		`sector.s-t01000-7.CurrentSealTask.ErrMsg=worker 10.0.0.1\: out of space` + "\n",
+		`sector.s-t01000-7.C2WorkerAddress=h\u00E9te` + "\n",
+		"sector.s-t01000-7.P2WorkerAddress=\n",
+		"sector.s-t01000-8.SectorId.Number=8\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the output lacks %q", want)
+		}
+	}
+	for _, blob := range []string{"Ticket", "Seed", "PreCommit1Out", "Commit1Out", "Commit2Out"} {
+		if strings.Contains(got, ".CurrentSealTask."+blob+"=") {
+			t.Errorf("the output holds the blob %s", blob)
+		}
+	}
+	if strings.Index(got, "sector.s-t01000-8.") < strings.LastIndex(got, "sector.s-t01000-7.") {
+		t.Error("the sectors are interleaved or out of order")
+	}
+
+	mustRun(t, "-in", in, "-out", out, "-format", "properties", "-key-format", "{miner}_{number}")
+	if got := readTestFile(t, out); !strings.HasPrefix(got, "sector.1000_7.SectorId.Miner=1000\n") {
+		t.Errorf("with -key-format the output starts %q", got[:strings.Index(got, "\n")+1])
+	}
+
+	for _, args := range [][]string{
+		{"-in", in, "-format", "properties"},
+		{"-in", in, "-out", out, "-format", "properties", "-versioned"},
+	} {
+		if res := runTool(t, args...); errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
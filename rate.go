@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// pacer spaces out records to at most a fixed number per second, as asked
+// with -rate. A nil pacer does not wait.
+type pacer struct {
+	interval time.Duration
+	next     time.Time
+}
+
+// newPacer returns a pacer for perSecond records a second, or nil for 0,
+// which means unlimited.
+func newPacer(perSecond int) *pacer {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &pacer{interval: time.Second / time.Duration(perSecond)}
+}
+
+// wait blocks until the next record may go out, or ctx is done. The first
+// record goes out at once.
+func (p *pacer) wait(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	now := time.Now()
+	if p.next.After(now) {
+		t := time.NewTimer(p.next.Sub(now))
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		now = p.next
+	}
+	p.next = now.Add(p.interval)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The paced tests send 6 records at 50 a second: the first goes out at
+// once and each of the other 5 20ms after the one before.
+const (
+	pacedRecords = 6
+	pacedRate    = "50"
+	pacedMin     = 100 * time.Millisecond
+	pacedMax     = 2 * time.Second
+)
+
+func TestPacer(t *testing.T) {
+	p := newPacer(0)
+	if p != nil {
+		t.Fatal("-rate 0 returned a pacer")
+	}
+	if err := p.wait(context.Background()); err != nil {
+		t.Errorf("a nil pacer: %v", err)
+	}
+
+	p = newPacer(50)
+	start := time.Now()
+	for i := 0; i < pacedRecords; i++ {
+		if err := p.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < pacedMin || elapsed > pacedMax {
+		t.Errorf("%d records at 50 a second took %v, want about %v", pacedRecords, elapsed, pacedMin)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.wait(ctx); err != context.Canceled {
+		t.Errorf("waiting with a cancelled context returned %v", err)
+	}
+}
+
+func TestRateJsonLines(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(pacedRecords)))
+	out := filepath.Join(dir, "out.jsonl")
+	start := time.Now()
+	mustRun(t, "-in", in, "-out", out, "-format", "jsonl", "-rate", pacedRate)
+	if elapsed := time.Since(start); elapsed < pacedMin || elapsed > pacedMax {
+		t.Errorf("the paced conversion took %v, want about %v", elapsed, pacedMin)
+	}
+	if got := lineNumbers(t, out); len(got) != pacedRecords {
+		t.Errorf("wrote the sectors %v, want %d", got, pacedRecords)
+	}
+}
+
+func TestRateServe(t *testing.T) {
+	in := writeGobState(t, tempDir(t), "state.gob", recordMap(testRecords(pacedRecords)))
+	srv, err := newServer([]string{in}, 1, mergeOptions{}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	start := time.Now()
+	var recordList []SectorRecord
+	getJson(t, "GET", ts.URL+"/sectors", http.StatusOK, &recordList)
+	if elapsed := time.Since(start); elapsed < pacedMin || elapsed > pacedMax {
+		t.Errorf("the paced /sectors took %v, want about %v", elapsed, pacedMin)
+	}
+	if len(recordList) != pacedRecords || recordList[pacedRecords-1].SectorId.Number != pacedRecords {
+		t.Errorf("got %d records, want all %d in sector order", len(recordList), pacedRecords)
+	}
+}
+
+func TestRateFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	out := filepath.Join(dir, "out")
+	for _, args := range [][]string{
+		{"-rate", "-1", "-format", "jsonl"},
+		{"-rate", "5"},
+		{"-rate", "5", "-format", "jsonl", "-split-size", "1000"},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", out}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
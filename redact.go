@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactConfig is the file given to -redact-config, in JSON or, with a
+// .yaml or .yml name, YAML:
+//
+//	rules:
+//	  - field: "*WorkerAddress"
+//	    action: mask
+//	  - field: "*Path"
+//	    action: keep-prefix-2
+//
+// A field is the dotted name of a string field of the record, such as
+// CurrentSealTask.CacheDirPath, or a pattern where * also matches dots.
+// The first rule a field matches decides what happens to it.
+type redactConfig struct {
+	Rules []redactRule `json:"rules" yaml:"rules"`
+}
+
+type redactRule struct {
+	Field  string `json:"field" yaml:"field"`
+	Action string `json:"action" yaml:"action"`
+	// keep is the N of keep-prefix-N.
+	keep int
+}
+
+// redaction is a parsed -redact-config: the rule applied to each string
+// field of the record it matches.
+type redaction struct {
+	fields []redactedField
+}
+
+type redactedField struct {
+	name  string
+	index []int
+	rule  redactRule
+}
+
+// readRedactConfig parses filename and resolves its rules against the
+// string fields of SectorRecord. A rule with an unknown action, or one
+// that matches no field, is an error.
+func readRedactConfig(filename string) (*redaction, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config redactConfig
+	if ext := strings.ToLower(filepath.Ext(filename)); ext == ".yaml" || ext == ".yml" {
+		err = yaml.Unmarshal(raw, &config)
+	} else {
+		err = json.Unmarshal(raw, &config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	fields := make([]redactedField, 0)
+	stringFields(reflect.TypeOf(SectorRecord{}), "", nil, func(name string, index []int) {
+		fields = append(fields, redactedField{name: name, index: index})
+	})
+	matched := make([]bool, len(config.Rules))
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if err := rule.parseAction(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", filename, i+1, err)
+		}
+	}
+	r := &redaction{}
+	for _, f := range fields {
+		for i, rule := range config.Rules {
+			if matchFieldPattern(rule.Field, f.name) {
+				matched[i] = true
+				f.rule = rule
+				r.fields = append(r.fields, f)
+				break
+			}
+		}
+	}
+	for i, ok := range matched {
+		if !ok {
+			return nil, fmt.Errorf("%s: rule %d: %q matches no string field of the record", filename, i+1, config.Rules[i].Field)
+		}
+	}
+	return r, nil
+}
+
+func (r *redactRule) parseAction() error {
+	switch {
+	case r.Action == "hash", r.Action == "mask", r.Action == "drop":
+		return nil
+	case strings.HasPrefix(r.Action, "keep-prefix-"):
+		n, err := strconv.Atoi(strings.TrimPrefix(r.Action, "keep-prefix-"))
+		if err == nil && n >= 0 {
+			r.keep = n
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %q, want hash, mask, drop or keep-prefix-N", r.Action)
+}
+
+// matchFieldPattern reports whether the dotted field name matches pattern,
+// in which * matches any run of characters, dots included, and everything
+// else only itself.
+func matchFieldPattern(pattern, name string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == name
+	}
+	if !strings.HasPrefix(name, pattern[:star]) {
+		return false
+	}
+	rest := pattern[star+1:]
+	for i := star; i <= len(name); i++ {
+		if matchFieldPattern(rest, name[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringFields calls emit with the dotted name and index path of every
+// string field of t, descending into structs.
+func stringFields(t reflect.Type, prefix string, index []int, emit func(name string, index []int)) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		name := prefix + jsonFieldName(f)
+		fi := append(append([]int(nil), index...), i)
+		switch f.Type.Kind() {
+		case reflect.String:
+			emit(name, fi)
+		case reflect.Struct:
+			stringFields(f.Type, name+".", fi, emit)
+		}
+	}
+}
+
+// apply rewrites the matched fields of every record in s and returns how
+// many values were changed.
+func (r *redaction) apply(s *State) int {
+	n := 0
+	for id := range s.state {
+		rec := s.state[id]
+		v := reflect.ValueOf(&rec).Elem()
+		changed := false
+		for _, f := range r.fields {
+			fv := v.FieldByIndex(f.index)
+			old := fv.String()
+			if redacted := f.rule.redact(old); redacted != old {
+				// The old value is kept out of the audit log, which
+				// would otherwise leak it.
+				s.audit.changed(id, f.name, "", redacted)
+				fv.SetString(redacted)
+				changed = true
+				n++
+			}
+		}
+		if changed {
+			s.updateSectorRecord(rec)
+		}
+	}
+	return n
+}
+
+// redact returns v with the rule applied. Empty values are kept as they
+// are.
+//   - hash writes the first 16 hex digits of the SHA-256 of v, the same for
+//     the same value, so records can still be compared.
+//   - mask writes ***, keeping the port of a host:port address.
+//   - drop writes the empty string.
+//   - keep-prefix-N keeps the first N elements of a slash-separated path
+//     and writes *** for the rest.
+func (r redactRule) redact(v string) string {
+	if v == "" {
+		return v
+	}
+	switch r.Action {
+	case "hash":
+		sum := sha256.Sum256([]byte(v))
+		return hex.EncodeToString(sum[:8])
+	case "mask":
+		if _, port, err := net.SplitHostPort(v); err == nil {
+			return net.JoinHostPort("***", port)
+		}
+		return "***"
+	case "drop":
+		return ""
+	}
+	parts := strings.Split(v, "/")
+	kept := 0
+	for i, p := range parts {
+		if p == "" {
+			continue
+		}
+		if kept == r.keep {
+			return strings.Join(append(parts[:i:i], "***"), "/")
+		}
+		kept++
+	}
+	return v
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchFieldPattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"P1WorkerAddress", "P1WorkerAddress", true},
+		{"P1WorkerAddress", "P2WorkerAddress", false},
+		{"*WorkerAddress", "C2WorkerAddress", true},
+		{"*Path", "CurrentSealTask.CacheDirPath", true},
+		{"*Path", "CurrentSealTask.TaskType", false},
+		{"CurrentFileTask.*", "CurrentFileTask.TargetCachePath", true},
+		{"CurrentFileTask.*", "CurrentSealTask.CacheDirPath", false},
+		{"P*Sealed*", "P2SealedSectorPath", true},
+		{"*", "ErrMsg", true},
+		{"Path", "CacheDirPath", false},
+	}
+	for _, tt := range tests {
+		if got := matchFieldPattern(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchFieldPattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedactRule(t *testing.T) {
+	tests := []struct {
+		action, v, want string
+	}{
+		{"mask", "10.0.0.1:3456", "***:3456"},
+		{"mask", "[::1]:3456", "***:3456"},
+		{"mask", "worker-7", "***"},
+		{"mask", "", ""},
+		{"drop", "/mnt/sealed/s-t01000-1", ""},
+		{"hash", "10.0.0.1:3456", "ccd7136f87d86605"},
+		{"keep-prefix-2", "/mnt/sealed/s-t01000-1", "/mnt/sealed/***"},
+		{"keep-prefix-2", "mnt/sealed/s-t01000-1", "mnt/sealed/***"},
+		{"keep-prefix-2", "/mnt/sealed", "/mnt/sealed"},
+		{"keep-prefix-0", "/mnt/sealed/s-t01000-1", "/***"},
+		{"keep-prefix-1", "/mnt//sealed/s-t01000-1", "/mnt//***"},
+	}
+	for _, tt := range tests {
+		r := redactRule{Action: tt.action}
+		if err := r.parseAction(); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.redact(tt.v); got != tt.want {
+			t.Errorf("%s of %q = %q, want %q", tt.action, tt.v, got, tt.want)
+		}
+	}
+	for _, action := range []string{"", "erase", "keep-prefix-", "keep-prefix--1", "keep-prefix-x"} {
+		r := redactRule{Action: action}
+		if err := r.parseAction(); err == nil {
+			t.Errorf("the action %q was accepted", action)
+		}
+	}
+}
+
+func TestReadRedactConfig(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		name, config string
+		fields       int
+		ok           bool
+	}{
+		{"rules.json", `{"rules":[{"field":"*WorkerAddress","action":"mask"}]}`, 4, true},
+		{"rules.yaml", "rules:\n  - field: \"*WorkerAddress\"\n    action: mask\n  - field: P1*\n    action: drop\n", 7, true},
+		{"rules.yml", "rules:\n  - field: \"*ErrMsg\"\n    action: hash\n", 2, true},
+		{"rules.json", `{"rules":[{"field":"*WorkerAddress","action":"erase"}]}`, 0, false},
+		{"rules.json", `{"rules":[{"field":"Miner","action":"mask"}]}`, 0, false},
+		{"rules.json", `{"rules":[{"field":"P1*","action":"mask"},{"field":"P1WorkerAddress","action":"drop"}]}`, 0, false},
+		{"rules.json", "rules: []", 0, false},
+	}
+	for _, tt := range tests {
+		r, err := readRedactConfig(writeTestFile(t, dir, tt.name, []byte(tt.config)))
+		if (err == nil) != tt.ok || tt.ok && len(r.fields) != tt.fields {
+			t.Errorf("%s %q: got %v, want %d field(s) redacted", tt.name, tt.config, err, tt.fields)
+		}
+	}
+	r, err := readRedactConfig(writeTestFile(t, dir, "rules.yaml", []byte("rules:\n  - field: P1WorkerAddress\n    action: drop\n  - field: \"*WorkerAddress\"\n    action: mask\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.fields {
+		if want := map[bool]string{true: "drop", false: "mask"}[f.name == "P1WorkerAddress"]; f.rule.Action != want {
+			t.Errorf("%s is redacted with %s, want %s by the first rule it matches", f.name, f.rule.Action, want)
+		}
+	}
+}
+
+func TestRedactConfigFlag(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(2)
+	recordList[0].P1CacheDirPath = "/mnt/cache/s-t01000-1"
+	recordList[0].CurrentSealTask.SealedSectorPath = "/mnt/sealed/s-t01000-1"
+	recordList[1].C2WorkerAddress = "gpu-3.example.com:2345"
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	config := writeTestFile(t, dir, "redact.yaml", []byte(`rules:
+  - field: "*WorkerAddress"
+    action: mask
+  - field: "*Path"
+    action: keep-prefix-2
+`))
+	out := filepath.Join(dir, "out.json")
+	audit := filepath.Join(dir, "audit.json")
+
+	res := mustRun(t, "-in", in, "-out", out, "-redact-config", config, "-audit", audit)
+	got := readJsonState(t, out)
+	if got[0].P1WorkerAddress != "***:3456" || got[1].C2WorkerAddress != "***:2345" {
+		t.Errorf("the worker addresses are %q and %q, want the hosts masked", got[0].P1WorkerAddress, got[1].C2WorkerAddress)
+	}
+	if got[0].P1CacheDirPath != "/mnt/cache/***" || got[0].CurrentSealTask.SealedSectorPath != "/mnt/sealed/***" {
+		t.Errorf("the paths are %q and %q, want their prefixes kept", got[0].P1CacheDirPath, got[0].CurrentSealTask.SealedSectorPath)
+	}
+	if got[1].P1CacheDirPath != "" {
+		t.Errorf("an empty path was redacted to %q", got[1].P1CacheDirPath)
+	}
+	if !strings.Contains(res.log, "redacted 5 value(s) following "+config) {
+		t.Errorf("the redaction was not reported:\n%s", res.log)
+	}
+	if logged := readTestFile(t, audit); strings.Contains(logged, "10.0.0.1") || strings.Contains(logged, "/mnt/cache/s-t01000-1") {
+		t.Errorf("the audit log leaks the redacted values:\n%s", logged)
+	}
+
+	bad := writeTestFile(t, dir, "bad.json", []byte(`{"rules":[{"field":"NoSuchField","action":"mask"}]}`))
+	if res := runTool(t, "-in", in, "-out", out, "-redact-config", bad); res.err == nil || !strings.Contains(res.err.Error(), "matches no string field") {
+		t.Errorf("a rule matching no field: got %v", res.err)
+	}
+}
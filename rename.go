@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldRenames maps record field names used by other schema versions, as
+// given to -rename-fields, to the SectorRecord field each holds. JSON
+// records are renamed before they are decoded.
+var fieldRenames map[string]string
+
+// parseFieldRenames parses comma-separated old=new pairs. Every new name
+// must be a top-level field of SectorRecord or of the current record
+// extension.
+func parseFieldRenames(list string) (map[string]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool)
+	rt := reflect.TypeOf(SectorRecord{})
+	for i := 0; i < rt.NumField(); i++ {
+		if f := rt.Field(i); f.PkgPath == "" && f.Tag.Get("json") != "-" {
+			known[jsonFieldName(f)] = true
+		}
+	}
+	if currentExtension != nil {
+		for n := range currentExtension.names {
+			known[n] = true
+		}
+	}
+	renames := make(map[string]string)
+	targets := make(map[string]string)
+	for _, pair := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, usageErrorf("-rename-fields entry %q is not old=new", pair)
+		}
+		old, new := parts[0], parts[1]
+		if !known[new] {
+			return nil, usageErrorf("-rename-fields %s: %s is not a record field", pair, new)
+		}
+		if known[old] {
+			return nil, usageErrorf("-rename-fields %s: %s is a current record field", pair, old)
+		}
+		if prev, ok := targets[new]; ok {
+			return nil, usageErrorf("-rename-fields renames both %s and %s to %s", prev, old, new)
+		}
+		renames[old] = new
+		targets[new] = old
+	}
+	return renames, nil
+}
+
+// renameFields returns the JSON object raw with its members renamed by
+// renames, keeping their order. A record holding both a field and the old
+// name of it is rejected rather than losing one of the values.
+func renameFields(raw json.RawMessage, renames map[string]string) (json.RawMessage, error) {
+	if t := bytes.TrimSpace(raw); len(t) == 0 || t[0] != '{' {
+		return raw, nil
+	}
+	members, err := decodeMembers(raw)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(members))
+	renamed := false
+	for _, m := range members {
+		present[m.key] = true
+		if _, ok := renames[m.key]; ok {
+			renamed = true
+		}
+	}
+	if !renamed {
+		return raw, nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range members {
+		key := m.key
+		if new, ok := renames[key]; ok {
+			if present[new] {
+				return nil, fmt.Errorf("record has both %s and its old name %s", new, key)
+			}
+			key = new
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(m.value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
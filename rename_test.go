@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFieldRenames(t *testing.T) {
+	tests := []struct {
+		list string
+		want map[string]string
+		err  string
+	}{
+		{"", nil, ""},
+		{"NVMESealedPath=P2SealedSectorPath", map[string]string{"NVMESealedPath": "P2SealedSectorPath"}, ""},
+		{"A=P1WorkerAddress, B=C1WorkerAddress", map[string]string{"A": "P1WorkerAddress", "B": "C1WorkerAddress"}, ""},
+		{"NVMESealedPath", nil, "is not old=new"},
+		{"=P2SealedSectorPath", nil, "is not old=new"},
+		{"Old=NoSuchField", nil, "NoSuchField is not a record field"},
+		{"P1WorkerAddress=P2SealedSectorPath", nil, "P1WorkerAddress is a current record field"},
+		{"A=P2SealedSectorPath,B=P2SealedSectorPath", nil, "renames both A and B to P2SealedSectorPath"},
+	}
+	for _, tt := range tests {
+		got, err := parseFieldRenames(tt.list)
+		if tt.err != "" {
+			if errorType(err) != "usage" || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseFieldRenames(%q) = %v, %v, want a usage error containing %q", tt.list, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFieldRenames(%q) = %v, %v, want %v", tt.list, got, err, tt.want)
+		}
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	renames := map[string]string{"NVMESealedPath": "P2SealedSectorPath"}
+	tests := []struct {
+		raw  string
+		want string
+		err  bool
+	}{
+		{`{"A":1,"NVMESealedPath":"/p2","B":2}`, `{"A":1,"P2SealedSectorPath":"/p2","B":2}`, false},
+		{`{"A":1}`, `{"A":1}`, false},
+		{`null`, `null`, false},
+		{`{"NVMESealedPath":"/old","P2SealedSectorPath":"/new"}`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := renameFields(json.RawMessage(tt.raw), renames)
+		if tt.err {
+			if err == nil {
+				t.Errorf("renameFields(%s) = %s, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("renameFields(%s) = %s, %v, want %s", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestRenameFieldsOnLoad(t *testing.T) {
+	dir := tempDir(t)
+	old := `[{"SectorId":{"Miner":1000,"Number":1},"NVMESealedPath":"/nvme/s-t01000-1"}]`
+	in := writeTestFile(t, dir, "old.json", []byte(old))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out)
+	if got := readJsonState(t, out); len(got) != 1 || got[0].P2SealedSectorPath != "" {
+		t.Fatalf("without -rename-fields got %+v, want the old field dropped", got)
+	}
+	for _, args := range [][]string{{}, {"-strict-json"}} {
+		mustRun(t, append([]string{"-in", in, "-out", out, "-rename-fields", "NVMESealedPath=P2SealedSectorPath"}, args...)...)
+		if got := readJsonState(t, out); len(got) != 1 || got[0].P2SealedSectorPath != "/nvme/s-t01000-1" {
+			t.Errorf("%q: got %+v, want the old field migrated", args, got)
+		}
+	}
+
+	both := `[{"SectorId":{"Miner":1000,"Number":2},"NVMESealedPath":"/old","P2SealedSectorPath":"/new"}]`
+	in = writeTestFile(t, dir, "both.json", []byte(both))
+	res := runTool(t, "-in", in, "-out", out, "-rename-fields", "NVMESealedPath=P2SealedSectorPath")
+	if res.code == 0 || !strings.Contains(res.err.Error(), "s-t01000-2: record has both P2SealedSectorPath and its old name NVMESealedPath") {
+		t.Errorf("a record with both names: exit status %d, %v", res.code, res.err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// The seal proof types of the Filecoin network this tool knows.
+const (
+	RegisteredSealProof_StackedDrg2KiBV1 RegisteredSealProof = iota
+	RegisteredSealProof_StackedDrg8MiBV1
+	RegisteredSealProof_StackedDrg512MiBV1
+	RegisteredSealProof_StackedDrg32GiBV1
+	RegisteredSealProof_StackedDrg64GiBV1
+
+	RegisteredSealProof_StackedDrg2KiBV1_1
+	RegisteredSealProof_StackedDrg8MiBV1_1
+	RegisteredSealProof_StackedDrg512MiBV1_1
+	RegisteredSealProof_StackedDrg32GiBV1_1
+	RegisteredSealProof_StackedDrg64GiBV1_1
+)
+
+var sealProofNames = map[RegisteredSealProof]string{
+	RegisteredSealProof_StackedDrg2KiBV1:     "StackedDrg2KiBV1",
+	RegisteredSealProof_StackedDrg8MiBV1:     "StackedDrg8MiBV1",
+	RegisteredSealProof_StackedDrg512MiBV1:   "StackedDrg512MiBV1",
+	RegisteredSealProof_StackedDrg32GiBV1:    "StackedDrg32GiBV1",
+	RegisteredSealProof_StackedDrg64GiBV1:    "StackedDrg64GiBV1",
+	RegisteredSealProof_StackedDrg2KiBV1_1:   "StackedDrg2KiBV1_1",
+	RegisteredSealProof_StackedDrg8MiBV1_1:   "StackedDrg8MiBV1_1",
+	RegisteredSealProof_StackedDrg512MiBV1_1: "StackedDrg512MiBV1_1",
+	RegisteredSealProof_StackedDrg32GiBV1_1:  "StackedDrg32GiBV1_1",
+	RegisteredSealProof_StackedDrg64GiBV1_1:  "StackedDrg64GiBV1_1",
+}
+
+// sealProofSectorSizes is the sector size, in bytes, each known seal proof
+// type seals.
+var sealProofSectorSizes = map[RegisteredSealProof]PaddedPieceSize{
+	RegisteredSealProof_StackedDrg2KiBV1:     2 << 10,
+	RegisteredSealProof_StackedDrg8MiBV1:     8 << 20,
+	RegisteredSealProof_StackedDrg512MiBV1:   512 << 20,
+	RegisteredSealProof_StackedDrg32GiBV1:    32 << 30,
+	RegisteredSealProof_StackedDrg64GiBV1:    64 << 30,
+	RegisteredSealProof_StackedDrg2KiBV1_1:   2 << 10,
+	RegisteredSealProof_StackedDrg8MiBV1_1:   8 << 20,
+	RegisteredSealProof_StackedDrg512MiBV1_1: 512 << 20,
+	RegisteredSealProof_StackedDrg32GiBV1_1:  32 << 30,
+	RegisteredSealProof_StackedDrg64GiBV1_1:  64 << 30,
+}
+
+// sectorSize returns the size of the sectors p seals, if p is known.
+func (p RegisteredSealProof) sectorSize() (PaddedPieceSize, bool) {
+	size, ok := sealProofSectorSizes[p]
+	return size, ok
+}
+
+// String returns the name of p, like StackedDrg32GiBV1_1, or
+// RegisteredSealProof(n) for a type this tool does not know.
+func (p RegisteredSealProof) String() string {
+	if name, ok := sealProofNames[p]; ok {
+		return name
+	}
+	return "RegisteredSealProof(" + strconv.FormatInt(int64(p), 10) + ")"
+}
+
+// MarshalJSON writes a known seal proof type by name and any other as its
+// number, which loads back as the same value.
+func (p RegisteredSealProof) MarshalJSON() ([]byte, error) {
+	if name, ok := sealProofNames[p]; ok {
+		return []byte(`"` + name + `"`), nil
+	}
+	return strconv.AppendInt(nil, int64(p), 10), nil
+}
+
+// UnmarshalJSON reads a seal proof type given by name or by number, so
+// the output of earlier versions still loads.
+func (p *RegisteredSealProof) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) == 0 || data[0] != '"' {
+		n, err := strconv.ParseInt(string(data), 10, 64)
+		if err != nil {
+			return fmt.Errorf("json: cannot unmarshal %s into a seal proof type", data)
+		}
+		*p = RegisteredSealProof(n)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for v, known := range sealProofNames {
+		if known == name {
+			*p = v
+			return nil
+		}
+	}
+	return fmt.Errorf("json: unknown seal proof type %q", name)
+}
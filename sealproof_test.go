@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSealProofJson(t *testing.T) {
+	tests := []struct {
+		proof RegisteredSealProof
+		name  string
+		size  PaddedPieceSize
+	}{
+		{RegisteredSealProof_StackedDrg2KiBV1, "StackedDrg2KiBV1", 2 << 10},
+		{RegisteredSealProof_StackedDrg8MiBV1, "StackedDrg8MiBV1", 8 << 20},
+		{RegisteredSealProof_StackedDrg512MiBV1, "StackedDrg512MiBV1", 512 << 20},
+		{RegisteredSealProof_StackedDrg32GiBV1, "StackedDrg32GiBV1", 32 << 30},
+		{RegisteredSealProof_StackedDrg64GiBV1, "StackedDrg64GiBV1", 64 << 30},
+		{RegisteredSealProof_StackedDrg2KiBV1_1, "StackedDrg2KiBV1_1", 2 << 10},
+		{RegisteredSealProof_StackedDrg8MiBV1_1, "StackedDrg8MiBV1_1", 8 << 20},
+		{RegisteredSealProof_StackedDrg512MiBV1_1, "StackedDrg512MiBV1_1", 512 << 20},
+		{RegisteredSealProof_StackedDrg32GiBV1_1, "StackedDrg32GiBV1_1", 32 << 30},
+		{RegisteredSealProof_StackedDrg64GiBV1_1, "StackedDrg64GiBV1_1", 64 << 30},
+	}
+	if len(tests) != len(sealProofNames) || len(sealProofSectorSizes) != len(sealProofNames) {
+		t.Fatalf("%d names and %d sector sizes, want both for the %d known types", len(sealProofNames), len(sealProofSectorSizes), len(tests))
+	}
+	for i, tt := range tests {
+		if int64(tt.proof) != int64(i) {
+			t.Errorf("%s = %d, want %d as numbered by the network", tt.name, int64(tt.proof), i)
+		}
+		if got := tt.proof.String(); got != tt.name {
+			t.Errorf("%d.String() = %q, want %q", i, got, tt.name)
+		}
+		if size, ok := tt.proof.sectorSize(); !ok || size != tt.size {
+			t.Errorf("%s seals %d bytes, want %d", tt.name, size, tt.size)
+		}
+		raw, err := json.Marshal(tt.proof)
+		if err != nil || string(raw) != `"`+tt.name+`"` {
+			t.Errorf("%s marshals as %s, %v", tt.name, raw, err)
+		}
+		// Both the name and the number load.
+		for _, data := range []string{string(raw), strconv.Itoa(i)} {
+			var got RegisteredSealProof
+			if err := json.Unmarshal([]byte(data), &got); err != nil || got != tt.proof {
+				t.Errorf("%s loads as %d, %v, want %s", data, int64(got), err, tt.name)
+			}
+		}
+	}
+}
+
+func TestUnknownSealProof(t *testing.T) {
+	p := RegisteredSealProof(42)
+	if got := p.String(); got != "RegisteredSealProof(42)" {
+		t.Errorf("String() = %q", got)
+	}
+	if _, ok := p.sectorSize(); ok {
+		t.Error("an unknown type has a sector size")
+	}
+	raw, err := json.Marshal(p)
+	if err != nil || string(raw) != "42" {
+		t.Errorf("marshals as %s, %v, want the number", raw, err)
+	}
+	var back RegisteredSealProof
+	if err := json.Unmarshal(raw, &back); err != nil || back != p {
+		t.Errorf("%s loads as %d, %v", raw, int64(back), err)
+	}
+	if raw, _ := json.Marshal(RegisteredSealProof(-1)); string(raw) != "-1" {
+		t.Errorf("-1 marshals as %s", raw)
+	}
+
+	tests := []struct {
+		data string
+		want RegisteredSealProof
+		ok   bool
+	}{
+		{`null`, 7, true},
+		{`-3`, -3, true},
+		{`"StackedDrg16GiBV1"`, 7, false},
+		{`"stackeddrg2kibv1"`, 7, false},
+		{`1.5`, 7, false},
+		{`true`, 7, false},
+		{`"StackedDrg2KiBV1`, 7, false},
+	}
+	for _, tt := range tests {
+		got := RegisteredSealProof(7)
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if (err == nil) != tt.ok || tt.ok && got != tt.want {
+			t.Errorf("%s loads as %d, %v", tt.data, int64(got), err)
+		}
+	}
+}
+
+func TestSealProofInRecords(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(2)
+	recordList[0].CurrentSealTask.SealProofType = RegisteredSealProof_StackedDrg32GiBV1_1
+	recordList[1].CurrentSealTask.SealProofType = 99
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "out.json")
+	mustRun(t, "-in", in, "-out", out)
+	got := readJsonState(t, out)
+	if got[0].CurrentSealTask.SealProofType != RegisteredSealProof_StackedDrg32GiBV1_1 || got[1].CurrentSealTask.SealProofType != 99 {
+		t.Errorf("loaded the seal proof types %s and %s back", got[0].CurrentSealTask.SealProofType, got[1].CurrentSealTask.SealProofType)
+	}
+	raw := readTestFile(t, out)
+	if !strings.Contains(raw, `"SealProofType":"StackedDrg32GiBV1_1"`) || !strings.Contains(raw, `"SealProofType":99`) {
+		t.Errorf("wrote\n%s\nwant the known type by name and the unknown one as a number", raw)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import "fmt"
+
+// Multicodecs of Filecoin sector commitments.
+const (
+	codecUnsealedCommitment = 0xf101
+	codecSealedCommitment   = 0xf102
+)
+
+// sectorCidMismatch is an inconsistency between the PreCommit2Out CIDs of
+// a record and the rest of it, and the record that fixes it.
+type sectorCidMismatch struct {
+	SectorID SectorID
+	Problem  string
+	fixed    SectorRecord
+}
+
+// sectorCidMismatches checks the PreCommit2Out CIDs of every record of
+// data against two facts:
+//   - A sector holding a single piece of the full sector size has the
+//     piece's CID as its unsealed CID (CommD). The piece CID is
+//     authoritative: it is the input the unsealed CID was computed from,
+//     so the fix copies it into PreCommit2Out.Unsealed.
+//   - Unsealed carries the unsealed and Sealed the sealed commitment
+//     codec. Two CIDs with each other's codec were swapped; the fix swaps
+//     them back.
+//
+// Records without PreCommit2Out CIDs have not reached precommit2 and are
+// skipped.
+func sectorCidMismatches(data map[SectorID]SectorRecord) []sectorCidMismatch {
+	found := make([]sectorCidMismatch, 0)
+	for _, r := range sortedRecords(data) {
+		out := r.CurrentSealTask.PreCommit2Out
+		if !out.Unsealed.Defined() && !out.Sealed.Defined() {
+			continue
+		}
+		if out.Unsealed.Defined() && out.Sealed.Defined() &&
+			out.Unsealed.Type() == codecSealedCommitment && out.Sealed.Type() == codecUnsealedCommitment {
+			fixed := r
+			fixed.CurrentSealTask.PreCommit2Out = SectorCids{Unsealed: out.Sealed, Sealed: out.Unsealed}
+			found = append(found, sectorCidMismatch{
+				SectorID: r.SectorId,
+				Problem:  "PreCommit2Out.Unsealed and Sealed are swapped",
+				fixed:    fixed,
+			})
+			r = fixed
+			out = fixed.CurrentSealTask.PreCommit2Out
+		}
+		pieces := r.CurrentSealTask.Pieces
+		size, ok := r.CurrentSealTask.SealProofType.sectorSize()
+		if !ok || len(pieces) != 1 || pieces[0].Size != size || !pieces[0].PieceCID.Defined() || !out.Unsealed.Defined() {
+			continue
+		}
+		if !out.Unsealed.Equals(pieces[0].PieceCID) {
+			fixed := r
+			fixed.CurrentSealTask.PreCommit2Out.Unsealed = pieces[0].PieceCID
+			found = append(found, sectorCidMismatch{
+				SectorID: r.SectorId,
+				Problem:  fmt.Sprintf("PreCommit2Out.Unsealed %s differs from the CID %s of the piece filling the sector", out.Unsealed, pieces[0].PieceCID),
+				fixed:    fixed,
+			})
+		}
+	}
+	return found
+}
+
+// checkSectorCids warns about every sectorCidMismatch of s and, with fix,
+// applies its fix. It returns the number of mismatches.
+func (s *State) checkSectorCids(fix bool) int {
+	found := sectorCidMismatches(s.state)
+	for _, m := range found {
+		if !fix {
+			warnf("%s: %s", sectorName(m.SectorID), m.Problem)
+			continue
+		}
+		infof("%s: fixing: %s", sectorName(m.SectorID), m.Problem)
+		old := s.state[m.SectorID].CurrentSealTask.PreCommit2Out
+		now := m.fixed.CurrentSealTask.PreCommit2Out
+		if !old.Unsealed.Equals(now.Unsealed) {
+			s.audit.changed(m.SectorID, "CurrentSealTask.PreCommit2Out.Unsealed", old.Unsealed.String(), now.Unsealed.String())
+		}
+		if !old.Sealed.Equals(now.Sealed) {
+			s.audit.changed(m.SectorID, "CurrentSealTask.PreCommit2Out.Sealed", old.Sealed.String(), now.Sealed.String())
+		}
+		s.updateSectorRecord(m.fixed)
+	}
+	return len(found)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// commitment returns a CID with the sector commitment codec.
+func commitment(t *testing.T, codec uint64, data string) cid.Cid {
+	t.Helper()
+	return cid.NewCidV1(codec, testCid(t, data).Hash())
+}
+
+// precommitted returns a 2 KiB sector holding pieces with the
+// PreCommit2Out CIDs out.
+func precommitted(number SectorNumber, out SectorCids, pieces ...PieceInfo) SectorRecord {
+	r := testRecord(1000, number, 2)
+	r.CurrentSealTask.SealProofType = RegisteredSealProof_StackedDrg2KiBV1_1
+	r.CurrentSealTask.Pieces = pieces
+	r.CurrentSealTask.PreCommit2Out = out
+	return r
+}
+
+func TestSectorCidMismatches(t *testing.T) {
+	commD := commitment(t, codecUnsealedCommitment, "commd")
+	other := commitment(t, codecUnsealedCommitment, "other")
+	commR := commitment(t, codecSealedCommitment, "commr")
+	full := PieceInfo{Size: 2048, PieceCID: commD}
+	half := PieceInfo{Size: 1024, PieceCID: commD}
+	tests := []struct {
+		name  string
+		r     SectorRecord
+		fixed SectorCids
+		want  []string
+	}{
+		{"consistent", precommitted(1, SectorCids{Unsealed: commD, Sealed: commR}, full), SectorCids{}, nil},
+		{"before precommit2", precommitted(1, SectorCids{}, full), SectorCids{}, nil},
+		{"swapped", precommitted(1, SectorCids{Unsealed: commR, Sealed: commD}, full),
+			SectorCids{Unsealed: commD, Sealed: commR},
+			[]string{"PreCommit2Out.Unsealed and Sealed are swapped"}},
+		{"unsealed differs from the piece", precommitted(1, SectorCids{Unsealed: other, Sealed: commR}, full),
+			SectorCids{Unsealed: commD, Sealed: commR},
+			[]string{"PreCommit2Out.Unsealed " + other.String() + " differs from the CID " + commD.String() + " of the piece filling the sector"}},
+		{"swapped and differing", precommitted(1, SectorCids{Unsealed: commR, Sealed: other}, full),
+			SectorCids{Unsealed: commD, Sealed: commR},
+			[]string{"PreCommit2Out.Unsealed and Sealed are swapped", "PreCommit2Out.Unsealed " + other.String() + " differs"}},
+		{"piece not filling the sector", precommitted(1, SectorCids{Unsealed: other, Sealed: commR}, half), SectorCids{}, nil},
+		{"several pieces", precommitted(1, SectorCids{Unsealed: other, Sealed: commR}, half, half), SectorCids{}, nil},
+	}
+	for _, tt := range tests {
+		found := sectorCidMismatches(recordMap([]SectorRecord{tt.r}))
+		if len(found) != len(tt.want) {
+			t.Errorf("%s: got %+v, want %d mismatch(es)", tt.name, found, len(tt.want))
+			continue
+		}
+		for i, m := range found {
+			if m.SectorID != tt.r.SectorId || !strings.HasPrefix(m.Problem, tt.want[i]) {
+				t.Errorf("%s: mismatch %d is %q, want %q", tt.name, i, m.Problem, tt.want[i])
+			}
+		}
+		if n := len(found); n > 0 && !reflect.DeepEqual(found[n-1].fixed.CurrentSealTask.PreCommit2Out, tt.fixed) {
+			t.Errorf("%s: fixed to %+v, want %+v", tt.name, found[n-1].fixed.CurrentSealTask.PreCommit2Out, tt.fixed)
+		}
+	}
+}
+
+func TestCheckSectorCids(t *testing.T) {
+	dir := tempDir(t)
+	commD := commitment(t, codecUnsealedCommitment, "commd")
+	commR := commitment(t, codecSealedCommitment, "commr")
+	swapped := precommitted(2, SectorCids{Unsealed: commR, Sealed: commD}, PieceInfo{Size: 2048, PieceCID: commD})
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{testRecord(1000, 1, 1), swapped}))
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", in, "-out", out, "-check-sector-cids")
+	if !strings.Contains(res.log, "s-t01000-2: PreCommit2Out.Unsealed and Sealed are swapped") || warnings != 1 {
+		t.Errorf("got %d warning(s), want one for the swapped CIDs:\n%s", warnings, res.log)
+	}
+	if got := loadTestState(t, out)[swapped.SectorId].CurrentSealTask.PreCommit2Out; !got.Unsealed.Equals(commR) {
+		t.Errorf("without -fix the CIDs were changed to %+v", got)
+	}
+
+	audit := filepath.Join(dir, "audit.jsonl")
+	res = mustRun(t, "-in", in, "-out", out, "-check-sector-cids", "-fix", "-audit", audit)
+	if got := loadTestState(t, out)[swapped.SectorId].CurrentSealTask.PreCommit2Out; !got.Unsealed.Equals(commD) || !got.Sealed.Equals(commR) {
+		t.Errorf("-fix wrote %+v, want the CIDs swapped back", got)
+	}
+	if warnings != 0 || !strings.Contains(res.log, "s-t01000-2: fixing: PreCommit2Out.Unsealed and Sealed are swapped") {
+		t.Errorf("-fix logged %d warning(s):\n%s", warnings, res.log)
+	}
+	if got := readTestFile(t, audit); strings.Count(got, "CurrentSealTask.PreCommit2Out.") != 2 {
+		t.Errorf("the audit log does not record both CID changes:\n%s", got)
+	}
+
+	if res := runTool(t, "-in", in, "-out", out, "-fix"); errorType(res.err) != "usage" {
+		t.Errorf("-fix without -check-sector-cids: got %v, want a usage error", res.err)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type server struct {
+	filePaths   []string
+	concurrency int
+	merge       mergeOptions
+	metrics     *serverMetrics
+	// rate, when set, streams /sectors at most this many records a
+	// second.
+	rate int
+
+	mu    sync.RWMutex
+	state *State
+}
+
+func newServer(filePaths []string, concurrency int, merge mergeOptions, rate int) (*server, error) {
+	srv := &server{filePaths: filePaths, concurrency: concurrency, merge: merge, metrics: newServerMetrics(), rate: rate}
+	if err := srv.reload(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// serve loads filePaths once and exposes the merged state read-only over
+// HTTP on addr.
+func serve(addr string, filePaths []string, concurrency int, merge mergeOptions, rate int) error {
+	srv, err := newServer(filePaths, concurrency, merge, rate)
+	if err != nil {
+		return err
+	}
+	infof("serving %s on %s", strings.Join(filePaths, ","), addr)
+	return http.ListenAndServe(addr, srv.handler())
+}
+
+func (srv *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sectors", srv.metrics.instrument("sectors", srv.handleSectors))
+	mux.HandleFunc("/sectors/", srv.metrics.instrument("sector", srv.handleSector))
+	mux.HandleFunc("/stats", srv.metrics.instrument("stats", srv.handleStats))
+	mux.HandleFunc("/reload", srv.metrics.instrument("reload", srv.handleReload))
+	mux.Handle("/metrics", srv.metrics.handler())
+	return mux
+}
+
+func (srv *server) reload() error {
+	data, err := loadStates(context.Background(), srv.filePaths, srv.concurrency, srv.merge)
+	if err != nil {
+		return err
+	}
+	s := &State{state: data}
+	srv.metrics.loaded(data)
+	srv.mu.Lock()
+	srv.state = s
+	srv.mu.Unlock()
+	return nil
+}
+
+func (srv *server) handleSectors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	srv.mu.RLock()
+	recordList := sortedRecords(srv.state.state)
+	srv.mu.RUnlock()
+	if srv.rate > 0 {
+		writeJSONPaced(r.Context(), w, recordList, newPacer(srv.rate))
+		return
+	}
+	writeJSON(w, recordList)
+}
+
+func (srv *server) handleSector(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/sectors/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	miner, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid miner: "+parts[0], http.StatusBadRequest)
+		return
+	}
+	number, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid sector number: "+parts[1], http.StatusBadRequest)
+		return
+	}
+	srv.mu.RLock()
+	record, ok := srv.state.state[SectorID{Miner: ActorID(miner), Number: SectorNumber(number)}]
+	srv.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, record)
+}
+
+func (srv *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	srv.mu.RLock()
+	st := computeStats(srv.state.state)
+	srv.mu.RUnlock()
+	writeJSON(w, st)
+}
+
+func (srv *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := srv.reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	srv.metrics.reloads.Inc()
+	srv.mu.RLock()
+	n := len(srv.state.state)
+	srv.mu.RUnlock()
+	writeJSON(w, map[string]int{"total": n})
+}
+
+func sectorIDLess(a, b SectorID) bool {
+	if a.Miner != b.Miner {
+		return a.Miner < b.Miner
+	}
+	return a.Number < b.Number
+}
+
+// writeJSONPaced streams recordList as a JSON array, flushing each record
+// as soon as p lets it go out. It stops when the client goes away.
+func writeJSONPaced(ctx context.Context, w http.ResponseWriter, recordList []SectorRecord, p *pacer) {
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(http.Flusher)
+	sep := "["
+	for _, r := range recordList {
+		if err := p.wait(ctx); err != nil {
+			return
+		}
+		raw, err := json.Marshal(r)
+		if err != nil {
+			errorf("%v", err)
+			return
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return
+		}
+		if _, err := w.Write(raw); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		sep = ","
+	}
+	if sep == "[" {
+		io.WriteString(w, sep)
+	}
+	io.WriteString(w, "]\n")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		errorf("%v", err)
+	}
+}
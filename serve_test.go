@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, recordList []SectorRecord) (*httptest.Server, string) {
+	t.Helper()
+	in := writeGobState(t, tempDir(t), "state.gob", recordMap(recordList))
+	srv, err := newServer([]string{in}, 1, mergeOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.handler())
+	t.Cleanup(ts.Close)
+	return ts, in
+}
+
+// getJson fetches url, checks the status code and decodes a 200 body
+// into v.
+func getJson(t *testing.T, method, url string, wantStatus int, v interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != wantStatus {
+		t.Fatalf("%s %s: status %d, want %d", method, url, resp.StatusCode, wantStatus)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+}
+
+func TestServeSectors(t *testing.T) {
+	ts, _ := newTestServer(t, testRecords(3))
+	var recordList []SectorRecord
+	getJson(t, "GET", ts.URL+"/sectors", http.StatusOK, &recordList)
+	if len(recordList) != 3 {
+		t.Fatalf("got %d records, want 3", len(recordList))
+	}
+	for i, r := range recordList {
+		if r.SectorId.Number != SectorNumber(i+1) {
+			t.Errorf("record %d is sector %d, want them in sector order", i, r.SectorId.Number)
+		}
+	}
+	getJson(t, "POST", ts.URL+"/sectors", http.StatusMethodNotAllowed, nil)
+}
+
+func TestServeSector(t *testing.T) {
+	ts, _ := newTestServer(t, testRecords(3))
+	var r SectorRecord
+	getJson(t, "GET", ts.URL+"/sectors/1000/2", http.StatusOK, &r)
+	if r.SectorId != (SectorID{Miner: 1000, Number: 2}) || r.SectorWorkingPhase != 2 {
+		t.Errorf("got %+v, want sector 1000/2 at phase 2", r.SectorId)
+	}
+	getJson(t, "GET", ts.URL+"/sectors/1000/9", http.StatusNotFound, nil)
+	getJson(t, "GET", ts.URL+"/sectors/x/1", http.StatusBadRequest, nil)
+	getJson(t, "GET", ts.URL+"/sectors/1000/x", http.StatusBadRequest, nil)
+	getJson(t, "GET", ts.URL+"/sectors/1000", http.StatusNotFound, nil)
+}
+
+func TestServeStats(t *testing.T) {
+	ts, _ := newTestServer(t, testRecords(3))
+	var st struct {
+		Total   int                 `json:"total"`
+		ByPhase map[string]int      `json:"byPhase"`
+		ByTask  map[TaskType]int    `json:"byTaskType"`
+		Workers map[string]struct{} `json:"byWorker"`
+	}
+	getJson(t, "GET", ts.URL+"/stats", http.StatusOK, &st)
+	if st.Total != 3 || st.ByPhase["2"] != 1 || st.ByTask["seal/v0/precommit/1"] != 3 {
+		t.Errorf("got stats %+v", st)
+	}
+	if _, ok := st.Workers["10.0.0.1:3456"]; !ok {
+		t.Errorf("byWorker %v lacks 10.0.0.1:3456", st.Workers)
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	ts, in := newTestServer(t, testRecords(2))
+	writeGobState(t, filepath.Dir(in), "state.gob", recordMap(testRecords(4)))
+	getJson(t, "GET", ts.URL+"/reload", http.StatusMethodNotAllowed, nil)
+	var got map[string]int
+	getJson(t, "POST", ts.URL+"/reload", http.StatusOK, &got)
+	if got["total"] != 4 {
+		t.Errorf("reload reports %v, want a total of 4", got)
+	}
+	var recordList []SectorRecord
+	getJson(t, "GET", ts.URL+"/sectors", http.StatusOK, &recordList)
+	if len(recordList) != 4 {
+		t.Errorf("got %d records after the reload, want 4", len(recordList))
+	}
+}
+
+func scrapeMetrics(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/metrics: status %d", resp.StatusCode)
+	}
+	return string(body)
+}
+
+func TestServeMetrics(t *testing.T) {
+	recordList := testRecords(3)
+	recordList[2].SectorWorkingPhase = 2
+	ts, in := newTestServer(t, recordList)
+	getJson(t, "GET", ts.URL+"/sectors", http.StatusOK, nil)
+	getJson(t, "GET", ts.URL+"/sectors/1000/1", http.StatusOK, nil)
+	getJson(t, "GET", ts.URL+"/sectors", http.StatusOK, nil)
+
+	metrics := scrapeMetrics(t, ts.URL)
+	for _, want := range []string{
+		`state_http_requests_total{handler="sectors"} 2`,
+		`state_http_requests_total{handler="sector"} 1`,
+		`state_sectors_loaded_total 3`,
+		`state_reloads_total 0`,
+		`state_sectors{phase="1"} 1`,
+		`state_sectors{phase="2"} 2`,
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("/metrics lacks %s:\n%s", want, metrics)
+		}
+	}
+
+	writeGobState(t, filepath.Dir(in), "state.gob", recordMap(testRecords(1)))
+	getJson(t, "POST", ts.URL+"/reload", http.StatusOK, nil)
+	metrics = scrapeMetrics(t, ts.URL)
+	for _, want := range []string{
+		`state_sectors_loaded_total 4`,
+		`state_reloads_total 1`,
+		`state_sectors{phase="1"} 1`,
+	} {
+		if !strings.Contains(metrics, want+"\n") {
+			t.Errorf("after the reload, /metrics lacks %s:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `state_sectors{phase="2"}`) {
+		t.Errorf("the gauge still counts a phase the reloaded state lacks:\n%s", metrics)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// remoteInputs holds the contents of the sftp:// inputs, downloaded by
+// fetchRemoteInputs before anything reads them.
+var remoteInputs = make(map[string][]byte)
+
+// sshOptions configures the SSH connections of sftp:// inputs.
+type sshOptions struct {
+	// keyFile is a private key to authenticate with, tried after the keys
+	// of a running SSH agent.
+	keyFile    string
+	knownHosts string
+}
+
+func isRemoteInput(p string) bool {
+	return strings.HasPrefix(p, "sftp://")
+}
+
+// fetchRemoteInputs downloads every sftp:// input into remoteInputs, one
+// connection per host.
+func fetchRemoteInputs(inputs []string, opts sshOptions) error {
+	clients := make(map[string]*sftp.Client)
+	conns := make([]*ssh.Client, 0)
+	defer func() {
+		// Closing the SSH connection ends its SFTP session too, without
+		// waiting for the server to acknowledge.
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for _, in := range inputs {
+		if !isRemoteInput(in) {
+			continue
+		}
+		addr, user, path, err := parseSftpURL(in)
+		if err != nil {
+			return err
+		}
+		key := user + "@" + addr
+		c, ok := clients[key]
+		if !ok {
+			var conn *ssh.Client
+			if conn, c, err = dialSftp(addr, user, opts); err != nil {
+				return fmt.Errorf("%s: %w", in, err)
+			}
+			conns = append(conns, conn)
+			clients[key] = c
+		}
+		raw, err := readRemoteFile(c, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", in, err)
+		}
+		infof("%s: downloaded %d bytes", in, len(raw))
+		remoteInputs[in] = raw
+	}
+	return nil
+}
+
+// parseSftpURL splits sftp://user@host:/path, or sftp://user@host:port/path,
+// into the address to dial, the user and the remote path. The user
+// defaults to the local one and the port to 22.
+func parseSftpURL(s string) (addr, user, path string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Hostname() == "" || u.Path == "" {
+		return "", "", "", fmt.Errorf("%s: want sftp://user@host:/path", s)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	user = u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	return net.JoinHostPort(u.Hostname(), port), user, u.Path, nil
+}
+
+func dialSftp(addr, user string, opts sshOptions) (*ssh.Client, *sftp.Client, error) {
+	auth, err := sshAuthMethods(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	hostKey, err := knownHostsCallback(opts.knownHosts)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, c, nil
+}
+
+// sshAuthMethods offers the keys of the agent at SSH_AUTH_SOCK, if one
+// runs, and then opts.keyFile, if set.
+func sshAuthMethods(opts sshOptions) ([]ssh.AuthMethod, error) {
+	auth := make([]ssh.AuthMethod, 0, 2)
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		} else {
+			debugf("ssh agent: %v", err)
+		}
+	}
+	if opts.keyFile != "" {
+		p, err := homedir.Expand(opts.keyFile)
+		if err != nil {
+			return nil, err
+		}
+		pem, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", opts.keyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no SSH credentials: start an ssh agent or pass -ssh-key")
+	}
+	return auth, nil
+}
+
+func knownHostsCallback(file string) (ssh.HostKeyCallback, error) {
+	p, err := homedir.Expand(file)
+	if err != nil {
+		return nil, err
+	}
+	return knownhosts.New(p)
+}
+
+func readRemoteFile(c *sftp.Client, path string) ([]byte, error) {
+	f, err := c.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseSftpURL(t *testing.T) {
+	setenv(t, "USER", "local")
+	tests := []struct {
+		url              string
+		addr, user, path string
+		ok               bool
+	}{
+		{"sftp://root@worker1:/home/root/.lotus_scheduler/state_data", "worker1:22", "root", "/home/root/.lotus_scheduler/state_data", true},
+		{"sftp://root@worker1:2222/state_data", "worker1:2222", "root", "/state_data", true},
+		{"sftp://worker1:/state_data", "worker1:22", "local", "/state_data", true},
+		{"sftp://root@[::1]:2222/state_data", "[::1]:2222", "root", "/state_data", true},
+		{"sftp://root@worker1", "", "", "", false},
+		{"sftp:///state_data", "", "", "", false},
+	}
+	for _, tt := range tests {
+		addr, user, path, err := parseSftpURL(tt.url)
+		if (err == nil) != tt.ok || addr != tt.addr || user != tt.user || path != tt.path {
+			t.Errorf("parseSftpURL(%q) = %q, %q, %q, %v", tt.url, addr, user, path, err)
+		}
+	}
+}
+
+// newTestKey returns a new RSA key and its PEM encoding.
+func newTestKey(t *testing.T) (ssh.Signer, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// serveSftp runs an SSH server on a local port, accepting the user tester
+// with clientKey, that serves the local file system over SFTP. It returns
+// the address the server listens on.
+func serveSftp(t *testing.T, hostKey ssh.Signer, clientKey ssh.PublicKey) string {
+	t.Helper()
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "tester" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", meta.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSftpConn(conn, config)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveSftpConn(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				// The payload of a subsystem request is the
+				// length-prefixed subsystem name.
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						defer ch.Close()
+						if server, err := sftp.NewServer(ch); err == nil {
+							server.Serve()
+						}
+					}()
+				}
+			}
+		}()
+	}
+}
+
+func TestSftpInput(t *testing.T) {
+	setenv(t, "SSH_AUTH_SOCK", "")
+	dir := tempDir(t)
+	hostKey, _ := newTestKey(t)
+	clientKey, clientPem := newTestKey(t)
+	addr := serveSftp(t, hostKey, clientKey.PublicKey())
+	keyFile := writeTestFile(t, dir, "id_rsa", clientPem)
+	knownHosts := writeTestFile(t, dir, "known_hosts", []byte(knownhosts.Line([]string{addr}, hostKey.PublicKey())+"\n"))
+	strangers := writeTestFile(t, dir, "empty_known_hosts", nil)
+
+	state := writeGobState(t, dir, "state_data", recordMap(testRecords(3)))
+	local := writeGobState(t, dir, "local.gob", recordMap([]SectorRecord{testRecord(1000, 4, 1)}))
+	remote := "sftp://tester@" + addr + filepath.ToSlash(state)
+	out := filepath.Join(dir, "out.json")
+
+	res := mustRun(t, "-in", remote, "-in", local, "-out", out, "-ssh-key", keyFile, "-ssh-known-hosts", knownHosts)
+	if got := sectorNumbers(readJsonState(t, out)); got != "1 2 3 4" {
+		t.Errorf("converted the sectors %s, want the remote 1 to 3 merged with the local 4", got)
+	}
+	if !strings.Contains(res.log, remote+": downloaded") {
+		t.Errorf("the download was not logged:\n%s", res.log)
+	}
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-in", remote, "-out", out, "-ssh-key", keyFile, "-ssh-known-hosts", strangers}, "knownhosts: key is unknown"},
+		{[]string{"-in", remote, "-out", out, "-ssh-known-hosts", knownHosts}, "no SSH credentials"},
+		{[]string{"-in", remote + ".missing", "-out", out, "-ssh-key", keyFile, "-ssh-known-hosts", knownHosts}, "not exist"},
+	}
+	for _, tt := range tests {
+		res := runTool(t, tt.args...)
+		if res.code == 0 || res.err == nil || !strings.Contains(res.err.Error(), tt.err) {
+			t.Errorf("%q: exit status %d, %v, want an error containing %q", tt.args, res.code, res.err, tt.err)
+		}
+	}
+	if res := runTool(t, "-in", remote); errorType(res.err) != "usage" {
+		t.Errorf("a remote input without -out: got %v, want a usage error", res.err)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarFields are the proof blobs -externalize-blobs moves out of the
+// JSON, by their path in a record.
+var sidecarFields = [][]string{
+	{"CurrentSealTask", "PreCommit1Out"},
+	{"CurrentSealTask", "Commit2Out"},
+}
+
+// blobRef replaces an externalized blob in the JSON. Path is relative to
+// the directory of the JSON file.
+type blobRef struct {
+	Path string `json:"blobRef"`
+}
+
+// externalizeBlobs writes the non-empty sidecar fields of the record raw
+// to <blobDir>/<key>-<field>.bin and replaces them by a blobRef
+// relative to outDir.
+func externalizeBlobs(raw json.RawMessage, id SectorID, blobDir string, outDir string) (json.RawMessage, error) {
+	if err := os.MkdirAll(blobDir, 0700); err != nil {
+		return nil, err
+	}
+	for _, path := range sidecarFields {
+		field := path[len(path)-1]
+		var err error
+		raw, err = replaceField(raw, path, func(value json.RawMessage) (json.RawMessage, error) {
+			b, err := unmarshalBlob(value)
+			if err != nil || len(b) == 0 {
+				return value, err
+			}
+			file := filepath.Join(blobDir, fmt.Sprintf("%s-%s.bin", sectorKeyFormat.format(id), field))
+			rel, err := filepath.Rel(outDir, file)
+			if err != nil {
+				return nil, err
+			}
+			if escapesDir(rel) {
+				return nil, fmt.Errorf("-externalize-blobs %s is outside %s, the directory of the output, where the references are loaded from", blobDir, outDir)
+			}
+			if err := ioutil.WriteFile(file, b, 0600); err != nil {
+				return nil, err
+			}
+			return json.Marshal(blobRef{Path: filepath.ToSlash(rel)})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// rehydrateBlobs replaces every blobRef in the record raw by the contents
+// of the sidecar file it names, resolved against dir. The paths come from
+// the input, so a reference out of dir is rejected rather than read.
+func rehydrateBlobs(raw json.RawMessage, dir string) (json.RawMessage, error) {
+	if !bytes.Contains(raw, []byte(`"blobRef"`)) {
+		return raw, nil
+	}
+	for _, path := range sidecarFields {
+		var err error
+		raw, err = replaceField(raw, path, func(value json.RawMessage) (json.RawMessage, error) {
+			if t := bytes.TrimSpace(value); len(t) == 0 || t[0] != '{' {
+				return value, nil
+			}
+			var ref blobRef
+			if err := json.Unmarshal(value, &ref); err != nil {
+				return nil, err
+			}
+			if strings.HasPrefix(ref.Path, "/") || escapesDir(filepath.FromSlash(ref.Path)) {
+				return nil, &jsonRecordError{err: fmt.Errorf("blob reference %q is outside %s", ref.Path, dir)}
+			}
+			b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(ref.Path)))
+			if err != nil {
+				return nil, err
+			}
+			return marshalBlob(b)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// escapesDir reports whether the relative path rel, joined to a directory,
+// names a file outside it.
+func escapesDir(rel string) bool {
+	rel = filepath.Clean(rel)
+	return filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
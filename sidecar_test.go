@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExternalizeBlobsRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	proof := bytes.Repeat([]byte("proof"), 1000)
+	withBlobs := testRecord(1000, 1, 1)
+	withBlobs.CurrentSealTask.PreCommit1Out = []byte("pc1out")
+	withBlobs.CurrentSealTask.Commit2Out = proof
+	withBlobs.CurrentSealTask.Ticket = []byte("ticket")
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{withBlobs, testRecord(1000, 2, 2)}))
+	out := filepath.Join(dir, "out", "state.json")
+	if err := os.Mkdir(filepath.Dir(out), 0700); err != nil {
+		t.Fatal(err)
+	}
+	blobs := filepath.Join(dir, "out", "blobs")
+
+	mustRun(t, "-in", in, "-out", out, "-externalize-blobs", blobs)
+	var raw []struct{ CurrentSealTask map[string]json.RawMessage }
+	if err := json.Unmarshal([]byte(readTestFile(t, out)), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(raw[0].CurrentSealTask["Commit2Out"]); got != `{"blobRef":"blobs/s-t01000-1-Commit2Out.bin"}` {
+		t.Errorf("Commit2Out = %s, want a reference relative to the JSON file", got)
+	}
+	if got := string(raw[0].CurrentSealTask["Ticket"]); got != `"dGlja2V0"` {
+		t.Errorf("Ticket = %s, want it left inline", got)
+	}
+	if got := readTestFile(t, filepath.Join(blobs, "s-t01000-1-Commit2Out.bin")); got != string(proof) {
+		t.Errorf("the Commit2Out sidecar holds %d bytes, want the %d of the proof", len(got), len(proof))
+	}
+	files, err := ioutil.ReadDir(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("wrote %d sidecar files, want 2: sector 2 has no blobs", len(files))
+	}
+
+	// Moving the output and its sidecars together keeps them loadable.
+	moved := filepath.Join(dir, "moved")
+	if err := os.Rename(filepath.Dir(out), moved); err != nil {
+		t.Fatal(err)
+	}
+	got := readJsonState(t, filepath.Join(moved, "state.json"))
+	if !reflect.DeepEqual(got[0].CurrentSealTask, withBlobs.CurrentSealTask) {
+		t.Errorf("loaded the seal task %+v, want %+v", got[0].CurrentSealTask, withBlobs.CurrentSealTask)
+	}
+}
+
+func TestRehydrateMissingSidecar(t *testing.T) {
+	dir := tempDir(t)
+	state := `[{"SectorId":{"Miner":1000,"Number":1},"CurrentSealTask":{"Commit2Out":{"blobRef":"blobs/gone.bin"}}}]`
+	_, err := loadByJson(writeTestFile(t, dir, "state.json", []byte(state)))
+	if err == nil || !strings.Contains(err.Error(), "gone.bin") {
+		t.Errorf("got %v, want the missing sidecar reported", err)
+	}
+}
+
+func TestRehydrateRejectsEscapingRefs(t *testing.T) {
+	dir := tempDir(t)
+	secret := writeTestFile(t, dir, "secret", []byte("not a blob"))
+	stateDir := filepath.Join(dir, "state")
+	if err := os.Mkdir(stateDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"../secret", "blobs/../../secret", "..", secret, filepath.ToSlash(secret)} {
+		state, err := json.Marshal([]map[string]interface{}{{
+			"SectorId":        SectorID{Miner: 1000, Number: 1},
+			"CurrentSealTask": map[string]interface{}{"Commit2Out": blobRef{Path: ref}},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := writeTestFile(t, stateDir, "state.json", state)
+		out := filepath.Join(dir, "out.json")
+		res := runTool(t, "-in", in, "-out", out)
+		if res.err == nil || !strings.Contains(res.err.Error(), "is outside "+stateDir) {
+			t.Errorf("%q: got %v, want the reference rejected", ref, res.err)
+		}
+		if _, err := os.Stat(out); err == nil {
+			t.Fatalf("%q: wrote %s", ref, out)
+		}
+	}
+
+	// A reference to a file named like .. stays inside.
+	writeTestFile(t, stateDir, "..blob", []byte("proof"))
+	if got, err := rehydrateBlobs(json.RawMessage(`{"CurrentSealTask":{"Commit2Out":{"blobRef":"..blob"}}}`), stateDir); err != nil || !strings.Contains(string(got), `"cHJvb2Y="`) {
+		t.Errorf("..blob rehydrated as %s, %v", got, err)
+	}
+}
+
+func TestExternalizeBlobsOutsideOutput(t *testing.T) {
+	dir := tempDir(t)
+	withBlobs := testRecord(1000, 1, 1)
+	withBlobs.CurrentSealTask.Commit2Out = []byte("proof")
+	in := writeGobState(t, dir, "in.gob", recordMap([]SectorRecord{withBlobs}))
+	out := filepath.Join(dir, "out", "state.json")
+	if err := os.Mkdir(filepath.Dir(out), 0700); err != nil {
+		t.Fatal(err)
+	}
+	blobs := filepath.Join(dir, "blobs")
+	res := runTool(t, "-in", in, "-out", out, "-externalize-blobs", blobs)
+	if res.err == nil || !strings.Contains(res.err.Error(), "is outside "+filepath.Dir(out)) {
+		t.Errorf("got %v, want sidecars outside the output refused", res.err)
+	}
+	if files, _ := ioutil.ReadDir(blobs); len(files) != 0 {
+		t.Errorf("wrote %d sidecar file(s) that could not be loaded back", len(files))
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errInterrupted ends a run stopped by SIGINT or SIGTERM before its output
+// was written.
+var errInterrupted = errors.New("interrupted")
+
+// interruptContext returns a context cancelled by the first SIGINT or
+// SIGTERM. Later signals get their default behaviour again, so a second
+// Ctrl-C still kills a stuck run.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sig:
+			signal.Stop(sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		cancel()
+	}
+}
+
+// checkInterrupted returns errInterrupted once ctx is cancelled.
+func checkInterrupted(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// interruptWhenWriting sends SIGINT to the test process once a file other
+// than the ones listed in keep shows up in dir, or the size of watch
+// changes: the paced save has started.
+func interruptWhenWriting(t *testing.T, dir, watch string, keep int) {
+	t.Helper()
+	fi, err := os.Stat(watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := fi.Size()
+	go func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			entries, _ := ioutil.ReadDir(dir)
+			fi, _ := os.Stat(watch)
+			if len(entries) > keep || fi != nil && fi.Size() != size {
+				break
+			}
+		}
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(os.Interrupt)
+	}()
+}
+
+func TestInterruptedSave(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"replace", []string{"-format", "jsonl"}},
+		{"append", []string{"-format", "jsonl", "-append"}},
+		{"flatten", []string{"-flatten"}},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		in := writeGobState(t, dir, "in.gob", recordMap(testRecords(50)))
+		out := writeTestFile(t, dir, "out.jsonl", []byte(`{"SectorId":{"Miner":1000,"Number":99}}`+"\n"))
+		before := readTestFile(t, out)
+
+		interruptWhenWriting(t, dir, out, 2)
+		res := runTool(t, append([]string{"-in", in, "-out", out, "-rate", "20"}, tt.args...)...)
+		if res.code != 130 || !strings.Contains(res.stderr, "interrupted, no partial output written.") {
+			t.Errorf("%s: exit status %d, stderr %q, want 130 and the interruption reported", tt.name, res.code, res.stderr)
+		}
+		if got := readTestFile(t, out); got != before {
+			t.Errorf("%s: the output holds %q, want it as it was", tt.name, got)
+		}
+		if entries, _ := ioutil.ReadDir(dir); len(entries) != 2 {
+			t.Errorf("%s: %d files in the directory, want no temporary file left", tt.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// snapshotTimeLayout stamps the names of snapshot files, <label>@<time>,
+// so that they sort in the order they were taken.
+const snapshotTimeLayout = "20060102T150405.000000000Z"
+
+// snapshot is a copy of a state file taken with -snapshot.
+type snapshot struct {
+	label string
+	taken time.Time
+	path  string
+	size  int64
+}
+
+func (s snapshot) name() string {
+	return s.label + "@" + s.taken.Format(snapshotTimeLayout)
+}
+
+// defaultSnapshotDir is where the snapshots of stateFile are kept unless
+// -snapshot-dir says otherwise: a snapshots directory next to it.
+func defaultSnapshotDir(stateFile string) string {
+	return filepath.Join(filepath.Dir(stateFile), "snapshots")
+}
+
+func checkSnapshotLabel(label string) error {
+	if label == "" || strings.ContainsAny(label, `/\@`) || strings.HasPrefix(label, ".") {
+		return usageErrorf("snapshot label %q must be non-empty, not start with a dot and hold none of / \\ @", label)
+	}
+	return nil
+}
+
+// takeSnapshot copies the bytes of stateFile, compressed or not, into dir
+// as a new snapshot labelled label.
+func takeSnapshot(ctx context.Context, dir, stateFile, label string, now time.Time) (snapshot, error) {
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		return snapshot{}, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return snapshot{}, err
+	}
+	s := snapshot{label: label, taken: now.UTC(), size: int64(len(data))}
+	s.path = filepath.Join(dir, s.name())
+	if err := writeFileAtomic(ctx, s.path, data, 0600); err != nil {
+		return snapshot{}, err
+	}
+	return s, nil
+}
+
+// listSnapshots returns the snapshots in dir, oldest first. Files not
+// named like a snapshot are ignored, and a missing dir holds none.
+func listSnapshots(dir string) ([]snapshot, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	snaps := make([]snapshot, 0, len(entries))
+	for _, e := range entries {
+		at := strings.LastIndexByte(e.Name(), '@')
+		if at <= 0 || !e.Mode().IsRegular() {
+			continue
+		}
+		taken, err := time.Parse(snapshotTimeLayout, e.Name()[at+1:])
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snapshot{label: e.Name()[:at], taken: taken, path: filepath.Join(dir, e.Name()), size: e.Size()})
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].taken.Before(snaps[j].taken) })
+	return snaps, nil
+}
+
+func printSnapshots(w io.Writer, snaps []snapshot) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SNAPSHOT\tLABEL\tTAKEN\tSIZE")
+	for _, s := range snaps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.name(), s.label, s.taken.Format(time.RFC3339), byteSize(s.size))
+	}
+	return tw.Flush()
+}
+
+// restoreSnapshot replaces target with the newest snapshot in dir
+// labelled label, or with the one of that exact name, <label>@<time>.
+func restoreSnapshot(ctx context.Context, dir, label, target string) (snapshot, error) {
+	snaps, err := listSnapshots(dir)
+	if err != nil {
+		return snapshot{}, err
+	}
+	var found *snapshot
+	for i := range snaps {
+		if snaps[i].label == label || snaps[i].name() == label {
+			found = &snaps[i]
+		}
+	}
+	if found == nil {
+		return snapshot{}, fmt.Errorf("no snapshot %q in %s", label, dir)
+	}
+	data, err := ioutil.ReadFile(found.path)
+	if err != nil {
+		return snapshot{}, err
+	}
+	if err := writeFileAtomic(ctx, target, data, 0600); err != nil {
+		return snapshot{}, err
+	}
+	return *found, nil
+}
+
+// runSnapshots takes, restores or lists the snapshots of the first -in,
+// as -snapshot, -restore or -list-snapshots asks.
+func runSnapshots(opts options) error {
+	n := 0
+	for _, set := range []bool{opts.snapshot != "", opts.restore != "", opts.listSnaps} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		return usageErrorf("-snapshot, -restore and -list-snapshots cannot be combined")
+	}
+	state := opts.inputs[0]
+	if isRemoteInput(state) || hasDir(opts.inputs[:1]) {
+		return usageErrorf("snapshots are of a local state file, not %s", state)
+	}
+	dir := opts.snapshotDir
+	if dir == "" {
+		dir = defaultSnapshotDir(state)
+	}
+	if opts.listSnaps {
+		snaps, err := listSnapshots(dir)
+		if err != nil {
+			return err
+		}
+		return printSnapshots(os.Stdout, snaps)
+	}
+	ctx, stop := interruptContext()
+	defer stop()
+	if opts.snapshot != "" {
+		if err := checkSnapshotLabel(opts.snapshot); err != nil {
+			return err
+		}
+		snap, err := takeSnapshot(ctx, dir, state, opts.snapshot, time.Now())
+		if err != nil {
+			return interruptedError(err)
+		}
+		infof("snapshot of %s written to %s", state, snap.path)
+	} else {
+		target := opts.out
+		if target == "" {
+			target = state
+		}
+		snap, err := restoreSnapshot(ctx, dir, opts.restore, target)
+		if err != nil {
+			return interruptedError(err)
+		}
+		infof("restored %s from %s", target, snap.path)
+	}
+	fmt.Println("done ok")
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSnapshotLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		ok    bool
+	}{
+		{"before-fix", true},
+		{"incident 42", true},
+		{"", false},
+		{".hidden", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"a@b", false},
+	}
+	for _, tt := range tests {
+		err := checkSnapshotLabel(tt.label)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkSnapshotLabel(%q) = %v", tt.label, err)
+		}
+		if err != nil && errorType(err) != "usage" {
+			t.Errorf("checkSnapshotLabel(%q) = %v, want a usage error", tt.label, err)
+		}
+	}
+}
+
+func TestSnapshotStore(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	snaps := filepath.Join(dir, "snapshots")
+	state := writeTestFile(t, dir, "state_data", []byte("first"))
+	if got, err := listSnapshots(snaps); err != nil || len(got) != 0 {
+		t.Fatalf("a missing directory holds %v, %v", got, err)
+	}
+
+	at := time.Date(2021, 3, 4, 5, 6, 7, 8, time.UTC)
+	first, err := takeSnapshot(ctx, snaps, state, "before", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(snaps, "before@20210304T050607.000000008Z"); first.path != want {
+		t.Errorf("the snapshot is %s, want %s", first.path, want)
+	}
+	writeTestFile(t, dir, "state_data", []byte("second"))
+	if _, err := takeSnapshot(ctx, snaps, state, "before", at.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "state_data", []byte("third!"))
+	if _, err := takeSnapshot(ctx, snaps, state, "after", at.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, snaps, "notes.txt", []byte("not a snapshot"))
+	writeTestFile(t, snaps, "x@yesterday", []byte("not a snapshot"))
+
+	got, err := listSnapshots(snaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(got))
+	for _, s := range got {
+		names = append(names, s.name())
+	}
+	want := "after@20210304T040607.000000008Z before@20210304T050607.000000008Z before@20210304T060607.000000008Z"
+	if strings.Join(names, " ") != want {
+		t.Errorf("listed %v, want %s oldest first", names, want)
+	}
+	if got[0].size != 6 || got[1].size != 5 {
+		t.Errorf("the sizes are %d and %d, want 6 and 5", got[0].size, got[1].size)
+	}
+
+	target := filepath.Join(dir, "restored")
+	tests := []struct {
+		label, want string
+	}{
+		{"before", "second"},
+		{"before@20210304T050607.000000008Z", "first"},
+		{"after", "third!"},
+	}
+	for _, tt := range tests {
+		if _, err := restoreSnapshot(ctx, snaps, tt.label, target); err != nil {
+			t.Fatalf("%s: %v", tt.label, err)
+		}
+		if got := readTestFile(t, target); got != tt.want {
+			t.Errorf("restoring %s wrote %q, want %q", tt.label, got, tt.want)
+		}
+	}
+	if _, err := restoreSnapshot(ctx, snaps, "missing", target); err == nil || !strings.Contains(err.Error(), `no snapshot "missing"`) {
+		t.Errorf("restoring a missing label: got %v", err)
+	}
+}
+
+func TestSnapshotFlags(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "state_data", recordMap(testRecords(2)))
+	original := readTestFile(t, in)
+
+	res := mustRun(t, "-in", in, "-snapshot", "before")
+	if res.stdout != "done ok\n" || !strings.Contains(res.log, "snapshot of "+in+" written to "+filepath.Join(dir, "snapshots", "before@")) {
+		t.Errorf("-snapshot printed %q, logging\n%s", res.stdout, res.log)
+	}
+	// A mutating run converts the state in place.
+	mustRun(t, "-in", in)
+	if readTestFile(t, in) == original {
+		t.Fatal("the conversion left the state unchanged")
+	}
+
+	res = mustRun(t, "-in", in, "-list-snapshots")
+	lines := strings.Split(strings.TrimSuffix(res.stdout, "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "SNAPSHOT") || !strings.HasPrefix(lines[1], "before@") || !strings.Contains(lines[1], " before ") {
+		t.Errorf("-list-snapshots printed\n%s", res.stdout)
+	}
+
+	mustRun(t, "-in", in, "-restore", "before")
+	if readTestFile(t, in) != original {
+		t.Error("-restore did not bring back the bytes of the snapshot")
+	}
+	if got := loadTestState(t, in); len(got) != 2 {
+		t.Errorf("the restored state holds %d records, want 2", len(got))
+	}
+
+	// -snapshot-dir and a -restore to -out.
+	other := filepath.Join(dir, "elsewhere")
+	mustRun(t, "-in", in, "-snapshot", "kept", "-snapshot-dir", other)
+	out := filepath.Join(dir, "copy")
+	mustRun(t, "-in", in, "-restore", "kept", "-snapshot-dir", other, "-out", out)
+	if readTestFile(t, out) != original {
+		t.Error("-restore -out did not write the snapshot there")
+	}
+	if res := mustRun(t, "-in", in, "-list-snapshots"); strings.Contains(res.stdout, "kept@") {
+		t.Errorf("a snapshot of -snapshot-dir is listed in the default directory:\n%s", res.stdout)
+	}
+
+	for _, args := range [][]string{
+		{"-snapshot", "a", "-restore", "a"},
+		{"-snapshot", "a", "-list-snapshots"},
+		{"-snapshot", "a/b"},
+	} {
+		if res := runTool(t, append([]string{"-in", in}, args...)...); errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+	if res := runTool(t, "-in", in, "-restore", "missing"); res.code != 1 {
+		t.Errorf("-restore of a missing label: exit status %d, %v", res.code, res.err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `
+CREATE TABLE sectors (
+	key                TEXT PRIMARY KEY,
+	miner              INTEGER NOT NULL,
+	number             INTEGER NOT NULL,
+	working_phase      INTEGER NOT NULL,
+	task_type          TEXT NOT NULL,
+	seal_proof_type    INTEGER NOT NULL,
+	finalized          INTEGER NOT NULL,
+	err_msg            TEXT NOT NULL,
+	unsealed_cid       TEXT,
+	sealed_cid         TEXT,
+	file_task_type     TEXT NOT NULL,
+	file_task_done     INTEGER NOT NULL,
+	file_task_err_msg  TEXT NOT NULL,
+	p1_worker_address  TEXT NOT NULL,
+	p2_worker_address  TEXT NOT NULL,
+	c1_worker_address  TEXT NOT NULL,
+	c2_worker_address  TEXT NOT NULL,
+	updated_at         TEXT
+);
+CREATE TABLE pieces (
+	sector_key  TEXT NOT NULL REFERENCES sectors(key),
+	idx         INTEGER NOT NULL,
+	size        INTEGER NOT NULL,
+	piece_cid   TEXT,
+	PRIMARY KEY (sector_key, idx)
+);
+`
+
+// storeSqlite writes data to filename as a SQLite database with a sectors
+// table of one row per record, keyed by -key-format, and a pieces table of
+// one row per piece of the current seal task. The blobs are left out and
+// undefined CIDs are NULL. The database is built in a temporary file that
+// replaces filename when complete.
+func storeSqlite(ctx context.Context, data map[SectorID]SectorRecord, filename string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	db, err := sql.Open("sqlite", tmp.Name())
+	if err != nil {
+		return err
+	}
+	records := sortedRecords(data)
+	if err := insertSqlite(ctx, db, records); err != nil {
+		db.Close()
+		return err
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	summary.wroteFile(len(records), filename)
+	return nil
+}
+
+func insertSqlite(ctx context.Context, db *sql.DB, records []SectorRecord) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	sectors, err := tx.Prepare(`INSERT INTO sectors VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	pieces, err := tx.Prepare(`INSERT INTO pieces VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	for i, r := range records {
+		if i%1024 == 0 {
+			if err := checkInterrupted(ctx); err != nil {
+				return err
+			}
+		}
+		key := sectorKeyFormat.format(r.SectorId)
+		t := r.CurrentSealTask
+		var updatedAt interface{}
+		if r.UpdatedAt != nil {
+			updatedAt = r.UpdatedAt.Format(time.RFC3339Nano)
+		}
+		_, err := sectors.Exec(key, int64(r.SectorId.Miner), int64(r.SectorId.Number),
+			int64(r.SectorWorkingPhase), string(t.TaskType), int64(t.SealProofType), t.Finalized, t.ErrMsg,
+			sqliteCid(t.PreCommit2Out.Unsealed), sqliteCid(t.PreCommit2Out.Sealed),
+			string(r.CurrentFileTask.FileTaskType), r.CurrentFileTask.Done, r.CurrentFileTask.ErrMsg,
+			r.P1WorkerAddress, r.P2WorkerAddress, r.C1WorkerAddress, r.C2WorkerAddress, updatedAt)
+		if err != nil {
+			return err
+		}
+		for idx, p := range t.Pieces {
+			if _, err := pieces.Exec(key, idx, int64(p.Size), sqliteCid(p.PieceCID)); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// sqliteCid is c as a string, or NULL when undefined.
+func sqliteCid(c cid.Cid) interface{} {
+	if !c.Defined() {
+		return nil
+	}
+	return c.String()
+}
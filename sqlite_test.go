@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSqliteOutput(t *testing.T) {
+	dir := tempDir(t)
+	recordList := testRecords(3)
+	recordList[0].CurrentSealTask.PreCommit2Out.Sealed = testCid(t, "sealed 1")
+	recordList[0].CurrentSealTask.Pieces = []PieceInfo{{Size: 1024, PieceCID: testCid(t, "piece 1")}, {Size: 1024}}
+	recordList[1].CurrentSealTask.TaskType = TTCommit2
+	recordList[1].CurrentSealTask.ErrMsg = "boom"
+	recordList[1].CurrentSealTask.Pieces = nil
+	updated := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	recordList[2].UpdatedAt = &updated
+	in := writeGobState(t, dir, "in.gob", recordMap(recordList))
+	out := filepath.Join(dir, "state.db")
+	// A stale file at -out is replaced, not added to.
+	writeTestFile(t, dir, "state.db", []byte("stale"))
+
+	mustRun(t, "-in", in, "-out", out, "-format", "sqlite", "-key-format", "{miner}/{number}")
+	db, err := sql.Open("sqlite", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	count := func(query string) int {
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return n
+	}
+	if n := count("SELECT COUNT(*) FROM sectors"); n != 3 {
+		t.Errorf("the sectors table has %d rows, want 3", n)
+	}
+	if n := count("SELECT COUNT(*) FROM pieces"); n != 3 {
+		t.Errorf("the pieces table has %d rows, want 2 of sector 1 and 1 of sector 3", n)
+	}
+	if n := count("SELECT COUNT(*) FROM pieces WHERE piece_cid IS NULL"); n != 2 {
+		t.Errorf("%d pieces have a NULL piece_cid, want the 2 without a CID", n)
+	}
+
+	var (
+		taskType, errMsg string
+		sealed           sql.NullString
+		phase            int
+	)
+	row := db.QueryRow("SELECT task_type, err_msg, sealed_cid, working_phase FROM sectors WHERE key = '1000/2'")
+	if err := row.Scan(&taskType, &errMsg, &sealed, &phase); err != nil {
+		t.Fatal(err)
+	}
+	if taskType != string(TTCommit2) || errMsg != "boom" || sealed.Valid || phase != 2 {
+		t.Errorf("sector 2 is %q, %q, %v, phase %d", taskType, errMsg, sealed, phase)
+	}
+	row = db.QueryRow("SELECT sealed_cid, miner, number FROM sectors WHERE key = '1000/1'")
+	var miner, number int64
+	if err := row.Scan(&sealed, &miner, &number); err != nil {
+		t.Fatal(err)
+	}
+	if want := testCid(t, "sealed 1").String(); sealed.String != want || miner != 1000 || number != 1 {
+		t.Errorf("sector 1 is %v, miner %d, number %d, want its sealed CID %s", sealed, miner, number, want)
+	}
+	var size int64
+	var pieceCid string
+	if err := db.QueryRow("SELECT size, piece_cid FROM pieces WHERE sector_key = '1000/1' AND idx = 0").Scan(&size, &pieceCid); err != nil {
+		t.Fatal(err)
+	}
+	if size != 1024 || pieceCid != testCid(t, "piece 1").String() {
+		t.Errorf("the first piece of sector 1 is %d bytes, %s", size, pieceCid)
+	}
+	var updatedAt sql.NullString
+	if err := db.QueryRow("SELECT updated_at FROM sectors WHERE key = '1000/3'").Scan(&updatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if updatedAt.String != "2021-03-04T05:06:07Z" {
+		t.Errorf("sector 3 was updated at %v", updatedAt)
+	}
+}
+
+func TestSqliteNeedsOut(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(1)))
+	for _, args := range [][]string{{}, {"-out", in}} {
+		res := runTool(t, append([]string{"-in", in, "-format", "sqlite"}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("%q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// unassignedWorker is the byWorker key of sectors no worker has taken yet.
+const unassignedWorker = "unassigned"
+
+type stats struct {
+	Total      int                        `json:"total"`
+	Finalized  int                        `json:"finalized"`
+	ByPhase    map[SectorWorkingPhase]int `json:"byPhase"`
+	ByTaskType map[TaskType]int           `json:"byTaskType"`
+	ByWorker   map[string]workerStats     `json:"byWorker"`
+	// BlobBytes totals the recordBlobs of every sector, and LargestRecord
+	// is the sector holding the most of them.
+	BlobBytes     byteSize     `json:"blobBytes"`
+	LargestRecord *sectorBlobs `json:"largestRecord,omitempty"`
+}
+
+// sectorBlobs is the blob total of one sector.
+type sectorBlobs struct {
+	id        SectorID
+	Sector    string   `json:"sector"`
+	BlobBytes byteSize `json:"blobBytes"`
+}
+
+// workerStats counts the sectors of one worker, as given by currentWorker.
+type workerStats struct {
+	Total   int                        `json:"total"`
+	ByPhase map[SectorWorkingPhase]int `json:"byPhase"`
+}
+
+func computeStats(data map[SectorID]SectorRecord) stats {
+	st := stats{
+		ByPhase:    make(map[SectorWorkingPhase]int),
+		ByTaskType: make(map[TaskType]int),
+		ByWorker:   make(map[string]workerStats),
+	}
+	for _, v := range data {
+		st.Total++
+		if v.CurrentSealTask.Finalized {
+			st.Finalized++
+		}
+		st.ByPhase[v.SectorWorkingPhase]++
+		st.ByTaskType[v.CurrentSealTask.TaskType]++
+		worker := currentWorker(v)
+		if worker == "" {
+			worker = unassignedWorker
+		}
+		ws, ok := st.ByWorker[worker]
+		if !ok {
+			ws.ByPhase = make(map[SectorWorkingPhase]int)
+		}
+		ws.Total++
+		ws.ByPhase[v.SectorWorkingPhase]++
+		st.ByWorker[worker] = ws
+		var n byteSize
+		for _, f := range recordBlobs(v) {
+			n += byteSize(len(f.b))
+		}
+		st.BlobBytes += n
+		if st.LargestRecord == nil || n > st.LargestRecord.BlobBytes ||
+			n == st.LargestRecord.BlobBytes && sectorIDLess(v.SectorId, st.LargestRecord.id) {
+			st.LargestRecord = &sectorBlobs{id: v.SectorId, Sector: sectorName(v.SectorId), BlobBytes: n}
+		}
+	}
+	return st
+}
+
+// writeStatsJson writes the stats of data to w as one JSON object.
+func writeStatsJson(w io.Writer, data map[SectorID]SectorRecord) error {
+	return json.NewEncoder(w).Encode(computeStats(data))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStatsJson(t *testing.T) {
+	done := testRecord(1000, 2, 3)
+	done.CurrentSealTask.Finalized = true
+	done.CurrentSealTask.Commit2Out = make([]byte, 100)
+	done.C1WorkerAddress = "10.0.0.9:3456"
+	idle := testRecord(1000, 3, 0)
+	idle.CurrentSealTask.TaskType = ""
+	idle.P1WorkerAddress = ""
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap([]SectorRecord{testRecord(1000, 1, 3), done, idle}))
+
+	res := mustRun(t, "-in", in, "-format", "stats-json")
+	var st struct {
+		Total      int            `json:"total"`
+		Finalized  int            `json:"finalized"`
+		ByPhase    map[string]int `json:"byPhase"`
+		ByTaskType map[string]int `json:"byTaskType"`
+		ByWorker   map[string]struct {
+			Total   int            `json:"total"`
+			ByPhase map[string]int `json:"byPhase"`
+		} `json:"byWorker"`
+		BlobBytes     int64 `json:"blobBytes"`
+		LargestRecord struct {
+			Sector    string `json:"sector"`
+			BlobBytes int64  `json:"blobBytes"`
+		} `json:"largestRecord"`
+	}
+	if err := json.NewDecoder(strings.NewReader(res.stdout)).Decode(&st); err != nil {
+		t.Fatalf("%v:\n%s", err, res.stdout)
+	}
+	if st.Total != 3 || st.Finalized != 1 {
+		t.Errorf("got a total of %d with %d finalized, want 3 and 1", st.Total, st.Finalized)
+	}
+	if st.ByPhase["3"] != 2 || st.ByPhase["0"] != 1 {
+		t.Errorf("byPhase = %v", st.ByPhase)
+	}
+	if st.ByTaskType["seal/v0/precommit/1"] != 2 || st.ByTaskType[""] != 1 {
+		t.Errorf("byTaskType = %v", st.ByTaskType)
+	}
+	if w := st.ByWorker["10.0.0.1:3456"]; w.Total != 1 || w.ByPhase["3"] != 1 {
+		t.Errorf("byWorker[10.0.0.1:3456] = %+v", w)
+	}
+	if w := st.ByWorker["10.0.0.9:3456"]; w.Total != 1 {
+		t.Errorf("byWorker[10.0.0.9:3456] = %+v, want the latest worker counted", w)
+	}
+	if w := st.ByWorker[unassignedWorker]; w.Total != 1 || w.ByPhase["0"] != 1 {
+		t.Errorf("byWorker[%s] = %+v", unassignedWorker, w)
+	}
+	if st.BlobBytes != 100 || st.LargestRecord.Sector != "s-t01000-2" || st.LargestRecord.BlobBytes != 100 {
+		t.Errorf("got %d blob bytes, largest %+v", st.BlobBytes, st.LargestRecord)
+	}
+}
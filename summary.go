@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runSummary collects the counts -summary reports at the end of a run.
+type runSummary struct {
+	start time.Time
+	// read counts the records loaded, dropped those left out by the empty
+	// record check, filters, -limit and -skip-bad-records, and written
+	// those saved, in bytes total.
+	read    int
+	dropped int
+	written int
+	bytes   int64
+}
+
+var summary = runSummary{start: time.Now()}
+
+// wrote records a successful write of records records in n bytes.
+func (s *runSummary) wrote(records int, n int64) {
+	s.written += records
+	s.bytes += n
+}
+
+// wroteFile is wrote for a file whose size is read back from disk.
+func (s *runSummary) wroteFile(records int, filename string) {
+	var n int64
+	if fi, err := os.Stat(filename); err == nil {
+		n = fi.Size()
+	}
+	s.wrote(records, n)
+}
+
+// line formats the summary as space-separated key=value pairs. The keys
+// and their order are fixed so that log scrapers can rely on them; status
+// is ok, error or interrupted.
+func (s *runSummary) line(format string, err error) string {
+	status := "ok"
+	switch {
+	case errors.Is(err, errInterrupted):
+		status = "interrupted"
+	case err != nil:
+		status = "error"
+	}
+	return fmt.Sprintf("summary duration_ms=%d read=%d written=%d dropped=%d bytes=%d format=%s status=%s",
+		time.Since(s.start).Milliseconds(), s.read, s.written, s.dropped, s.bytes, format, status)
+}
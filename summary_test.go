@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// summaryFields parses the -summary line ending stderr.
+func summaryFields(t *testing.T, stderr string) map[string]string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(stderr, "\n"), "\n")
+	last := strings.Fields(lines[len(lines)-1])
+	if len(last) == 0 || last[0] != "summary" {
+		t.Fatalf("stderr does not end with the summary line:\n%s", stderr)
+	}
+	fields := make(map[string]string)
+	for _, kv := range last[1:] {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			t.Fatalf("%q is not a key=value pair", kv)
+		}
+		fields[kv[:i]] = kv[i+1:]
+	}
+	return fields
+}
+
+func TestSummaryLine(t *testing.T) {
+	s := runSummary{start: time.Now(), read: 5, written: 3, dropped: 2, bytes: 1024}
+	tests := []struct {
+		err    error
+		status string
+	}{
+		{nil, "ok"},
+		{errors.New("boom"), "error"},
+		{fmt.Errorf("saving: %w", errInterrupted), "interrupted"},
+	}
+	for _, tt := range tests {
+		line := s.line("jsonl", tt.err)
+		want := "read=5 written=3 dropped=2 bytes=1024 format=jsonl status=" + tt.status
+		if !strings.HasPrefix(line, "summary duration_ms=") || !strings.HasSuffix(line, " "+want) {
+			t.Errorf("%v: got %q, want the fields %s in order", tt.err, line, want)
+		}
+	}
+}
+
+func TestSummaryAfterConversion(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", append(testRecords(4), SectorRecord{}))
+	tests := []struct {
+		args                   []string
+		read, written, dropped string
+		format                 string
+	}{
+		{nil, "5", "4", "1", "json"},
+		{[]string{"-canonical"}, "5", "4", "1", "json"},
+		{[]string{"-format", "jsonl", "-number-min", "3"}, "5", "2", "3", "jsonl"},
+		{[]string{"-flatten"}, "5", "4", "1", "flatten"},
+	}
+	for _, tt := range tests {
+		out := filepath.Join(dir, "out")
+		res := mustRun(t, append([]string{"-in", in, "-out", out, "-summary"}, tt.args...)...)
+		fields := summaryFields(t, res.stderr)
+		fi, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"read": tt.read, "written": tt.written, "dropped": tt.dropped,
+			"bytes": fmt.Sprint(fi.Size()), "format": tt.format, "status": "ok",
+		}
+		for k, v := range want {
+			if fields[k] != v {
+				t.Errorf("%q: %s=%s, want %s", tt.args, k, fields[k], v)
+			}
+		}
+		if _, ok := fields["duration_ms"]; !ok {
+			t.Errorf("%q: the summary lacks duration_ms", tt.args)
+		}
+	}
+
+	res := runTool(t, "-in", filepath.Join(dir, "missing"), "-out", filepath.Join(dir, "out"), "-summary")
+	if fields := summaryFields(t, res.stderr); fields["status"] != "error" || fields["written"] != "0" {
+		t.Errorf("a failed run summarised as %v", fields)
+	}
+	if res := mustRun(t, "-in", in, "-out", filepath.Join(dir, "out")); strings.Contains(res.stderr, "summary ") {
+		t.Errorf("the summary was printed without -summary:\n%s", res.stderr)
+	}
+}
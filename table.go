@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+const tableCellWidth = 40
+
+// writeTable prints one aligned row per sector for reading in a terminal.
+// The output is not meant to be loaded back.
+func writeTable(w io.Writer, data map[SectorID]SectorRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SECTOR\tPHASE\tTASK\tFINALIZED\tWORKER")
+	for _, r := range sortedRecords(data) {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%t\t%s\n",
+			sectorName(r.SectorId),
+			r.SectorWorkingPhase,
+			cell(string(r.CurrentSealTask.TaskType)),
+			r.CurrentSealTask.Finalized,
+			cell(currentWorker(r)),
+		)
+	}
+	return tw.Flush()
+}
+
+// currentWorker returns the address of the worker of the latest phase the
+// sector has been assigned to.
+func currentWorker(r SectorRecord) string {
+	for _, addr := range []string{r.C2WorkerAddress, r.C1WorkerAddress, r.P2WorkerAddress, r.P1WorkerAddress} {
+		if addr != "" {
+			return addr
+		}
+	}
+	return ""
+}
+
+func cell(v string) string {
+	if v == "" {
+		return "-"
+	}
+	v, _ = truncate(v, tableCellWidth)
+	return v
+}
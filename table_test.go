@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableOutput(t *testing.T) {
+	long := testRecord(1000, 2, 3)
+	long.CurrentSealTask.TaskType = TaskType(strings.Repeat("x", 50))
+	long.CurrentSealTask.Finalized = true
+	long.C1WorkerAddress = "10.0.0.9:3456"
+	idle := testRecord(1000, 3, 0)
+	idle.CurrentSealTask.TaskType = ""
+	idle.P1WorkerAddress = ""
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap([]SectorRecord{testRecord(1000, 1, 1), long, idle}))
+
+	res := mustRun(t, "-in", in, "-format", "table")
+	lines := strings.Split(strings.TrimSuffix(res.stdout, "\n"), "\n")
+	want := [][]string{
+		{"SECTOR", "PHASE", "TASK", "FINALIZED", "WORKER"},
+		{"s-t01000-1", "1", "seal/v0/precommit/1", "false", "10.0.0.1:3456"},
+		{"s-t01000-2", "3", strings.Repeat("x", tableCellWidth) + "...", "true", "10.0.0.9:3456"},
+		{"s-t01000-3", "0", "-", "false", "-"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), res.stdout)
+	}
+	column := strings.Index(lines[0], "PHASE")
+	for i, line := range lines {
+		if got := strings.Fields(line); strings.Join(got, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d = %q, want the cells %q", i, line, want[i])
+		}
+		if !strings.HasPrefix(line[column:], want[i][1]+" ") {
+			t.Errorf("line %d: the phase is not aligned under its header:\n%s", i, res.stdout)
+		}
+	}
+}
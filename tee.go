@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// teeStdout is the -tee target naming stdout.
+const teeStdout = "-"
+
+// stagedTee holds the -tee copies of an output until the output itself is
+// written: the file targets in temporary files next to each, and the
+// bytes still due on stdout.
+type stagedTee struct {
+	data   []byte
+	stdout bool
+	tmps   map[string]*os.File
+}
+
+// stageTee writes data, the output about to be written, to a temporary
+// file for every file target through one io.MultiWriter, so that a copy
+// that cannot be written fails the save before the output is touched.
+// The caller writes the output and then commits or discards the copies.
+func stageTee(data []byte, targets []string) (*stagedTee, error) {
+	t := &stagedTee{data: data, tmps: make(map[string]*os.File)}
+	writers := make([]io.Writer, 0, len(targets))
+	for _, target := range targets {
+		if target == teeStdout {
+			t.stdout = true
+			continue
+		}
+		tmp, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".tmp*")
+		if err != nil {
+			t.discard()
+			return nil, fmt.Errorf("-tee %s: %w", target, err)
+		}
+		t.tmps[target] = tmp
+		writers = append(writers, tmp)
+	}
+	if _, err := io.MultiWriter(writers...).Write(data); err != nil {
+		t.discard()
+		return nil, fmt.Errorf("-tee: %w", err)
+	}
+	for target, tmp := range t.tmps {
+		err := tmp.Sync()
+		if err == nil {
+			err = tmp.Chmod(0600)
+		}
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			t.discard()
+			return nil, fmt.Errorf("-tee %s: %w", target, err)
+		}
+	}
+	return t, nil
+}
+
+// commit renames the staged copies into place and writes the stdout one.
+// A rename can still fail after the output was written; the error then
+// names every target left without its copy.
+func (t *stagedTee) commit() error {
+	var failed []string
+	for target, tmp := range t.tmps {
+		if err := os.Rename(tmp.Name(), target); err != nil {
+			os.Remove(tmp.Name())
+			failed = append(failed, fmt.Sprintf("%s: %v", target, err))
+		}
+	}
+	t.tmps = nil
+	if t.stdout {
+		if _, err := os.Stdout.Write(t.data); err != nil {
+			failed = append(failed, fmt.Sprintf("stdout: %v", err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("output written, but not its -tee copies to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// discard removes the staged copies, for an output that was not written.
+func (t *stagedTee) discard() {
+	for _, tmp := range t.tmps {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	t.tmps = nil
+}
+
+// teesToStdout reports whether stdout is one of the -tee targets, which
+// then holds nothing but the output.
+func teesToStdout(targets []string) bool {
+	for _, t := range targets {
+		if t == teeStdout {
+			return true
+		}
+	}
+	return false
+}
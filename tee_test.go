@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dirEntries returns the names of the files in dir.
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestStageTee(t *testing.T) {
+	dir := tempDir(t)
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	data := []byte(`[{"SectorId":{"Miner":1000,"Number":1}}]`)
+
+	staged, err := stageTee(data, []string{a, teeStdout, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := dirEntries(t, dir); len(names) != 2 || !strings.HasPrefix(names[0], ".a.json.tmp") || !strings.HasPrefix(names[1], ".b.json.tmp") {
+		t.Errorf("staging left %v, want only the temporary copies", names)
+	}
+	stdout := redirect(t, &os.Stdout)
+	err = staged.commit()
+	printed := stdout()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed != string(data) || readTestFile(t, a) != string(data) || readTestFile(t, b) != string(data) {
+		t.Errorf("stdout got %q, the files %q and %q, want %s each", printed, readTestFile(t, a), readTestFile(t, b), data)
+	}
+	if names := dirEntries(t, dir); len(names) != 2 {
+		t.Errorf("committing left %v", names)
+	}
+
+	c := filepath.Join(dir, "c.json")
+	staged, err = stageTee(data, []string{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged.discard()
+	if names := dirEntries(t, dir); len(names) != 2 {
+		t.Errorf("discarding left %v, want only a.json and b.json", names)
+	}
+
+	if _, err := stageTee(data, []string{c, filepath.Join(dir, "missing", "d.json")}); err == nil || !strings.Contains(err.Error(), "-tee "+filepath.Join(dir, "missing", "d.json")) {
+		t.Errorf("a target in a missing directory: got %v", err)
+	}
+	if names := dirEntries(t, dir); len(names) != 2 {
+		t.Errorf("a failed staging left %v", names)
+	}
+}
+
+func TestTeeFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(testRecords(3)))
+	out := filepath.Join(dir, "out")
+	teeCopy := filepath.Join(dir, "copy")
+	for _, args := range [][]string{{}, {"-format", "jsonl"}, {"-format", "map-json"}, {"-canonical"}, {"-explain"}} {
+		res := mustRun(t, append([]string{"-in", in, "-out", out, "-tee", teeCopy, "-tee", "-"}, args...)...)
+		written := readTestFile(t, out)
+		if readTestFile(t, teeCopy) != written {
+			t.Errorf("%q: the -tee file differs from -out", args)
+		}
+		if res.stdout != written {
+			t.Errorf("%q: stdout got\n%s\nwant only the output\n%s", args, res.stdout, written)
+		}
+		if got := loadTestState(t, teeCopy); len(got) != 3 {
+			t.Errorf("%q: the copy loads %d records, want 3", args, len(got))
+		}
+	}
+
+	// Without stdout as a target the run still ends with done ok.
+	if res := mustRun(t, "-in", in, "-out", out, "-tee", teeCopy); res.stdout != "done ok\n" {
+		t.Errorf("a -tee file printed %q", res.stdout)
+	}
+
+	// A copy that cannot be written fails the save before -out changes.
+	writeTestFile(t, dir, "out", []byte("before"))
+	res := runTool(t, "-in", in, "-out", out, "-tee", filepath.Join(dir, "missing", "copy"))
+	if res.err == nil || readTestFile(t, out) != "before" {
+		t.Errorf("an unwritable -tee: got %v, with -out holding %q", res.err, readTestFile(t, out))
+	}
+
+	for _, args := range [][]string{
+		{"-format", "properties"},
+		{"-format", "jsonl", "-append"},
+		{"-format", "jsonl", "-split-size", "1000"},
+		{"-flatten"},
+	} {
+		res := runTool(t, append([]string{"-in", in, "-out", filepath.Join(dir, "other"), "-tee", teeCopy}, args...)...)
+		if errorType(res.err) != "usage" {
+			t.Errorf("-tee with %q: got %v, want a usage error", args, res.err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Transcode converts the state read from in, in the format inFmt, to
+// outFmt on out, applying the default conversion: empty records are
+// dropped and the Commit1Out of sectors at commit 2 is cleared. A gob
+// stream of single records, a slice-shaped gob and a JSON array are written
+// record by record without building the State map; a map-shaped gob is
+// decoded in full and written in sector order. Duplicate sectors of a
+// stream are written as they come.
+func Transcode(in io.Reader, out io.Writer, inFmt, outFmt string) error {
+	if outFmt != "json" {
+		return fmt.Errorf("unsupported output format %q", outFmt)
+	}
+	a := &jsonArrayWriter{w: bufio.NewWriter(out)}
+	write := func(r SectorRecord) error {
+		if r.SectorId == (SectorID{}) {
+			return nil
+		}
+		clearCommit1Out(&r)
+		return a.write(r)
+	}
+	var err error
+	switch inFmt {
+	case "gob":
+		err = transcodeGob(in, write)
+	case "json":
+		err = transcodeJson(in, write)
+	default:
+		err = fmt.Errorf("unsupported input format %q", inFmt)
+	}
+	if err != nil {
+		return err
+	}
+	return a.close()
+}
+
+func transcodeGob(in io.Reader, write func(SectorRecord) error) error {
+	// Peek at the type definitions to learn the shape of the value, then
+	// replay what was read in front of the rest of the stream.
+	var head bytes.Buffer
+	types, valueID, err := readGobTypes(io.TeeReader(in, &head))
+	if err != nil {
+		return err
+	}
+	kind := ""
+	for _, t := range types {
+		if t.ID == valueID {
+			kind = t.Kind
+		}
+	}
+	dec := gob.NewDecoder(io.MultiReader(&head, in))
+
+	switch kind {
+	case "struct":
+		for {
+			var r SectorRecord
+			err := dec.Decode(&r)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := write(r); err != nil {
+				return err
+			}
+		}
+	case "slice":
+		// gob decodes a slice as one value, but its records go out one at
+		// a time.
+		recordList := make([]SectorRecord, 0)
+		if err := dec.Decode(&recordList); err != nil {
+			return err
+		}
+		for i := range recordList {
+			if err := write(recordList[i]); err != nil {
+				return err
+			}
+			recordList[i] = SectorRecord{}
+		}
+		return nil
+	default:
+		data := make(map[SectorID]SectorRecord)
+		if err := dec.Decode(&data); err != nil {
+			return err
+		}
+		for _, r := range sortedRecords(data) {
+			if err := write(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func transcodeJson(in io.Reader, write func(SectorRecord) error) error {
+	dec := json.NewDecoder(in)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected a JSON array, got %v", tok)
+	}
+	for dec.More() {
+		var r SectorRecord
+		if err := dec.Decode(&r); err != nil {
+			return err
+		}
+		if err := write(r); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
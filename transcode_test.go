@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gobStream encodes each record as its own gob value, as producers
+// streaming single records write them.
+func gobStream(t testing.TB, recordList []SectorRecord) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for _, r := range recordList {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestTranscode(t *testing.T) {
+	dir := tempDir(t)
+	recordList := fastPathRecords()
+	jsonIn, err := json.Marshal(recordList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fastConvert writes the default conversion of a gob to a file.
+	opts, err := parseFlags([]string{"-in", "unused"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast := filepath.Join(dir, "fast.json")
+	slice := writeGobState(t, dir, "slice.gob", recordList)
+	if handled, err := fastConvert(context.Background(), slice, fast, opts); !handled || err != nil {
+		t.Fatalf("fastConvert handled %v, %v", handled, err)
+	}
+	want := readTestFile(t, fast)
+
+	tests := []struct {
+		name, format string
+		in           []byte
+	}{
+		{"slice", "gob", []byte(readTestFile(t, slice))},
+		{"map", "gob", []byte(readTestFile(t, writeGobState(t, dir, "map.gob", recordMap(recordList))))},
+		{"stream", "gob", gobStream(t, recordList)},
+		{"array", "json", jsonIn},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		if err := Transcode(bytes.NewReader(tt.in), &out, tt.format, "json"); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out.String() != want {
+			t.Errorf("%s: transcoded\n%s\nwant the default conversion\n%s", tt.name, out.String(), want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := Transcode(strings.NewReader("[]"), &out, "json", "json"); err != nil || out.String() != "[]" {
+		t.Errorf("an empty array transcoded to %q, %v", out.String(), err)
+	}
+	for _, tt := range []struct {
+		in, inFmt, outFmt, err string
+	}{
+		{"[]", "json", "jsonl", `unsupported output format "jsonl"`},
+		{"[]", "yaml", "json", `unsupported input format "yaml"`},
+		{`{"SectorId":{}}`, "json", "json", "expected a JSON array"},
+		{`[{"SectorId":`, "json", "json", "unexpected EOF"},
+		{"junk", "gob", "json", ""},
+	} {
+		err := Transcode(strings.NewReader(tt.in), ioutil.Discard, tt.inFmt, tt.outFmt)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %q: got %v, want %q", tt.inFmt, tt.in, err, tt.err)
+		}
+	}
+}
+
+// BenchmarkTranscode transcodes the same records from each gob shape, and
+// loads and saves the slice through the State for comparison. Transcoding
+// the slice allocates less than half the bytes of the State path, and the
+// stream, which never holds more than one record, less again.
+func BenchmarkTranscode(b *testing.B) {
+	dir := tempDir(b)
+	recordList := make([]SectorRecord, 0, 20000)
+	for n := 1; n <= 20000; n++ {
+		r := testRecord(1000, SectorNumber(n), 5)
+		r.CurrentSealTask.TaskType = TTCommit2
+		r.CurrentSealTask.Commit1Out = make([]byte, 256)
+		recordList = append(recordList, r)
+	}
+	slice := writeGobState(b, dir, "slice.gob", recordList)
+	inputs := []struct {
+		name string
+		in   []byte
+	}{
+		{"slice", []byte(readTestFile(b, slice))},
+		{"stream", gobStream(b, recordList)},
+		{"map", []byte(readTestFile(b, writeGobState(b, dir, "map.gob", recordMap(recordList))))},
+	}
+	for _, input := range inputs {
+		in := input.in
+		b.Run(input.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := Transcode(bytes.NewReader(in), ioutil.Discard, "gob", "json"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	out := filepath.Join(dir, "out.json")
+	b.Run("state", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			s, err := newState(slice, mergeOptions{})
+			if err != nil {
+				b.Fatal(err)
+			}
+			s.filePath = out
+			if err := s.save(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// forceTypeCompat lets gob inputs load whose field types differ from the
+// local ones in one of the known-safe ways below, as asked with
+// -force-type-compat. Gob matches fields by name but fails when their
+// types disagree, as they do for files of producers with slightly
+// different type definitions. The substitutions are:
+//   - a signed integer for an unsigned field, or the other way round; a
+//     value out of range of the local field fails the load;
+//   - a byte array, such as [32]byte, for a byte slice such as
+//     SealRandomness, and a byte slice for a byte array such as uuid.UUID;
+//   - a string for a byte slice, and a byte slice for a string;
+//   - a byte slice or array for a type decoding itself from binary, such
+//     as uuid.UUID, and a string for one decoding itself from text, such
+//     as cid.Cid.
+//
+// The risk is that the producer meant something else by a field of that
+// name: a substitution only carries the bits over and cannot tell a
+// renumbered enum or a hash of a different length from the real thing.
+// Each substitution is logged as a warning, so -fail-on-warning still
+// refuses such a file.
+var forceTypeCompat bool
+
+var (
+	bytesType             = reflect.TypeOf([]byte(nil))
+	stringType            = reflect.TypeOf("")
+	gobDecoderType        = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Ids of the builtin gob types a substitution can receive.
+const (
+	gobIntID    = 2
+	gobUintID   = 3
+	gobBytesID  = 5
+	gobStringID = 6
+)
+
+// gobCompat maps the local types values are decoded into to stand-ins
+// gob accepts for the types a stream was written with.
+type gobCompat struct {
+	filename string
+	types    map[int64]gobType
+	valueID  int64
+	// standIns caches the stand-in of each local type, nil when none is
+	// needed.
+	standIns map[reflect.Type]reflect.Type
+	subs     map[string]string
+}
+
+// newGobCompat reads the type definitions at the head of raw, or returns
+// nil when they cannot be read and the decoder is left to report why.
+func newGobCompat(filename string, raw []byte) *gobCompat {
+	types, valueID, err := readGobTypes(bytes.NewReader(raw))
+	if err != nil {
+		return nil
+	}
+	c := &gobCompat{
+		filename: filename,
+		types:    make(map[int64]gobType, len(types)),
+		valueID:  valueID,
+		standIns: make(map[reflect.Type]reflect.Type),
+	}
+	for _, t := range types {
+		c.types[t.ID] = t
+	}
+	return c
+}
+
+// target returns what to decode into in place of v, a pointer to a local
+// value: v itself when the stream matches its type, or a pointer to a new
+// stand-in value to convert from afterwards.
+func (c *gobCompat) target(v interface{}) interface{} {
+	local := reflect.TypeOf(v).Elem()
+	standIn, ok := c.standIns[local]
+	if !ok {
+		c.subs = make(map[string]string)
+		if t, changed := c.standIn(local, c.valueID, ""); changed {
+			standIn = t
+			paths := make([]string, 0, len(c.subs))
+			for p := range c.subs {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			for _, p := range paths {
+				warnf("%s: -force-type-compat: %s: %s", c.filename, p, c.subs[p])
+			}
+		}
+		c.standIns[local] = standIn
+	}
+	if standIn == nil {
+		return v
+	}
+	return reflect.New(standIn).Interface()
+}
+
+// convert copies the decoded stand-in into v.
+func (c *gobCompat) convert(v, decoded interface{}) error {
+	if v == decoded {
+		return nil
+	}
+	return convertCompat(reflect.ValueOf(v).Elem(), reflect.ValueOf(decoded).Elem(), "")
+}
+
+func (c *gobCompat) remoteName(id int64) string {
+	if name, ok := gobBuiltinTypes[id]; ok {
+		return name
+	}
+	if t, ok := c.types[id]; ok && t.Name != "" {
+		return t.Name
+	}
+	return fmt.Sprintf("type %d", id)
+}
+
+// isByteArray reports whether the remote type id is an array of bytes.
+func (c *gobCompat) isByteArray(id int64) (gobType, bool) {
+	t, ok := c.types[id]
+	return t, ok && t.Kind == "array" && t.Elem == gobUintID
+}
+
+func decodesItself(t reflect.Type) bool {
+	p := reflect.PtrTo(t)
+	return p.Implements(gobDecoderType) || p.Implements(binaryUnmarshalerType) || p.Implements(textUnmarshalerType)
+}
+
+// standIn returns a type gob can decode the remote type id into and that
+// converts to local, and whether it differs from local. path names the
+// field for the log.
+func (c *gobCompat) standIn(local reflect.Type, id int64, path string) (reflect.Type, bool) {
+	remote, defined := c.types[id]
+	sub := func(t reflect.Type) (reflect.Type, bool) {
+		name := path
+		if name == "" {
+			name = "value"
+		}
+		c.subs[name] = fmt.Sprintf("received %s, loaded as %s", c.remoteName(id), local)
+		return t, true
+	}
+	if decodesItself(local) {
+		if defined && remote.Kind != "struct" && remote.Kind != "map" && remote.Kind != "slice" && remote.Kind != "array" {
+			return local, false
+		}
+		p := reflect.PtrTo(local)
+		binary := p.Implements(binaryUnmarshalerType)
+		if arr, ok := c.isByteArray(id); ok && binary {
+			return sub(reflect.ArrayOf(int(arr.Len), reflect.TypeOf(byte(0))))
+		}
+		switch {
+		case id == gobBytesID && binary:
+			return sub(bytesType)
+		case id == gobStringID && p.Implements(textUnmarshalerType):
+			return sub(stringType)
+		}
+		return local, false
+	}
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	switch local.Kind() {
+	case reflect.Ptr:
+		if t, changed := c.standIn(local.Elem(), id, path); changed {
+			return reflect.PtrTo(t), true
+		}
+	case reflect.Struct:
+		if !defined || remote.Kind != "struct" {
+			return local, false
+		}
+		remoteFields := make(map[string]int64, len(remote.Fields))
+		for _, f := range remote.Fields {
+			remoteFields[f.Name] = f.ID
+		}
+		fields := make([]reflect.StructField, 0, local.NumField())
+		changed := false
+		for i := 0; i < local.NumField(); i++ {
+			f := local.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if rid, ok := remoteFields[f.Name]; ok {
+				t, ch := c.standIn(f.Type, rid, join(f.Name))
+				f.Type = t
+				changed = changed || ch
+			}
+			f.Index, f.Offset = nil, 0
+			fields = append(fields, f)
+		}
+		if changed {
+			return reflect.StructOf(fields), true
+		}
+	case reflect.Map:
+		if !defined || remote.Kind != "map" {
+			return local, false
+		}
+		key, kch := c.standIn(local.Key(), remote.Key, join("(key)"))
+		elem, ech := c.standIn(local.Elem(), remote.Elem, path)
+		if kch || ech {
+			return reflect.MapOf(key, elem), true
+		}
+	case reflect.Slice:
+		if local.Elem().Kind() == reflect.Uint8 {
+			if arr, ok := c.isByteArray(id); ok {
+				return sub(reflect.ArrayOf(int(arr.Len), local.Elem()))
+			}
+			if id == gobStringID {
+				return sub(stringType)
+			}
+			return local, false
+		}
+		if defined && remote.Kind == "slice" {
+			if elem, changed := c.standIn(local.Elem(), remote.Elem, path); changed {
+				return reflect.SliceOf(elem), true
+			}
+		}
+	case reflect.Array:
+		if local.Elem().Kind() == reflect.Uint8 && id == gobBytesID {
+			return sub(bytesType)
+		}
+	case reflect.String:
+		if id == gobBytesID {
+			return sub(bytesType)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if id == gobUintID {
+			return sub(reflect.TypeOf(uint64(0)))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if id == gobIntID {
+			return sub(reflect.TypeOf(int64(0)))
+		}
+	}
+	return local, false
+}
+
+// compatBytes returns the bytes of a string, byte slice or byte array.
+func compatBytes(v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.String:
+		return []byte(v.String())
+	case reflect.Array:
+		b := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(b), v)
+		return b
+	}
+	return v.Bytes()
+}
+
+// convertCompat sets dst, an addressable local value, from src, the value
+// of its stand-in, failing for integers out of range of dst.
+func convertCompat(dst, src reflect.Value, path string) error {
+	if src.Type() == dst.Type() {
+		dst.Set(src)
+		return nil
+	}
+	fail := func(format string, v ...interface{}) error {
+		if path == "" {
+			path = "value"
+		}
+		return fmt.Errorf("-force-type-compat: %s: %s", path, fmt.Sprintf(format, v...))
+	}
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	if decodesItself(dst.Type()) {
+		// Gob leaves zero values out, so an empty one stands for the
+		// zero value of dst rather than something to decode.
+		if compatBytes(src) == nil || src.Kind() == reflect.String && src.Len() == 0 {
+			return nil
+		}
+		p := dst.Addr().Interface()
+		if u, ok := p.(encoding.BinaryUnmarshaler); ok && src.Kind() != reflect.String {
+			if err := u.UnmarshalBinary(compatBytes(src)); err != nil {
+				return fail("%v", err)
+			}
+			return nil
+		}
+		if u, ok := p.(encoding.TextUnmarshaler); ok && src.Kind() == reflect.String {
+			if err := u.UnmarshalText([]byte(src.String())); err != nil {
+				return fail("%v", err)
+			}
+			return nil
+		}
+	}
+	dt := dst.Type()
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return nil
+		}
+		dst.Set(reflect.New(dt.Elem()))
+		return convertCompat(dst.Elem(), src.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < dt.NumField(); i++ {
+			f := dt.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if sf := src.FieldByName(f.Name); sf.IsValid() {
+				if err := convertCompat(dst.Field(i), sf, join(f.Name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case reflect.Map:
+		if src.IsNil() {
+			return nil
+		}
+		m := reflect.MakeMapWithSize(dt, src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			k := reflect.New(dt.Key()).Elem()
+			if err := convertCompat(k, iter.Key(), join("(key)")); err != nil {
+				return err
+			}
+			e := reflect.New(dt.Elem()).Elem()
+			if err := convertCompat(e, iter.Value(), path); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Slice:
+		if dt.Elem().Kind() == reflect.Uint8 {
+			dst.Set(reflect.ValueOf(compatBytes(src)).Convert(dt))
+			return nil
+		}
+		if src.IsNil() {
+			return nil
+		}
+		s := reflect.MakeSlice(dt, src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			if err := convertCompat(s.Index(i), src.Index(i), path); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+	case reflect.Array:
+		b := compatBytes(src)
+		if len(b) != dt.Len() {
+			return fail("received %d bytes for %s", len(b), dt)
+		}
+		reflect.Copy(dst, reflect.ValueOf(b))
+		return nil
+	case reflect.String:
+		dst.SetString(string(compatBytes(src)))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		u := src.Uint()
+		if u > math.MaxInt64 || dst.OverflowInt(int64(u)) {
+			return fail("%d is out of range of %s", u, dt)
+		}
+		dst.SetInt(int64(u))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := src.Int()
+		if n < 0 || dst.OverflowUint(uint64(n)) {
+			return fail("%d is out of range of %s", n, dt)
+		}
+		dst.SetUint(uint64(n))
+		return nil
+	}
+	return fail("cannot convert %s to %s", src.Type(), dt)
+}
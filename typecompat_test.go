@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// The record types of a producer whose definitions differ from the local
+// ones in the ways -force-type-compat accepts.
+type otherSectorID struct {
+	Miner  int64
+	Number int64
+}
+
+type otherCids struct {
+	Sealed string
+}
+
+type otherTask struct {
+	SectorID      otherSectorID
+	TaskType      string
+	SealProofType uint64
+	Ticket        [32]byte
+	Seed          string
+	PreCommit2Out otherCids
+	Commit1Out    string
+	ErrMsg        []byte
+}
+
+type otherFileTask struct {
+	ID   [16]byte
+	Done bool
+}
+
+type otherRecord struct {
+	SectorId           otherSectorID
+	SectorWorkingPhase uint64
+	CurrentSealTask    otherTask
+	CurrentFileTask    otherFileTask
+	P1WorkerAddress    []byte
+}
+
+func TestForceTypeCompat(t *testing.T) {
+	dir := tempDir(t)
+	sealed := testCid(t, "sealed")
+	id := uuid.MustParse("6f1c3a9e-0d2b-4c1e-9a57-3b8d2e4f6a10")
+	var ticket [32]byte
+	copy(ticket[:], "ticket of thirty-two bytes, ok!!")
+	key := otherSectorID{Miner: 1000, Number: 7}
+	in := writeGobState(t, dir, "other.gob", map[otherSectorID]otherRecord{key: {
+		SectorId:           key,
+		SectorWorkingPhase: 3,
+		CurrentSealTask: otherTask{
+			SectorID:      key,
+			TaskType:      "seal/v0/precommit/2",
+			SealProofType: 5,
+			Ticket:        ticket,
+			Seed:          "seed",
+			PreCommit2Out: otherCids{Sealed: sealed.String()},
+			Commit1Out:    "commit1",
+			ErrMsg:        []byte("boom"),
+		},
+		CurrentFileTask: otherFileTask{ID: id, Done: true},
+		P1WorkerAddress: []byte("10.0.0.1:3456"),
+	}})
+	out := filepath.Join(dir, "out.json")
+
+	if res := runTool(t, "-in", in, "-out", out); res.err == nil {
+		t.Fatal("a gob of mismatched types loaded without -force-type-compat")
+	}
+	res := mustRun(t, "-in", in, "-out", out, "-force-type-compat")
+	got := loadTestState(t, out)
+	r, ok := got[SectorID{Miner: 1000, Number: 7}]
+	if !ok || len(got) != 1 {
+		t.Fatalf("loaded %v, want sector 7 of miner 1000", got)
+	}
+	task := r.CurrentSealTask
+	if r.SectorWorkingPhase != 3 || task.SectorID != r.SectorId || task.SealProofType != 5 || task.TaskType != "seal/v0/precommit/2" {
+		t.Errorf("the integer and string fields loaded as %+v", r)
+	}
+	if !bytes.Equal(task.Ticket, ticket[:]) || string(task.Seed) != "seed" || string(task.Commit1Out) != "commit1" {
+		t.Errorf("the blobs loaded as %q, %q and %q", task.Ticket, task.Seed, task.Commit1Out)
+	}
+	if task.PreCommit2Out.Sealed != sealed || task.ErrMsg != "boom" || r.P1WorkerAddress != "10.0.0.1:3456" {
+		t.Errorf("loaded %s, %q and %q", task.PreCommit2Out.Sealed, task.ErrMsg, r.P1WorkerAddress)
+	}
+	if r.CurrentFileTask.ID != id || !r.CurrentFileTask.Done {
+		t.Errorf("the file task loaded as %+v", r.CurrentFileTask)
+	}
+	for _, want := range []string{
+		"-force-type-compat: (key).Miner: received int, loaded as main.ActorID",
+		"-force-type-compat: CurrentSealTask.Ticket: received [32]uint8, loaded as main.SealRandomness",
+		"-force-type-compat: CurrentSealTask.PreCommit2Out.Sealed: received string, loaded as cid.Cid",
+		"-force-type-compat: CurrentFileTask.ID: received [16]uint8, loaded as uuid.UUID",
+		"-force-type-compat: P1WorkerAddress: received []byte, loaded as string",
+		"-force-type-compat: SectorWorkingPhase: received uint, loaded as main.SectorWorkingPhase",
+	} {
+		if !strings.Contains(res.log, want) {
+			t.Errorf("the log does not warn %q:\n%s", want, res.log)
+		}
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-force-type-compat", "-fail-on-warning"); res.code != 1 {
+		t.Errorf("-fail-on-warning with substitutions: exit status %d, want 1", res.code)
+	}
+
+	// A matching file needs no substitution.
+	same := writeGobState(t, dir, "same.gob", recordMap(testRecords(2)))
+	if res := mustRun(t, "-in", same, "-out", out, "-force-type-compat"); strings.Contains(res.log, "-force-type-compat") {
+		t.Errorf("a gob of the local types was warned about:\n%s", res.log)
+	}
+}
+
+func TestForceTypeCompatOutOfRange(t *testing.T) {
+	dir := tempDir(t)
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"negative.gob", map[otherSectorID]otherRecord{{Miner: 1000, Number: -1}: {}}, "-force-type-compat: (key).Number: -1 is out of range of main.SectorNumber"},
+		{"short.gob", map[SectorID]struct{ CurrentFileTask struct{ ID [4]byte } }{{Miner: 1000, Number: 1}: {CurrentFileTask: struct{ ID [4]byte }{ID: [4]byte{1, 2, 3, 4}}}}, "-force-type-compat: CurrentFileTask.ID: invalid UUID"},
+		{"uint.gob", map[SectorID]struct{ SectorWorkingPhase uint64 }{{Miner: 1000, Number: 1}: {SectorWorkingPhase: 1 << 63}}, "is out of range of main.SectorWorkingPhase"},
+	}
+	for _, tt := range tests {
+		in := writeGobState(t, dir, tt.name, tt.v)
+		res := runTool(t, "-in", in, "-out", filepath.Join(dir, "out.json"), "-force-type-compat")
+		if res.err == nil || !strings.Contains(res.err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.name, res.err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+)
+
+// upgradeLegacy makes recordDecoder accept the record encodings of older
+// versions of this tool, as asked with -upgrade.
+var upgradeLegacy bool
+
+// upgradeRecord rewrites the legacy encodings in the JSON record raw into
+// the current ones and returns the result with one line per change:
+//   - CIDs written as a bare string become {"/": "..."}, and empty strings
+//     and objects become null, which loads as cid.Undef.
+//   - Blobs written as arrays of byte values become base64 strings.
+//   - Members the record type no longer has are dropped; with -strict-json
+//     they would fail the load.
+//
+// Fields missing from raw load as their zero value, which is reported too.
+func upgradeRecord(raw json.RawMessage) (json.RawMessage, []string, error) {
+	changes := make([]string, 0)
+	var err error
+	cidPaths := [][]string{
+		{"CurrentSealTask", "PreCommit2Out", "Unsealed"},
+		{"CurrentSealTask", "PreCommit2Out", "Sealed"},
+	}
+	var head struct {
+		CurrentSealTask struct{ Pieces []json.RawMessage }
+	}
+	json.Unmarshal(raw, &head)
+	for i := range head.CurrentSealTask.Pieces {
+		cidPaths = append(cidPaths, []string{"CurrentSealTask", "Pieces", strconv.Itoa(i), "PieceCID"})
+	}
+	for _, path := range cidPaths {
+		if raw, err = replaceField(raw, path, upgradeCid(path, &changes)); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, b := range recordBlobs(SectorRecord{}) {
+		path := strings.Split(b.name, ".")
+		if raw, err = replaceField(raw, path, upgradeBlob(b.name, &changes)); err != nil {
+			return nil, nil, err
+		}
+	}
+	raw, err = dropUnknownMembers(raw, reflect.TypeOf(SectorRecord{}), "", &changes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, changes, nil
+}
+
+func upgradeCid(path []string, changes *[]string) func(json.RawMessage) (json.RawMessage, error) {
+	name := strings.Join(path, ".")
+	return func(value json.RawMessage) (json.RawMessage, error) {
+		t := bytes.TrimSpace(value)
+		var s string
+		switch {
+		case string(t) == "{}" || string(t) == `""`:
+			*changes = append(*changes, name+": empty CID written as null")
+			return json.RawMessage("null"), nil
+		case json.Unmarshal(t, &s) == nil:
+			c, err := cid.Decode(s)
+			if err != nil {
+				return value, nil
+			}
+			*changes = append(*changes, name+": string CID written as a link")
+			return json.Marshal(c)
+		}
+		return value, nil
+	}
+}
+
+func upgradeBlob(name string, changes *[]string) func(json.RawMessage) (json.RawMessage, error) {
+	return func(value json.RawMessage) (json.RawMessage, error) {
+		t := bytes.TrimSpace(value)
+		if len(t) == 0 || t[0] != '[' {
+			return value, nil
+		}
+		var b []uint8
+		if err := json.Unmarshal(t, &b); err != nil {
+			return value, nil
+		}
+		*changes = append(*changes, fmt.Sprintf("%s: byte array of %d value(s) written as base64", name, len(b)))
+		return marshalBlob(b)
+	}
+}
+
+// jsonUnmarshaler is implemented by the types that decode themselves, such
+// as CIDs and blobs, whose members are theirs to check.
+var jsonUnmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// dropUnknownMembers removes the members of the JSON value raw, of type t
+// at path, that t does not have, descending into structs and slices, and
+// records which were dropped and which fields are missing. The fields of
+// the record extension, and with keepUnknownFields all top-level members,
+// are kept.
+func dropUnknownMembers(raw json.RawMessage, t reflect.Type, path string, changes *[]string) (json.RawMessage, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	tr := bytes.TrimSpace(raw)
+	if len(tr) == 0 || reflect.PtrTo(t).Implements(jsonUnmarshaler) {
+		return raw, nil
+	}
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	switch {
+	case t.Kind() == reflect.Slice && tr[0] == '[':
+		var elems []json.RawMessage
+		if err := json.Unmarshal(tr, &elems); err != nil {
+			return raw, nil
+		}
+		for i := range elems {
+			var err error
+			if elems[i], err = dropUnknownMembers(elems[i], t.Elem(), join(strconv.Itoa(i)), changes); err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(elems)
+	case t.Kind() != reflect.Struct || tr[0] != '{':
+		return raw, nil
+	}
+	fields := make(map[string]reflect.Type)
+	// required are the fields current output always writes.
+	required := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		fields[jsonFieldName(f)] = f.Type
+		if !strings.Contains(f.Tag.Get("json"), ",omitempty") {
+			required = append(required, jsonFieldName(f))
+		}
+	}
+	members, err := decodeMembers(tr)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool, len(members))
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	n := 0
+	for _, m := range members {
+		present[m.key] = true
+		value := m.value
+		if ft, ok := fields[m.key]; ok {
+			if value, err = dropUnknownMembers(value, ft, join(m.key), changes); err != nil {
+				return nil, err
+			}
+		} else if path != "" || !keepUnknownFields && (currentExtension == nil || !currentExtension.names[m.key]) {
+			*changes = append(*changes, join(m.key)+": unknown field dropped")
+			continue
+		}
+		if n > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+		n++
+	}
+	buf.WriteByte('}')
+	for _, name := range required {
+		// Empty Pieces are left out of current output, see
+		// omitEmptyPieces.
+		if !present[name] && join(name) != "CurrentSealTask.Pieces" {
+			*changes = append(*changes, join(name)+": missing, loaded as its zero value")
+		}
+	}
+	return buf.Bytes(), nil
+}
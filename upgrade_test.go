@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeRecord(t *testing.T) {
+	piece := testCid(t, "piece")
+	link, err := json.Marshal(piece)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		raw     string
+		want    string
+		changes []string
+	}{
+		{"string CID",
+			`{"CurrentSealTask":{"Pieces":[{"Size":2048,"PieceCID":"` + piece.String() + `"}]}}`,
+			`{"CurrentSealTask":{"Pieces":[{"Size":2048,"PieceCID":` + string(link) + `}]}}`,
+			[]string{"CurrentSealTask.Pieces.0.PieceCID: string CID written as a link"}},
+		{"empty CIDs",
+			`{"CurrentSealTask":{"PreCommit2Out":{"Unsealed":"","Sealed":{}}}}`,
+			`{"CurrentSealTask":{"PreCommit2Out":{"Unsealed":null,"Sealed":null}}}`,
+			[]string{"CurrentSealTask.PreCommit2Out.Unsealed: empty CID written as null", "CurrentSealTask.PreCommit2Out.Sealed: empty CID written as null"}},
+		{"current CID", `{"CurrentSealTask":{"PreCommit2Out":{"Unsealed":` + string(link) + `}}}`, "", nil},
+		{"undecodable CID string", `{"CurrentSealTask":{"PreCommit2Out":{"Sealed":"not a cid"}}}`, "", nil},
+		{"byte array blob",
+			`{"CurrentSealTask":{"Ticket":[1,2,3,4],"Seed":"AQID"}}`,
+			`{"CurrentSealTask":{"Ticket":"AQIDBA==","Seed":"AQID"}}`,
+			[]string{"CurrentSealTask.Ticket: byte array of 4 value(s) written as base64"}},
+		{"unknown members",
+			`{"SectorId":{"Miner":1000,"Number":1,"Old":1},"WorkerGroup":"nvme"}`,
+			`{"SectorId":{"Miner":1000,"Number":1}}`,
+			[]string{"SectorId.Old: unknown field dropped", "WorkerGroup: unknown field dropped"}},
+	}
+	for _, tt := range tests {
+		got, changes, err := upgradeRecord(json.RawMessage(tt.raw))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := tt.want
+		if want == "" {
+			want = tt.raw
+		}
+		if string(got) != want {
+			t.Errorf("%s: upgraded to\n%s\nwant\n%s", tt.name, got, want)
+		}
+		// Every level of the record lacks fields, so only the changes
+		// other than those reports are compared.
+		reported := make([]string, 0)
+		for _, c := range changes {
+			if !strings.HasSuffix(c, ": missing, loaded as its zero value") {
+				reported = append(reported, c)
+			}
+		}
+		if len(tt.changes) == 0 {
+			tt.changes = []string{}
+		}
+		if !reflect.DeepEqual(reported, tt.changes) {
+			t.Errorf("%s: reported %q, want %q", tt.name, reported, tt.changes)
+		}
+	}
+}
+
+func TestUpgradeLegacyFixture(t *testing.T) {
+	const legacy = "testdata/legacy_output.json"
+	dir := tempDir(t)
+	out := filepath.Join(dir, "out.json")
+	if res := runTool(t, "-in", legacy, "-out", out); res.code == 0 {
+		t.Fatal("the legacy fixture loads without -upgrade")
+	}
+
+	res := mustRun(t, "-in", legacy, "-out", out, "-upgrade")
+	for _, want := range []string{
+		"s-t01000-1: upgrade: CurrentSealTask.Pieces.0.PieceCID: string CID written as a link",
+		"s-t01000-1: upgrade: CurrentSealTask.Ticket: byte array of 4 value(s) written as base64",
+		"s-t01000-1: upgrade: CurrentSealTask.RetryCount: unknown field dropped",
+		"s-t01000-1: upgrade: WorkerGroup: unknown field dropped",
+		"s-t01000-2: upgrade: CurrentSealTask.PreCommit2Out.Unsealed: empty CID written as null",
+		"s-t01000-2: upgrade: CurrentFileTask: missing, loaded as its zero value",
+	} {
+		if !strings.Contains(res.log, want) {
+			t.Errorf("the log lacks %q", want)
+		}
+	}
+
+	got := readJsonState(t, out)
+	if len(got) != 2 {
+		t.Fatalf("upgraded %d records, want 2", len(got))
+	}
+	task := got[0].CurrentSealTask
+	if task.Pieces[0].PieceCID.String() != "bafkreie2wxlalytphcqnbrl27uwdoe2xbmyuzes7fyhqbefh3423jdy7su" ||
+		task.PreCommit2Out.Sealed.String() != "bagboeayseakezwtslnfshoqaemb4rmbrtpruarnpersein5js45z3df56l3do" {
+		t.Errorf("the CIDs of sector 1 were not kept: %+v", task)
+	}
+	if string(task.Ticket) != "\x01\x02\x03\x04" || task.SealProofType != RegisteredSealProof_StackedDrg2KiBV1_1 {
+		t.Errorf("sector 1 upgraded with Ticket %v and seal proof %v", task.Ticket, task.SealProofType)
+	}
+	if p := got[1].CurrentSealTask.Pieces; len(p) != 1 || p[0].PieceCID.Defined() || got[1].P1WorkerAddress != "10.0.0.2:3456" {
+		t.Errorf("sector 2 upgraded to %+v", got[1])
+	}
+	if strings.Contains(readTestFile(t, out), "WorkerGroup") {
+		t.Error("the dropped member was written")
+	}
+
+	// The upgraded output is current: it loads strictly and reconverts to
+	// the same bytes.
+	again := filepath.Join(dir, "again.json")
+	mustRun(t, "-in", out, "-out", again, "-strict-json")
+	if readTestFile(t, again) != readTestFile(t, out) {
+		t.Error("reconverting the upgraded output changed it")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// severity is how serious a validation issue is. Errors fail -validate;
+// warnings are logged and fail it only with -fail-on-warning.
+type severity int
+
+const (
+	severityError severity = iota
+	severityWarning
+)
+
+// validationIssue is a rule violation found in one sector record.
+type validationIssue struct {
+	SectorID SectorID
+	Rule     string
+	Severity severity
+	Message  string
+}
+
+type validationRule struct {
+	name     string
+	severity severity
+	check    func(r SectorRecord) []string
+}
+
+// The piece sizes of a sector are a warning: they do not stop it from
+// loading or sealing further, but a sealed sector should add up.
+var validationRules = []validationRule{
+	{name: "task-phase", check: checkTaskPhase},
+	{name: "piece-sizes", severity: severityWarning, check: checkPieceSizes},
+}
+
+// stateValidationRule checks a property spanning several records.
+type stateValidationRule struct {
+	name     string
+	severity severity
+	check    func(data map[SectorID]SectorRecord) []validationIssue
+}
+
+var stateValidationRules = []stateValidationRule{
+	{name: "path-collision", check: checkPathCollisions},
+}
+
+// taskPhaseExpectation describes what a record must look like while its
+// current seal task has a given type. A zero maxPhase leaves the upper
+// bound open.
+type taskPhaseExpectation struct {
+	minPhase          SectorWorkingPhase
+	maxPhase          SectorWorkingPhase
+	needPreCommit2Out bool
+}
+
+// taskPhaseExpectations is the single table of task/phase coherence rules.
+// A sector at commit2 has left the zero (not started) phase and must carry
+// the sealed and unsealed CIDs produced by precommit2.
+var taskPhaseExpectations = map[TaskType]taskPhaseExpectation{
+	TTCommit2: {minPhase: 1, needPreCommit2Out: true},
+}
+
+func checkTaskPhase(r SectorRecord) []string {
+	exp, ok := taskPhaseExpectations[r.CurrentSealTask.TaskType]
+	if !ok {
+		return nil
+	}
+	var msgs []string
+	phase := r.SectorWorkingPhase
+	if phase < exp.minPhase || (exp.maxPhase != 0 && phase > exp.maxPhase) {
+		msgs = append(msgs, fmt.Sprintf("task %s at unexpected phase %d", r.CurrentSealTask.TaskType, phase))
+	}
+	if exp.needPreCommit2Out {
+		out := r.CurrentSealTask.PreCommit2Out
+		if !out.Unsealed.Defined() || !out.Sealed.Defined() {
+			msgs = append(msgs, fmt.Sprintf("task %s without PreCommit2Out CIDs", r.CurrentSealTask.TaskType))
+		}
+	}
+	return msgs
+}
+
+// checkPieceSizes checks that the pieces of a sealed sector, one with a
+// PreCommit2Out.Sealed CID, fill it: each padded size must be a power of
+// two of at least 128 bytes, and together they must add up to the sector
+// size of the seal proof type. The pieces sealed include the padding
+// pieces, so no room is left over.
+func checkPieceSizes(r SectorRecord) []string {
+	t := r.CurrentSealTask
+	size, ok := t.SealProofType.sectorSize()
+	if !ok || !t.PreCommit2Out.Sealed.Defined() {
+		return nil
+	}
+	var msgs []string
+	var sum PaddedPieceSize
+	for i, p := range t.Pieces {
+		if p.Size < 128 || p.Size&(p.Size-1) != 0 {
+			msgs = append(msgs, fmt.Sprintf("piece %d has size %d, not a padded piece size", i, p.Size))
+		}
+		sum += p.Size
+	}
+	if sum != size {
+		msgs = append(msgs, fmt.Sprintf("pieces add up to %d bytes, not the %d of seal proof type %s", sum, size, t.SealProofType))
+	}
+	return msgs
+}
+
+// recordPath is a file system path held by a record and the field it is in.
+type recordPath struct {
+	field string
+	path  string
+}
+
+// recordPaths returns the non-empty path fields of r.
+func recordPaths(r SectorRecord) []recordPath {
+	all := []recordPath{
+		{"MinerUnsealedSectorPath", r.MinerUnsealedSectorPath},
+		{"MinerSealedSectorPath", r.MinerSealedSectorPath},
+		{"MinerCacheDirPath", r.MinerCacheDirPath},
+		{"P1UnsealedSectorPath", r.P1UnsealedSectorPath},
+		{"P1SealedSectorPath", r.P1SealedSectorPath},
+		{"P1CacheDirPath", r.P1CacheDirPath},
+		{"P2SealedSectorPath", r.P2SealedSectorPath},
+		{"P2CacheDirPath", r.P2CacheDirPath},
+		{"C1SealedSectorPath", r.C1SealedSectorPath},
+		{"C1CacheDirPath", r.C1CacheDirPath},
+		{"CurrentSealTask.CacheDirPath", r.CurrentSealTask.CacheDirPath},
+		{"CurrentSealTask.StagedSectorPath", r.CurrentSealTask.StagedSectorPath},
+		{"CurrentSealTask.SealedSectorPath", r.CurrentSealTask.SealedSectorPath},
+		{"CurrentFileTask.SourceUnsealedSectorPath", r.CurrentFileTask.SourceUnsealedSectorPath},
+		{"CurrentFileTask.SourceSealedSectorPath", r.CurrentFileTask.SourceSealedSectorPath},
+		{"CurrentFileTask.SourceCachePath", r.CurrentFileTask.SourceCachePath},
+		{"CurrentFileTask.TargetUnsealedSectorPath", r.CurrentFileTask.TargetUnsealedSectorPath},
+		{"CurrentFileTask.TargetSealedSectorPath", r.CurrentFileTask.TargetSealedSectorPath},
+		{"CurrentFileTask.TargetCachePath", r.CurrentFileTask.TargetCachePath},
+	}
+	paths := make([]recordPath, 0, len(all))
+	for _, p := range all {
+		if p.path != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// checkPathCollisions reports every sector sharing a path with another
+// sector, in any of their path fields. Two sectors writing the same file
+// corrupt each other. A record repeating a path in several of its own
+// fields is fine.
+func checkPathCollisions(data map[SectorID]SectorRecord) []validationIssue {
+	var paths []string
+	owners := make(map[string][]SectorID)
+	fields := make(map[string]map[SectorID]string)
+	for _, r := range sortedRecords(data) {
+		for _, p := range recordPaths(r) {
+			if fields[p.path] == nil {
+				fields[p.path] = make(map[SectorID]string)
+				paths = append(paths, p.path)
+			}
+			if _, ok := fields[p.path][r.SectorId]; ok {
+				continue
+			}
+			fields[p.path][r.SectorId] = p.field
+			owners[p.path] = append(owners[p.path], r.SectorId)
+		}
+	}
+	issues := make([]validationIssue, 0)
+	for _, path := range paths {
+		ids := owners[path]
+		if len(ids) < 2 {
+			continue
+		}
+		for _, id := range ids {
+			others := make([]string, 0, len(ids)-1)
+			for _, other := range ids {
+				if other != id {
+					others = append(others, sectorName(other))
+				}
+			}
+			issues = append(issues, validationIssue{
+				SectorID: id,
+				Message:  fmt.Sprintf("%s %s is also used by %s", fields[path][id], path, strings.Join(others, ", ")),
+			})
+		}
+	}
+	return issues
+}
+
+// validate runs every rule over the state and returns the issues ordered
+// by sector.
+func validate(data map[SectorID]SectorRecord) []validationIssue {
+	ids := make([]SectorID, 0, len(data))
+	for id := range data {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return sectorIDLess(ids[i], ids[j]) })
+
+	issues := make([]validationIssue, 0)
+	for _, id := range ids {
+		processingSector(id)
+		for _, rule := range validationRules {
+			for _, msg := range rule.check(data[id]) {
+				issues = append(issues, validationIssue{SectorID: id, Rule: rule.name, Severity: rule.severity, Message: msg})
+			}
+		}
+	}
+	for _, rule := range stateValidationRules {
+		for _, issue := range rule.check(data) {
+			issue.Rule = rule.name
+			issue.Severity = rule.severity
+			issues = append(issues, issue)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return sectorIDLess(issues[i].SectorID, issues[j].SectorID)
+	})
+	return issues
+}
+
+// printValidationIssues prints the error-level issues to w and logs the
+// warnings with warnf, where -fail-on-warning counts them. It returns the
+// number of errors.
+func printValidationIssues(w io.Writer, issues []validationIssue) int {
+	errs, warns := 0, 0
+	for _, issue := range issues {
+		if issue.Severity == severityWarning {
+			warnf("%s: [%s] %s", sectorName(issue.SectorID), issue.Rule, issue.Message)
+			warns++
+			continue
+		}
+		fmt.Fprintf(w, "%s: [%s] %s\n", sectorName(issue.SectorID), issue.Rule, issue.Message)
+		errs++
+	}
+	fmt.Fprintf(w, "%d issue(s) found, %d warning(s)\n", errs, warns)
+	return errs
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+)
+
+func TestCheckTaskPhase(t *testing.T) {
+	sealed := SectorCids{Unsealed: testCid(t, "unsealed"), Sealed: testCid(t, "sealed")}
+	commit2 := func(phase SectorWorkingPhase, out SectorCids) SectorRecord {
+		r := testRecord(1000, 1, phase)
+		r.CurrentSealTask.TaskType = TTCommit2
+		r.CurrentSealTask.PreCommit2Out = out
+		return r
+	}
+	tests := []struct {
+		name string
+		r    SectorRecord
+		want []string
+	}{
+		{"coherent commit2", commit2(5, sealed), nil},
+		{"task without expectations", testRecord(1000, 1, 0), nil},
+		{"commit2 at phase 0", commit2(0, sealed), []string{"task seal/v0/commit/2 at unexpected phase 0"}},
+		{"commit2 without CIDs", commit2(5, SectorCids{Sealed: sealed.Sealed}), []string{"task seal/v0/commit/2 without PreCommit2Out CIDs"}},
+		{"both", commit2(0, SectorCids{}), []string{
+			"task seal/v0/commit/2 at unexpected phase 0",
+			"task seal/v0/commit/2 without PreCommit2Out CIDs",
+		}},
+	}
+	for _, tt := range tests {
+		if got := checkTaskPhase(tt.r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsTaskPhase(t *testing.T) {
+	bad := testRecord(1000, 2, 0)
+	bad.CurrentSealTask.TaskType = TTCommit2
+	issues := validate(recordMap([]SectorRecord{testRecord(1000, 1, 1), bad}))
+	if len(issues) != 2 {
+		t.Fatalf("got issues %+v, want two for sector 1000/2", issues)
+	}
+	for _, issue := range issues {
+		if issue.SectorID != bad.SectorId || issue.Rule != "task-phase" || issue.Severity != severityError {
+			t.Errorf("got issue %+v", issue)
+		}
+	}
+}
+
+func TestCheckPathCollisions(t *testing.T) {
+	a, b, c := testRecord(1000, 1, 1), testRecord(1000, 2, 1), testRecord(1000, 3, 1)
+	a.P1SealedSectorPath = "/sealed/s-t01000-1"
+	a.CurrentSealTask.SealedSectorPath = "/sealed/s-t01000-1"
+	b.P2SealedSectorPath = "/sealed/s-t01000-1"
+	c.CurrentSealTask.SealedSectorPath = "/sealed/s-t01000-1"
+	c.P1CacheDirPath = "/cache/s-t01000-3"
+
+	got := checkPathCollisions(recordMap([]SectorRecord{a, b, c}))
+	want := []validationIssue{
+		{SectorID: a.SectorId, Message: "P1SealedSectorPath /sealed/s-t01000-1 is also used by s-t01000-2, s-t01000-3"},
+		{SectorID: b.SectorId, Message: "P2SealedSectorPath /sealed/s-t01000-1 is also used by s-t01000-1, s-t01000-3"},
+		{SectorID: c.SectorId, Message: "CurrentSealTask.SealedSectorPath /sealed/s-t01000-1 is also used by s-t01000-1, s-t01000-2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	if got := checkPathCollisions(recordMap([]SectorRecord{a, testRecord(1000, 2, 1)})); len(got) != 0 {
+		t.Errorf("a record repeating its own path was reported: %+v", got)
+	}
+}
+
+func TestValidateReportsPathCollisions(t *testing.T) {
+	a, b := testRecord(1000, 1, 1), testRecord(1000, 2, 1)
+	a.MinerSealedSectorPath = "/sealed/shared"
+	b.MinerSealedSectorPath = "/sealed/shared"
+	in := writeGobState(t, tempDir(t), "in.gob", recordMap([]SectorRecord{a, b}))
+
+	res := runTool(t, "-in", in, "-validate")
+	if res.code != 1 {
+		t.Errorf("-validate exited %d, want 1", res.code)
+	}
+	for _, want := range []string{
+		"s-t01000-1: [path-collision] MinerSealedSectorPath /sealed/shared is also used by s-t01000-2\n",
+		"s-t01000-2: [path-collision] MinerSealedSectorPath /sealed/shared is also used by s-t01000-1\n",
+	} {
+		if !strings.Contains(res.stdout, want) {
+			t.Errorf("-validate lacks %q:\n%s", want, res.stdout)
+		}
+	}
+}
+
+func TestCheckPieceSizes(t *testing.T) {
+	sealed := func(proof RegisteredSealProof, sizes ...PaddedPieceSize) SectorRecord {
+		r := testRecord(1000, 1, 5)
+		r.CurrentSealTask.SealProofType = proof
+		r.CurrentSealTask.PreCommit2Out.Sealed = testCid(t, "sealed")
+		r.CurrentSealTask.Pieces = nil
+		for _, size := range sizes {
+			r.CurrentSealTask.Pieces = append(r.CurrentSealTask.Pieces, PieceInfo{Size: size})
+		}
+		return r
+	}
+	unsealed := sealed(RegisteredSealProof_StackedDrg2KiBV1_1, 1024)
+	unsealed.CurrentSealTask.PreCommit2Out.Sealed = cid.Undef
+	tests := []struct {
+		name string
+		r    SectorRecord
+		want []string
+	}{
+		{"one piece filling 2 KiB", sealed(RegisteredSealProof_StackedDrg2KiBV1, 2048), nil},
+		{"data and padding pieces", sealed(RegisteredSealProof_StackedDrg8MiBV1_1, 4<<20, 2<<20, 1<<20, 1<<20), nil},
+		{"32 GiB", sealed(RegisteredSealProof_StackedDrg32GiBV1_1, 16<<30, 16<<30), nil},
+		{"not yet sealed", unsealed, nil},
+		{"unknown proof type", sealed(RegisteredSealProof(42), 1024), nil},
+		{"too little", sealed(RegisteredSealProof_StackedDrg2KiBV1_1, 1024), []string{
+			"pieces add up to 1024 bytes, not the 2048 of seal proof type StackedDrg2KiBV1_1",
+		}},
+		{"too much", sealed(RegisteredSealProof_StackedDrg2KiBV1, 2048, 128), []string{
+			"pieces add up to 2176 bytes, not the 2048 of seal proof type StackedDrg2KiBV1",
+		}},
+		{"no pieces", sealed(RegisteredSealProof_StackedDrg2KiBV1), []string{
+			"pieces add up to 0 bytes, not the 2048 of seal proof type StackedDrg2KiBV1",
+		}},
+		{"unpadded sizes", sealed(RegisteredSealProof_StackedDrg2KiBV1, 1000, 64, 984), []string{
+			"piece 0 has size 1000, not a padded piece size",
+			"piece 1 has size 64, not a padded piece size",
+			"piece 2 has size 984, not a padded piece size",
+		}},
+	}
+	for _, tt := range tests {
+		if got := checkPieceSizes(tt.r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateReportsPieceSizes(t *testing.T) {
+	good := pieceSizeWarning(t, 1)
+	good.CurrentSealTask.Pieces = []PieceInfo{{Size: 2048}}
+	issues := validate(recordMap([]SectorRecord{good, pieceSizeWarning(t, 2)}))
+	if len(issues) != 1 {
+		t.Fatalf("got issues %+v, want one for sector 1000/2", issues)
+	}
+	if issue := issues[0]; issue.SectorID != (SectorID{Miner: 1000, Number: 2}) || issue.Rule != "piece-sizes" || issue.Severity != severityWarning {
+		t.Errorf("got issue %+v", issue)
+	}
+}
+
+// pieceSizeWarning returns a sealed 2 KiB sector whose pieces do not fill
+// it, which the piece-sizes rule reports as a warning.
+func pieceSizeWarning(t *testing.T, number SectorNumber) SectorRecord {
+	t.Helper()
+	r := testRecord(1000, number, 1)
+	r.CurrentSealTask.SealProofType = RegisteredSealProof_StackedDrg2KiBV1_1
+	r.CurrentSealTask.PreCommit2Out.Sealed = testCid(t, "sealed")
+	r.CurrentSealTask.Pieces = []PieceInfo{{Size: 1024}}
+	return r
+}
+
+func TestPrintValidationIssues(t *testing.T) {
+	issues := []validationIssue{
+		{SectorID: SectorID{Miner: 1000, Number: 1}, Rule: "task-phase", Message: "bad phase"},
+		{SectorID: SectorID{Miner: 1000, Number: 2}, Rule: "piece-sizes", Severity: severityWarning, Message: "short"},
+	}
+	var logged, out strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	warnings = 0
+	if errs := printValidationIssues(&out, issues); errs != 1 {
+		t.Errorf("counted %d error(s), want 1", errs)
+	}
+	if want := "s-t01000-1: [task-phase] bad phase\n1 issue(s) found, 1 warning(s)\n"; out.String() != want {
+		t.Errorf("printed\n%swant\n%s", out.String(), want)
+	}
+	if !strings.Contains(logged.String(), "s-t01000-2: [piece-sizes] short") || warnings != 1 {
+		t.Errorf("the warning was not logged and counted, %d warning(s):\n%s", warnings, logged.String())
+	}
+}
+
+func TestFailOnWarning(t *testing.T) {
+	dir := tempDir(t)
+	warnOnly := writeGobState(t, dir, "warn.gob", recordMap([]SectorRecord{testRecord(1000, 1, 1), pieceSizeWarning(t, 2)}))
+
+	res := runTool(t, "-in", warnOnly, "-validate")
+	if res.code != 0 || !strings.Contains(res.stdout, "0 issue(s) found, 1 warning(s)") {
+		t.Errorf("a warning-only state: exit status %d with\n%s", res.code, res.stdout)
+	}
+	if !strings.Contains(res.log, "s-t01000-2: [piece-sizes]") {
+		t.Errorf("the warning was not logged:\n%s", res.log)
+	}
+	res = runTool(t, "-in", warnOnly, "-validate", "-fail-on-warning")
+	if res.code != 1 || !strings.Contains(res.stderr, "1 warning(s), failing as -fail-on-warning asks") {
+		t.Errorf("-fail-on-warning: exit status %d with\n%s", res.code, res.stderr)
+	}
+	if strings.Contains(res.stdout, "[piece-sizes]") {
+		t.Errorf("-fail-on-warning reported the warning as an issue:\n%s", res.stdout)
+	}
+
+	// Warnings of a conversion count too.
+	blob := testRecord(1000, 1, 1)
+	blob.CurrentSealTask.PreCommit1Out = make([]byte, 100)
+	in := writeGobState(t, dir, "blob.gob", recordMap([]SectorRecord{blob}))
+	out := filepath.Join(dir, "out.json")
+	if res := runTool(t, "-in", in, "-out", out, "-max-blob-size", "10"); res.code != 0 {
+		t.Errorf("an oversized blob: exit status %d, want 0", res.code)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-max-blob-size", "10", "-fail-on-warning"); res.code != 1 {
+		t.Errorf("an oversized blob with -fail-on-warning: exit status %d, want 1", res.code)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-fail-on-warning"); res.code != 0 {
+		t.Errorf("no warnings with -fail-on-warning: exit status %d, want 0", res.code)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A -where expression keeps the records it is true for. The grammar, from
+// lowest to highest precedence:
+//
+//	expr       = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | "(" expr ")" | comparison
+//	comparison = field op value
+//	op         = "==" | "!=" | "<" | "<=" | ">" | ">="
+//
+// The fields are phase (SectorWorkingPhase), miner and number (of the
+// sector ID), finalized (of the current seal task), tasktype (of the
+// current seal task, given as the raw string or a constant name like
+// TTCommit2) and worker (any of the P1, P2, C1 and C2 worker addresses, so
+// worker!=x holds when none of them is x). Numbers and booleans compare as
+// such, strings only with == and !=. A string value is either quoted with
+// " or ', or a bare word of anything but whitespace and the characters
+// ()!=<>&|.
+//
+// For example: phase>3 && finalized==false || tasktype==TTCommit2.
+
+type whereKind int
+
+const (
+	whereInt whereKind = iota
+	whereUint
+	whereBool
+	whereString
+)
+
+type whereField struct {
+	kind whereKind
+	// value returns the field of r; for worker, every address.
+	value func(r SectorRecord) []string
+}
+
+var whereFields = map[string]whereField{
+	"phase": {whereInt, func(r SectorRecord) []string {
+		return []string{strconv.Itoa(int(r.SectorWorkingPhase))}
+	}},
+	"miner": {whereUint, func(r SectorRecord) []string {
+		return []string{strconv.FormatUint(uint64(r.SectorId.Miner), 10)}
+	}},
+	"number": {whereUint, func(r SectorRecord) []string {
+		return []string{strconv.FormatUint(uint64(r.SectorId.Number), 10)}
+	}},
+	"finalized": {whereBool, func(r SectorRecord) []string {
+		return []string{strconv.FormatBool(r.CurrentSealTask.Finalized)}
+	}},
+	"tasktype": {whereString, func(r SectorRecord) []string {
+		return []string{string(r.CurrentSealTask.TaskType)}
+	}},
+	"worker": {whereString, func(r SectorRecord) []string {
+		return []string{r.P1WorkerAddress, r.P2WorkerAddress, r.C1WorkerAddress, r.C2WorkerAddress}
+	}},
+}
+
+// parseWhere compiles the -where expression src into a record filter.
+func parseWhere(src string) (recordFilter, error) {
+	tokens, err := lexWhere(src)
+	if err != nil {
+		return nil, fmt.Errorf("-where: %w", err)
+	}
+	p := &whereParser{tokens: tokens}
+	keep, err := p.expr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-where: %w", err)
+	}
+	return keep, nil
+}
+
+type whereToken struct {
+	text string
+	// value is set for string and word tokens, which are never operators.
+	value bool
+}
+
+func lexWhere(src string) ([]whereToken, error) {
+	tokens := make([]whereToken, 0)
+	special := func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("()!=<>&|\"'", r)
+	}
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, whereToken{text: string(r)})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(rs) && rs[end] != r {
+				end++
+			}
+			if end == len(rs) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, whereToken{text: string(rs[i+1 : end]), value: true})
+			i = end + 1
+		case strings.ContainsRune("!=<>&|", r):
+			op := string(r)
+			if i+1 < len(rs) {
+				if two := string(rs[i : i+2]); two == "==" || two == "!=" || two == "<=" || two == ">=" || two == "&&" || two == "||" {
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("unknown operator %q at offset %d", op, i)
+			}
+			tokens = append(tokens, whereToken{text: op})
+			i += len(op)
+		default:
+			end := i
+			for end < len(rs) && !special(rs[end]) {
+				end++
+			}
+			tokens = append(tokens, whereToken{text: string(rs[i:end]), value: true})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+var whereComparisons = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+// accept advances past the next token if it is the operator op.
+func (p *whereParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].value && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *whereParser) expr() (recordFilter, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r SectorRecord) bool { return l(r) || right(r) }
+	}
+	return left, nil
+}
+
+func (p *whereParser) and() (recordFilter, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(r SectorRecord) bool { return l(r) && right(r) }
+	}
+	return left, nil
+}
+
+func (p *whereParser) unary() (recordFilter, error) {
+	if p.accept("!") {
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(r SectorRecord) bool { return !inner(r) }, nil
+	}
+	if p.accept("(") {
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *whereParser) comparison() (recordFilter, error) {
+	if p.pos+3 > len(p.tokens) {
+		return nil, fmt.Errorf("incomplete comparison at the end")
+	}
+	name, op, value := p.tokens[p.pos], p.tokens[p.pos+1], p.tokens[p.pos+2]
+	if !name.value {
+		return nil, fmt.Errorf("unexpected %q, want a field", name.text)
+	}
+	field, ok := whereFields[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q, want phase, miner, number, finalized, tasktype or worker", name.text)
+	}
+	if op.value || !whereComparisons[op.text] {
+		return nil, fmt.Errorf("unexpected %q after %s, want a comparison", op.text, name.text)
+	}
+	if !value.value {
+		return nil, fmt.Errorf("unexpected %q after %s%s, want a value", value.text, name.text, op.text)
+	}
+	p.pos += 3
+	cmp, err := whereComparison(field.kind, name.text, op.text, value.text)
+	if err != nil {
+		return nil, err
+	}
+	return func(r SectorRecord) bool {
+		values := field.value(r)
+		if op.text == "!=" {
+			// Holds when no value equals, so worker!=x excludes x in
+			// any role.
+			for _, v := range values {
+				if !cmp(v) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, v := range values {
+			if cmp(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// whereComparison returns whether a field value of kind, in its string
+// form, stands in relation op to want.
+func whereComparison(kind whereKind, name, op, want string) (func(string) bool, error) {
+	order := func(c int) bool {
+		switch op {
+		case "==":
+			return c == 0
+		case "!=":
+			return c != 0
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		}
+		return c >= 0
+	}
+	switch kind {
+	case whereInt:
+		w, err := strconv.ParseInt(want, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s wants an integer, not %q", name, want)
+		}
+		return func(v string) bool {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return order(compareInts(n, w))
+		}, nil
+	case whereUint:
+		w, err := strconv.ParseUint(want, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s wants an unsigned integer, not %q", name, want)
+		}
+		return func(v string) bool {
+			n, _ := strconv.ParseUint(v, 10, 64)
+			return order(compareUints(n, w))
+		}, nil
+	}
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("%s can only be compared with == or !=", name)
+	}
+	if kind == whereBool {
+		w, err := strconv.ParseBool(want)
+		if err != nil {
+			return nil, fmt.Errorf("%s wants true or false, not %q", name, want)
+		}
+		want = strconv.FormatBool(w)
+	}
+	if name == "tasktype" {
+		want = string(parseTaskType(want))
+	}
+	return func(v string) bool { return order(strings.Compare(v, want)) }, nil
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareUints(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
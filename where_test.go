@@ -0,0 +1,104 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// whereRecords returns sector 1 at precommit1, 2 at commit2 with a second
+// worker, 3 finalized on another worker and sector 4 of miner 2000.
+func whereRecords() []SectorRecord {
+	recordList := []SectorRecord{testRecord(1000, 1, 1), testRecord(1000, 2, 3), testRecord(1000, 3, 5), testRecord(2000, 4, 4)}
+	recordList[1].CurrentSealTask.TaskType = TTCommit2
+	recordList[1].C1WorkerAddress = "10.0.0.2:3456"
+	recordList[2].CurrentSealTask.TaskType = TTCommit2
+	recordList[2].CurrentSealTask.Finalized = true
+	recordList[2].P1WorkerAddress = "10.0.0.3:3456"
+	return recordList
+}
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []SectorNumber
+	}{
+		{"phase>3", []SectorNumber{3, 4}},
+		{"phase>3 && finalized==false", []SectorNumber{4}},
+		{"phase == 1 || phase == 5", []SectorNumber{1, 3}},
+		{"phase>-1", []SectorNumber{1, 2, 3, 4}},
+		// && binds tighter than ||, and parentheses override it.
+		{"finalized==true || phase<2 && miner==1000", []SectorNumber{1, 3}},
+		{"(finalized==true || phase<2) && miner==2000", []SectorNumber{}},
+		{"miner==2000 || phase>=3 && phase<=3", []SectorNumber{2, 4}},
+		// ! applies to the comparison or group after it.
+		{"!finalized==true", []SectorNumber{1, 2, 4}},
+		{"!(phase>=3)", []SectorNumber{1}},
+		{"!!(phase>=3)", []SectorNumber{2, 3, 4}},
+		{"number<=2&&number>=2", []SectorNumber{2}},
+		{"phase!=3", []SectorNumber{1, 3, 4}},
+		{"tasktype==TTCommit2", []SectorNumber{2, 3}},
+		{"tasktype=='seal/v0/commit/2'", []SectorNumber{2, 3}},
+		{`tasktype!="seal/v0/precommit/1"`, []SectorNumber{2, 3}},
+		// worker matches any of the worker addresses.
+		{"worker==10.0.0.2:3456", []SectorNumber{2}},
+		{"worker!=10.0.0.1:3456", []SectorNumber{3}},
+		{"finalized==False", []SectorNumber{1, 2, 4}},
+	}
+	for _, tt := range tests {
+		keep, err := parseWhere(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := keptNumbers(whereRecords(), keep); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s kept %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestWhereErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{"", "incomplete comparison"},
+		{"phase", "incomplete comparison"},
+		{"phase>3 &&", "incomplete comparison"},
+		{"color==red", `unknown field "color"`},
+		{"==3 && phase>1", `unexpected "==", want a field`},
+		{"phase 3 >", `unexpected "3" after phase, want a comparison`},
+		{"phase>>3", `unexpected ">" after phase>, want a value`},
+		{"phase=3", `unknown operator "="`},
+		{"phase>3 & phase<5", `unknown operator "&"`},
+		{"phase>x", `phase wants an integer, not "x"`},
+		{"miner>-1", "miner wants an unsigned integer"},
+		{"tasktype>a", "tasktype can only be compared with == or !="},
+		{"finalized==maybe", `finalized wants true or false, not "maybe"`},
+		{"(phase>3", "missing )"},
+		{"phase>3)", `unexpected ")"`},
+		{"phase>3 phase<5", `unexpected "phase"`},
+		{"worker=='10.0.0.1", "unterminated string at offset 8"},
+	}
+	for _, tt := range tests {
+		_, err := parseWhere(tt.expr)
+		if err == nil || !strings.HasPrefix(err.Error(), "-where: ") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parseWhere(%q) = %v, want an error containing %q", tt.expr, err, tt.err)
+		}
+	}
+}
+
+func TestWhereFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(whereRecords()))
+	out := filepath.Join(dir, "out.json")
+
+	mustRun(t, "-in", in, "-out", out, "-where", "tasktype==TTCommit2 || miner==2000", "-number-max", "3")
+	if got := sectorNumbers(readJsonState(t, out)); got != "2 3" {
+		t.Errorf("kept the sectors %s, want 2 and 3 with both filters applied", got)
+	}
+	if res := runTool(t, "-in", in, "-out", out, "-where", "phase>"); errorType(res.err) != "usage" {
+		t.Errorf("an invalid -where: got %v, want a usage error", res.err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// workerUse is a worker address with the number of sectors referencing
+// it in any of the P1, P2, C1 and C2 roles.
+type workerUse struct {
+	address string
+	sectors int
+}
+
+// distinctWorkers returns the non-empty worker addresses of data in
+// address order. A sector holding an address in several roles counts once.
+func distinctWorkers(data map[SectorID]SectorRecord) []workerUse {
+	counts := make(map[string]int)
+	for _, r := range data {
+		seen := make(map[string]bool, 4)
+		for _, a := range []string{r.P1WorkerAddress, r.P2WorkerAddress, r.C1WorkerAddress, r.C2WorkerAddress} {
+			if a != "" && !seen[a] {
+				seen[a] = true
+				counts[a]++
+			}
+		}
+	}
+	workers := make([]workerUse, 0, len(counts))
+	for a, n := range counts {
+		workers = append(workers, workerUse{address: a, sectors: n})
+	}
+	sort.Slice(workers, func(i, j int) bool { return workers[i].address < workers[j].address })
+	return workers
+}
+
+// printWorkers writes one address per line, followed by a tab and its
+// sector count when withCounts is set.
+func printWorkers(w io.Writer, workers []workerUse, withCounts bool) {
+	for _, u := range workers {
+		if withCounts {
+			fmt.Fprintf(w, "%s\t%d\n", u.address, u.sectors)
+		} else {
+			fmt.Fprintln(w, u.address)
+		}
+	}
+}
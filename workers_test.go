@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// workerRecords are sectors 1 to 4 of miner 1000, with 10.0.0.1:3456 in
+// the P1 role of every one.
+func workerRecords() []SectorRecord {
+	recordList := testRecords(4)
+	recordList[0].P2WorkerAddress = "10.0.0.2:3456"
+	recordList[1].P2WorkerAddress = "10.0.0.2:3456"
+	recordList[1].C1WorkerAddress = "10.0.0.2:3456"
+	recordList[1].C2WorkerAddress = "gpu-1:2345"
+	recordList[2].C1WorkerAddress = "10.0.0.1:3456"
+	recordList[3].P1WorkerAddress = ""
+	recordList[3].C2WorkerAddress = "gpu-1:2345"
+	return recordList
+}
+
+func TestDistinctWorkers(t *testing.T) {
+	want := []workerUse{
+		{"10.0.0.1:3456", 3},
+		{"10.0.0.2:3456", 2},
+		{"gpu-1:2345", 2},
+	}
+	if got := distinctWorkers(recordMap(workerRecords())); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := distinctWorkers(recordMap(nil)); len(got) != 0 {
+		t.Errorf("a state without records has the workers %v", got)
+	}
+
+	var buf bytes.Buffer
+	printWorkers(&buf, want, false)
+	if got := buf.String(); got != "10.0.0.1:3456\n10.0.0.2:3456\ngpu-1:2345\n" {
+		t.Errorf("printed %q", got)
+	}
+	buf.Reset()
+	printWorkers(&buf, want, true)
+	if got := buf.String(); got != "10.0.0.1:3456\t3\n10.0.0.2:3456\t2\ngpu-1:2345\t2\n" {
+		t.Errorf("with counts printed %q", got)
+	}
+}
+
+func TestWorkersFlag(t *testing.T) {
+	dir := tempDir(t)
+	in := writeGobState(t, dir, "in.gob", recordMap(workerRecords()))
+	out := filepath.Join(dir, "out.json")
+	if res := mustRun(t, "-in", in, "-out", out, "-workers"); res.stdout != "10.0.0.1:3456\n10.0.0.2:3456\ngpu-1:2345\n" {
+		t.Errorf("-workers printed %q", res.stdout)
+	}
+	if res := mustRun(t, "-in", in, "-workers", "-worker-counts"); res.stdout != "10.0.0.1:3456\t3\n10.0.0.2:3456\t2\ngpu-1:2345\t2\n" {
+		t.Errorf("-worker-counts printed %q", res.stdout)
+	}
+	if _, err := ioutil.ReadFile(out); err == nil {
+		t.Error("-workers saved the state")
+	}
+	if res := runTool(t, "-in", in, "-worker-counts"); errorType(res.err) != "usage" {
+		t.Errorf("-worker-counts without -workers: got %v, want a usage error", res.err)
+	}
+}